@@ -77,7 +77,7 @@ func executeAiDump(cmd *cobra.Command, _ []string) error {
 	}
 
 	// Get Kong state with the AI tag selector
-	ks, err := getKongState(ctx, wsClient)
+	ks, _, err := getKongState(ctx, wsClient)
 	if err != nil {
 		return fmt.Errorf("getting Kong state: %w", err)
 	}
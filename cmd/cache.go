@@ -0,0 +1,15 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+func newCacheSubCmd() *cobra.Command {
+	cacheCmd := &cobra.Command{
+		Use:   "cache [sub-command]...",
+		Short: "Subcommand to host the decK local cache operations",
+		Long:  `Subcommand to host the decK local cache operations.`,
+	}
+
+	return cacheCmd
+}
@@ -0,0 +1,246 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/kong/deck/schemas"
+	"github.com/kong/deck/validate"
+	"github.com/kong/go-database-reconciler/pkg/schema"
+	reconcilerUtils "github.com/kong/go-database-reconciler/pkg/utils"
+	"github.com/kong/go-kong/kong"
+	"github.com/spf13/cobra"
+)
+
+var schemaKinds = []string{schemas.KindEntity, schemas.KindPlugin, schemas.KindPartial, schemas.KindVault}
+
+// enabledPlugins returns the names of the plugins available on a Kong, from
+// the response of its root endpoint.
+func enabledPlugins(root map[string]interface{}) []string {
+	plugins, _ := root["plugins"].(map[string]interface{})
+	available, _ := plugins["available_on_server"].(map[string]interface{})
+	names := make([]string, 0, len(available))
+	for name := range available {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// pullSchemas fetches the schemas of all entities and enabled plugins of
+// Kong, replacing the cached ones.
+func pullSchemas(ctx context.Context, client *kong.Client, store *schemas.Store) (schemas.Version, map[string]int, error) {
+	root, err := client.Root(ctx)
+	if err != nil {
+		return schemas.Version{}, nil, fmt.Errorf("fetching Kong version: %w", err)
+	}
+	v, err := schemas.VersionOf(kong.VersionFromInfo(root))
+	if err != nil {
+		return schemas.Version{}, nil, err
+	}
+
+	pulled := map[string]int{}
+	put := func(kind, name string, s kong.Schema, err error) error {
+		if err != nil {
+			return fmt.Errorf("fetching schema of %s/%s: %w", kind, name, err)
+		}
+		if s == nil {
+			// the entity does not exist in this version, or edition
+			return nil
+		}
+		if err := store.Put(v, kind, name, s); err != nil {
+			return err
+		}
+		pulled[kind]++
+		return nil
+	}
+
+	entityTypes := map[string]bool{}
+	for _, entityType := range validate.EntityMap {
+		entityTypes[entityType] = true
+	}
+	for entityType := range entityTypes {
+		s, err := schema.FetchEntitySchema(ctx, client, false, entityType)
+		if err := put(schemas.KindEntity, entityType, s, err); err != nil {
+			return v, pulled, err
+		}
+	}
+	for _, name := range enabledPlugins(root) {
+		s, err := schema.FetchPluginSchema(ctx, client, name)
+		if err := put(schemas.KindPlugin, name, s, err); err != nil {
+			return v, pulled, err
+		}
+	}
+	return v, pulled, nil
+}
+
+func executeCacheSchemasPull(cmd *cobra.Command, _ []string) error {
+	if inKonnectMode(nil) {
+		return errors.New("the schemas of Konnect are not cached, pull them from Kong Gateway")
+	}
+	client, err := reconcilerUtils.GetKongClient(rootConfig)
+	if err != nil {
+		return err
+	}
+	store := schemas.NewStore(schemaStoreDir())
+	v, pulled, err := pullSchemas(cmd.Context(), client, store)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Cached %d entity and %d plugin schemas of Kong %s in %s.\n",
+		pulled[schemas.KindEntity], pulled[schemas.KindPlugin], v, store.Dir())
+	return nil
+}
+
+func printCachedSchemas(w io.Writer, store *schemas.Store, versions []schemas.Version) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tENTITIES\tPLUGINS\tPARTIALS\tVAULTS")
+	for _, v := range versions {
+		fmt.Fprint(tw, v.String())
+		for _, kind := range schemaKinds {
+			names, err := store.Names(v, kind)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(tw, "\t%d", len(names))
+		}
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
+
+func executeCacheSchemasList(cmd *cobra.Command, _ []string) error {
+	store := schemas.NewStore(schemaStoreDir())
+	versions, err := store.Versions()
+	if err != nil {
+		return err
+	}
+	if len(versions) == 0 {
+		fmt.Fprintf(cmd.ErrOrStderr(), "No schemas cached in %s.\n", store.Dir())
+		return nil
+	}
+	return printCachedSchemas(cmd.OutOrStdout(), store, versions)
+}
+
+// clearSchemas removes the cached schemas of the versions, or of all
+// versions when none is given, and returns the removed versions. A version
+// without edition removes the schemas of both editions.
+func clearSchemas(store *schemas.Store, args []string) ([]schemas.Version, error) {
+	var selected []schemas.Version
+	for _, arg := range args {
+		v, err := schemas.ParseVersion(arg)
+		if err != nil {
+			return nil, err
+		}
+		selected = append(selected, v)
+	}
+	versions, err := store.Versions()
+	if err != nil {
+		return nil, err
+	}
+	var removed []schemas.Version
+	for _, stored := range versions {
+		match := len(selected) == 0
+		for _, v := range selected {
+			if v.Major == stored.Major && v.Minor == stored.Minor && (v.Edition == "" || v.Edition == stored.Edition) {
+				match = true
+			}
+		}
+		if !match {
+			continue
+		}
+		if err := store.Remove(stored); err != nil {
+			return removed, err
+		}
+		removed = append(removed, stored)
+	}
+	return removed, nil
+}
+
+// clearClusterSchemas removes the schemas cached from each Kong, and reports
+// whether there were any.
+func clearClusterSchemas(store *schemas.Store) (bool, error) {
+	dir := filepath.Join(store.Dir(), schemaClustersDir)
+	if _, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return false, fmt.Errorf("removing the schemas cached from Kong: %w", err)
+	}
+	return true, nil
+}
+
+func executeCacheSchemasClear(cmd *cobra.Command, args []string) error {
+	store := schemas.NewStore(schemaStoreDir())
+	removed, err := clearSchemas(store, args)
+	for _, v := range removed {
+		fmt.Fprintf(cmd.OutOrStdout(), "Removed the cached schemas of Kong %s.\n", v)
+	}
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		cleared, err := clearClusterSchemas(store)
+		if err != nil {
+			return err
+		}
+		if cleared {
+			fmt.Fprintln(cmd.OutOrStdout(), "Removed the schemas cached from Kong by --schema-cache.")
+			return nil
+		}
+	}
+	if len(removed) == 0 {
+		fmt.Fprintln(cmd.ErrOrStderr(), "No cached schemas to remove.")
+	}
+	return nil
+}
+
+func newCacheSchemasCmd() *cobra.Command {
+	schemasCmd := &cobra.Command{
+		Use:   "schemas [sub-command]...",
+		Short: "Manage the schemas cached from Kong",
+		Long: `Manage the entity and plugin schemas cached from Kong.
+
+With --schema-cache, decK caches the schemas it fetches from Kong on disk,
+by Admin API address and Kong version, and reuses them in later validate,
+diff, sync and dump runs instead of fetching them again. Schemas of custom
+plugins are not cached, as they are not tied to the version of Kong. The
+cache is in $HOME/.deck/cache/schemas unless set with --schema-cache-dir.
+
+The schemas pulled, or fetched by 'deck gateway validate' with
+--schema-cache, are stored by Kong version and edition for offline
+validation with 'deck gateway validate --kong-version'. Pull the schemas
+again after changing the custom plugins of Kong.`,
+	}
+
+	schemasCmd.AddCommand(&cobra.Command{
+		Use:   "pull",
+		Short: "Cache the schemas of all entities and enabled plugins of Kong",
+		Long: `Fetch the schemas of all entities and enabled plugins of Kong,
+replacing the cached ones. Schemas of partials and vaults are cached when
+they are first used.`,
+		Args: validateNoArgs,
+		RunE: executeCacheSchemasPull,
+	})
+	schemasCmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List the cached schemas by Kong version",
+		Args:  validateNoArgs,
+		RunE:  executeCacheSchemasList,
+	})
+	schemasCmd.AddCommand(&cobra.Command{
+		Use:   "clear [version...]",
+		Short: "Remove cached schemas",
+		Long: `Remove the cached schemas of the given Kong versions, such as 3.10 or
+3.10-enterprise, or all cached schemas when no version is given.`,
+		RunE: executeCacheSchemasClear,
+	})
+	return schemasCmd
+}
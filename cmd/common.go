@@ -2,7 +2,6 @@ package cmd
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
@@ -200,10 +199,11 @@ func syncMain(ctx context.Context, filenames []string, dry bool, parallelism,
 	if enableJSONOutput {
 		initJSONOutput()
 	}
-	targetContent, err := file.GetContentFromFiles(filenames, false)
+	targetContent, settings, err := readStateFiles(filenames)
 	if err != nil {
 		return err
 	}
+	setStateFileSettings(settings)
 	return syncContent(ctx, targetContent, dry, parallelism, delay, workspace, enableJSONOutput, applyType)
 }
 
@@ -246,6 +246,13 @@ func syncContent(ctx context.Context, targetContent *file.Content, dry bool, par
 		targetContent.CACertificates = []file.FCACertificate{}
 	}
 
+	// Keep an untouched copy of the target for the plan, as the target content
+	// is augmented below with entities looked up from Kong.
+	var planTarget *file.Content
+	if dry && diffCmdOutPlan != "" {
+		planTarget = targetContent.DeepCopy()
+	}
+
 	cmd := "sync"
 	if applyType == ApplyTypePartial {
 		cmd = "apply"
@@ -355,6 +362,7 @@ func syncContent(ctx context.Context, targetContent *file.Content, dry bool, par
 		if err != nil {
 			return err
 		}
+		cacheSchemas(kongClient, rootConfig.Address, kongVersion)
 	}
 
 	schemaRegistry = schema.NewRegistry(kongClient, mode == modeKonnect)
@@ -367,6 +375,13 @@ func syncContent(ctx context.Context, targetContent *file.Content, dry bool, par
 	if err != nil {
 		return err
 	}
+	if planTarget != nil && len(dumpConfig.SelectorTags) > 0 {
+		// record tags given via --select-tag so that the plan is applied with them
+		if planTarget.Info == nil {
+			planTarget.Info = &file.Info{}
+		}
+		planTarget.Info.SelectorTags = dumpConfig.SelectorTags
+	}
 
 	dumpConfig.LookUpSelectorTagsConsumers, err = determineLookUpSelectorTagsConsumers(*targetContent)
 	if err != nil {
@@ -462,10 +477,15 @@ func syncContent(ctx context.Context, targetContent *file.Content, dry bool, par
 		dumpConfig.IsConsumerGroupScopedPluginSupported = true
 	}
 
-	// read the current state
-	var currentState *state.KongState
+	// read the current state, the target is resolved against the whole of it
+	var currentState, unfilteredState *state.KongState
+	currentRawState := &reconcilerUtils.KongRawState{}
 	if workspaceExists {
-		currentState, err = fetchCurrentState(ctx, kongClient, dumpConfig)
+		currentRawState, err = dump.Get(ctx, kongClient, dumpConfig)
+		if err != nil {
+			return err
+		}
+		unfilteredState, currentState, err = activeEntityFilter.currentStates(currentRawState)
 		if err != nil {
 			return err
 		}
@@ -475,7 +495,53 @@ func syncContent(ctx context.Context, targetContent *file.Content, dry bool, par
 		if err != nil {
 			return err
 		}
+		unfilteredState = currentState
+	}
+
+	// fingerprint the current state before diffing, as the diff updates it
+	var fingerprint string
+	if planTarget != nil || activePlan != nil {
+		fingerprint, err = stateFingerprint(currentState, kongVersion)
+		if err != nil {
+			return err
+		}
+		if activePlan != nil {
+			if err := verifyPlanFingerprint(activePlan, fingerprint); err != nil {
+				return err
+			}
+		}
+	}
+
+	// snapshot the current state before changing anything, so that the sync
+	// can be rolled back
+	var snapshot string
+	if !dry && (syncCmdSnapshot || syncCmdRollbackOnError) {
+		writeConfig := file.WriteConfig{
+			Workspace:                        workspaceName,
+			SelectTags:                       dumpConfig.SelectorTags,
+			KongVersion:                      kongVersion,
+			IsConsumerGroupPolicyOverrideSet: dumpConfig.IsConsumerGroupPolicyOverrideSet,
+			IncludePluginDefinitions:         dumpConfig.IncludePluginDefinitions,
+		}
+		if mode == modeKonnect {
+			writeConfig.ControlPlaneName = konnectControlPlane
+		}
+		// the change limits of the sync do not apply to its rollback
+		rules := currentPlanRules()
+		rules.MaxDeletesPerType = nil
+		snapshot, err = writeSnapshot(currentState, syncCmdSnapshotDir, snapshotInfo{
+			KongAddr: historyKongAddr(),
+			Rules:    rules,
+		}, writeConfig)
+		if err != nil {
+			return err
+		}
+		if !enableJSONOutput {
+			fmt.Fprintf(os.Stderr, "Snapshot written to %s\n", snapshot)
+		}
+	}
 
+	if !workspaceExists {
 		if enableJSONOutput {
 			workspace := diff.EntityState{
 				Name: wsConfig.Workspace,
@@ -498,13 +564,17 @@ func syncContent(ctx context.Context, targetContent *file.Content, dry bool, par
 	}
 	// read the target state
 	rawState, err := file.Get(ctx, targetContent, file.RenderConfig{
-		CurrentState:     currentState,
+		CurrentState:     unfilteredState,
 		KongVersion:      parsedKongVersion,
 		DiagnosticPolicy: diagnosticPolicy,
 	}, dumpConfig, kongClient)
 	if err != nil {
 		return err
 	}
+	activeEntityFilter.filterRawState(rawState)
+	if err := applyIgnoreFields(ignoreFieldRules(), currentRawState, rawState); err != nil {
+		return err
+	}
 	if err := checkForRBACResources(*rawState, dumpConfig.RBACResourcesOnly); err != nil {
 		return err
 	}
@@ -516,10 +586,37 @@ func syncContent(ctx context.Context, targetContent *file.Content, dry bool, par
 		return err
 	}
 
+	// check the changes against the change budget, protected entities and
+	// the plan being applied before making any change
+	budget, protection := syncChangeBudget(), syncProtection()
+	if !dry && (budget.enabled() || protection != nil || activeSyncEvents.needsTotal() || activePlan != nil) {
+		changes, err := previewChanges(ctx, currentRawState, rawState, parallelism,
+			kongClient, mode == modeKonnect, applyType)
+		if err != nil {
+			return err
+		}
+		if activePlan != nil {
+			if err := verifyPlanChanges(activePlan, changes); err != nil {
+				return err
+			}
+		}
+		activeSyncEvents.setTotal(changes)
+		if err := protection.check(changes); err != nil {
+			return reportProtectedEntities(err, enableJSONOutput)
+		}
+		if err := budget.check(changes, rawEntityCount(currentRawState)); err != nil {
+			return err
+		}
+	}
+
 	totalOps, err := performDiff(
 		ctx, currentState, targetState, dry, parallelism, delay, kongClient, mode == modeKonnect,
 		enableJSONOutput, applyType,
 	)
+	if err != nil && snapshot != "" && syncCmdRollbackOnError {
+		err = rollbackFailedSync(ctx, err, snapshot, kongClient, parsedKongVersion,
+			parallelism, delay, mode == modeKonnect, enableJSONOutput)
+	}
 	if err != nil {
 		if enableJSONOutput {
 			var errs reconcilerUtils.ErrArray
@@ -532,21 +629,50 @@ func syncContent(ctx context.Context, targetContent *file.Content, dry bool, par
 			return err
 		}
 	}
-	if diffCmdNonZeroExitCode && totalOps > 0 {
-		os.Exit(exitCodeDiffDetection)
+	if err == nil && !dry && applyType != ApplyTypePartial {
+		recordHistoryRevision(historyCommandSync, rawState, historyKongAddr(), workspaceName, kongVersion)
 	}
-	if enableJSONOutput {
-		jsonOutputBytes, jsonErr := json.MarshalIndent(jsonOutput, "", "\t")
-		if jsonErr != nil {
+	if planTarget != nil && err == nil {
+		plan, err := newSyncPlan(planTarget, workspaceName, fingerprint)
+		if err != nil {
+			return err
+		}
+		if err := writeSyncPlan(plan, diffCmdOutPlan); err != nil {
 			return err
 		}
-		jsonOutputString := string(jsonOutputBytes)
-		if !noMaskValues {
-			jsonOutputString = diff.MaskEnvVarValue(jsonOutputString)
+		if !enableJSONOutput {
+			fmt.Fprintf(os.Stderr, "Plan written to %s\n", diffCmdOutPlan)
+		}
+	}
+	if enableJSONOutput {
+		// the report is printed before exiting, for CI to get it when there
+		// are changes
+		if err := printJSONOutput(dry); err != nil {
+			return err
 		}
+	}
+	if diffCmdNonZeroExitCode && totalOps > 0 {
+		os.Exit(exitCodeDiffDetection)
+	}
+	return nil
+}
 
-		cprint.BluePrintLn(jsonOutputString + "\n")
+// printJSONOutput prints the JSON report, or the report format requested
+// for diffs.
+func printJSONOutput(dry bool) error {
+	reportFormat := diffOutputFormatJSON
+	if dry && diffCmdOutputFormat != "" && diffCmdOutputFormat != diffOutputFormatText {
+		reportFormat = diffCmdOutputFormat
 	}
+	jsonOutputString, err := renderDiffReport(jsonOutput, reportFormat)
+	if err != nil {
+		return err
+	}
+	if !noMaskValues {
+		jsonOutputString = maskDiffValues(jsonOutputString)
+	}
+
+	cprint.BluePrintLn(jsonOutputString + "\n")
 	return nil
 }
 
@@ -766,17 +892,17 @@ func determineSelectorTag(targetContent file.Content, config dump.Config) ([]str
 	return config.SelectorTags, nil
 }
 
-func fetchCurrentState(ctx context.Context, client *kong.Client, dumpConfig dump.Config) (*state.KongState, error) {
+// fetchCurrentRawState reads the configuration from Kong, narrowed down by
+// the entity filters.
+func fetchCurrentRawState(ctx context.Context, client *kong.Client,
+	dumpConfig dump.Config,
+) (*reconcilerUtils.KongRawState, error) {
 	rawState, err := dump.Get(ctx, client, dumpConfig)
 	if err != nil {
 		return nil, err
 	}
-
-	currentState, err := state.Get(rawState)
-	if err != nil {
-		return nil, err
-	}
-	return currentState, nil
+	activeEntityFilter.filterRawState(rawState)
+	return rawState, nil
 }
 
 // This function uses client.Server to check for the Server header in the response.
@@ -796,28 +922,58 @@ func performDiff(ctx context.Context, currentState, targetState *state.KongState
 	enableJSONOutput bool, applyType ApplyType,
 ) (int, error) {
 	shouldSkipDeletes := applyType == ApplyTypePartial
+	// events are recorded from the results of the changes, which the syncer
+	// reports instead of printing them. It does not report anything, nor
+	// closes its results, with an invalid parallelism.
+	recordEvents := !dry && activeSyncEvents != nil && parallelism > 0
 
 	s, err := diff.NewSyncer(diff.SyncerOpts{
-		CurrentState:       currentState,
-		TargetState:        targetState,
-		KongClient:         client,
-		StageDelaySec:      delay,
-		NoMaskValues:       noMaskValues,
-		IsKonnect:          isKonnect,
-		NoDeletes:          shouldSkipDeletes,
-		SkipSchemaDefaults: isKonnect && skipDefaultsFill,
-		SchemaRegistry:     schemaRegistry,
+		CurrentState:        currentState,
+		TargetState:         targetState,
+		KongClient:          client,
+		StageDelaySec:       delay,
+		NoMaskValues:        noMaskValues,
+		CreatePrintln:       maskingPrintln(cprint.CreatePrintln),
+		UpdatePrintln:       maskingPrintln(cprint.UpdatePrintln),
+		DeletePrintln:       maskingPrintln(cprint.DeletePrintln),
+		IsKonnect:           isKonnect,
+		NoDeletes:           shouldSkipDeletes,
+		SkipSchemaDefaults:  isKonnect && skipDefaultsFill,
+		SchemaRegistry:      schemaRegistry,
+		EnableEntityActions: recordEvents,
 	})
 	if err != nil {
 		return 0, err
 	}
 
-	stats, errs, changes := s.Solve(ctx, parallelism, dry, enableJSONOutput)
+	// changes are collected rather than printed when they are needed for
+	// the JSON report or for a plan file
+	collectChanges := enableJSONOutput || (dry && diffCmdOutPlan != "")
+
+	var recordedChanges func() diff.EntityChanges
+	if recordEvents {
+		stopEvents, err := activeSyncEvents.track(client)
+		if err != nil {
+			return 0, err
+		}
+		defer stopEvents()
+		recordedChanges = activeSyncEvents.consumeActions(s, collectChanges)
+	}
+
+	stats, errs, changes := s.Solve(ctx, parallelism, dry, collectChanges)
+	if recordedChanges != nil {
+		recorded := recordedChanges()
+		changes.Creating, changes.Updating, changes.Deleting = recorded.Creating, recorded.Updating, recorded.Deleting
+	}
 	totalOps := stats.CreateOps.Count() + stats.UpdateOps.Count() + stats.DeleteOps.Count()
 	// print stats before error to report completed operations
 	if !enableJSONOutput {
+		if collectChanges {
+			printPlanChanges(changes)
+		}
 		printStats(stats)
-	} else {
+	}
+	if collectChanges {
 		jsonOutput.Changes = diff.EntityChanges{
 			Creating:         append(jsonOutput.Changes.Creating, changes.Creating...),
 			Updating:         append(jsonOutput.Changes.Updating, changes.Updating...),
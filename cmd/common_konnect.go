@@ -7,6 +7,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/kong/go-database-reconciler/pkg/cprint"
 	"github.com/kong/go-database-reconciler/pkg/diff"
 	"github.com/kong/go-database-reconciler/pkg/dump"
 	"github.com/kong/go-database-reconciler/pkg/file"
@@ -236,6 +237,7 @@ func performKonnectDump(ctx context.Context, client *kong.Client, wsName string,
 	if err != nil {
 		return fmt.Errorf("reading configuration: %w", err)
 	}
+	activeEntityFilter.filterRawState(rawState)
 	ks, err := state.Get(rawState)
 	if err != nil {
 		return fmt.Errorf("building state: %w", err)
@@ -248,10 +250,11 @@ func performKonnectDump(ctx context.Context, client *kong.Client, wsName string,
 		cfg.Filename = dumpCmdKongStateFile
 	}
 
-	if cfg.SanitizeContent {
-		return sanitizeContent(ctx, client, ks, cfg, true)
+	if err := writeKongState(ctx, client, ks, cfg, true); err != nil {
+		return err
 	}
-	return file.KongStateToFile(ks, cfg)
+	recordHistoryRevision(historyCommandDump, rawState, historyKongAddr(), wsName, cfg.KongVersion)
+	return nil
 }
 
 func syncKonnect(ctx context.Context,
@@ -265,7 +268,7 @@ func syncKonnect(ctx context.Context,
 	}
 
 	// read target file
-	targetContent, err := file.GetContentFromFiles(filenames, false)
+	targetContent, _, err := readStateFiles(filenames)
 	if err != nil {
 		return err
 	}
@@ -335,6 +338,9 @@ func syncKonnect(ctx context.Context,
 		KongClient:    kongClient,
 		KonnectClient: konnectClient,
 		NoMaskValues:  noMaskValues,
+		CreatePrintln: maskingPrintln(cprint.CreatePrintln),
+		UpdatePrintln: maskingPrintln(cprint.UpdatePrintln),
+		DeletePrintln: maskingPrintln(cprint.DeletePrintln),
 	})
 	if err != nil {
 		return err
@@ -0,0 +1,351 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/kong/go-database-reconciler/pkg/file"
+	reconcilerUtils "github.com/kong/go-database-reconciler/pkg/utils"
+	"github.com/kong/go-kong/kong"
+	"github.com/spf13/pflag"
+)
+
+var (
+	ignoreFields []string
+	// activeIgnoreFields is built from the flag above by
+	// preRunIgnoreFieldFlags.
+	activeIgnoreFields []ignoreFieldRule
+	// stateFileIgnoreFields holds the rules of the _info.ignore_fields of the
+	// state files being synced.
+	stateFileIgnoreFields []ignoreFieldRule
+)
+
+// rawStateFields maps the entity types of state files to the fields of the
+// raw state holding them.
+var rawStateFields = map[string]string{
+	"services":                              "Services",
+	"routes":                                "Routes",
+	"plugins":                               "Plugins",
+	"filter_chains":                         "FilterChains",
+	"upstreams":                             "Upstreams",
+	"targets":                               "Targets",
+	"certificates":                          "Certificates",
+	"snis":                                  "SNIs",
+	"ca_certificates":                       "CACertificates",
+	"consumers":                             "Consumers",
+	"consumer_groups":                       "ConsumerGroups",
+	"vaults":                                "Vaults",
+	"licenses":                              "Licenses",
+	"partials":                              "Partials",
+	"keyauth_credentials":                   "KeyAuths",
+	"hmacauth_credentials":                  "HMACAuths",
+	"jwt_secrets":                           "JWTAuths",
+	"basicauth_credentials":                 "BasicAuths",
+	"acls":                                  "ACLGroups",
+	"oauth2_credentials":                    "Oauth2Creds",
+	"mtls_auth_credentials":                 "MTLSAuths",
+	"degraphql_routes":                      "DegraphqlRoutes",
+	"graphql_ratelimiting_cost_decorations": "GraphqlRateLimitingCostDecorations",
+	"rbac_roles":                            "RBACRoles",
+	"rbac_endpoint_permissions":             "RBACEndpointPermissions",
+	"keys":                                  "Keys",
+	"key_sets":                              "KeySets",
+	"cloned_plugins":                        "ClonedPluginDefinitions",
+	"custom_plugins":                        "CustomPluginDefinitions",
+	"ai_models":                             "AIModels",
+}
+
+// ignoreFieldRule is a field of an entity type that is managed outside of
+// decK: its value in Kong is kept, whatever the state files declare.
+type ignoreFieldRule struct {
+	entityType string
+	// path holds the keys leading to the field; "*" matches any key.
+	path []string
+}
+
+// String returns the rule in the 'type:path' form it is declared in.
+func (r ignoreFieldRule) String() string {
+	return r.entityType + ":" + strings.Join(r.path, ".")
+}
+
+func addIgnoreFieldFlags(set *pflag.FlagSet) {
+	set.StringArrayVar(&ignoreFields, "ignore-field", []string{},
+		"a field managed outside of decK, given as 'type:path', e.g. 'target:weight'\n"+
+			"or 'plugin:config.*.updated_at'. The value in Kong of the field is kept: it is\n"+
+			"not reported as a difference, and not overwritten. Can be repeated, and can\n"+
+			"also be set in the _info.ignore_fields of state files.")
+}
+
+func preRunIgnoreFieldFlags() error {
+	rules, err := parseIgnoreFieldRules("--ignore-field", ignoreFields)
+	if err != nil {
+		return err
+	}
+	activeIgnoreFields = rules
+	return nil
+}
+
+func parseIgnoreFieldRules(source string, values []string) ([]ignoreFieldRule, error) {
+	rules := make([]ignoreFieldRule, 0, len(values))
+	for _, value := range values {
+		t, fieldPath, ok := strings.Cut(value, ":")
+		if !ok || fieldPath == "" {
+			return nil, fmt.Errorf("invalid %s value %q, expected 'type:path', e.g. 'target:weight'", source, value)
+		}
+		entityType, err := parseEntityFilterType(source, t)
+		if err != nil {
+			return nil, err
+		}
+		path := strings.Split(fieldPath, ".")
+		for _, key := range path {
+			if key == "" {
+				return nil, fmt.Errorf("invalid %s path %q", source, fieldPath)
+			}
+		}
+		if path[0] == "id" {
+			return nil, fmt.Errorf("invalid %s value %q, the ID of entities cannot be ignored", source, value)
+		}
+		rules = append(rules, ignoreFieldRule{entityType: entityType, path: path})
+	}
+	return rules, nil
+}
+
+// ignoreFieldRules returns the rules of the flags and of the state files.
+func ignoreFieldRules() []ignoreFieldRule {
+	rules := make([]ignoreFieldRule, 0, len(activeIgnoreFields)+len(stateFileIgnoreFields))
+	rules = append(rules, activeIgnoreFields...)
+	return append(rules, stateFileIgnoreFields...)
+}
+
+// applyIgnoreFields sets the ignored fields of the entities of target to
+// their value in current, so that the diff treats them as equal and a sync
+// writes back the value in Kong. Entities are matched on their IDs, which
+// are filled in from the current state when the target state is built.
+func applyIgnoreFields(rules []ignoreFieldRule, current, target *reconcilerUtils.KongRawState) error {
+	if len(rules) == 0 || current == nil || target == nil {
+		return nil
+	}
+	byType := map[string][][]string{}
+	for _, rule := range rules {
+		byType[rule.entityType] = append(byType[rule.entityType], rule.path)
+	}
+	currentValue, targetValue := reflect.ValueOf(current).Elem(), reflect.ValueOf(target).Elem()
+	for entityType, paths := range byType {
+		field := rawStateFields[entityType]
+		currentEntities := map[string]reflect.Value{}
+		for _, e := range rawEntities(currentValue.FieldByName(field)) {
+			if id := rawEntityID(e); id != "" {
+				currentEntities[id] = e
+			}
+		}
+		for _, e := range rawEntities(targetValue.FieldByName(field)) {
+			c, ok := currentEntities[rawEntityID(e)]
+			if !ok {
+				continue
+			}
+			if err := keepCurrentFields(c, e, paths); err != nil {
+				return fmt.Errorf("ignoring fields of %s %s: %w", entityType, rawEntityID(e), err)
+			}
+		}
+	}
+	return nil
+}
+
+// rawEntities returns pointers to the entities of a raw state field.
+func rawEntities(field reflect.Value) []reflect.Value {
+	if !field.IsValid() || field.Kind() != reflect.Slice {
+		return nil
+	}
+	entities := make([]reflect.Value, 0, field.Len())
+	for i := 0; i < field.Len(); i++ {
+		e := field.Index(i)
+		if cg, ok := e.Interface().(*kong.ConsumerGroupObject); ok && cg != nil {
+			// consumer groups are wrapped with their consumers and plugins
+			e = reflect.ValueOf(cg.ConsumerGroup)
+		}
+		if e.Kind() == reflect.Ptr && !e.IsNil() && e.Elem().Kind() == reflect.Struct {
+			entities = append(entities, e)
+		}
+	}
+	return entities
+}
+
+func rawEntityID(e reflect.Value) string {
+	id := e.Elem().FieldByName("ID")
+	if !id.IsValid() || id.IsNil() {
+		return ""
+	}
+	s, _ := id.Interface().(*string)
+	return *s
+}
+
+// keepCurrentFields sets the fields of target at paths to their value in
+// current, or removes them when current does not set them.
+func keepCurrentFields(current, target reflect.Value, paths [][]string) error {
+	currentObj, err := toJSONObject(current.Interface())
+	if err != nil {
+		return err
+	}
+	targetObj, err := toJSONObject(target.Interface())
+	if err != nil {
+		return err
+	}
+	before, err := json.Marshal(targetObj)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		keepCurrentField(currentObj, targetObj, path)
+	}
+	after, err := json.Marshal(targetObj)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(before, after) {
+		return nil
+	}
+	updated := reflect.New(target.Elem().Type())
+	if err := json.Unmarshal(after, updated.Interface()); err != nil {
+		return err
+	}
+	target.Elem().Set(updated.Elem())
+	return nil
+}
+
+func keepCurrentField(current, target map[string]interface{}, path []string) {
+	keys := []string{path[0]}
+	if path[0] == "*" {
+		keys = keys[:0]
+		for key := range current {
+			keys = append(keys, key)
+		}
+		for key := range target {
+			if _, ok := current[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+	}
+	for _, key := range keys {
+		if len(path) == 1 {
+			if value, ok := current[key]; ok {
+				target[key] = value
+			} else {
+				delete(target, key)
+			}
+			continue
+		}
+		currentChild, ok := current[key].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		targetChild, ok := target[key].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		keepCurrentField(currentChild, targetChild, path[1:])
+	}
+}
+
+func toJSONObject(v interface{}) (map[string]interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var obj map[string]interface{}
+	if err := json.Unmarshal(b, &obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// readStateFiles reads state files like file.GetContentFromFiles does, and
+// returns the settings of their _info that the file format of decK does not
+// declare, see stateFileSettings. Files referring to secrets are rendered
+// first, see stateSecrets.
+func readStateFiles(filenames []string) (*file.Content, stateFileSettings, error) {
+	var settings stateFileSettings
+	files, err := readStateFileContents(filenames)
+	if err != nil {
+		return nil, settings, err
+	}
+	render := false
+	for _, f := range files {
+		render = render || usesStateSecrets(f.content)
+	}
+	envVarsMode := file.EnvVarsExpand
+	if render {
+		if err := (&stateTemplate{}).renderFiles(files); err != nil {
+			return nil, settings, err
+		}
+		// the environment variables are substituted when rendering the files
+		envVarsMode = file.EnvVarsSkip
+	}
+
+	var stageDir string
+	defer func() {
+		if stageDir != "" {
+			_ = os.RemoveAll(stageDir)
+		}
+	}()
+	staged := make([]string, 0, len(files))
+	for i, f := range files {
+		fileSettings, stripped, err := takeStateFileSettings(f.content)
+		if err != nil {
+			return nil, settings, fmt.Errorf("reading file %s: %w", f.name, err)
+		}
+		settings.merge(fileSettings)
+		b := f.content
+		if stripped != nil {
+			b = stripped
+		} else if !f.modified && f.name != "-" {
+			staged = append(staged, f.name)
+			continue
+		}
+
+		// stdin can only be read once, it is staged as the other files
+		// changed before reading them
+		if stageDir == "" {
+			if stageDir, err = os.MkdirTemp("", "deck-state-"); err != nil {
+				return nil, settings, fmt.Errorf("staging state file: %w", err)
+			}
+		}
+		filename := stagedFileName(stageDir, i, f.name)
+		if err := os.WriteFile(filename, b, 0o600); err != nil {
+			return nil, settings, fmt.Errorf("staging state file: %w", err)
+		}
+		staged = append(staged, filename)
+	}
+	if stageDir == "" {
+		// read the files as given, for the errors to refer to them
+		staged = filenames
+	}
+	content, err := file.GetContentFromFilesWithEnvVars(staged, envVarsMode)
+	if err != nil {
+		return nil, settings, err
+	}
+	return content, settings, nil
+}
+
+// stateFileNames returns the state files of a file or directory, "-" being
+// stdin.
+func stateFileNames(fileOrDir string) ([]string, error) {
+	if fileOrDir == "-" {
+		return []string{fileOrDir}, nil
+	}
+	info, err := os.Stat(fileOrDir)
+	if err != nil {
+		return nil, fmt.Errorf("reading state file: %w", err)
+	}
+	if !info.IsDir() {
+		return []string{fileOrDir}, nil
+	}
+	names, err := reconcilerUtils.ConfigFilesInDir(fileOrDir)
+	if err != nil {
+		return nil, fmt.Errorf("getting files from directory: %w", err)
+	}
+	return names, nil
+}
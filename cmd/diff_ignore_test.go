@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	reconcilerUtils "github.com/kong/go-database-reconciler/pkg/utils"
+	"github.com/kong/go-kong/kong"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseIgnoreFieldRules(t *testing.T) {
+	rules, err := parseIgnoreFieldRules("--ignore-field", []string{"target:weight", "Plugins:config.*.updated_at"})
+	require.NoError(t, err)
+	assert.Equal(t, []ignoreFieldRule{
+		{entityType: "targets", path: []string{"weight"}},
+		{entityType: "plugins", path: []string{"config", "*", "updated_at"}},
+	}, rules)
+
+	for value, message := range map[string]string{
+		"weight":            `invalid --ignore-field value "weight", expected 'type:path', e.g. 'target:weight'`,
+		"target:config..a":  `invalid --ignore-field path "config..a"`,
+		"service:id":        `invalid --ignore-field value "service:id", the ID of entities cannot be ignored`,
+		"workspace:comment": "",
+	} {
+		_, err := parseIgnoreFieldRules("--ignore-field", []string{value})
+		require.Error(t, err, value)
+		if message != "" {
+			assert.EqualError(t, err, message)
+		}
+	}
+}
+
+func TestApplyIgnoreFields(t *testing.T) {
+	current := &reconcilerUtils.KongRawState{
+		Targets: []*kong.Target{{ID: kong.String("t1"), Target: kong.String("a:80"), Weight: kong.Int(42)}},
+		Plugins: []*kong.Plugin{{
+			ID: kong.String("p1"), Name: kong.String("custom"),
+			Config: kong.Configuration{
+				"limits": map[string]interface{}{"updated_at": 2.0, "max": 10.0},
+				"quota":  map[string]interface{}{"max": 5.0},
+			},
+		}},
+	}
+	target := &reconcilerUtils.KongRawState{
+		Targets: []*kong.Target{
+			{ID: kong.String("t1"), Target: kong.String("a:80"), Weight: kong.Int(100)},
+			{ID: kong.String("t2"), Target: kong.String("b:80"), Weight: kong.Int(100)},
+		},
+		Plugins: []*kong.Plugin{{
+			ID: kong.String("p1"), Name: kong.String("custom"),
+			Config: kong.Configuration{
+				"limits": map[string]interface{}{"updated_at": 1.0, "max": 20.0},
+				"quota":  map[string]interface{}{"max": 5.0, "updated_at": 1.0},
+			},
+		}},
+	}
+	rules, err := parseIgnoreFieldRules("--ignore-field", []string{"target:weight", "plugin:config.*.updated_at"})
+	require.NoError(t, err)
+	require.NoError(t, applyIgnoreFields(rules, current, target))
+
+	assert.Equal(t, 42, *target.Targets[0].Weight)
+	// entities not in Kong yet are created as declared
+	assert.Equal(t, 100, *target.Targets[1].Weight)
+	assert.Equal(t, kong.Configuration{
+		"limits": map[string]interface{}{"updated_at": 2.0, "max": 20.0},
+		"quota":  map[string]interface{}{"max": 5.0},
+	}, target.Plugins[0].Config)
+}
+
+func TestReadStateFilesIgnoreFields(t *testing.T) {
+	dir := t.TempDir()
+	withRules := filepath.Join(dir, "upstreams.yaml")
+	require.NoError(t, os.WriteFile(withRules, []byte(`_format_version: "3.0"
+_info:
+  select_tags: [team-a]
+  ignore_fields:
+  - target:weight
+upstreams:
+- name: orders
+  targets:
+  - target: a:80
+    weight: 100
+`), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "services.yaml"), []byte(`_format_version: "3.0"
+services:
+- name: orders
+  host: orders
+`), 0o600))
+
+	content, settings, err := readStateFiles([]string{dir})
+	require.NoError(t, err)
+	assert.Equal(t, []ignoreFieldRule{{entityType: "targets", path: []string{"weight"}}}, settings.ignoreFields)
+	assert.Equal(t, []string{"team-a"}, content.Info.SelectorTags)
+	require.Len(t, content.Upstreams, 1)
+	require.Len(t, content.Services, 1)
+
+	_, _, err = readStateFiles([]string{writeTestFile(t, "kong.yaml",
+		"_format_version: \"3.0\"\n_info:\n  ignore_fields: [weight]\n")})
+	require.ErrorContains(t, err, `invalid _info.ignore_fields value "weight"`)
+}
+
+func TestReadStateFilesMaxDeletesPerType(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "routes.yaml"), []byte(`_format_version: "3.0"
+_info:
+  select_tags:
+  - team-a
+  max_deletes_per_type:
+    route: 10
+    service: 2
+services:
+- name: orders
+  host: orders
+`), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "plugins.yaml"), []byte(`_format_version: "3.0"
+_info:
+  max_deletes_per_type:
+    route: 3
+  ignore_fields:
+  - target:weight
+`), 0o600))
+
+	content, settings, err := readStateFiles([]string{dir})
+	require.NoError(t, err)
+	// the lowest limit of the files wins
+	assert.Equal(t, map[string]int{"route": 3, "service": 2}, settings.maxDeletesPerType)
+	assert.Len(t, settings.ignoreFields, 1)
+	assert.Equal(t, []string{"team-a"}, content.Info.SelectorTags)
+	require.Len(t, content.Services, 1)
+
+	for _, invalid := range []string{"route: -1", "route: 1.5", "route: many"} {
+		_, _, err = readStateFiles([]string{writeTestFile(t, "kong.yaml",
+			"_format_version: \"3.0\"\n_info:\n  max_deletes_per_type:\n    "+invalid+"\n")})
+		require.ErrorContains(t, err, `invalid _info.max_deletes_per_type limit for "route"`)
+	}
+	_, _, err = readStateFiles([]string{writeTestFile(t, "kong.yaml",
+		"_format_version: \"3.0\"\n_info:\n  max_deletes_per_type: [route]\n")})
+	require.ErrorContains(t, err, "_info.max_deletes_per_type must map entity kinds to limits")
+}
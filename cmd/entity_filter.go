@@ -0,0 +1,398 @@
+package cmd
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/kong/go-database-reconciler/pkg/state"
+	reconcilerUtils "github.com/kong/go-database-reconciler/pkg/utils"
+	"github.com/kong/go-kong/kong"
+	"github.com/spf13/pflag"
+)
+
+var (
+	entityFilterIncludeTypes []string
+	entityFilterExcludeTypes []string
+	entityFilterOnly         []string
+	// activeEntityFilter is built from the flags above by preRunEntityFilterFlags;
+	// nil means no filtering.
+	activeEntityFilter *entityFilter
+)
+
+// entityFilterTypes maps the singular form of every entity type that can be
+// filtered to the plural form used in state files.
+var entityFilterTypes = map[string]string{
+	"service":                              "services",
+	"route":                                "routes",
+	"plugin":                               "plugins",
+	"filter_chain":                         "filter_chains",
+	"upstream":                             "upstreams",
+	"target":                               "targets",
+	"certificate":                          "certificates",
+	"sni":                                  "snis",
+	"ca_certificate":                       "ca_certificates",
+	"consumer":                             "consumers",
+	"consumer_group":                       "consumer_groups",
+	"vault":                                "vaults",
+	"license":                              "licenses",
+	"partial":                              "partials",
+	"keyauth_credential":                   "keyauth_credentials",
+	"hmacauth_credential":                  "hmacauth_credentials",
+	"jwt_secret":                           "jwt_secrets",
+	"basicauth_credential":                 "basicauth_credentials",
+	"acl":                                  "acls",
+	"oauth2_credential":                    "oauth2_credentials",
+	"mtls_auth_credential":                 "mtls_auth_credentials",
+	"degraphql_route":                      "degraphql_routes",
+	"graphql_ratelimiting_cost_decoration": "graphql_ratelimiting_cost_decorations",
+	"rbac_role":                            "rbac_roles",
+	"rbac_endpoint_permission":             "rbac_endpoint_permissions",
+	"key":                                  "keys",
+	"key_set":                              "key_sets",
+	"cloned_plugin":                        "cloned_plugins",
+	"custom_plugin":                        "custom_plugins",
+	"ai_model":                             "ai_models",
+}
+
+// entityFilter narrows a state down to a slice of entities, selected by
+// entity type and by name or ID globs.
+type entityFilter struct {
+	include map[string]bool
+	exclude map[string]bool
+	// only holds the name/ID globs per entity type. Types without globs are
+	// not restricted.
+	only map[string][]string
+}
+
+func addEntityFilterFlags(set *pflag.FlagSet) {
+	set.StringSliceVar(&entityFilterIncludeTypes, "include-entity-type", []string{},
+		"only operate on entities of the given types, e.g. 'services,routes,plugins'.\n"+
+			"All other entity types are left untouched.")
+	set.StringSliceVar(&entityFilterExcludeTypes, "exclude-entity-type", []string{},
+		"do not operate on entities of the given types, e.g. 'consumers'.")
+	set.StringArrayVar(&entityFilterOnly, "only", []string{},
+		"only operate on entities whose name or ID matches a glob, given as\n"+
+			"'type:glob', e.g. 'service:payments-*'. Can be repeated; entities of a\n"+
+			"type match if they match any of its globs. Entities that belong to a\n"+
+			"filtered-out entity (routes of a service, plugins, credentials, ...)\n"+
+			"are filtered out as well.")
+}
+
+func preRunEntityFilterFlags() error {
+	filter, err := newEntityFilter(entityFilterIncludeTypes, entityFilterExcludeTypes, entityFilterOnly)
+	if err != nil {
+		return err
+	}
+	activeEntityFilter = filter
+	return nil
+}
+
+// newEntityFilter validates the filter flags. It returns nil when no filter
+// was requested.
+func newEntityFilter(include, exclude, only []string) (*entityFilter, error) {
+	if len(include) == 0 && len(exclude) == 0 && len(only) == 0 {
+		return nil, nil
+	}
+	f := &entityFilter{
+		include: map[string]bool{},
+		exclude: map[string]bool{},
+		only:    map[string][]string{},
+	}
+	for _, t := range include {
+		entityType, err := parseEntityFilterType("--include-entity-type", t)
+		if err != nil {
+			return nil, err
+		}
+		f.include[entityType] = true
+	}
+	for _, t := range exclude {
+		entityType, err := parseEntityFilterType("--exclude-entity-type", t)
+		if err != nil {
+			return nil, err
+		}
+		if f.include[entityType] {
+			return nil, fmt.Errorf("entity type %q cannot be both included and excluded", entityType)
+		}
+		f.exclude[entityType] = true
+	}
+	for _, o := range only {
+		t, glob, ok := strings.Cut(o, ":")
+		if !ok || glob == "" {
+			return nil, fmt.Errorf("invalid --only value %q, expected 'type:glob'", o)
+		}
+		entityType, err := parseEntityFilterType("--only", t)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, fmt.Errorf("invalid --only glob %q: %w", glob, err)
+		}
+		f.only[entityType] = append(f.only[entityType], glob)
+	}
+	return f, nil
+}
+
+func parseEntityFilterType(flag, t string) (string, error) {
+	t = strings.ToLower(strings.TrimSpace(strings.ReplaceAll(t, "-", "_")))
+	if plural, ok := entityFilterTypes[t]; ok {
+		return plural, nil
+	}
+	for _, plural := range entityFilterTypes {
+		if t == plural {
+			return plural, nil
+		}
+	}
+	types := make([]string, 0, len(entityFilterTypes))
+	for _, plural := range entityFilterTypes {
+		types = append(types, plural)
+	}
+	sort.Strings(types)
+	return "", fmt.Errorf("invalid %s entity type %q, must be one of: %s", flag, t, strings.Join(types, ", "))
+}
+
+func (f *entityFilter) typeIncluded(entityType string) bool {
+	if len(f.include) > 0 && !f.include[entityType] {
+		return false
+	}
+	return !f.exclude[entityType]
+}
+
+func (f *entityFilter) nameMatches(entityType string, id *string, names ...*string) bool {
+	globs, ok := f.only[entityType]
+	if !ok {
+		return true
+	}
+	candidates := append([]*string{id}, names...)
+	for _, glob := range globs {
+		for _, c := range candidates {
+			if c == nil {
+				continue
+			}
+			// patterns were validated in newEntityFilter
+			if ok, _ := path.Match(glob, *c); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// filterRawState removes all entities from raw that do not pass the filter.
+// The same filter is applied to the current and the target state, so that
+// entities outside of the filter are neither created, updated nor deleted.
+//
+// Entities that are removed by a name filter take their children with them:
+// routes of a removed service, plugins scoped to a removed service, route,
+// consumer or consumer group, credentials of a removed consumer and so on.
+// Removing a whole entity type does not cascade.
+func (f *entityFilter) filterRawState(raw *reconcilerUtils.KongRawState) {
+	if f == nil || raw == nil {
+		return
+	}
+	dropped := map[string]bool{}
+	// keep reports whether an entity passes the filter and remembers the IDs
+	// of entities removed by name, or because their parent was removed.
+	keep := func(entityType string, id *string, parents []*string, names ...*string) bool {
+		if !f.typeIncluded(entityType) {
+			return false
+		}
+		for _, p := range parents {
+			if p != nil && dropped[*p] {
+				if id != nil {
+					dropped[*id] = true
+				}
+				return false
+			}
+		}
+		if !f.nameMatches(entityType, id, names...) {
+			if id != nil {
+				dropped[*id] = true
+			}
+			return false
+		}
+		return true
+	}
+
+	// parents are filtered before their children
+	raw.Services = filterSlice(raw.Services, func(s *kong.Service) bool {
+		return keep("services", s.ID, nil, s.Name)
+	})
+	raw.Routes = filterSlice(raw.Routes, func(r *kong.Route) bool {
+		return keep("routes", r.ID, []*string{serviceID(r.Service)}, r.Name)
+	})
+	raw.Upstreams = filterSlice(raw.Upstreams, func(u *kong.Upstream) bool {
+		return keep("upstreams", u.ID, nil, u.Name)
+	})
+	raw.Targets = filterSlice(raw.Targets, func(t *kong.Target) bool {
+		var upstream *string
+		if t.Upstream != nil {
+			upstream = t.Upstream.ID
+		}
+		return keep("targets", t.ID, []*string{upstream}, t.Target)
+	})
+	raw.CACertificates = filterSlice(raw.CACertificates, func(c *kong.CACertificate) bool {
+		return keep("ca_certificates", c.ID, nil)
+	})
+	raw.Certificates = filterSlice(raw.Certificates, func(c *kong.Certificate) bool {
+		return keep("certificates", c.ID, nil)
+	})
+	raw.SNIs = filterSlice(raw.SNIs, func(s *kong.SNI) bool {
+		var certificate *string
+		if s.Certificate != nil {
+			certificate = s.Certificate.ID
+		}
+		return keep("snis", s.ID, []*string{certificate}, s.Name)
+	})
+	raw.Consumers = filterSlice(raw.Consumers, func(c *kong.Consumer) bool {
+		return keep("consumers", c.ID, nil, c.Username, c.CustomID)
+	})
+	raw.ConsumerGroups = filterSlice(raw.ConsumerGroups, func(cg *kong.ConsumerGroupObject) bool {
+		if cg.ConsumerGroup == nil {
+			return f.typeIncluded("consumer_groups")
+		}
+		return keep("consumer_groups", cg.ConsumerGroup.ID, nil, cg.ConsumerGroup.Name)
+	})
+	for _, cg := range raw.ConsumerGroups {
+		cg.Consumers = filterSlice(cg.Consumers, func(c *kong.Consumer) bool {
+			return f.typeIncluded("consumers") && (c.ID == nil || !dropped[*c.ID])
+		})
+	}
+	raw.KeySets = filterSlice(raw.KeySets, func(s *kong.KeySet) bool {
+		return keep("key_sets", s.ID, nil, s.Name)
+	})
+	raw.Keys = filterSlice(raw.Keys, func(k *kong.Key) bool {
+		var set *string
+		if k.Set != nil {
+			set = k.Set.ID
+		}
+		return keep("keys", k.ID, []*string{set}, k.Name, k.KID)
+	})
+	raw.Partials = filterSlice(raw.Partials, func(p *kong.Partial) bool {
+		return keep("partials", p.ID, nil, p.Name)
+	})
+	raw.Vaults = filterSlice(raw.Vaults, func(v *kong.Vault) bool {
+		return keep("vaults", v.ID, nil, v.Prefix, v.Name)
+	})
+	raw.Licenses = filterSlice(raw.Licenses, func(l *kong.License) bool {
+		return keep("licenses", l.ID, nil)
+	})
+	raw.RBACRoles = filterSlice(raw.RBACRoles, func(r *kong.RBACRole) bool {
+		return keep("rbac_roles", r.ID, nil, r.Name)
+	})
+	raw.RBACEndpointPermissions = filterSlice(raw.RBACEndpointPermissions, func(p *kong.RBACEndpointPermission) bool {
+		var role *string
+		if p.Role != nil {
+			role = p.Role.ID
+		}
+		return keep("rbac_endpoint_permissions", nil, []*string{role}, p.Endpoint)
+	})
+	raw.ClonedPluginDefinitions = filterSlice(raw.ClonedPluginDefinitions, func(p *kong.ClonedPluginDefinition) bool {
+		return keep("cloned_plugins", p.ID, nil, p.Name)
+	})
+	raw.CustomPluginDefinitions = filterSlice(raw.CustomPluginDefinitions, func(p *kong.CustomPluginDefinition) bool {
+		return keep("custom_plugins", p.ID, nil, p.Name)
+	})
+	raw.AIModels = filterSlice(raw.AIModels, func(m *kong.AIModel) bool {
+		return keep("ai_models", m.ID, nil, m.Name)
+	})
+
+	// children of services, routes and consumers
+	raw.Plugins = filterSlice(raw.Plugins, func(p *kong.Plugin) bool {
+		var consumerGroup *string
+		if p.ConsumerGroup != nil {
+			consumerGroup = p.ConsumerGroup.ID
+		}
+		return keep("plugins", p.ID, []*string{
+			serviceID(p.Service), routeID(p.Route), consumerID(p.Consumer), consumerGroup,
+		}, p.Name, p.InstanceName)
+	})
+	raw.FilterChains = filterSlice(raw.FilterChains, func(c *kong.FilterChain) bool {
+		return keep("filter_chains", c.ID, []*string{serviceID(c.Service), routeID(c.Route)}, c.Name)
+	})
+	raw.DegraphqlRoutes = filterSlice(raw.DegraphqlRoutes, func(r *kong.DegraphqlRoute) bool {
+		return keep("degraphql_routes", r.ID, []*string{serviceID(r.Service)}, r.URI)
+	})
+	raw.GraphqlRateLimitingCostDecorations = filterSlice(raw.GraphqlRateLimitingCostDecorations,
+		func(d *kong.GraphqlRateLimitingCostDecoration) bool {
+			return keep("graphql_ratelimiting_cost_decorations", d.ID, []*string{serviceID(d.Service)}, d.TypePath)
+		})
+	raw.KeyAuths = filterSlice(raw.KeyAuths, func(c *kong.KeyAuth) bool {
+		return keep("keyauth_credentials", c.ID, []*string{consumerID(c.Consumer)})
+	})
+	raw.HMACAuths = filterSlice(raw.HMACAuths, func(c *kong.HMACAuth) bool {
+		return keep("hmacauth_credentials", c.ID, []*string{consumerID(c.Consumer)}, c.Username)
+	})
+	raw.JWTAuths = filterSlice(raw.JWTAuths, func(c *kong.JWTAuth) bool {
+		return keep("jwt_secrets", c.ID, []*string{consumerID(c.Consumer)}, c.Key)
+	})
+	raw.BasicAuths = filterSlice(raw.BasicAuths, func(c *kong.BasicAuthOptions) bool {
+		return keep("basicauth_credentials", c.ID, []*string{consumerID(c.Consumer)}, c.Username)
+	})
+	raw.ACLGroups = filterSlice(raw.ACLGroups, func(c *kong.ACLGroup) bool {
+		return keep("acls", c.ID, []*string{consumerID(c.Consumer)}, c.Group)
+	})
+	raw.Oauth2Creds = filterSlice(raw.Oauth2Creds, func(c *kong.Oauth2Credential) bool {
+		return keep("oauth2_credentials", c.ID, []*string{consumerID(c.Consumer)}, c.Name, c.ClientID)
+	})
+	raw.MTLSAuths = filterSlice(raw.MTLSAuths, func(c *kong.MTLSAuth) bool {
+		return keep("mtls_auth_credentials", c.ID, []*string{consumerID(c.Consumer)}, c.SubjectName)
+	})
+}
+
+// currentStates builds the current state of a diff from raw. It returns the
+// unfiltered state, that the target is resolved against so that references
+// to entities outside of the filter keep their IDs (the service of a route
+// when services are excluded, for example), and the filtered state, that the
+// filtered target is diffed against. raw is narrowed down by the filter.
+func (f *entityFilter) currentStates(raw *reconcilerUtils.KongRawState) (
+	unfiltered, current *state.KongState, err error,
+) {
+	if f == nil {
+		current, err = state.Get(raw)
+		return current, current, err
+	}
+	if unfiltered, err = state.Get(raw); err != nil {
+		return nil, nil, err
+	}
+	f.filterRawState(raw)
+	if current, err = state.Get(raw); err != nil {
+		return nil, nil, err
+	}
+	return unfiltered, current, nil
+}
+
+func filterSlice[T any](entities []T, keep func(T) bool) []T {
+	if entities == nil {
+		return nil
+	}
+	kept := entities[:0]
+	for _, e := range entities {
+		if keep(e) {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
+
+func serviceID(s *kong.Service) *string {
+	if s == nil {
+		return nil
+	}
+	return s.ID
+}
+
+func routeID(r *kong.Route) *string {
+	if r == nil {
+		return nil
+	}
+	return r.ID
+}
+
+func consumerID(c *kong.Consumer) *string {
+	if c == nil {
+		return nil
+	}
+	return c.ID
+}
@@ -0,0 +1,200 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kong/go-database-reconciler/pkg/dump"
+	"github.com/kong/go-database-reconciler/pkg/file"
+	reconcilerUtils "github.com/kong/go-database-reconciler/pkg/utils"
+	"github.com/kong/go-kong/kong"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewEntityFilter(t *testing.T) {
+	f, err := newEntityFilter(nil, nil, nil)
+	require.NoError(t, err)
+	assert.Nil(t, f)
+
+	f, err = newEntityFilter([]string{"service", "routes", "key-sets"}, []string{"consumers"},
+		[]string{"service:payments-*", "services:billing"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"services": true, "routes": true, "key_sets": true}, f.include)
+	assert.Equal(t, map[string]bool{"consumers": true}, f.exclude)
+	assert.Equal(t, map[string][]string{"services": {"payments-*", "billing"}}, f.only)
+
+	_, err = newEntityFilter([]string{"servces"}, nil, nil)
+	require.ErrorContains(t, err, `invalid --include-entity-type entity type "servces"`)
+
+	_, err = newEntityFilter([]string{"routes"}, []string{"route"}, nil)
+	require.EqualError(t, err, `entity type "routes" cannot be both included and excluded`)
+
+	_, err = newEntityFilter(nil, nil, []string{"payments-*"})
+	require.EqualError(t, err, `invalid --only value "payments-*", expected 'type:glob'`)
+
+	_, err = newEntityFilter(nil, nil, []string{"service:[payments"})
+	require.ErrorContains(t, err, `invalid --only glob "[payments"`)
+}
+
+func testFilterRawState() *reconcilerUtils.KongRawState {
+	paymentsRef := &kong.Service{ID: kong.String("payments-id")}
+	ordersRef := &kong.Service{ID: kong.String("orders-id")}
+	return &reconcilerUtils.KongRawState{
+		Services: []*kong.Service{
+			{ID: kong.String("payments-id"), Name: kong.String("payments-api")},
+			{ID: kong.String("orders-id"), Name: kong.String("orders-api")},
+		},
+		Routes: []*kong.Route{
+			{ID: kong.String("r1"), Name: kong.String("pay"), Service: paymentsRef},
+			{ID: kong.String("r2"), Name: kong.String("order"), Service: ordersRef},
+		},
+		Plugins: []*kong.Plugin{
+			{ID: kong.String("p1"), Name: kong.String("rate-limiting"), Route: &kong.Route{ID: kong.String("r2")}},
+			{ID: kong.String("p2"), Name: kong.String("cors"), Service: paymentsRef},
+			{ID: kong.String("p3"), Name: kong.String("prometheus")},
+		},
+		Consumers: []*kong.Consumer{
+			{ID: kong.String("c1"), Username: kong.String("alice")},
+			{ID: kong.String("c2"), Username: kong.String("bob")},
+		},
+		ConsumerGroups: []*kong.ConsumerGroupObject{{
+			ConsumerGroup: &kong.ConsumerGroup{ID: kong.String("cg1"), Name: kong.String("gold")},
+			Consumers:     []*kong.Consumer{{ID: kong.String("c1")}, {ID: kong.String("c2")}},
+		}},
+		KeyAuths: []*kong.KeyAuth{
+			{ID: kong.String("k1"), Consumer: &kong.Consumer{ID: kong.String("c1")}},
+			{ID: kong.String("k2"), Consumer: &kong.Consumer{ID: kong.String("c2")}},
+		},
+	}
+}
+
+func TestEntityFilter_FilterRawState(t *testing.T) {
+	t.Run("include types", func(t *testing.T) {
+		f, err := newEntityFilter([]string{"services", "routes"}, nil, nil)
+		require.NoError(t, err)
+		raw := testFilterRawState()
+		f.filterRawState(raw)
+		assert.Len(t, raw.Services, 2)
+		assert.Len(t, raw.Routes, 2)
+		assert.Empty(t, raw.Plugins)
+		assert.Empty(t, raw.Consumers)
+		assert.Empty(t, raw.ConsumerGroups)
+		assert.Empty(t, raw.KeyAuths)
+	})
+
+	t.Run("exclude types does not cascade", func(t *testing.T) {
+		f, err := newEntityFilter(nil, []string{"services"}, nil)
+		require.NoError(t, err)
+		raw := testFilterRawState()
+		f.filterRawState(raw)
+		assert.Empty(t, raw.Services)
+		assert.Len(t, raw.Routes, 2)
+		assert.Len(t, raw.Plugins, 3)
+	})
+
+	t.Run("name globs cascade to children", func(t *testing.T) {
+		f, err := newEntityFilter(nil, nil, []string{"service:payments-*", "consumer:alice"})
+		require.NoError(t, err)
+		raw := testFilterRawState()
+		f.filterRawState(raw)
+		require.Len(t, raw.Services, 1)
+		assert.Equal(t, "payments-api", *raw.Services[0].Name)
+		require.Len(t, raw.Routes, 1)
+		assert.Equal(t, "pay", *raw.Routes[0].Name)
+		// p1 belongs to a route of orders-api, p3 is global
+		require.Len(t, raw.Plugins, 2)
+		assert.Equal(t, "p2", *raw.Plugins[0].ID)
+		assert.Equal(t, "p3", *raw.Plugins[1].ID)
+		require.Len(t, raw.Consumers, 1)
+		require.Len(t, raw.KeyAuths, 1)
+		assert.Equal(t, "k1", *raw.KeyAuths[0].ID)
+		require.Len(t, raw.ConsumerGroups, 1)
+		require.Len(t, raw.ConsumerGroups[0].Consumers, 1)
+		assert.Equal(t, "c1", *raw.ConsumerGroups[0].Consumers[0].ID)
+	})
+
+	t.Run("IDs match globs", func(t *testing.T) {
+		f, err := newEntityFilter(nil, nil, []string{"route:r2"})
+		require.NoError(t, err)
+		raw := testFilterRawState()
+		f.filterRawState(raw)
+		require.Len(t, raw.Routes, 1)
+		assert.Equal(t, "order", *raw.Routes[0].Name)
+		assert.Len(t, raw.Services, 2)
+	})
+
+	t.Run("nil filter", func(t *testing.T) {
+		var f *entityFilter
+		raw := testFilterRawState()
+		f.filterRawState(raw)
+		assert.Len(t, raw.Plugins, 3)
+	})
+}
+
+func TestEntityFilter_CurrentStates(t *testing.T) {
+	// the target of a sync with the same services, routes and plugins as
+	// testFilterRawState, without IDs
+	target := &file.Content{
+		FormatVersion: "3.0",
+		Services: []file.FService{
+			{
+				Service: kong.Service{Name: kong.String("payments-api")},
+				Routes:  []*file.FRoute{{Route: kong.Route{Name: kong.String("pay")}}},
+			},
+			{
+				Service: kong.Service{Name: kong.String("orders-api")},
+				Routes: []*file.FRoute{{
+					Route:   kong.Route{Name: kong.String("order")},
+					Plugins: []*file.FPlugin{{Plugin: kong.Plugin{Name: kong.String("rate-limiting")}}},
+				}},
+			},
+		},
+	}
+
+	for _, tc := range []struct {
+		name             string
+		include, exclude []string
+	}{
+		{name: "services excluded", exclude: []string{"services"}},
+		{name: "routes and plugins included", include: []string{"routes", "plugins"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := newEntityFilter(tc.include, tc.exclude, nil)
+			require.NoError(t, err)
+			currentRaw := testFilterRawState()
+			currentRaw.KeyAuths[0].Key = kong.String("alice-key")
+			currentRaw.KeyAuths[1].Key = kong.String("bob-key")
+			unfiltered, current, err := f.currentStates(currentRaw)
+			require.NoError(t, err)
+			services, err := current.Services.GetAll()
+			require.NoError(t, err)
+			assert.Empty(t, services)
+
+			raw, err := file.Get(context.Background(), target.DeepCopy(), file.RenderConfig{
+				CurrentState: unfiltered,
+				KongVersion:  reconcilerUtils.Kong300Version,
+			}, dump.Config{}, nil)
+			require.NoError(t, err)
+			f.filterRawState(raw)
+
+			assert.Empty(t, raw.Services)
+			require.Len(t, raw.Routes, 2)
+			routes := map[string]*kong.Route{}
+			for _, r := range raw.Routes {
+				routes[*r.Name] = r
+			}
+			// routes keep their IDs and the IDs of their services, which are
+			// outside of the filter
+			assert.Equal(t, "r1", *routes["pay"].ID)
+			assert.Equal(t, "payments-id", *routes["pay"].Service.ID)
+			assert.Equal(t, "r2", *routes["order"].ID)
+			assert.Equal(t, "orders-id", *routes["order"].Service.ID)
+			if f.typeIncluded("plugins") {
+				require.Len(t, raw.Plugins, 1)
+				assert.Equal(t, "p1", *raw.Plugins[0].ID)
+				assert.Equal(t, "r2", *raw.Plugins[0].Route.ID)
+			}
+		})
+	}
+}
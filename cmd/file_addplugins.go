@@ -44,57 +44,20 @@ func executeAddPlugins(cmd *cobra.Command, cfgFiles []string) error {
 		}
 	}
 
-	var pluginFiles []plugins.DeckPluginFile
-	{
-		for _, filename := range cfgFiles {
-			var file plugins.DeckPluginFile
-			if err := file.ParseFile(filename); err != nil {
-				return fmt.Errorf("failed to parse plugin file '%s'; %w", filename, err)
-			}
-			pluginFiles = append(pluginFiles, file)
-		}
-	}
-
 	// do the work: read/add-plugins/write
 	jsondata, err := filebasics.DeserializeFile(cmdAddPluginsInputFilename)
 	if err != nil {
 		return fmt.Errorf("failed to read input file '%s'; %w", cmdAddPluginsInputFilename, err)
 	}
-	yamlNode := jsonbasics.ConvertToYamlNode(jsondata)
-
-	// apply CLI flags
-	plugger := plugins.Plugger{}
-	plugger.SetYamlData(yamlNode)
-	err = plugger.SetSelectors(cmdAddPluginsSelectors)
-	if err != nil {
-		return fmt.Errorf("failed to set selectors; %w", err)
-	}
-	err = plugger.AddPlugins(pluginConfigs, cmdAddPluginsOverwrite)
-	if err != nil {
-		return fmt.Errorf("failed to add plugins; %w", err)
-	}
-	yamlNode = plugger.GetYamlData()
-
-	// apply plugin-files
-	for i, pluginFile := range pluginFiles {
-		err = pluginFile.Apply(yamlNode)
-		if err != nil {
-			return fmt.Errorf("failed to apply plugin file '%s'; %w", cfgFiles[i], err)
-		}
-	}
-	jsondata = plugger.GetData()
 
 	trackInfo := deckformat.HistoryNewEntry("add-plugins")
 	trackInfo["input"] = cmdAddPluginsInputFilename
 	trackInfo["output"] = cmdAddPluginOutputFilename
-	trackInfo["overwrite"] = cmdAddPluginsOverwrite
-	if len(pluginConfigs) > 0 {
-		trackInfo["configs"] = pluginConfigs
-	}
-	if len(cfgFiles) > 0 {
-		trackInfo["pluginfiles"] = cfgFiles
+	jsondata, err = addPlugins(jsondata, cmdAddPluginsSelectors, pluginConfigs, cmdAddPluginsOverwrite,
+		cfgFiles, trackInfo)
+	if err != nil {
+		return err
 	}
-	trackInfo["selectors"] = cmdAddPluginsSelectors
 	deckformat.HistoryAppend(jsondata, trackInfo)
 
 	return filebasics.WriteSerializedFile(
@@ -103,6 +66,49 @@ func executeAddPlugins(cmd *cobra.Command, cfgFiles []string) error {
 		filebasics.OutputFormat(cmdAddPluginOutputFormat))
 }
 
+// addPlugins adds the plugin configs to the objects of data that match the
+// selectors, and applies the plugin files. It is shared by the add-plugins
+// command and pipeline step. The options are recorded in trackInfo.
+func addPlugins(
+	data map[string]interface{}, selectors []string, pluginConfigs []map[string]interface{}, overwrite bool,
+	pluginFilenames []string, trackInfo map[string]interface{},
+) (map[string]interface{}, error) {
+	trackInfo["overwrite"] = overwrite
+	if len(pluginConfigs) > 0 {
+		trackInfo["configs"] = pluginConfigs
+	}
+	if len(pluginFilenames) > 0 {
+		trackInfo["pluginfiles"] = pluginFilenames
+	}
+	trackInfo["selectors"] = selectors
+
+	pluginFiles := make([]plugins.DeckPluginFile, len(pluginFilenames))
+	for i, filename := range pluginFilenames {
+		if err := pluginFiles[i].ParseFile(filename); err != nil {
+			return nil, fmt.Errorf("failed to parse plugin file '%s'; %w", filename, err)
+		}
+	}
+
+	// apply the configs
+	plugger := plugins.Plugger{}
+	plugger.SetYamlData(jsonbasics.ConvertToYamlNode(data))
+	if err := plugger.SetSelectors(selectors); err != nil {
+		return nil, fmt.Errorf("failed to set selectors; %w", err)
+	}
+	if err := plugger.AddPlugins(pluginConfigs, overwrite); err != nil {
+		return nil, fmt.Errorf("failed to add plugins; %w", err)
+	}
+	yamlNode := plugger.GetYamlData()
+
+	// apply plugin-files
+	for i, pluginFile := range pluginFiles {
+		if err := pluginFile.Apply(yamlNode); err != nil {
+			return nil, fmt.Errorf("failed to apply plugin file '%s'; %w", pluginFilenames[i], err)
+		}
+	}
+	return plugger.GetData(), nil
+}
+
 //
 //
 // Define the CLI data for the add-plugins command
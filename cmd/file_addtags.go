@@ -33,28 +33,37 @@ func executeAddTags(cmd *cobra.Command, tagsToAdd []string) error {
 		return fmt.Errorf("failed to read input file '%s'; %w", cmdAddTagsInputFilename, err)
 	}
 
-	tagger := tags.Tagger{}
-	tagger.SetData(data)
-	err = tagger.SetSelectors(cmdAddTagsSelectors)
-	if err != nil {
-		return fmt.Errorf("failed to set selectors; %w", err)
-	}
-	err = tagger.AddTags(tagsToAdd)
-	if err != nil {
-		return fmt.Errorf("failed to add tags; %w", err)
-	}
-	data = tagger.GetData()
-
 	trackInfo := deckformat.HistoryNewEntry("add-tags")
 	trackInfo["input"] = cmdAddTagsInputFilename
 	trackInfo["output"] = cmdAddTagsOutputFilename
-	trackInfo["tags"] = tagsToAdd
-	trackInfo["selectors"] = cmdAddTagsSelectors
+	if data, err = addTags(data, tagsToAdd, cmdAddTagsSelectors, trackInfo); err != nil {
+		return err
+	}
 	deckformat.HistoryAppend(data, trackInfo)
 
 	return filebasics.WriteSerializedFile(cmdAddTagsOutputFilename, data, filebasics.OutputFormat(cmdAddTagsOutputFormat))
 }
 
+// addTags adds tags to the objects of data that match the selectors, it is
+// shared by the add-tags command and pipeline step. The options are recorded in
+// trackInfo.
+func addTags(
+	data map[string]interface{}, tagsToAdd, selectors []string, trackInfo map[string]interface{},
+) (map[string]interface{}, error) {
+	trackInfo["tags"] = tagsToAdd
+	trackInfo["selectors"] = selectors
+
+	tagger := tags.Tagger{}
+	tagger.SetData(data)
+	if err := tagger.SetSelectors(selectors); err != nil {
+		return nil, fmt.Errorf("failed to set selectors; %w", err)
+	}
+	if err := tagger.AddTags(tagsToAdd); err != nil {
+		return nil, fmt.Errorf("failed to add tags; %w", err)
+	}
+	return tagger.GetData(), nil
+}
+
 //
 //
 // Define the CLI data for the add-tags command
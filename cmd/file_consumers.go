@@ -0,0 +1,260 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/kong/go-apiops/filebasics"
+	"github.com/kong/go-database-reconciler/pkg/file"
+	"github.com/spf13/cobra"
+)
+
+var (
+	cmdConsumersFrom          string
+	cmdConsumersMapping       string
+	cmdConsumersMergeInto     string
+	cmdConsumersInputFile     string
+	cmdConsumersOutputFile    string
+	cmdConsumersOutputFormat  string
+	cmdConsumersListSeparator string
+)
+
+// consumerExportColumns are the columns of an export. Credentials are
+// exported by their non-secret identifiers, or counted for key-auth.
+var consumerExportColumns = []string{
+	"id", "username", "custom_id", "tags", "consumer_groups",
+	"key_auth", "basic_auth", "hmac_auth", "jwt", "oauth2", "acls", "mtls_auth",
+}
+
+// Executes the CLI command "consumers import"
+func executeConsumersImport(cmd *cobra.Command, _ []string) error {
+	_ = sendAnalytics("file-consumers-import", "", modeLocal)
+	outputFormat := strings.ToUpper(getFormatFlagValue(cmd, cmdConsumersOutputFormat))
+
+	mapping, err := readConsumerMapping(cmdConsumersMapping)
+	if err != nil {
+		return err
+	}
+	records, err := readConsumerRecords(cmdConsumersFrom, mapping)
+	if err != nil {
+		return fmt.Errorf("failed reading '%s'; %w", cmdConsumersFrom, err)
+	}
+	consumers, groups, err := importConsumers(records, mapping)
+	if err != nil {
+		return fmt.Errorf("failed importing '%s'; %w", cmdConsumersFrom, err)
+	}
+
+	content := map[string]interface{}{"_format_version": "3.0"}
+	if cmdConsumersMergeInto != "" {
+		if content, err = filebasics.DeserializeFile(cmdConsumersMergeInto); err != nil {
+			return fmt.Errorf("failed reading file to merge into '%s'; %w", cmdConsumersMergeInto, err)
+		}
+	}
+	if err := mergeImportedConsumers(content, consumers, groups); err != nil {
+		return err
+	}
+	return filebasics.WriteSerializedFile(cmdConsumersOutputFile, content, filebasics.OutputFormat(outputFormat))
+}
+
+// Executes the CLI command "consumers export"
+func executeConsumersExport(_ *cobra.Command, _ []string) error {
+	_ = sendAnalytics("file-consumers-export", "", modeLocal)
+
+	content, err := file.GetContentFromFiles([]string{cmdConsumersInputFile}, false)
+	if err != nil {
+		return fmt.Errorf("failed reading input file '%s'; %w", cmdConsumersInputFile, err)
+	}
+	b, err := exportConsumers(content, cmdConsumersListSeparator)
+	if err != nil {
+		return err
+	}
+	return filebasics.WriteFile(cmdConsumersOutputFile, b)
+}
+
+// exportConsumers flattens the consumers of content to CSV, a row per
+// consumer. Secrets are not exported.
+func exportConsumers(content *file.Content, separator string) ([]byte, error) {
+	// memberships may be declared by the consumers or by the groups
+	groupMembers := map[string][]string{}
+	for _, group := range content.ConsumerGroups {
+		if group.Name == nil {
+			continue
+		}
+		for _, consumer := range group.Consumers {
+			for _, key := range []*string{consumer.ID, consumer.Username, consumer.CustomID} {
+				if key != nil {
+					groupMembers[*key] = append(groupMembers[*key], *group.Name)
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.Write(consumerExportColumns); err != nil {
+		return nil, fmt.Errorf("writing CSV: %w", err)
+	}
+	for _, c := range content.Consumers {
+		var groups []string
+		for _, group := range c.Groups {
+			if group.Name != nil {
+				groups = append(groups, *group.Name)
+			}
+		}
+		for _, key := range []*string{c.ID, c.Username, c.CustomID} {
+			if key != nil {
+				for _, group := range groupMembers[*key] {
+					if !slices.Contains(groups, group) {
+						groups = append(groups, group)
+					}
+				}
+			}
+		}
+
+		var basicAuths, hmacAuths, jwts, oauth2s, acls, mtlsAuths []string
+		for _, cred := range c.BasicAuths {
+			basicAuths = appendString(basicAuths, cred.Username)
+		}
+		for _, cred := range c.HMACAuths {
+			hmacAuths = appendString(hmacAuths, cred.Username)
+		}
+		for _, cred := range c.JWTAuths {
+			jwts = appendString(jwts, cred.Key)
+		}
+		for _, cred := range c.Oauth2Creds {
+			oauth2s = appendString(oauth2s, cred.Name)
+		}
+		for _, acl := range c.ACLGroups {
+			acls = appendString(acls, acl.Group)
+		}
+		for _, cred := range c.MTLSAuths {
+			mtlsAuths = appendString(mtlsAuths, cred.SubjectName)
+		}
+
+		row := []string{
+			stringValue(c.ID),
+			stringValue(c.Username),
+			stringValue(c.CustomID),
+			strings.Join(appendStrings(nil, c.Tags), separator),
+			strings.Join(groups, separator),
+			strconv.Itoa(len(c.KeyAuths)),
+			strings.Join(basicAuths, separator),
+			strings.Join(hmacAuths, separator),
+			strings.Join(jwts, separator),
+			strings.Join(oauth2s, separator),
+			strings.Join(acls, separator),
+			strings.Join(mtlsAuths, separator),
+		}
+		if err := w.Write(row); err != nil {
+			return nil, fmt.Errorf("writing CSV: %w", err)
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, fmt.Errorf("writing CSV: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func appendString(values []string, s *string) []string {
+	if s == nil {
+		return values
+	}
+	return append(values, *s)
+}
+
+func appendStrings(values []string, s []*string) []string {
+	for _, v := range s {
+		values = appendString(values, v)
+	}
+	return values
+}
+
+//
+//
+// Define the CLI data for the consumers command
+//
+//
+
+func newConsumersCmd() *cobra.Command {
+	consumersCmd := &cobra.Command{
+		Use:   "consumers",
+		Short: "Import and export consumers in bulk",
+		Long: `The consumers command converts consumers between decK files and
+CSV or JSONL files, to onboard or audit consumers in bulk.`,
+	}
+
+	importCmd := &cobra.Command{
+		Use:   "import",
+		Short: "Import consumers from a CSV or JSONL file",
+		Long: `Import consumers from a CSV file, with a header row, or a JSONL file,
+with an object per line, into decK format.
+
+The mapping file maps columns, or keys of the JSONL objects, to the fields of
+the consumers:
+
+  username: email
+  custom_id: partner_id
+  tags: labels            # values separated by list_separator, or an array
+  consumer_groups: tiers  # values separated by list_separator, or an array
+  key_auth: api_key
+  basic_auth:
+    username: email
+    password: password
+  static_tags: [partner]  # added to all consumers
+  list_separator: ";"     # default ";"
+
+The consumer groups of the consumers are added to the output. With
+--merge-into, existing consumers with the same username, or custom_id, are
+updated: the imported fields are set, and the tags, groups and credentials
+are added to theirs.`,
+		RunE: executeConsumersImport,
+		Example: "# Import partners into an existing decK file\n" +
+			"deck file consumers import --from users.csv --mapping mapping.yaml \\\n" +
+			"  --merge-into kong.yaml --output-file kong.yaml",
+		Args: cobra.NoArgs,
+	}
+	importCmd.Flags().StringVar(&cmdConsumersFrom, "from", "",
+		"CSV (.csv) or JSONL (.jsonl, .ndjson) file to import the consumers from.")
+	importCmd.Flags().StringVar(&cmdConsumersMapping, "mapping", "",
+		"mapping file, mapping columns to the fields of the consumers.")
+	importCmd.Flags().StringVar(&cmdConsumersMergeInto, "merge-into", "",
+		"decK file to merge the imported consumers into.")
+	importCmd.Flags().StringVarP(&cmdConsumersOutputFile, "output-file", "o", "-",
+		"Output file to write. Use - to write to stdout.")
+	importCmd.Flags().StringVarP(&cmdConsumersOutputFormat, "format", "", "yaml", "output format: yaml or json")
+	_ = importCmd.MarkFlagRequired("from")
+	_ = importCmd.MarkFlagRequired("mapping")
+
+	exportCmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export the consumers of a decK file to CSV",
+		Long: `Export the consumers of a decK file to CSV, a row per consumer, for
+auditing. Secrets are not exported: key-auth credentials are counted, and the
+other credentials are listed by their username, key, name or subject name.
+
+The columns are: ` + strings.Join(consumerExportColumns, ", ") + ".",
+		RunE: executeConsumersExport,
+		Args: cobra.NoArgs,
+	}
+	exportCmd.Flags().StringVarP(&cmdConsumersInputFile, "state", "s", "-",
+		"decK file to process. Use - to read from stdin.")
+	exportCmd.Flags().StringVarP(&cmdConsumersOutputFile, "output-file", "o", "-",
+		"Output file to write. Use - to write to stdout.")
+	exportCmd.Flags().StringVar(&cmdConsumersListSeparator, "list-separator", defaultConsumerListSeparator,
+		"separator of the values of list columns.")
+
+	consumersCmd.AddCommand(importCmd, exportCmd)
+	return consumersCmd
+}
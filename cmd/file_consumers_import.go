@@ -0,0 +1,351 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/kong/go-database-reconciler/pkg/file"
+	"github.com/kong/go-kong/kong"
+	"sigs.k8s.io/yaml"
+)
+
+const defaultConsumerListSeparator = ";"
+
+// consumerMapping maps the columns of the records of an import, or the keys
+// of JSONL objects, to the fields of consumers.
+type consumerMapping struct {
+	Username  string `json:"username,omitempty"`
+	CustomID  string `json:"custom_id,omitempty"`
+	Tags      string `json:"tags,omitempty"`
+	Groups    string `json:"consumer_groups,omitempty"`
+	KeyAuth   string `json:"key_auth,omitempty"`
+	BasicAuth *struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	} `json:"basic_auth,omitempty"`
+	// StaticTags are added to all consumers.
+	StaticTags []string `json:"static_tags,omitempty"`
+	// ListSeparator separates the values of the tags and consumer groups
+	// columns, JSONL records may use arrays instead.
+	ListSeparator string `json:"list_separator,omitempty"`
+}
+
+func readConsumerMapping(filename string) (*consumerMapping, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("reading mapping file: %w", err)
+	}
+	var mapping consumerMapping
+	if err := yaml.UnmarshalStrict(b, &mapping); err != nil {
+		return nil, fmt.Errorf("parsing mapping file '%s': %w", filename, err)
+	}
+	if mapping.Username == "" && mapping.CustomID == "" {
+		return nil, fmt.Errorf("mapping file '%s': username or custom_id must be mapped", filename)
+	}
+	if mapping.BasicAuth != nil && (mapping.BasicAuth.Username == "" || mapping.BasicAuth.Password == "") {
+		return nil, fmt.Errorf("mapping file '%s': basic_auth requires both username and password", filename)
+	}
+	if mapping.ListSeparator == "" {
+		mapping.ListSeparator = defaultConsumerListSeparator
+	}
+	return &mapping, nil
+}
+
+// consumerRecord is a record of an import, by column.
+type consumerRecord map[string]interface{}
+
+func (r consumerRecord) value(column string) string {
+	switch v := r[column].(type) {
+	case nil:
+		return ""
+	case string:
+		return strings.TrimSpace(v)
+	case json.Number:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func (r consumerRecord) list(column, separator string) []string {
+	var values []string
+	if array, ok := r[column].([]interface{}); ok {
+		for _, v := range array {
+			values = append(values, strings.TrimSpace(fmt.Sprint(v)))
+		}
+	} else {
+		values = strings.Split(r.value(column), separator)
+	}
+	return slices.DeleteFunc(values, func(v string) bool { return v == "" })
+}
+
+// columns returns the columns mapped to fields.
+func (m *consumerMapping) columns() []string {
+	columns := []string{m.Username, m.CustomID, m.Tags, m.Groups, m.KeyAuth}
+	if m.BasicAuth != nil {
+		columns = append(columns, m.BasicAuth.Username, m.BasicAuth.Password)
+	}
+	return slices.DeleteFunc(columns, func(c string) bool { return c == "" })
+}
+
+// readConsumerRecords reads the records of a CSV file, with a header row,
+// or of a JSONL file, with an object per line. The columns of a CSV file
+// must include the mapped ones, the keys of JSONL objects are optional.
+func readConsumerRecords(filename string, mapping *consumerMapping) ([]consumerRecord, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("reading records: %w", err)
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv":
+		return readCSVRecords(f, mapping)
+	case ".jsonl", ".ndjson":
+		return readJSONLRecords(f)
+	default:
+		return nil, fmt.Errorf("unknown format of '%s', use a .csv, .jsonl or .ndjson file", filename)
+	}
+}
+
+func readCSVRecords(r io.Reader, mapping *consumerMapping) ([]consumerRecord, error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading CSV header: %w", err)
+	}
+	for i := range header {
+		header[i] = strings.TrimSpace(header[i])
+	}
+	for _, column := range mapping.columns() {
+		if !slices.Contains(header, column) {
+			return nil, fmt.Errorf("mapped column '%s' is not in the CSV header", column)
+		}
+	}
+	var records []consumerRecord
+	for {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading CSV: %w", err)
+		}
+		record := make(consumerRecord, len(header))
+		for i, column := range header {
+			record[column] = row[i]
+		}
+		records = append(records, record)
+	}
+}
+
+func readJSONLRecords(r io.Reader) ([]consumerRecord, error) {
+	var records []consumerRecord
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		// numbers are kept as written, e.g. IDs too large for a float64
+		decoder := json.NewDecoder(bytes.NewReader(scanner.Bytes()))
+		decoder.UseNumber()
+		var record consumerRecord
+		if err := decoder.Decode(&record); err != nil {
+			return nil, fmt.Errorf("reading JSONL: line %d: %w", line, err)
+		}
+		if _, err := decoder.Token(); !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("reading JSONL: line %d: unexpected data after the object", line)
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading JSONL: %w", err)
+	}
+	return records, nil
+}
+
+// importConsumers converts records to consumers, returning them along with
+// the consumer groups they are members of.
+func importConsumers(records []consumerRecord, mapping *consumerMapping) ([]file.FConsumer, []string, error) {
+	consumers := make([]file.FConsumer, 0, len(records))
+	var groups []string
+	seen := map[string]int{}
+	for i, record := range records {
+		n := i + 1
+		var consumer file.FConsumer
+		if username := record.value(mapping.Username); mapping.Username != "" && username != "" {
+			consumer.Username = kong.String(username)
+		}
+		if customID := record.value(mapping.CustomID); mapping.CustomID != "" && customID != "" {
+			consumer.CustomID = kong.String(customID)
+		}
+		if consumer.Username == nil && consumer.CustomID == nil {
+			return nil, nil, fmt.Errorf("record %d: no username or custom_id", n)
+		}
+		key := consumerKey(consumer.Username, consumer.CustomID)
+		if first, ok := seen[key]; ok {
+			return nil, nil, fmt.Errorf("record %d: consumer %s is already imported by record %d", n, key, first)
+		}
+		seen[key] = n
+
+		tags := slices.Clone(mapping.StaticTags)
+		if mapping.Tags != "" {
+			tags = append(tags, record.list(mapping.Tags, mapping.ListSeparator)...)
+		}
+		if len(tags) > 0 {
+			consumer.Tags = kong.StringSlice(tags...)
+		}
+		if mapping.Groups != "" {
+			for _, group := range record.list(mapping.Groups, mapping.ListSeparator) {
+				consumer.Groups = append(consumer.Groups, &kong.ConsumerGroup{Name: kong.String(group)})
+				if !slices.Contains(groups, group) {
+					groups = append(groups, group)
+				}
+			}
+		}
+		if mapping.KeyAuth != "" {
+			if key := record.value(mapping.KeyAuth); key != "" {
+				consumer.KeyAuths = []*kong.KeyAuth{{Key: kong.String(key)}}
+			}
+		}
+		if mapping.BasicAuth != nil {
+			username, password := record.value(mapping.BasicAuth.Username), record.value(mapping.BasicAuth.Password)
+			// the username column may be shared with the consumer, so records
+			// without a password have no credential
+			switch {
+			case password == "":
+			case username == "":
+				return nil, nil, fmt.Errorf("record %d: basic-auth password without username", n)
+			default:
+				consumer.BasicAuths = []*kong.BasicAuth{{Username: kong.String(username), Password: kong.String(password)}}
+			}
+		}
+		consumers = append(consumers, consumer)
+	}
+	return consumers, groups, nil
+}
+
+// consumerKey identifies a consumer by its username, else its custom ID.
+func consumerKey(username, customID *string) string {
+	if username != nil {
+		return "'" + *username + "'"
+	}
+	return "with custom_id '" + *customID + "'"
+}
+
+// mergeImportedConsumers adds the imported consumers to a state file. An
+// existing consumer with the same username, or custom ID, is updated: the
+// imported fields are set, and the tags, consumer groups and credentials
+// are added to its own.
+func mergeImportedConsumers(content map[string]interface{}, consumers []file.FConsumer, groups []string) error {
+	existing, _ := content["consumers"].([]interface{})
+	for _, consumer := range consumers {
+		imported, err := toGenericMap(consumer)
+		if err != nil {
+			return err
+		}
+		match := findConsumer(existing, consumer)
+		if match == nil {
+			existing = append(existing, imported)
+			continue
+		}
+		for _, field := range []string{"username", "custom_id"} {
+			if v, ok := imported[field]; ok {
+				match[field] = v
+			}
+		}
+		match["tags"] = mergeList(match["tags"], imported["tags"], func(v interface{}) interface{} { return v })
+		for _, field := range []struct{ name, key string }{
+			{"groups", "name"},
+			{"keyauth_credentials", "key"},
+			{"basicauth_credentials", "username"},
+		} {
+			match[field.name] = mergeList(match[field.name], imported[field.name], func(v interface{}) interface{} {
+				m, _ := v.(map[string]interface{})
+				return m[field.key]
+			})
+		}
+		for key, v := range match {
+			if v == nil {
+				delete(match, key)
+			}
+		}
+	}
+	content["consumers"] = existing
+
+	existingGroups, _ := content["consumer_groups"].([]interface{})
+	for _, group := range groups {
+		found := slices.ContainsFunc(existingGroups, func(g interface{}) bool {
+			m, ok := g.(map[string]interface{})
+			return ok && m["name"] == group
+		})
+		if !found {
+			existingGroups = append(existingGroups, map[string]interface{}{"name": group})
+		}
+	}
+	if len(existingGroups) > 0 {
+		content["consumer_groups"] = existingGroups
+	}
+	return nil
+}
+
+func findConsumer(consumers []interface{}, consumer file.FConsumer) map[string]interface{} {
+	for _, c := range consumers {
+		m, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if consumer.Username != nil && m["username"] == *consumer.Username {
+			return m
+		}
+		if consumer.Username == nil && consumer.CustomID != nil && m["custom_id"] == *consumer.CustomID {
+			return m
+		}
+	}
+	return nil
+}
+
+// mergeList returns the elements of existing, with the elements of imported
+// replacing the ones with the same key, or appended. nil is returned when
+// both are empty.
+func mergeList(existing, imported interface{}, key func(interface{}) interface{}) interface{} {
+	result, _ := existing.([]interface{})
+	importedList, _ := imported.([]interface{})
+	for _, v := range importedList {
+		i := slices.IndexFunc(result, func(e interface{}) bool { return key(e) == key(v) })
+		if i >= 0 {
+			result[i] = v
+		} else {
+			result = append(result, v)
+		}
+	}
+	if len(result) == 0 {
+		return nil
+	}
+	return result
+}
+
+func toGenericMap(v interface{}) (map[string]interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("encoding consumer: %w", err)
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("decoding consumer: %w", err)
+	}
+	return m, nil
+}
@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kong/go-database-reconciler/pkg/file"
+	"github.com/kong/go-kong/kong"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testConsumerMapping = `username: email
+custom_id: partner_id
+tags: labels
+consumer_groups: tiers
+key_auth: api_key
+basic_auth:
+  username: email
+  password: password
+static_tags: [partner]
+`
+
+func writeTestFile(t *testing.T, name, content string) string {
+	filename := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(filename, []byte(content), 0o600))
+	return filename
+}
+
+func TestImportConsumers(t *testing.T) {
+	mapping, err := readConsumerMapping(writeTestFile(t, "mapping.yaml", testConsumerMapping))
+	require.NoError(t, err)
+
+	csvRecords, err := readConsumerRecords(writeTestFile(t, "users.csv",
+		"email,partner_id,labels,tiers,api_key,password\n"+
+			"alice@example.com,p-1,eu;beta,gold,k-1,pw-1\n"+
+			"bob@example.com,p-2,,,,\n"), mapping)
+	require.NoError(t, err)
+	jsonlRecords, err := readConsumerRecords(writeTestFile(t, "users.jsonl",
+		`{"email": "alice@example.com", "partner_id": "p-1", "labels": ["eu", "beta"], "tiers": ["gold"], `+
+			`"api_key": "k-1", "password": "pw-1"}`+"\n"+
+			`{"email": "bob@example.com", "partner_id": 2}`+"\n"), mapping)
+	require.NoError(t, err)
+
+	for name, records := range map[string][]consumerRecord{"csv": csvRecords, "jsonl": jsonlRecords} {
+		t.Run(name, func(t *testing.T) {
+			consumers, groups, err := importConsumers(records, mapping)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"gold"}, groups)
+			require.Len(t, consumers, 2)
+			assert.Equal(t, file.FConsumer{
+				Consumer: kong.Consumer{
+					Username: kong.String("alice@example.com"),
+					CustomID: kong.String("p-1"),
+					Tags:     kong.StringSlice("partner", "eu", "beta"),
+				},
+				Groups:   []*kong.ConsumerGroup{{Name: kong.String("gold")}},
+				KeyAuths: []*kong.KeyAuth{{Key: kong.String("k-1")}},
+				BasicAuths: []*kong.BasicAuth{{
+					Username: kong.String("alice@example.com"), Password: kong.String("pw-1"),
+				}},
+			}, consumers[0])
+			assert.Equal(t, "bob@example.com", *consumers[1].Username)
+			assert.Empty(t, consumers[1].KeyAuths)
+			assert.Empty(t, consumers[1].BasicAuths)
+		})
+	}
+
+	// large integers are imported as written
+	records, err := readConsumerRecords(writeTestFile(t, "users.jsonl",
+		`{"email": 10000000, "partner_id": 123456789012, "labels": [1e3, 98765432109876543]}`+"\n"), mapping)
+	require.NoError(t, err)
+	consumers, _, err := importConsumers(records, mapping)
+	require.NoError(t, err)
+	require.Len(t, consumers, 1)
+	assert.Equal(t, "10000000", *consumers[0].Username)
+	assert.Equal(t, "123456789012", *consumers[0].CustomID)
+	assert.Equal(t, kong.StringSlice("partner", "1e3", "98765432109876543"), consumers[0].Tags)
+
+	_, err = readConsumerRecords(writeTestFile(t, "users.jsonl", `{"email": "a"} {}`+"\n"), mapping)
+	require.ErrorContains(t, err, "line 1: unexpected data after the object")
+
+	_, err = readConsumerRecords(writeTestFile(t, "users.csv", "mail,partner_id\n"), mapping)
+	require.EqualError(t, err, "mapped column 'email' is not in the CSV header")
+	_, _, err = importConsumers([]consumerRecord{{"email": "a"}, {"email": "a"}}, mapping)
+	require.EqualError(t, err, "record 2: consumer 'a' is already imported by record 1")
+}
+
+func TestMergeImportedConsumers(t *testing.T) {
+	content := map[string]interface{}{
+		"_format_version": "3.0",
+		"consumers": []interface{}{
+			map[string]interface{}{
+				"username":            "alice",
+				"tags":                []interface{}{"legacy"},
+				"keyauth_credentials": []interface{}{map[string]interface{}{"key": "old"}},
+				"acls":                []interface{}{map[string]interface{}{"group": "admins"}},
+			},
+		},
+		"consumer_groups": []interface{}{map[string]interface{}{"name": "gold"}},
+	}
+	consumers := []file.FConsumer{
+		{
+			Consumer: kong.Consumer{Username: kong.String("alice"), Tags: kong.StringSlice("partner")},
+			Groups:   []*kong.ConsumerGroup{{Name: kong.String("silver")}},
+			KeyAuths: []*kong.KeyAuth{{Key: kong.String("new")}},
+		},
+		{Consumer: kong.Consumer{Username: kong.String("bob")}},
+	}
+	require.NoError(t, mergeImportedConsumers(content, consumers, []string{"silver"}))
+
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{
+			"username": "alice",
+			"tags":     []interface{}{"legacy", "partner"},
+			"groups":   []interface{}{map[string]interface{}{"name": "silver"}},
+			"keyauth_credentials": []interface{}{
+				map[string]interface{}{"key": "old"},
+				map[string]interface{}{"key": "new"},
+			},
+			"acls": []interface{}{map[string]interface{}{"group": "admins"}},
+		},
+		map[string]interface{}{"username": "bob"},
+	}, content["consumers"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"name": "gold"},
+		map[string]interface{}{"name": "silver"},
+	}, content["consumer_groups"])
+}
+
+func TestExportConsumers(t *testing.T) {
+	content := &file.Content{
+		Consumers: []file.FConsumer{
+			{
+				Consumer:   kong.Consumer{Username: kong.String("alice"), Tags: kong.StringSlice("eu", "beta")},
+				Groups:     []*kong.ConsumerGroup{{Name: kong.String("gold")}},
+				KeyAuths:   []*kong.KeyAuth{{Key: kong.String("k-1")}, {Key: kong.String("k-2")}},
+				BasicAuths: []*kong.BasicAuth{{Username: kong.String("alice"), Password: kong.String("pw")}},
+				ACLGroups:  []*kong.ACLGroup{{Group: kong.String("admins")}},
+			},
+			{Consumer: kong.Consumer{CustomID: kong.String("p-2")}},
+		},
+		ConsumerGroups: []file.FConsumerGroupObject{{
+			ConsumerGroup: kong.ConsumerGroup{Name: kong.String("silver")},
+			Consumers:     []*kong.Consumer{{Username: kong.String("alice")}, {CustomID: kong.String("p-2")}},
+		}},
+	}
+	b, err := exportConsumers(content, ";")
+	require.NoError(t, err)
+	assert.Equal(t, "id,username,custom_id,tags,consumer_groups,key_auth,basic_auth,hmac_auth,jwt,oauth2,acls,mtls_auth\n"+
+		",alice,,eu;beta,gold;silver,2,alice,,,,admins,\n"+
+		",,p-2,,silver,0,,,,,,\n", string(b))
+	assert.NotContains(t, string(b), "pw")
+}
@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/kong/deck/kong2openapi"
+	"github.com/kong/go-apiops/filebasics"
+	"github.com/kong/go-apiops/logbasics"
+	"github.com/kong/go-database-reconciler/pkg/file"
+	"github.com/spf13/cobra"
+)
+
+var (
+	cmdK2OinputFilename  string
+	cmdK2OoutputFilename string
+	cmdK2OoutputFormat   string
+	cmdK2Otitle          string
+	cmdK2Oversion        string
+)
+
+// Executes the CLI command "kong2openapi"
+func executeKong2OpenAPI(cmd *cobra.Command, _ []string) error {
+	verbosity, _ := cmd.Flags().GetInt("verbose")
+	logbasics.Initialize(log.LstdFlags, verbosity)
+	_ = sendAnalytics("file-kong2openapi", "", modeLocal)
+
+	cmdK2OoutputFormat = strings.ToUpper(getFormatFlagValue(cmd, cmdK2OoutputFormat))
+
+	content, err := file.GetContentFromFiles([]string{cmdK2OinputFilename}, false)
+	if err != nil {
+		return fmt.Errorf("failed reading input file '%s'; %w", cmdK2OinputFilename, err)
+	}
+	doc, warnings, err := kong2openapi.Convert(content, kong2openapi.Options{
+		Title:   cmdK2Otitle,
+		Version: cmdK2Oversion,
+	})
+	if err != nil {
+		return fmt.Errorf("failed converting decK file '%s' to OpenAPI; %w", cmdK2OinputFilename, err)
+	}
+	for _, warning := range warnings {
+		fmt.Fprintf(cmd.ErrOrStderr(), "Warning: %s\n", warning)
+	}
+	return filebasics.WriteSerializedFile(cmdK2OoutputFilename, doc, filebasics.OutputFormat(cmdK2OoutputFormat))
+}
+
+//
+//
+// Define the CLI data for the kong2openapi command
+//
+//
+
+func newKong2OpenAPICmd() *cobra.Command {
+	kong2openapiCmd := &cobra.Command{
+		Use:   "kong2openapi",
+		Short: "Generate an OpenAPI spec from Kong's decK format",
+		Long: `Generate an OpenAPI 3.0 spec from the services and routes of a decK file.
+
+The servers are the URLs of the services, and the paths and operations are
+generated from the paths and methods of their routes. Regex paths in the form
+openapi2kong generates them are converted back to path templates, other regex
+paths are skipped with a warning.
+
+Security schemes are generated from key-auth, basic-auth and openid-connect
+plugins, and parameters and request bodies from request-validator plugins.
+`,
+		RunE: executeKong2OpenAPI,
+		Example: "# Generate an OpenAPI spec from a decK file\n" +
+			"deck file kong2openapi --state kong.yaml --output-file openapi.yaml",
+		Args: cobra.NoArgs,
+	}
+
+	kong2openapiCmd.Flags().StringVarP(&cmdK2OinputFilename, "state", "s", "-",
+		"decK file to process. Use - to read from stdin.")
+	kong2openapiCmd.Flags().StringVarP(&cmdK2OoutputFilename, "output-file", "o", "-",
+		"Output file to write. Use - to write to stdout.")
+	kong2openapiCmd.Flags().StringVarP(&cmdK2OoutputFormat, "format", "", "yaml", "output format: yaml or json")
+	kong2openapiCmd.Flags().StringVar(&cmdK2Otitle, "title", "",
+		"title of the generated spec (default \"Kong API\").")
+	kong2openapiCmd.Flags().StringVar(&cmdK2Oversion, "api-version", "",
+		"version of the generated spec (default \"1.0.0\").")
+
+	return kong2openapiCmd
+}
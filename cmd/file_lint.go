@@ -27,8 +27,11 @@ func executeLint(cmd *cobra.Command, args []string) error {
 	logbasics.Initialize(log.LstdFlags, verbosity)
 	_ = sendAnalytics("file-lint", "", modeLocal)
 
-	lintErrs, err := lint.Lint(cmdLintInputFilename, args[0],
-		cmdLintFailSeverity, cmdLintOnlyFailures)
+	stateFileBytes, err := filebasics.ReadFile(cmdLintInputFilename)
+	if err != nil {
+		return fmt.Errorf("failed to read input file '%s'; %w", cmdLintInputFilename, err)
+	}
+	lintErrs, err := lintState(stateFileBytes, args[0], cmdLintFailSeverity, cmdLintOnlyFailures)
 	if err != nil {
 		return err
 	}
@@ -49,6 +52,18 @@ func executeLint(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// lintState lints the content of a state file against the ruleset file, it is
+// shared by the lint command and pipeline step.
+func lintState(
+	stateFileBytes []byte, ruleset, failSeverity string, onlyFailures bool,
+) (map[string]interface{}, error) {
+	rulesetBytes, err := filebasics.ReadFile(ruleset)
+	if err != nil {
+		return nil, fmt.Errorf("error reading ruleset file: %w", err)
+	}
+	return lint.WithContent(stateFileBytes, rulesetBytes, failSeverity, onlyFailures)
+}
+
 //
 //
 // Define the CLI data for the lint command
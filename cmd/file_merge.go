@@ -1,18 +1,20 @@
 package cmd
 
 import (
+	"fmt"
+	"io"
 	"log"
 
 	"github.com/kong/go-apiops/deckformat"
 	"github.com/kong/go-apiops/filebasics"
 	"github.com/kong/go-apiops/logbasics"
-	"github.com/kong/go-apiops/merge"
 	"github.com/spf13/cobra"
 )
 
 var (
 	cmdMergeOutputFilename string
 	cmdMergeOutputFormat   string
+	cmdMergeOnConflict     string
 )
 
 // Executes the CLI command "merge"
@@ -21,8 +23,19 @@ func executeMerge(cmd *cobra.Command, args []string) error {
 	logbasics.Initialize(log.LstdFlags, verbosity)
 	_ = sendAnalytics("file-merge", "", modeLocal)
 
+	if cmdMergeOnConflict != "" {
+		err := validateInputFlag("on-conflict", cmdMergeOnConflict, mergeOnConflictStrategies, "")
+		if err != nil {
+			return err
+		}
+	}
+
 	// do the work: read/merge
-	merged, info, err := merge.Files(args)
+	sources, info, err := loadMergeSources(args)
+	if err != nil {
+		return err
+	}
+	merged, err := mergeWithConflicts(sources, cmdMergeOnConflict, cmd.ErrOrStderr())
 	if err != nil {
 		return err
 	}
@@ -30,6 +43,9 @@ func executeMerge(cmd *cobra.Command, args []string) error {
 	historyEntry := deckformat.HistoryNewEntry("merge")
 	historyEntry["output"] = cmdMergeOutputFilename
 	historyEntry["files"] = info
+	if cmdMergeOnConflict != "" {
+		historyEntry["on-conflict"] = cmdMergeOnConflict
+	}
 	deckformat.HistoryClear(merged)
 	deckformat.HistoryAppend(merged, historyEntry)
 
@@ -39,6 +55,24 @@ func executeMerge(cmd *cobra.Command, args []string) error {
 		filebasics.OutputFormat(getFormatFlagValue(cmd, cmdMergeOutputFormat)))
 }
 
+// mergeWithConflicts merges the sources, resolving conflicts with the
+// on-conflict strategy. Conflicts are an error with the 'error' strategy, and
+// are reported to stderr otherwise. It is shared by the merge command and
+// pipeline step.
+func mergeWithConflicts(sources []mergeSource, onConflict string, stderr io.Writer) (map[string]interface{}, error) {
+	merged, conflicts, err := mergeSources(sources, onConflict)
+	if err != nil {
+		return nil, err
+	}
+	if onConflict == mergeOnConflictError && len(conflicts) > 0 {
+		return nil, mergeConflictError(conflicts)
+	}
+	if len(conflicts) > 0 {
+		fmt.Fprint(stderr, mergeConflictWarning(conflicts, onConflict))
+	}
+	return merged, nil
+}
+
 //
 //
 // Define the CLI data for the merge command
@@ -58,16 +92,34 @@ provided.
 Doesn't perform any checks on content, e.g. duplicates, or any validations.
 
 If the input files are not compatible, returns an error. Compatibility is
-determined by the '_transform' and '_format_version' fields.`,
+determined by the '_transform' and '_format_version' fields.
+
+Top-level entities defined in more than one file are conflicts, reported with
+the files defining them. Entities are identified by their name, or username,
+custom_id, prefix, target, or ID; plugins by their name and the entities they
+are scoped to, or their instance_name. By default all definitions are kept;
+use --on-conflict to resolve conflicts:
+
+  error       fail, listing the conflicts
+  first-wins  keep the definition of the first file
+  last-wins   keep the definition of the last file, in place of the first
+  deep-merge  merge the definitions field by field, later files win; nested
+              entities, such as the routes of a service, are merged the same
+              way`,
 		RunE: executeMerge,
 		Example: "# Merge 3 files\n" +
-			"deck file merge -o merged.yaml file1.yaml file2.yaml file3.yaml",
+			"deck file merge -o merged.yaml file1.yaml file2.yaml file3.yaml\n" +
+			"# Merge fragments owned by different teams, failing if they collide\n" +
+			"deck file merge --on-conflict error -o merged.yaml team-a.yaml team-b.yaml",
 		Args: cobra.MinimumNArgs(1),
 	}
 
 	mergeCmd.Flags().StringVarP(&cmdMergeOutputFilename, "output-file", "o", "-",
 		"Output file to write to. Use - to write to stdout.")
 	mergeCmd.Flags().StringVarP(&cmdMergeOutputFormat, "format", "", "yaml", "output format: yaml or json")
+	mergeCmd.Flags().StringVar(&cmdMergeOnConflict, "on-conflict", "",
+		fmt.Sprintf("how to resolve entities defined in more than one file %v.\n"+
+			"By default all definitions are kept.", mergeOnConflictStrategies))
 
 	return mergeCmd
 }
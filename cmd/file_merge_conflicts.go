@@ -0,0 +1,315 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/kong/go-apiops/deckformat"
+	"github.com/kong/go-apiops/merge"
+)
+
+// The strategies of 'deck file merge --on-conflict'. Without a strategy, the
+// conflicting entities are all kept, as merge.Files does.
+const (
+	mergeOnConflictError     = "error"
+	mergeOnConflictFirstWins = "first-wins"
+	mergeOnConflictLastWins  = "last-wins"
+	mergeOnConflictDeepMerge = "deep-merge"
+)
+
+var mergeOnConflictStrategies = []string{
+	mergeOnConflictError, mergeOnConflictFirstWins, mergeOnConflictLastWins, mergeOnConflictDeepMerge,
+}
+
+// mergeEntityFields are the fields identifying the entities of the entity
+// lists, in order of preference. Plugins are identified by their name and
+// scope, see mergeEntityKey.
+var mergeEntityFields = map[string][]string{
+	"services":        {"name", "id"},
+	"routes":          {"name", "id"},
+	"upstreams":       {"name", "id"},
+	"targets":         {"target", "id"},
+	"consumers":       {"username", "custom_id", "id"},
+	"consumer_groups": {"name", "id"},
+	"certificates":    {"id"},
+	"ca_certificates": {"id"},
+	"snis":            {"name", "id"},
+	"vaults":          {"prefix", "id"},
+	"key_sets":        {"name", "id"},
+	"keys":            {"name", "kid", "id"},
+	"partials":        {"name", "id"},
+	"filter_chains":   {"name", "id"},
+	"plugins":         {"instance_name", "id"},
+}
+
+// mergePluginScopes are the fields scoping plugins to other entities.
+var mergePluginScopes = []string{"service", "route", "consumer", "consumer_group"}
+
+// mergeSource is a file to merge, or the document of a pipeline.
+type mergeSource struct {
+	filename string
+	data     map[string]interface{}
+}
+
+// mergeConflict is an entity defined in more than one source.
+type mergeConflict struct {
+	entity string
+	files  []string
+}
+
+// mergeEntity is an entity of a merged entity list, and the source that
+// defined it first.
+type mergeEntity struct {
+	value    interface{}
+	filename string
+	conflict *mergeConflict
+}
+
+// loadMergeSources reads the files to merge, see merge.Files. It returns the
+// history info of the files as merge.Files does.
+func loadMergeSources(filenames []string) ([]mergeSource, []interface{}, error) {
+	sources := make([]mergeSource, 0, len(filenames))
+	info := make([]interface{}, 0, len(filenames))
+	for _, filename := range filenames {
+		data, history, err := merge.Files([]string{filename})
+		if err != nil {
+			return nil, nil, err
+		}
+		sources = append(sources, mergeSource{filename: filename, data: data})
+		info = append(info, history...)
+	}
+	return sources, info, nil
+}
+
+// mergeSources merges sources in order the way merge.Files does: lists are
+// concatenated, other keys are overwritten. Entities of the top-level entity
+// lists defined in more than one source are conflicts, resolved with strategy.
+// With the error strategy, the conflicts are returned without a result.
+func mergeSources(sources []mergeSource, strategy string) (map[string]interface{}, []*mergeConflict, error) {
+	result := map[string]interface{}{}
+	entities := map[string][]*mergeEntity{}
+	entityIndex := map[string]map[string]*mergeEntity{}
+	var conflicts []*mergeConflict
+	minorVersion := 0
+
+	for i, source := range sources {
+		if i == 0 {
+			for _, key := range []string{deckformat.TransformKey, deckformat.VersionKey} {
+				if source.data[key] != nil {
+					result[key] = source.data[key]
+				}
+			}
+		}
+		if err := deckformat.CompatibleFile(result, source.data); err != nil {
+			return nil, nil, fmt.Errorf("failed to merge %s: %w", source.filename, err)
+		}
+		if _, minor, _ := deckformat.ParseFormatVersion(source.data); minor > minorVersion {
+			minorVersion = minor
+		}
+
+		keys := make([]string, 0, len(source.data))
+		for key := range source.data {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			value := source.data[key]
+			list, isList := value.([]interface{})
+			if _, isEntityList := mergeEntityFields[key]; !isEntityList || !isList {
+				if existing, wasList := result[key].([]interface{}); wasList && isList {
+					result[key] = append(append([]interface{}{}, existing...), list...)
+				} else {
+					result[key] = value
+				}
+				continue
+			}
+
+			if entityIndex[key] == nil {
+				entityIndex[key] = map[string]*mergeEntity{}
+			}
+			for _, e := range list {
+				id, label := mergeEntityKey(key, e)
+				existing := entityIndex[key][id]
+				if id == "" || existing == nil {
+					added := &mergeEntity{value: e, filename: source.filename}
+					entities[key] = append(entities[key], added)
+					if id != "" {
+						entityIndex[key][id] = added
+					}
+					continue
+				}
+
+				if existing.conflict == nil {
+					existing.conflict = &mergeConflict{entity: label, files: []string{existing.filename}}
+					conflicts = append(conflicts, existing.conflict)
+				}
+				existing.conflict.files = append(existing.conflict.files, source.filename)
+
+				switch strategy {
+				case mergeOnConflictFirstWins, mergeOnConflictError:
+				case mergeOnConflictLastWins:
+					existing.value = e
+				case mergeOnConflictDeepMerge:
+					existing.value = deepMergeEntity(existing.value, e)
+				default:
+					entities[key] = append(entities[key], &mergeEntity{value: e, filename: source.filename})
+				}
+			}
+		}
+	}
+	if strategy == mergeOnConflictError && len(conflicts) > 0 {
+		return nil, conflicts, nil
+	}
+
+	for key, list := range entities {
+		values := make([]interface{}, len(list))
+		for i, entity := range list {
+			values[i] = entity.value
+		}
+		result[key] = values
+	}
+	if result[deckformat.VersionKey] != nil {
+		if major, _, _ := deckformat.ParseFormatVersion(result); major == 0 {
+			delete(result, deckformat.VersionKey)
+		} else {
+			result[deckformat.VersionKey] = fmt.Sprint(major, ".", minorVersion)
+		}
+	}
+	return result, conflicts, nil
+}
+
+// mergeEntityKey returns the key identifying an entity of the list key, and
+// a description of the entity. Entities without a key are never conflicts.
+func mergeEntityKey(key string, e interface{}) (string, string) {
+	entity, ok := e.(map[string]interface{})
+	if !ok {
+		return "", ""
+	}
+	kind := strings.TrimSuffix(key, "s")
+	for _, field := range mergeEntityFields[key] {
+		if value, ok := entity[field].(string); ok && value != "" {
+			return field + "=" + value, fmt.Sprintf("%s '%s'", kind, value)
+		}
+	}
+	if key != "plugins" {
+		return "", ""
+	}
+
+	name, _ := entity["name"].(string)
+	if name == "" {
+		return "", ""
+	}
+	id := "name=" + name
+	var scopes []string
+	for _, scope := range mergePluginScopes {
+		if ref := mergeEntityRef(entity[scope]); ref != "" {
+			id += "," + scope + "=" + ref
+			scopes = append(scopes, fmt.Sprintf("%s '%s'", scope, ref))
+		}
+	}
+	if len(scopes) == 0 {
+		return id, fmt.Sprintf("plugin '%s' (global)", name)
+	}
+	return id, fmt.Sprintf("plugin '%s' on %s", name, strings.Join(scopes, ", "))
+}
+
+// mergeEntityRef returns the name or ID of a reference to an entity, which is
+// either a string or an object.
+func mergeEntityRef(ref interface{}) string {
+	switch ref := ref.(type) {
+	case string:
+		return ref
+	case map[string]interface{}:
+		for _, field := range []string{"name", "username", "id"} {
+			if value, ok := ref[field].(string); ok && value != "" {
+				return value
+			}
+		}
+	}
+	return ""
+}
+
+// deepMergeEntity merges value into existing: objects are merged field by
+// field, and the entity lists nested in them entity by entity. Other fields
+// are set to their value in value.
+func deepMergeEntity(existing, value interface{}) interface{} {
+	existingObject, ok := existing.(map[string]interface{})
+	object, isObject := value.(map[string]interface{})
+	if !ok || !isObject {
+		return value
+	}
+
+	result := make(map[string]interface{}, len(existingObject))
+	for key, v := range existingObject {
+		result[key] = v
+	}
+	for key, v := range object {
+		existingList, wasList := result[key].([]interface{})
+		list, isList := v.([]interface{})
+		if _, isEntityList := mergeEntityFields[key]; isEntityList && wasList && isList {
+			result[key] = deepMergeEntities(existingList, list, key)
+			continue
+		}
+		if _, wasObject := result[key].(map[string]interface{}); wasObject {
+			result[key] = deepMergeEntity(result[key], v)
+			continue
+		}
+		result[key] = v
+	}
+	return result
+}
+
+// deepMergeEntities merges the entities of list into existing, the entities
+// of the list key. The entities that are in both are merged, the others are
+// appended.
+func deepMergeEntities(existing, list []interface{}, key string) []interface{} {
+	result := append([]interface{}{}, existing...)
+	index := map[string]int{}
+	for i, e := range result {
+		if id, _ := mergeEntityKey(key, e); id != "" {
+			index[id] = i
+		}
+	}
+	for _, e := range list {
+		id, _ := mergeEntityKey(key, e)
+		if i, ok := index[id]; ok && id != "" {
+			result[i] = deepMergeEntity(result[i], e)
+			continue
+		}
+		result = append(result, e)
+	}
+	return result
+}
+
+// mergeConflictReport describes the conflicts, one per line.
+func mergeConflictReport(conflicts []*mergeConflict) string {
+	var b strings.Builder
+	for _, conflict := range conflicts {
+		fmt.Fprintf(&b, "  %s: %s\n", conflict.entity, strings.Join(conflict.files, ", "))
+	}
+	return b.String()
+}
+
+// mergeConflictError returns the error for conflicts with the error strategy.
+func mergeConflictError(conflicts []*mergeConflict) error {
+	return fmt.Errorf("%d entities are defined in more than one file:\n%s"+
+		"use --on-conflict first-wins, last-wins or deep-merge to resolve them",
+		len(conflicts), mergeConflictReport(conflicts))
+}
+
+// mergeConflictWarning returns the warning reporting conflicts resolved with
+// strategy.
+func mergeConflictWarning(conflicts []*mergeConflict, strategy string) string {
+	resolution := "all definitions are kept, use --on-conflict to resolve them"
+	switch strategy {
+	case mergeOnConflictFirstWins:
+		resolution = "the first definition is kept"
+	case mergeOnConflictLastWins:
+		resolution = "the last definition is kept"
+	case mergeOnConflictDeepMerge:
+		resolution = "the definitions are merged"
+	}
+	return fmt.Sprintf("Warning: %d entities are defined in more than one file, %s:\n%s",
+		len(conflicts), resolution, mergeConflictReport(conflicts))
+}
@@ -0,0 +1,157 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/kong/go-apiops/filebasics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mergeTestSources(t *testing.T) []mergeSource {
+	t.Helper()
+	files := []struct{ name, content string }{
+		{"team-a.yaml", `_format_version: "3.0"
+services:
+- name: orders
+  host: orders.a
+  read_timeout: 1000
+  routes:
+  - name: orders-list
+    paths: [/orders]
+plugins:
+- name: cors
+- name: rate-limiting
+  service: orders
+  config: {minute: 10}
+`},
+		{"team-b.yaml", `_format_version: "3.1"
+services:
+- name: orders
+  host: orders.b
+  routes:
+  - name: orders-list
+    methods: [GET]
+  - name: orders-create
+    paths: [/orders]
+- name: billing
+  host: billing
+plugins:
+- name: cors
+  config: {origins: ["*"]}
+- name: rate-limiting
+  service: billing
+`},
+	}
+	sources := make([]mergeSource, 0, len(files))
+	for _, f := range files {
+		data, err := filebasics.Deserialize([]byte(f.content))
+		require.NoError(t, err)
+		sources = append(sources, mergeSource{filename: f.name, data: data})
+	}
+	return sources
+}
+
+func TestMergeSourcesConflicts(t *testing.T) {
+	result, conflicts, err := mergeSources(mergeTestSources(t), "")
+	require.NoError(t, err)
+
+	require.Len(t, conflicts, 2)
+	assert.Equal(t, "plugin 'cors' (global)", conflicts[0].entity)
+	assert.Equal(t, []string{"team-a.yaml", "team-b.yaml"}, conflicts[0].files)
+	assert.Equal(t, "service 'orders'", conflicts[1].entity)
+	assert.Equal(t,
+		"  plugin 'cors' (global): team-a.yaml, team-b.yaml\n  service 'orders': team-a.yaml, team-b.yaml\n",
+		mergeConflictReport(conflicts))
+
+	// all definitions are kept, as merge.Files does
+	assert.Equal(t, "3.1", result["_format_version"])
+	assert.Len(t, getSlice(t, result, "services"), 3)
+	assert.Len(t, getSlice(t, result, "plugins"), 4)
+}
+
+func TestMergeSourcesStrategies(t *testing.T) {
+	t.Run("error", func(t *testing.T) {
+		result, conflicts, err := mergeSources(mergeTestSources(t), mergeOnConflictError)
+		require.NoError(t, err)
+		assert.Nil(t, result)
+		require.Len(t, conflicts, 2)
+		assert.ErrorContains(t, mergeConflictError(conflicts),
+			"2 entities are defined in more than one file:\n  plugin 'cors' (global): team-a.yaml, team-b.yaml\n")
+	})
+
+	t.Run("first-wins", func(t *testing.T) {
+		result, _, err := mergeSources(mergeTestSources(t), mergeOnConflictFirstWins)
+		require.NoError(t, err)
+		services := getSlice(t, result, "services")
+		require.Len(t, services, 2)
+		assert.Equal(t, "orders.a", asMap(t, services[0])["host"])
+		assert.Equal(t, "billing", asMap(t, services[1])["name"])
+		plugins := getSlice(t, result, "plugins")
+		require.Len(t, plugins, 3)
+		assert.Nil(t, asMap(t, plugins[0])["config"])
+	})
+
+	t.Run("last-wins", func(t *testing.T) {
+		result, _, err := mergeSources(mergeTestSources(t), mergeOnConflictLastWins)
+		require.NoError(t, err)
+		services := getSlice(t, result, "services")
+		require.Len(t, services, 2)
+		orders := asMap(t, services[0])
+		assert.Equal(t, "orders.b", orders["host"])
+		assert.Nil(t, orders["read_timeout"])
+	})
+
+	t.Run("deep-merge", func(t *testing.T) {
+		result, _, err := mergeSources(mergeTestSources(t), mergeOnConflictDeepMerge)
+		require.NoError(t, err)
+		services := getSlice(t, result, "services")
+		require.Len(t, services, 2)
+		orders := asMap(t, services[0])
+		assert.Equal(t, "orders.b", orders["host"])
+		assert.EqualValues(t, 1000, orders["read_timeout"])
+
+		routes := getSlice(t, orders, "routes")
+		require.Len(t, routes, 2)
+		list := asMap(t, routes[0])
+		assert.Equal(t, "orders-list", list["name"])
+		assert.Equal(t, []interface{}{"/orders"}, list["paths"])
+		assert.Equal(t, []interface{}{"GET"}, list["methods"])
+		assert.Equal(t, "orders-create", asMap(t, routes[1])["name"])
+
+		cors := asMap(t, getSlice(t, result, "plugins")[0])
+		assert.Equal(t, map[string]interface{}{"origins": []interface{}{"*"}}, cors["config"])
+	})
+}
+
+func TestMergeEntityKey(t *testing.T) {
+	tests := []struct {
+		name      string
+		key       string
+		entity    interface{}
+		wantID    string
+		wantLabel string
+	}{
+		{"service", "services", map[string]interface{}{"name": "a", "id": "1"}, "name=a", "service 'a'"},
+		{"consumer by custom_id", "consumers", map[string]interface{}{"custom_id": "c1"}, "custom_id=c1", "consumer 'c1'"},
+		{
+			"scoped plugin", "plugins",
+			map[string]interface{}{
+				"name": "acl", "route": "r", "consumer": map[string]interface{}{"username": "u"},
+			},
+			"name=acl,route=r,consumer=u", "plugin 'acl' on route 'r', consumer 'u'",
+		},
+		{
+			"plugin instance", "plugins", map[string]interface{}{"name": "acl", "instance_name": "acl-1"},
+			"instance_name=acl-1", "plugin 'acl-1'",
+		},
+		{"certificate without id", "certificates", map[string]interface{}{"cert": "x"}, "", ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			id, label := mergeEntityKey(tc.key, tc.entity)
+			assert.Equal(t, tc.wantID, id)
+			assert.Equal(t, tc.wantLabel, label)
+		})
+	}
+}
@@ -43,55 +43,59 @@ func executeNamespace(cmd *cobra.Command, _ []string) error {
 	trackInfo := deckformat.HistoryNewEntry("namespace")
 	trackInfo["input"] = cmdNamespaceInputFilename
 	trackInfo["output"] = cmdNamespaceOutputFilename
-	trackInfo["selectors"] = cmdNamespaceSelectors
-	trackInfo["path-prefix"] = cmdNamespacePathPrefix
-	trackInfo["hosts"] = cmdNamespaceHosts
-	trackInfo["clear-hosts"] = cmdClearHosts
 
 	// do the work: read/namespace/write
 	data, err := filebasics.DeserializeFile(cmdNamespaceInputFilename)
 	if err != nil {
 		return fmt.Errorf("failed to read input file '%s'; %w", cmdNamespaceInputFilename, err)
 	}
+	data, err = applyNamespace(data, cmdNamespaceSelectors, cmdNamespacePathPrefix, cmdNamespaceHosts,
+		cmdClearHosts, cmdNamespaceAllowEmptySelectors, trackInfo)
+	if err != nil {
+		if strings.Contains(err.Error(), "no routes matched the selectors") {
+			// append CLI specific message
+			err = fmt.Errorf("%w (use --allow-empty-selectors to suppress this error)", err)
+		}
+		return err
+	}
 	deckformat.HistoryAppend(data, trackInfo)
 
-	yamlNode := jsonbasics.ConvertToYamlNode(data)
+	return filebasics.WriteSerializedFile(cmdNamespaceOutputFilename, data,
+		filebasics.OutputFormat(cmdNamespaceOutputFormat))
+}
+
+// applyNamespace applies the path-prefix and the hosts to the routes of data
+// that match the selectors, it is shared by the namespace command and pipeline
+// step. The options are recorded in trackInfo.
+func applyNamespace(
+	data map[string]interface{}, selectorExprs []string, pathPrefix string, hosts []string,
+	clearHosts, allowEmptySelectors bool, trackInfo map[string]interface{},
+) (map[string]interface{}, error) {
+	trackInfo["selectors"] = selectorExprs
+	trackInfo["path-prefix"] = pathPrefix
+	trackInfo["hosts"] = hosts
+	trackInfo["clear-hosts"] = clearHosts
 
-	// var selectors yamlbasics.SelectorSet
-	selectors, err := yamlbasics.NewSelectorSet(cmdNamespaceSelectors)
+	yamlNode := jsonbasics.ConvertToYamlNode(data)
+	selectors, err := yamlbasics.NewSelectorSet(selectorExprs)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// apply the path-based namespace
-	if cmdNamespacePathPrefix != "" {
-		err = namespace.Apply(yamlNode, selectors, cmdNamespacePathPrefix, cmdNamespaceAllowEmptySelectors)
-		if err != nil {
-			if strings.Contains(err.Error(), "no routes matched the selectors") {
-				// append CLI specific message
-				err = fmt.Errorf("%w (use --allow-empty-selectors to suppress this error)", err)
-			}
-			return fmt.Errorf("failed to apply the path namespace: %w", err)
+	if pathPrefix != "" {
+		if err := namespace.Apply(yamlNode, selectors, pathPrefix, allowEmptySelectors); err != nil {
+			return nil, fmt.Errorf("failed to apply the path namespace: %w", err)
 		}
 	}
 
 	// apply the host-based namespace
-	if len(cmdNamespaceHosts) > 0 || cmdClearHosts {
-		err = namespace.ApplyNamespaceHost(yamlNode, selectors, cmdNamespaceHosts,
-			cmdClearHosts, cmdNamespaceAllowEmptySelectors)
-		if err != nil {
-			if strings.Contains(err.Error(), "no routes matched the selectors") {
-				// append CLI specific message
-				err = fmt.Errorf("%w (use --allow-empty-selectors to suppress this error)", err)
-			}
-			return fmt.Errorf("failed to apply the host namespace: %w", err)
+	if len(hosts) > 0 || clearHosts {
+		if err := namespace.ApplyNamespaceHost(yamlNode, selectors, hosts, clearHosts, allowEmptySelectors); err != nil {
+			return nil, fmt.Errorf("failed to apply the host namespace: %w", err)
 		}
 	}
-
-	data = jsonbasics.ConvertToJSONobject(yamlNode)
-
-	return filebasics.WriteSerializedFile(cmdNamespaceOutputFilename, data,
-		filebasics.OutputFormat(cmdNamespaceOutputFormat))
+	return jsonbasics.ConvertToJSONobject(yamlNode), nil
 }
 
 //
@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"strings"
 
@@ -25,6 +26,8 @@ var (
 	cmdO2KignoreCircularRefs  bool
 	cmdO2KskipRouteByHeader   bool
 	cmdO2KreuseService        bool
+	cmdO2KmergeInto           string
+	cmdO2KpruneRemoved        bool
 )
 
 // Executes the CLI command "openapi2kong"
@@ -42,6 +45,18 @@ func executeOpenapi2Kong(cmd *cobra.Command, _ []string) error {
 	if cmdO2KinsoCompat {
 		cmdO2KskipID = true // this is implicit in inso compatibility mode
 	}
+	if cmdO2KpruneRemoved && cmdO2KmergeInto == "" {
+		return fmt.Errorf("--prune-removed requires --merge-into")
+	}
+	if cmdO2KmergeInto != "" {
+		if cmdO2KskipID {
+			return fmt.Errorf("--merge-into matches entities on their IDs, " +
+				"it cannot be combined with --no-id or --inso-compatible")
+		}
+		if cmdO2KmergeInto == "-" && cmdO2KinputFilename == "-" {
+			return fmt.Errorf("--merge-into and --spec cannot both read from stdin")
+		}
+	}
 	options := openapi2kong.O2kOptions{
 		Tags:                 cmdO2KentityTags,
 		DocName:              cmdO2KdocName,
@@ -60,16 +75,53 @@ func executeOpenapi2Kong(cmd *cobra.Command, _ []string) error {
 	trackInfo["uuid-base"] = cmdO2KdocName
 
 	// do the work: read/convert/write
-	content, err := filebasics.ReadFile(cmdO2KinputFilename)
+	result, err := convertOpenAPISpec(cmdO2KinputFilename, options, cmdO2KmergeInto, cmdO2KpruneRemoved,
+		cmd.ErrOrStderr(), trackInfo)
 	if err != nil {
 		return err
 	}
+	deckformat.HistoryAppend(result, trackInfo)
+	return filebasics.WriteSerializedFile(cmdO2KoutputFilename, result, filebasics.OutputFormat(cmdO2KoutputFormat))
+}
+
+// convertOpenAPISpec converts the OpenAPI spec file to a decK file. With
+// mergeInto, the result is merged into that decK file, the entities that are
+// no longer generated are reported to stderr and kept, or removed with prune.
+// It is shared by the openapi2kong command and pipeline step. The merge-into
+// file is recorded in trackInfo.
+func convertOpenAPISpec(
+	spec string, options openapi2kong.O2kOptions, mergeInto string, prune bool, stderr io.Writer,
+	trackInfo map[string]interface{},
+) (map[string]interface{}, error) {
+	content, err := filebasics.ReadFile(spec)
+	if err != nil {
+		return nil, err
+	}
 	result, err := openapi2kong.Convert(content, options)
 	if err != nil {
-		return fmt.Errorf("failed converting OpenAPI spec '%s'; %w", cmdO2KinputFilename, err)
+		return nil, fmt.Errorf("failed converting OpenAPI spec '%s'; %w", spec, err)
 	}
-	deckformat.HistoryAppend(result, trackInfo)
-	return filebasics.WriteSerializedFile(cmdO2KoutputFilename, result, filebasics.OutputFormat(cmdO2KoutputFormat))
+	if mergeInto == "" {
+		return result, nil
+	}
+
+	existing, err := filebasics.DeserializeFile(mergeInto)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading file to merge into '%s'; %w", mergeInto, err)
+	}
+	merger := &o2kMerger{prune: prune}
+	if result, err = merger.merge(result, existing); err != nil {
+		return nil, err
+	}
+	action := "kept"
+	if prune {
+		action = "removed"
+	}
+	for _, entity := range merger.removed {
+		fmt.Fprintf(stderr, "Warning: %s is no longer generated from the spec, %s\n", entity, action)
+	}
+	trackInfo["merge-into"] = mergeInto
+	return result, nil
 }
 
 //
@@ -126,5 +178,13 @@ The output will be targeted at Kong version 3.x.
 		"reuse existing Kong services when multiple paths share the same backend URL.\n"+
 			"When set, deduplicates services by checking host, protocol, port, and path.")
 
+	openapi2kongCmd.Flags().StringVar(&cmdO2KmergeInto, "merge-into", "",
+		"previously generated decK file to merge the result into. Entities are matched\n"+
+			"on their IDs, the fields generated from the spec are updated, and the plugins\n"+
+			"and fields added by hand are kept. Entities no longer generated are reported.")
+	openapi2kongCmd.Flags().BoolVar(&cmdO2KpruneRemoved, "prune-removed", false,
+		"remove the entities no longer generated from the spec when using --merge-into,\n"+
+			"rather than keeping them.")
+
 	return openapi2kongCmd
 }
@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// nameBasedUUID matches version 5 UUIDs, which are derived from a name.
+var nameBasedUUID = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-5[0-9a-fA-F]{3}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// o2kEntityLists are the keys of the entity lists openapi2kong generates.
+// They are merged entity by entity, matched on their IDs.
+var o2kEntityLists = map[string]bool{
+	"services":  true,
+	"routes":    true,
+	"plugins":   true,
+	"upstreams": true,
+	"targets":   true,
+}
+
+// o2kMerger merges the result of openapi2kong into a previously generated,
+// and possibly hand-edited, file.
+type o2kMerger struct {
+	// prune drops the entities that are no longer generated from the spec.
+	prune bool
+	// removed describes the entities that are no longer generated.
+	removed []string
+}
+
+// merge returns existing updated with generated: the fields generated from
+// the spec are updated, the fields and entities added by hand are kept.
+func (m *o2kMerger) merge(generated, existing map[string]interface{}) (map[string]interface{}, error) {
+	// normalize the generated file to the types of a deserialized one
+	b, err := json.Marshal(generated)
+	if err != nil {
+		return nil, fmt.Errorf("encoding generated file: %w", err)
+	}
+	var normalized map[string]interface{}
+	if err := json.Unmarshal(b, &normalized); err != nil {
+		return nil, fmt.Errorf("decoding generated file: %w", err)
+	}
+	return m.mergeObject(normalized, existing, ""), nil
+}
+
+func (m *o2kMerger) mergeObject(generated, existing map[string]interface{}, path string) map[string]interface{} {
+	result := make(map[string]interface{}, len(existing))
+	for key, value := range existing {
+		result[key] = value
+	}
+	for key, value := range generated {
+		existingValue, ok := existing[key]
+		if !ok {
+			result[key] = value
+			continue
+		}
+		switch value := value.(type) {
+		case []interface{}:
+			existingList, isList := existingValue.([]interface{})
+			if o2kEntityLists[key] && isList {
+				result[key] = m.mergeEntities(value, existingList, path, key)
+				continue
+			}
+		case map[string]interface{}:
+			if existingObject, isObject := existingValue.(map[string]interface{}); isObject {
+				result[key] = m.mergeObject(value, existingObject, path)
+				continue
+			}
+		}
+		result[key] = value
+	}
+	return result
+}
+
+// mergeEntities merges a list of generated entities into the existing list,
+// keeping the order of the existing entities and appending the new ones.
+func (m *o2kMerger) mergeEntities(generated, existing []interface{}, path, key string) []interface{} {
+	generatedByID := map[string]map[string]interface{}{}
+	for _, e := range generated {
+		if entity, ok := e.(map[string]interface{}); ok {
+			if id, ok := entity["id"].(string); ok {
+				generatedByID[id] = entity
+			}
+		}
+	}
+
+	kind := strings.TrimSuffix(key, "s")
+	result := make([]interface{}, 0, len(generated))
+	merged := map[string]bool{}
+	for _, e := range existing {
+		entity, ok := e.(map[string]interface{})
+		if !ok {
+			result = append(result, e)
+			continue
+		}
+		id, _ := entity["id"].(string)
+		entityPath := path + kind + " " + o2kEntityName(entity)
+		if g, ok := generatedByID[id]; ok {
+			result = append(result, m.mergeObject(g, entity, entityPath+" > "))
+			merged[id] = true
+			continue
+		}
+		if isGeneratedID(id) {
+			// generated by a previous run, but no longer by the spec
+			m.removed = append(m.removed, entityPath)
+			if m.prune {
+				continue
+			}
+		}
+		result = append(result, entity)
+	}
+	for _, e := range generated {
+		entity, ok := e.(map[string]interface{})
+		if !ok {
+			result = append(result, e)
+			continue
+		}
+		if id, _ := entity["id"].(string); !merged[id] {
+			result = append(result, entity)
+		}
+	}
+	return result
+}
+
+// isGeneratedID returns whether id is derived from a name, as the IDs
+// openapi2kong generates are, rather than random.
+func isGeneratedID(id string) bool {
+	return nameBasedUUID.MatchString(id)
+}
+
+func o2kEntityName(entity map[string]interface{}) string {
+	for _, key := range []string{"name", "target", "id"} {
+		if name, ok := entity[key].(string); ok {
+			return name
+		}
+	}
+	return "<unnamed>"
+}
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/kong/go-apiops/filebasics"
+	"github.com/kong/go-apiops/openapi2kong"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const o2kMergeSpec = `openapi: 3.0.3
+info:
+  title: Orders
+  version: 1.0.0
+servers:
+  - url: https://%s/api
+x-kong-plugin-key-auth:
+  config:
+    key_names: [apikey]
+paths:
+  /orders:
+    get:
+      operationId: list-orders
+`
+
+func o2kConvert(t *testing.T, spec string) map[string]interface{} {
+	result, err := openapi2kong.Convert([]byte(spec), openapi2kong.O2kOptions{})
+	require.NoError(t, err)
+	// serialize as the file would be written
+	b, err := filebasics.Serialize(result, filebasics.OutputFormatYaml)
+	require.NoError(t, err)
+	content, err := filebasics.Deserialize(b)
+	require.NoError(t, err)
+	return content
+}
+
+func TestO2kMerger(t *testing.T) {
+	v1 := fmt.Sprintf(o2kMergeSpec, "orders.internal") + `  /orders/{id}:
+    get:
+      operationId: get-order
+`
+	existing := o2kConvert(t, v1)
+
+	// edit the generated file by hand
+	service := existing["services"].([]interface{})[0].(map[string]interface{})
+	service["read_timeout"] = 5000
+	keyAuth := service["plugins"].([]interface{})[0].(map[string]interface{})
+	keyAuth["config"].(map[string]interface{})["hide_credentials"] = true
+	service["plugins"] = append(service["plugins"].([]interface{}), map[string]interface{}{
+		"name":   "rate-limiting",
+		"config": map[string]interface{}{"minute": 10},
+	})
+	existing["consumers"] = []interface{}{map[string]interface{}{"username": "alice"}}
+
+	// the server changes and GET /orders/{id} is removed from the spec
+	v2 := fmt.Sprintf(o2kMergeSpec, "orders.example.com")
+	generated, err := openapi2kong.Convert([]byte(v2), openapi2kong.O2kOptions{})
+	require.NoError(t, err)
+
+	for _, prune := range []bool{false, true} {
+		merger := &o2kMerger{prune: prune}
+		merged, err := merger.merge(generated, existing)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"service orders > route orders_get-order"}, merger.removed)
+
+		mergedService := merged["services"].([]interface{})[0].(map[string]interface{})
+		assert.Equal(t, "orders.example.com", mergedService["host"], "generated fields are updated")
+		assert.Equal(t, 5000, mergedService["read_timeout"], "fields added by hand are kept")
+		plugins := mergedService["plugins"].([]interface{})
+		require.Len(t, plugins, 2, "plugins added by hand are kept")
+		assert.Equal(t, map[string]interface{}{"key_names": []interface{}{"apikey"}, "hide_credentials": true},
+			plugins[0].(map[string]interface{})["config"])
+		assert.Equal(t, "rate-limiting", plugins[1].(map[string]interface{})["name"])
+		assert.Equal(t, existing["consumers"], merged["consumers"])
+
+		routes := mergedService["routes"].([]interface{})
+		if prune {
+			require.Len(t, routes, 1)
+		} else {
+			require.Len(t, routes, 2, "routes no longer generated are kept")
+		}
+		assert.Equal(t, "orders_list-orders", routes[0].(map[string]interface{})["name"])
+	}
+}
+
+func TestIsGeneratedID(t *testing.T) {
+	assert.True(t, isGeneratedID("5d3ee1a6-4b1c-5b6e-9f0a-2c1d3e4f5a6b"))
+	assert.False(t, isGeneratedID("5d3ee1a6-4b1c-4b6e-9f0a-2c1d3e4f5a6b"), "random UUIDs are not generated")
+	assert.False(t, isGeneratedID(""))
+}
@@ -39,22 +39,32 @@ func executePatch(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	patchFiles := make([]patch.DeckPatchFile, 0)
-	{
-		for _, filename := range args {
-			var patchfile patch.DeckPatchFile
-			err := patchfile.ParseFile(filename)
-			if err != nil {
-				return fmt.Errorf("failed to parse '%s': %w", filename, err)
-			}
-			patchFiles = append(patchFiles, patchfile)
-		}
-	}
-
 	trackInfo := deckformat.HistoryNewEntry("patch")
 	trackInfo["input"] = cmdPatchInputFilename
 	trackInfo["output"] = cmdPatchOutputFilename
-	if (len(valuesPatch.ObjValues) + len(valuesPatch.Remove) + len(valuesPatch.ArrValues)) > 0 {
+
+	// do the work; read/patch/write
+	data, err := filebasics.DeserializeFile(cmdPatchInputFilename)
+	if err != nil {
+		return fmt.Errorf("failed to read input file '%s'; %w", cmdPatchInputFilename, err)
+	}
+	if data, err = patchDocument(data, valuesPatch, args, trackInfo); err != nil {
+		return err
+	}
+	deckformat.HistoryAppend(data, trackInfo)
+
+	return filebasics.WriteSerializedFile(cmdPatchOutputFilename, data, filebasics.OutputFormat(cmdPatchOutputFormat))
+}
+
+// patchDocument applies the values patch, and then the patch files, to data. It
+// is shared by the patch command and pipeline step. The options are recorded
+// in trackInfo.
+func patchDocument(
+	data map[string]interface{}, valuesPatch patch.DeckPatch, patchFilenames []string,
+	trackInfo map[string]interface{},
+) (map[string]interface{}, error) {
+	hasValues := len(valuesPatch.ObjValues)+len(valuesPatch.Remove)+len(valuesPatch.ArrValues) > 0
+	if hasValues {
 		trackInfo["selector"] = valuesPatch.SelectorSources
 	}
 	if len(valuesPatch.ObjValues) != 0 {
@@ -66,42 +76,34 @@ func executePatch(cmd *cobra.Command, args []string) error {
 	if len(valuesPatch.Remove) != 0 {
 		trackInfo["remove"] = valuesPatch.Remove
 	}
-	if len(args) != 0 {
-		trackInfo["patchfiles"] = args
+	if len(patchFilenames) != 0 {
+		trackInfo["patchfiles"] = patchFilenames
 	}
 
-	// do the work; read/patch/write
-	data, err := filebasics.DeserializeFile(cmdPatchInputFilename)
-	if err != nil {
-		return fmt.Errorf("failed to read input file '%s'; %w", cmdPatchInputFilename, err)
+	patchFiles := make([]patch.DeckPatchFile, len(patchFilenames))
+	for i, filename := range patchFilenames {
+		if err := patchFiles[i].ParseFile(filename); err != nil {
+			return nil, fmt.Errorf("failed to parse '%s': %w", filename, err)
+		}
 	}
-	deckformat.HistoryAppend(data, trackInfo) // add before patching, so patch can operate on it
 
 	yamlNode := jsonbasics.ConvertToYamlNode(data)
-
-	if (len(valuesPatch.ObjValues) + len(valuesPatch.Remove) + len(valuesPatch.ArrValues)) > 0 {
-		// apply selector + value flags
+	if hasValues {
+		// apply selector + values
 		logbasics.Debug("applying value-flags")
-		err = valuesPatch.ApplyToNodes(yamlNode)
-		if err != nil {
-			return fmt.Errorf("failed to apply command-line values; %w", err)
+		if err := valuesPatch.ApplyToNodes(yamlNode); err != nil {
+			return nil, fmt.Errorf("failed to apply values; %w", err)
 		}
 	}
 
-	if len(args) > 0 {
-		// apply patch files
-		for i, patchFile := range patchFiles {
-			logbasics.Debug("applying patch-file", "file", i)
-			err := patchFile.Apply(yamlNode)
-			if err != nil {
-				return fmt.Errorf("failed to apply patch-file '%s'; %w", args[i], err)
-			}
+	// apply patch files
+	for i, patchFile := range patchFiles {
+		logbasics.Debug("applying patch-file", "file", i)
+		if err := patchFile.Apply(yamlNode); err != nil {
+			return nil, fmt.Errorf("failed to apply patch-file '%s'; %w", patchFilenames[i], err)
 		}
 	}
-
-	data = jsonbasics.ConvertToJSONobject(yamlNode)
-
-	return filebasics.WriteSerializedFile(cmdPatchOutputFilename, data, filebasics.OutputFormat(cmdPatchOutputFormat))
+	return jsonbasics.ConvertToJSONobject(yamlNode), nil
 }
 
 //
@@ -0,0 +1,699 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/kong/deck/convert"
+	"github.com/kong/deck/lint"
+	"github.com/kong/go-apiops/deckformat"
+	"github.com/kong/go-apiops/filebasics"
+	"github.com/kong/go-apiops/logbasics"
+	"github.com/kong/go-apiops/namespace"
+	"github.com/kong/go-apiops/openapi2kong"
+	"github.com/kong/go-apiops/patch"
+	"github.com/kong/go-apiops/yamlbasics"
+	"github.com/kong/go-database-reconciler/pkg/file"
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	cmdPipelineInputFilename  string
+	cmdPipelineOutputFilename string
+	cmdPipelineOutputFormat   string
+)
+
+// pipelineFile is the format of a pipeline file.
+type pipelineFile struct {
+	Input  string            `json:"input"`
+	Output string            `json:"output"`
+	Format string            `json:"format"`
+	Steps  []json.RawMessage `json:"steps"`
+}
+
+// pipelineStep is a step of a pipeline, holding the options of the 'deck file'
+// command it runs.
+type pipelineStep interface {
+	// validate checks the options, before any step runs.
+	validate() error
+	// run transforms data, and records the options in trackInfo, the history
+	// entry of the step.
+	run(p *pipeline, data map[string]interface{}, trackInfo map[string]interface{}) (map[string]interface{}, error)
+}
+
+// pipelineSteps are the 'deck file' commands a pipeline can run.
+var pipelineSteps = map[string]func() pipelineStep{
+	"openapi2kong": func() pipelineStep { return &o2kPipelineStep{} },
+	"namespace":    func() pipelineStep { return &namespacePipelineStep{} },
+	"add-plugins":  func() pipelineStep { return &addPluginsPipelineStep{} },
+	"add-tags":     func() pipelineStep { return &addTagsPipelineStep{} },
+	"remove-tags":  func() pipelineStep { return &removeTagsPipelineStep{} },
+	"patch":        func() pipelineStep { return &patchPipelineStep{} },
+	"merge":        func() pipelineStep { return &mergePipelineStep{} },
+	"lint":         func() pipelineStep { return &lintPipelineStep{} },
+	"convert":      func() pipelineStep { return &convertPipelineStep{} },
+}
+
+type pipelineStepEntry struct {
+	name  string
+	cmd   string
+	label string
+	step  pipelineStep
+}
+
+// pipeline is a parsed pipeline file.
+type pipeline struct {
+	filename string
+	// dir is the directory the file names in the pipeline file are relative to.
+	dir    string
+	input  string
+	output string
+	format string
+	steps  []pipelineStepEntry
+	stderr io.Writer
+}
+
+// pipelineStepNames returns the names of the steps a pipeline can run.
+func pipelineStepNames() []string {
+	names := make([]string, 0, len(pipelineSteps))
+	for name := range pipelineSteps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// decodeStrict decodes JSON into v, rejecting unknown fields.
+func decodeStrict(b []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
+
+// loadPipeline reads and validates a pipeline file.
+func loadPipeline(filename string) (*pipeline, error) {
+	b, err := filebasics.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pipeline file '%s'; %w", filename, err)
+	}
+	jsonBytes, err := yaml.YAMLToJSON(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse pipeline file '%s'; %w", filename, err)
+	}
+	var pf pipelineFile
+	if err := decodeStrict(jsonBytes, &pf); err != nil {
+		return nil, fmt.Errorf("failed to parse pipeline file '%s'; %w", filename, err)
+	}
+	if len(pf.Steps) == 0 {
+		return nil, fmt.Errorf("pipeline file '%s' has no steps", filename)
+	}
+
+	p := &pipeline{filename: filename, dir: ".", stderr: os.Stderr}
+	if filename != "-" {
+		p.dir = filepath.Dir(filename)
+	}
+	p.input = p.path(pf.Input)
+	p.output = p.path(pf.Output)
+	p.format = pf.Format
+
+	for i, raw := range pf.Steps {
+		entry, err := parsePipelineStep(i, raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pipeline file '%s'; %w", filename, err)
+		}
+		p.steps = append(p.steps, entry)
+	}
+	return p, nil
+}
+
+// parsePipelineStep parses the i-th step of a pipeline: 'run' names the
+// command, 'name' optionally names the step, the other fields are the options
+// of the command.
+func parsePipelineStep(i int, raw json.RawMessage) (pipelineStepEntry, error) {
+	entry := pipelineStepEntry{label: fmt.Sprintf("step %d", i+1)}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return entry, fmt.Errorf("%s: a step must be an object; %w", entry.label, err)
+	}
+	for key, target := range map[string]*string{"run": &entry.cmd, "name": &entry.name} {
+		if value, ok := fields[key]; ok {
+			if err := json.Unmarshal(value, target); err != nil {
+				return entry, fmt.Errorf("%s: '%s' must be a string", entry.label, key)
+			}
+			delete(fields, key)
+		}
+	}
+	if entry.name != "" {
+		entry.label = fmt.Sprintf("step %d '%s'", i+1, entry.name)
+	}
+
+	newStep, ok := pipelineSteps[entry.cmd]
+	if !ok {
+		if entry.cmd == "" {
+			return entry, fmt.Errorf("%s: missing 'run', one of: %s", entry.label,
+				strings.Join(pipelineStepNames(), ", "))
+		}
+		return entry, fmt.Errorf("%s: unknown command '%s', one of: %s", entry.label, entry.cmd,
+			strings.Join(pipelineStepNames(), ", "))
+	}
+	entry.label = fmt.Sprintf("%s (%s)", entry.label, entry.cmd)
+
+	entry.step = newStep()
+	options, err := json.Marshal(fields)
+	if err != nil {
+		return entry, err
+	}
+	if err := decodeStrict(options, entry.step); err != nil {
+		return entry, fmt.Errorf("%s: invalid options; %w", entry.label, err)
+	}
+	if err := entry.step.validate(); err != nil {
+		return entry, fmt.Errorf("%s: %w", entry.label, err)
+	}
+	return entry, nil
+}
+
+// path returns the path of a file named in the pipeline file.
+func (p *pipeline) path(name string) string {
+	if name == "" || name == "-" || filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(p.dir, name)
+}
+
+// paths returns the paths of files named in the pipeline file.
+func (p *pipeline) paths(names []string) []string {
+	paths := make([]string, len(names))
+	for i, name := range names {
+		paths[i] = p.path(name)
+	}
+	return paths
+}
+
+// run runs the steps of the pipeline on data, and returns the result. Each
+// step adds an entry to the history of the result.
+func (p *pipeline) run(data map[string]interface{}) (map[string]interface{}, error) {
+	for _, entry := range p.steps {
+		logbasics.Info("running pipeline step", "step", entry.label)
+
+		trackInfo := deckformat.HistoryNewEntry(entry.cmd)
+		trackInfo["pipeline"] = p.filename
+		if entry.name != "" {
+			trackInfo["step"] = entry.name
+		}
+		result, err := entry.step.run(p, data, trackInfo)
+		if err != nil {
+			return nil, fmt.Errorf("pipeline %s failed: %w", entry.label, err)
+		}
+		deckformat.HistoryAppend(result, trackInfo)
+		data = result
+	}
+	return data, nil
+}
+
+// openapi2kong
+
+type o2kPipelineStep struct {
+	Spec                 string   `json:"spec"`
+	UUIDBase             string   `json:"uuid-base"`
+	SelectTag            []string `json:"select-tag"`
+	NoID                 bool     `json:"no-id"`
+	InsoCompatible       bool     `json:"inso-compatible"`
+	GenerateSecurity     bool     `json:"generate-security"`
+	IgnoreSecurityErrors bool     `json:"ignore-security-errors"`
+	IgnoreCircularRefs   bool     `json:"ignore-circular-refs"`
+	SkipRouteByHeader    bool     `json:"skip-route-by-header"`
+	ReuseServices        bool     `json:"reuse-services"`
+	MergeInto            string   `json:"merge-into"`
+	PruneRemoved         bool     `json:"prune-removed"`
+}
+
+func (s *o2kPipelineStep) validate() error {
+	if s.Spec == "" {
+		return fmt.Errorf("'spec' is required")
+	}
+	if s.PruneRemoved && s.MergeInto == "" {
+		return fmt.Errorf("'prune-removed' requires 'merge-into'")
+	}
+	if s.MergeInto != "" && (s.NoID || s.InsoCompatible) {
+		return fmt.Errorf("'merge-into' matches entities on their IDs, " +
+			"it cannot be combined with 'no-id' or 'inso-compatible'")
+	}
+	return nil
+}
+
+func (s *o2kPipelineStep) run(
+	p *pipeline, data map[string]interface{}, trackInfo map[string]interface{},
+) (map[string]interface{}, error) {
+	for key := range data {
+		if key != deckformat.HistoryKey {
+			return nil, fmt.Errorf("openapi2kong generates a new document, " +
+				"it must be the first step of a pipeline without input (use 'merge-into' to update a file)")
+		}
+	}
+
+	spec := p.path(s.Spec)
+	trackInfo["input"] = spec
+	trackInfo["uuid-base"] = s.UUIDBase
+	result, err := convertOpenAPISpec(spec, openapi2kong.O2kOptions{
+		Tags:                 s.SelectTag,
+		DocName:              s.UUIDBase,
+		SkipID:               s.NoID || s.InsoCompatible,
+		InsoCompat:           s.InsoCompatible,
+		OIDC:                 s.GenerateSecurity,
+		IgnoreSecurityErrors: s.IgnoreSecurityErrors,
+		IgnoreCircularRefs:   s.IgnoreCircularRefs,
+		SkipRouteByHeader:    s.SkipRouteByHeader,
+		ReuseServices:        s.ReuseServices,
+	}, p.path(s.MergeInto), s.PruneRemoved, p.stderr, trackInfo)
+	if err != nil {
+		return nil, err
+	}
+	deckformat.HistorySet(result, deckformat.HistoryGet(data))
+	return result, nil
+}
+
+// namespace
+
+type namespacePipelineStep struct {
+	Selector            []string `json:"selector"`
+	PathPrefix          string   `json:"path-prefix"`
+	AllowEmptySelectors bool     `json:"allow-empty-selectors"`
+	Host                []string `json:"host"`
+	ClearHosts          bool     `json:"clear-hosts"`
+}
+
+func (s *namespacePipelineStep) validate() error {
+	if s.PathPrefix != "" {
+		if err := namespace.CheckNamespace(s.PathPrefix); err != nil {
+			return fmt.Errorf("invalid path-prefix '%s': %w", s.PathPrefix, err)
+		}
+	}
+	_, err := yamlbasics.NewSelectorSet(s.Selector)
+	return err
+}
+
+func (s *namespacePipelineStep) run(
+	_ *pipeline, data map[string]interface{}, trackInfo map[string]interface{},
+) (map[string]interface{}, error) {
+	return applyNamespace(data, s.Selector, s.PathPrefix, s.Host, s.ClearHosts, s.AllowEmptySelectors, trackInfo)
+}
+
+// add-plugins
+
+type addPluginsPipelineStep struct {
+	Selector []string `json:"selector"`
+	// Config holds plugin configurations, as objects or, like the --config
+	// flag, as JSON strings.
+	Config      []interface{} `json:"config"`
+	Overwrite   bool          `json:"overwrite"`
+	PluginFiles []string      `json:"plugin-files"`
+
+	configs []map[string]interface{}
+}
+
+func (s *addPluginsPipelineStep) validate() error {
+	if len(s.Config) == 0 && len(s.Selector) > 0 {
+		return fmt.Errorf("'selector' given, but no 'config' specified")
+	}
+	if len(s.Config) == 0 && len(s.PluginFiles) == 0 {
+		return fmt.Errorf("either 'config' or 'plugin-files' is required")
+	}
+	for _, config := range s.Config {
+		switch c := config.(type) {
+		case map[string]interface{}:
+			s.configs = append(s.configs, c)
+		case string:
+			pluginConfig, err := filebasics.Deserialize([]byte(c))
+			if err != nil {
+				return fmt.Errorf("failed to deserialize plugin config '%s'; %w", c, err)
+			}
+			s.configs = append(s.configs, pluginConfig)
+		default:
+			return fmt.Errorf("plugin config must be an object or a JSON string, got %T", config)
+		}
+	}
+	return nil
+}
+
+func (s *addPluginsPipelineStep) run(
+	p *pipeline, data map[string]interface{}, trackInfo map[string]interface{},
+) (map[string]interface{}, error) {
+	return addPlugins(data, s.Selector, s.configs, s.Overwrite, p.paths(s.PluginFiles), trackInfo)
+}
+
+// add-tags
+
+type addTagsPipelineStep struct {
+	Tags     []string `json:"tags"`
+	Selector []string `json:"selector"`
+}
+
+func (s *addTagsPipelineStep) validate() error {
+	if len(s.Tags) == 0 {
+		return fmt.Errorf("'tags' is required")
+	}
+	return nil
+}
+
+func (s *addTagsPipelineStep) run(
+	_ *pipeline, data map[string]interface{}, trackInfo map[string]interface{},
+) (map[string]interface{}, error) {
+	return addTags(data, s.Tags, s.Selector, trackInfo)
+}
+
+// remove-tags
+
+type removeTagsPipelineStep struct {
+	Tags           []string `json:"tags"`
+	Selector       []string `json:"selector"`
+	KeepEmptyArray bool     `json:"keep-empty-array"`
+	KeepOnly       bool     `json:"keep-only"`
+}
+
+func (s *removeTagsPipelineStep) validate() error {
+	if !s.KeepOnly && len(s.Tags) == 0 {
+		return fmt.Errorf("no tags to remove")
+	}
+	return nil
+}
+
+func (s *removeTagsPipelineStep) run(
+	_ *pipeline, data map[string]interface{}, trackInfo map[string]interface{},
+) (map[string]interface{}, error) {
+	return removeTags(data, s.Tags, s.Selector, s.KeepOnly, s.KeepEmptyArray, trackInfo)
+}
+
+// patch
+
+type patchPipelineStep struct {
+	Selector   []string `json:"selector"`
+	Value      []string `json:"value"`
+	PatchFiles []string `json:"patch-files"`
+
+	valuesPatch patch.DeckPatch
+}
+
+func (s *patchPipelineStep) validate() error {
+	if len(s.Value) == 0 && len(s.PatchFiles) == 0 {
+		return fmt.Errorf("either 'value' or 'patch-files' is required")
+	}
+	var err error
+	s.valuesPatch.SelectorSources = s.Selector
+	s.valuesPatch.ObjValues, s.valuesPatch.Remove, s.valuesPatch.ArrValues, err = patch.ValidateValuesFlags(s.Value)
+	if err != nil {
+		return fmt.Errorf("failed parsing 'value' entry; %w", err)
+	}
+	return nil
+}
+
+func (s *patchPipelineStep) run(
+	p *pipeline, data map[string]interface{}, trackInfo map[string]interface{},
+) (map[string]interface{}, error) {
+	return patchDocument(data, s.valuesPatch, p.paths(s.PatchFiles), trackInfo)
+}
+
+// merge
+
+type mergePipelineStep struct {
+	Files      []string `json:"files"`
+	OnConflict string   `json:"on-conflict"`
+}
+
+func (s *mergePipelineStep) validate() error {
+	if len(s.Files) == 0 {
+		return fmt.Errorf("'files' is required")
+	}
+	if s.OnConflict != "" {
+		return validateInputFlag("on-conflict", s.OnConflict, mergeOnConflictStrategies, "")
+	}
+	return nil
+}
+
+// run merges the files into the document, the way 'deck file merge' merges
+// files. The history of the files is recorded in the entry of the step.
+func (s *mergePipelineStep) run(
+	p *pipeline, data map[string]interface{}, trackInfo map[string]interface{},
+) (map[string]interface{}, error) {
+	files, info, err := loadMergeSources(p.paths(s.Files))
+	if err != nil {
+		return nil, err
+	}
+	history := deckformat.HistoryGet(data)
+	var sources []mergeSource
+	for key := range data {
+		if key != deckformat.HistoryKey {
+			sources = append(sources, mergeSource{filename: "the pipeline document", data: data})
+			break
+		}
+	}
+	sources = append(sources, files...)
+
+	result, err := mergeWithConflicts(sources, s.OnConflict, p.stderr)
+	if err != nil {
+		return nil, err
+	}
+	deckformat.HistorySet(result, history)
+
+	trackInfo["files"] = info
+	if s.OnConflict != "" {
+		trackInfo["on-conflict"] = s.OnConflict
+	}
+	return result, nil
+}
+
+// lint
+
+type lintPipelineStep struct {
+	Ruleset             string `json:"ruleset"`
+	Format              string `json:"format"`
+	FailSeverity        string `json:"fail-severity"`
+	DisplayOnlyFailures bool   `json:"display-only-failures"`
+	OutputFile          string `json:"output-file"`
+}
+
+func (s *lintPipelineStep) validate() error {
+	if s.Ruleset == "" {
+		return fmt.Errorf("'ruleset' is required")
+	}
+	if s.Format == "" {
+		s.Format = plainTextFormat
+	}
+	if s.FailSeverity == "" {
+		s.FailSeverity = "error"
+	}
+	return nil
+}
+
+// run lints the document, it fails if any results are of the fail-severity or
+// above. The document is not changed.
+func (s *lintPipelineStep) run(
+	p *pipeline, data map[string]interface{}, trackInfo map[string]interface{},
+) (map[string]interface{}, error) {
+	stateBytes, err := filebasics.Serialize(data, filebasics.OutputFormatYaml)
+	if err != nil {
+		return nil, err
+	}
+	ruleset := p.path(s.Ruleset)
+	lintErrs, err := lintState(stateBytes, ruleset, s.FailSeverity, s.DisplayOnlyFailures)
+	if err != nil {
+		return nil, err
+	}
+
+	// the results go to stderr by default, stdout is for the document
+	output := p.stderr
+	if s.OutputFile == "-" {
+		output = os.Stdout
+	} else if s.OutputFile != "" {
+		f, err := os.Create(p.path(s.OutputFile))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create lint output file '%s'; %w", p.path(s.OutputFile), err)
+		}
+		defer f.Close()
+		output = f
+	}
+	failed, err := lint.WriteLintOutput(output, lintErrs, s.Format)
+	if err != nil {
+		return nil, err
+	}
+	if failed {
+		return nil, errors.New("linting errors detected")
+	}
+	trackInfo["ruleset"] = ruleset
+	trackInfo["fail-severity"] = s.FailSeverity
+	return data, nil
+}
+
+// convert
+
+type convertPipelineStep struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+
+	from convert.Format
+	to   convert.Format
+}
+
+func (s *convertPipelineStep) validate() error {
+	var err error
+	if s.from, err = convert.ParseFormat(s.From); err != nil {
+		return err
+	}
+	if s.to, err = convert.ParseFormat(s.To); err != nil {
+		return err
+	}
+	if s.from == convert.FormatDistributed {
+		return fmt.Errorf("converting from '%s' format is not supported in a pipeline, "+
+			"use 'deck file render'", s.from)
+	}
+	return nil
+}
+
+// run converts the document with 'deck file convert'. Its history is kept, the
+// conversion does not know about it.
+func (s *convertPipelineStep) run(
+	p *pipeline, data map[string]interface{}, trackInfo map[string]interface{},
+) (map[string]interface{}, error) {
+	history := deckformat.HistoryGet(data)
+	stripped := make(map[string]interface{}, len(data))
+	for key, value := range data {
+		stripped[key] = value
+	}
+	deckformat.HistoryClear(stripped)
+
+	stateBytes, err := filebasics.Serialize(stripped, filebasics.OutputFormatYaml)
+	if err != nil {
+		return nil, err
+	}
+	input, err := file.GetContentFromReader(bytes.NewReader(stateBytes), file.EnvVarsSkip)
+	if err != nil {
+		return nil, err
+	}
+	output, err := convert.ConvertContent(input, stateBytes, p.input, s.from, s.to)
+	if err != nil {
+		return nil, err
+	}
+	outputBytes, err := json.Marshal(output)
+	if err != nil {
+		return nil, err
+	}
+	result, err := filebasics.Deserialize(outputBytes)
+	if err != nil {
+		return nil, err
+	}
+	deckformat.HistorySet(result, history)
+
+	trackInfo["from"] = string(s.from)
+	trackInfo["to"] = string(s.to)
+	return result, nil
+}
+
+// Executes the CLI command "pipeline run"
+func executePipelineRun(cmd *cobra.Command, args []string) error {
+	verbosity, _ := cmd.Flags().GetInt("verbose")
+	logbasics.Initialize(log.LstdFlags, verbosity)
+	_ = sendAnalytics("file-pipeline-run", "", modeLocal)
+
+	p, err := loadPipeline(args[0])
+	if err != nil {
+		return err
+	}
+	p.stderr = cmd.ErrOrStderr()
+
+	if cmd.Flags().Changed("state") {
+		p.input = cmdPipelineInputFilename
+	}
+	output := p.output
+	if cmd.Flags().Changed("output-file") || output == "" {
+		output = cmdPipelineOutputFilename
+	}
+	format := p.format
+	if cmd.Flags().Changed("format") || format == "" {
+		format = getFormatFlagValue(cmd, cmdPipelineOutputFormat)
+	}
+
+	data := map[string]interface{}{}
+	if p.input != "" {
+		if data, err = filebasics.DeserializeFile(p.input); err != nil {
+			return fmt.Errorf("failed to read input file '%s'; %w", p.input, err)
+		}
+	}
+
+	result, err := p.run(data)
+	if err != nil {
+		return err
+	}
+	return filebasics.WriteSerializedFile(output, result, filebasics.OutputFormat(strings.ToUpper(format)))
+}
+
+//
+//
+// Define the CLI data for the pipeline command
+//
+//
+
+func newFilePipelineCmd() *cobra.Command {
+	pipelineCmd := &cobra.Command{
+		Use:   "pipeline",
+		Short: "Run pipelines of decK file transformations",
+		Long: `The pipeline command runs a chain of 'deck file' commands, declared in a
+pipeline file, on a single in-memory document.`,
+	}
+
+	runCmd := &cobra.Command{
+		Use:   "run [flags] pipeline-file",
+		Short: "Run the steps of a pipeline file",
+		Long: `Run the steps of a pipeline file, in order, on a single in-memory document.
+
+Each step runs a 'deck file' command, named by 'run', with the options that
+command accepts as flags. The positional arguments of the commands are options
+as well: 'tags' for add-tags and remove-tags, 'plugin-files' for add-plugins,
+'patch-files' for patch, 'files' for merge, and 'ruleset' for lint. The
+document is read from 'input', or generated by a first openapi2kong step, and
+written to 'output'. File names are relative to the pipeline file.
+
+  input: kong.yaml                # optional, use - to read from stdin
+  output: kong.out.yaml           # default - (stdout)
+  format: yaml                    # yaml or json
+  steps:
+  - run: namespace
+    path-prefix: /orders
+  - name: team tags               # optional, to refer to the step
+    run: add-tags
+    tags: [team-orders]
+  - run: patch
+    selector: ["$..services[*]"]
+    value: ["read_timeout:10000"]
+  - run: merge
+    files: [shared-plugins.yaml]
+  - run: lint
+    ruleset: ruleset.yaml
+
+Like the 'deck file' commands, each step adds an entry to the history of the
+document. The results of lint steps are written to stderr, or to their
+'output-file'. When a step fails, nothing is written and the error names the step.`,
+		RunE: executePipelineRun,
+		Example: "# Run the pipeline, writing to the output of the pipeline file\n" +
+			"deck file pipeline run pipeline.yaml\n" +
+			"# Run the pipeline on another input\n" +
+			"deck file pipeline run pipeline.yaml --state other.yaml -o other.out.yaml",
+		Args: cobra.ExactArgs(1),
+	}
+	runCmd.Flags().StringVarP(&cmdPipelineInputFilename, "state", "s", "",
+		"decK file to process, instead of the input of the pipeline file. Use - to read from stdin.")
+	runCmd.Flags().StringVarP(&cmdPipelineOutputFilename, "output-file", "o", "-",
+		"Output file to write to, instead of the output of the pipeline file. Use - to write to stdout.")
+	runCmd.Flags().StringVarP(&cmdPipelineOutputFormat, "format", "", "yaml", "output format: yaml or json")
+
+	pipelineCmd.AddCommand(runCmd)
+	return pipelineCmd
+}
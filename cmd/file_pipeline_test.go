@@ -0,0 +1,213 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kong/go-apiops/filebasics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writePipelineFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600))
+	}
+	return dir
+}
+
+const pipelineTestState = `_format_version: "3.0"
+services:
+- name: orders
+  host: orders.internal
+  routes:
+  - name: list
+    paths: [/list]
+`
+
+func TestLoadPipeline(t *testing.T) {
+	tests := []struct {
+		name     string
+		pipeline string
+		wantErr  string
+	}{
+		{
+			name:     "no steps",
+			pipeline: "input: kong.yaml\n",
+			wantErr:  "has no steps",
+		},
+		{
+			name:     "unknown command",
+			pipeline: "steps:\n- run: add-tags\n  tags: [a]\n- run: sync\n",
+			wantErr:  "step 2: unknown command 'sync'",
+		},
+		{
+			name:     "missing run",
+			pipeline: "steps:\n- tags: [a]\n",
+			wantErr:  "step 1: missing 'run'",
+		},
+		{
+			name:     "unknown option",
+			pipeline: "steps:\n- name: tag\n  run: add-tags\n  tags: [a]\n  selectors: [x]\n",
+			wantErr:  `step 1 'tag' (add-tags): invalid options; json: unknown field "selectors"`,
+		},
+		{
+			name:     "invalid options",
+			pipeline: "steps:\n- run: namespace\n  path-prefix: /a\n- run: patch\n",
+			wantErr:  "step 2 (patch): either 'value' or 'patch-files' is required",
+		},
+		{
+			name:     "distributed conversion",
+			pipeline: "steps:\n- run: convert\n  from: distributed\n  to: kong-gateway-3.x\n",
+			wantErr:  "step 1 (convert): converting from 'distributed' format is not supported",
+		},
+		{
+			name:     "unknown pipeline field",
+			pipeline: "stages: []\n",
+			wantErr:  `unknown field "stages"`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := writePipelineFiles(t, map[string]string{"pipeline.yaml": tc.pipeline})
+			_, err := loadPipeline(filepath.Join(dir, "pipeline.yaml"))
+			require.ErrorContains(t, err, tc.wantErr)
+		})
+	}
+}
+
+func TestPipelineRun(t *testing.T) {
+	dir := writePipelineFiles(t, map[string]string{
+		"kong.yaml": pipelineTestState,
+		"shared.yaml": `_format_version: "3.1"
+plugins:
+- name: cors
+`,
+		"pipeline.yaml": `input: kong.yaml
+output: out.yaml
+steps:
+- run: namespace
+  path-prefix: /orders
+- name: team tags
+  run: add-tags
+  tags: [team-orders]
+- run: add-plugins
+  selector: ["$..services[*]"]
+  config:
+  - name: rate-limiting
+    config: {minute: 10}
+  - '{"name": "acl"}'
+- run: patch
+  selector: ["$..services[*]"]
+  value: ["read_timeout:10000"]
+- run: merge
+  files: [shared.yaml]
+`,
+	})
+
+	p, err := loadPipeline(filepath.Join(dir, "pipeline.yaml"))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "kong.yaml"), p.input)
+	assert.Equal(t, filepath.Join(dir, "out.yaml"), p.output)
+	require.Len(t, p.steps, 5)
+
+	data, err := filebasics.DeserializeFile(p.input)
+	require.NoError(t, err)
+	result, err := p.run(data)
+	require.NoError(t, err)
+
+	assert.Equal(t, "3.1", result["_format_version"])
+	pluginList := getSlice(t, result, "plugins")
+	require.Len(t, pluginList, 1)
+	assert.Equal(t, "cors", asMap(t, pluginList[0])["name"])
+
+	services := getSlice(t, result, "services")
+	require.Len(t, services, 1)
+	service := asMap(t, services[0])
+	assert.EqualValues(t, 10000, service["read_timeout"])
+	assert.Equal(t, []interface{}{"team-orders"}, service["tags"])
+	servicePlugins := getSlice(t, service, "plugins")
+	require.Len(t, servicePlugins, 2)
+	assert.Equal(t, "rate-limiting", asMap(t, servicePlugins[0])["name"])
+	assert.Equal(t, "acl", asMap(t, servicePlugins[1])["name"])
+
+	route := asMap(t, getSlice(t, service, "routes")[0])
+	assert.Equal(t, []interface{}{"/orders/list"}, route["paths"])
+}
+
+func TestPipelineRunReportsFailingStep(t *testing.T) {
+	dir := writePipelineFiles(t, map[string]string{
+		"kong.yaml": pipelineTestState,
+		"pipeline.yaml": `input: kong.yaml
+steps:
+- run: add-tags
+  tags: [a]
+- name: fixups
+  run: patch
+  patch-files: [missing.yaml]
+- run: add-tags
+  tags: [b]
+`,
+	})
+
+	p, err := loadPipeline(filepath.Join(dir, "pipeline.yaml"))
+	require.NoError(t, err)
+	data, err := filebasics.DeserializeFile(p.input)
+	require.NoError(t, err)
+	_, err = p.run(data)
+	require.ErrorContains(t, err, "pipeline step 2 'fixups' (patch) failed: failed to parse")
+	assert.ErrorContains(t, err, "missing.yaml")
+}
+
+func TestPipelineRunConvert(t *testing.T) {
+	dir := writePipelineFiles(t, map[string]string{
+		"pipeline.yaml": `steps:
+- run: convert
+  from: "3.4"
+  to: "3.10"
+`,
+	})
+
+	p, err := loadPipeline(filepath.Join(dir, "pipeline.yaml"))
+	require.NoError(t, err)
+	data, err := filebasics.Deserialize([]byte(pipelineTestState))
+	require.NoError(t, err)
+	result, err := p.run(data)
+	require.NoError(t, err)
+
+	services := getSlice(t, result, "services")
+	require.Len(t, services, 1)
+	assert.Equal(t, "orders", asMap(t, services[0])["name"])
+}
+
+func TestPipelineRunLintWritesToStderr(t *testing.T) {
+	dir := writePipelineFiles(t, map[string]string{
+		"ruleset.yaml": `rules:
+  service-tags:
+    description: services must be tagged
+    given: $.services[*]
+    severity: warn
+    then:
+      field: tags
+      function: truthy
+`,
+		"pipeline.yaml": `steps:
+- run: lint
+  ruleset: ruleset.yaml
+`,
+	})
+
+	p, err := loadPipeline(filepath.Join(dir, "pipeline.yaml"))
+	require.NoError(t, err)
+	var stderr bytes.Buffer
+	p.stderr = &stderr
+	data, err := filebasics.Deserialize([]byte(pipelineTestState))
+	require.NoError(t, err)
+	_, err = p.run(data)
+	require.NoError(t, err)
+	assert.Contains(t, stderr.String(), "Linting Violations: 1")
+}
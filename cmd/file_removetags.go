@@ -39,28 +39,14 @@ func executeRemoveTags(cmd *cobra.Command, tagsToRemove []string) error {
 		return fmt.Errorf("failed to read input file '%s'; %w", cmdRemoveTagsInputFilename, err)
 	}
 
-	tagger := tags.Tagger{}
-	tagger.SetData(data)
-	err = tagger.SetSelectors(cmdRemoveTagsSelectors)
-	if err != nil {
-		return fmt.Errorf("failed to set selectors; %w", err)
-	}
-	if cmdRemoveTagsKeepOnlyTags {
-		err = tagger.RemoveUnknownTags(tagsToRemove, !cmdRemoveTagsKeepEmptyArrays)
-	} else {
-		err = tagger.RemoveTags(tagsToRemove, !cmdRemoveTagsKeepEmptyArrays)
-	}
-	if err != nil {
-		return fmt.Errorf("failed to remove tags; %w", err)
-	}
-	data = tagger.GetData()
-
 	trackInfo := deckformat.HistoryNewEntry("remove-tags")
 	trackInfo["input"] = cmdRemoveTagsInputFilename
 	trackInfo["output"] = cmdRemoveTagsOutputFilename
-	trackInfo["tags"] = tagsToRemove
-	trackInfo["keep-empty-array"] = cmdRemoveTagsKeepEmptyArrays
-	trackInfo["selectors"] = cmdRemoveTagsSelectors
+	data, err = removeTags(data, tagsToRemove, cmdRemoveTagsSelectors,
+		cmdRemoveTagsKeepOnlyTags, cmdRemoveTagsKeepEmptyArrays, trackInfo)
+	if err != nil {
+		return err
+	}
 	deckformat.HistoryAppend(data, trackInfo)
 
 	return filebasics.WriteSerializedFile(
@@ -69,6 +55,35 @@ func executeRemoveTags(cmd *cobra.Command, tagsToRemove []string) error {
 		filebasics.OutputFormat(cmdRemoveTagsOutputFormat))
 }
 
+// removeTags removes tags from the objects of data that match the selectors, or
+// with keepOnly, all the other tags. It is shared by the remove-tags command and
+// pipeline step. The options are recorded in trackInfo.
+func removeTags(
+	data map[string]interface{}, tagsToRemove, selectors []string, keepOnly, keepEmptyArray bool,
+	trackInfo map[string]interface{},
+) (map[string]interface{}, error) {
+	trackInfo["tags"] = tagsToRemove
+	trackInfo["keep-only"] = keepOnly
+	trackInfo["keep-empty-array"] = keepEmptyArray
+	trackInfo["selectors"] = selectors
+
+	tagger := tags.Tagger{}
+	tagger.SetData(data)
+	if err := tagger.SetSelectors(selectors); err != nil {
+		return nil, fmt.Errorf("failed to set selectors; %w", err)
+	}
+	var err error
+	if keepOnly {
+		err = tagger.RemoveUnknownTags(tagsToRemove, !keepEmptyArray)
+	} else {
+		err = tagger.RemoveTags(tagsToRemove, !keepEmptyArray)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to remove tags; %w", err)
+	}
+	return tagger.GetData(), nil
+}
+
 //
 //
 // Define the CLI data for the remove-tags command
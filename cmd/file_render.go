@@ -13,6 +13,8 @@ var (
 	fileRenderCmdKongFileOutput  string
 	fileRenderCmdStateFormat     string
 	fileRenderCmdPopulateEnvVars bool
+	fileRenderCmdValues          []string
+	fileRenderCmdStrict          bool
 )
 
 func executeFileRenderCmd(cmd *cobra.Command, _ []string) error {
@@ -22,8 +24,29 @@ func executeFileRenderCmd(cmd *cobra.Command, _ []string) error {
 		envVarsMode = file.EnvVarsExpand
 	}
 
+	tmpl := &stateTemplate{
+		mockEnvVars: envVarsMode == file.EnvVarsMock,
+		strict:      fileRenderCmdStrict,
+	}
+	if len(fileRenderCmdValues) > 0 {
+		values, err := loadTemplateValues(fileRenderCmdValues)
+		if err != nil {
+			return err
+		}
+		tmpl.values = values
+	}
+	stateFiles, rendered, cleanup, err := renderStateTemplates(fileRenderCmdKongStateFile, tmpl)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	if rendered {
+		// the environment variables are substituted when rendering the templates
+		envVarsMode = file.EnvVarsSkip
+	}
+
 	return convert.Convert(
-		fileRenderCmdKongStateFile,
+		stateFiles,
 		fileRenderCmdKongFileOutput,
 		file.Format(strings.ToUpper(getFormatFlagValue(cmd, fileRenderCmdStateFormat))),
 		convert.FormatDistributed,
@@ -51,6 +74,44 @@ For example, the following command takes two input files and renders them as one
 combined JSON file:
 
   deck file render kong1.yml kong2.yml -o kong3 --format json
+
+With --values, the files are templates rendered with the values of values
+files, under .Values. The templates use the syntax of the environment variable
+substitution, with conditionals and loops:
+
+  services:
+  - name: orders
+    url: ${{ .Values.orders.url }}
+    routes:
+  ${{- range .Values.regions }}
+    - name: orders-${{ .name }}
+      hosts: [${{ .host }}]
+  ${{- end }}
+  ${{- if .Values.rateLimit }}
+    plugins:
+    - name: rate-limiting
+      config:
+        minute: ${{ .Values.rateLimit }}
+  ${{- end }}
+
+Values files are applied in order: the objects of later files are merged into
+those of earlier ones, other values are replaced. Undefined values render
+empty; with --strict, undefined values, and values the files do not refer to,
+are errors:
+
+  deck file render kong.yaml --values base.yaml --values prod.yaml --strict
+
+State files can also read secrets, which are mocked unless --populate-env-vars
+is given:
+
+  ${{ file "/run/secrets/db-password" }}        the content of a file
+  ${{ vault "secret/orders#password" }}         a field of a Vault KV secret
+  ${{ sops "secrets.enc.yaml" "db.password" }}  a value of a SOPS encrypted file
+
+Vault is reached with VAULT_ADDR, VAULT_TOKEN and VAULT_NAMESPACE. SOPS files
+are decrypted with the sops binary, with the age key of SOPS_AGE_KEY_FILE.
+The sync and diff commands resolve the secrets too, and mask them in their
+output like the values of DECK_ environment variables.
 `,
 		Args: cobra.ArbitraryArgs,
 		RunE: executeFileRenderCmd,
@@ -74,6 +135,11 @@ combined JSON file:
 	renderCmd.Flags().BoolVar(&fileRenderCmdPopulateEnvVars, "populate-env-vars", false,
 		"Populate 'DECK_' environment variables in the output file. The default behavior\n"+
 			"is to mock environment variable values.")
+	renderCmd.Flags().StringArrayVar(&fileRenderCmdValues, "values", []string{},
+		"values file to render the input files with, as templates.\n"+
+			"Repeat to override the values of earlier files.")
+	renderCmd.Flags().BoolVar(&fileRenderCmdStrict, "strict", false,
+		"fail on undefined values, and on values the input files do not refer to.")
 	addDiagnosticSeverityFlags(renderCmd.Flags())
 
 	return renderCmd
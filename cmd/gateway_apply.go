@@ -39,6 +39,18 @@ func newApplyCmd() *cobra.Command {
 			if err := checkParallelism(applyCmdParallelism); err != nil {
 				return err
 			}
+			if err := preRunEntityFilterFlags(); err != nil {
+				return err
+			}
+			if err := preRunIgnoreFieldFlags(); err != nil {
+				return err
+			}
+			if err := preRunProtectFlags(); err != nil {
+				return err
+			}
+			if err := preRunSyncEventsFlags(); err != nil {
+				return err
+			}
 			return preRunSilenceEventsFlag()
 		},
 	}
@@ -61,6 +73,10 @@ func newApplyCmd() *cobra.Command {
 	applyCmd.Flags().BoolVar(&dumpConfig.IncludePluginDefinitions, "include-plugin-definitions",
 		false, "allow deck to apply plugin definitions.\n"+
 			"Plugin definitions work with Konnect and Gateway versions >= 3.15.")
+	addEntityFilterFlags(applyCmd.Flags())
+	addIgnoreFieldFlags(applyCmd.Flags())
+	addProtectFlags(applyCmd.Flags())
+	addSyncEventsFlags(applyCmd.Flags())
 	addDiagnosticSeverityFlags(applyCmd.Flags())
 	addSilenceEventsFlag(applyCmd.Flags())
 
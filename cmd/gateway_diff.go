@@ -3,6 +3,7 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/spf13/cobra"
 )
@@ -13,11 +14,15 @@ var (
 	diffCmdNonZeroExitCode bool
 	diffWorkspace          string
 	diffJSONOutput         bool
+	diffCmdOutputFormat    string
 )
 
 func executeDiff(cmd *cobra.Command, _ []string) error {
+	// every format other than text is rendered from the JSON report
+	enableJSONOutput := diffJSONOutput ||
+		(diffCmdOutputFormat != "" && diffCmdOutputFormat != diffOutputFormatText)
 	return syncMain(cmd.Context(), diffCmdKongStateFile, true,
-		diffCmdParallelism, 0, diffWorkspace, diffJSONOutput, ApplyTypeFull)
+		diffCmdParallelism, 0, diffWorkspace, enableJSONOutput, ApplyTypeFull)
 }
 
 // newDiffCmd represents the diff command
@@ -31,12 +36,25 @@ func newDiffCmd(deprecated bool) *cobra.Command {
 		if len(diffCmdKongStateFile) == 0 {
 			diffCmdKongStateFile = []string{"-"}
 		}
+		if err := validateDiffOutputFormat(diffCmdOutputFormat); err != nil {
+			return err
+		}
+		if diffJSONOutput && diffCmdOutputFormat != diffOutputFormatText &&
+			diffCmdOutputFormat != diffOutputFormatJSON {
+			return fmt.Errorf("--json-output cannot be used with --output-format %s", diffCmdOutputFormat)
+		}
 		if err := preRunDiagnosticPolicyFlags(); err != nil {
 			return err
 		}
 		if err := checkParallelism(diffCmdParallelism); err != nil {
 			return err
 		}
+		if err := preRunEntityFilterFlags(); err != nil {
+			return err
+		}
+		if err := preRunIgnoreFieldFlags(); err != nil {
+			return err
+		}
 		return preRunSilenceEventsFlag()
 	}
 
@@ -98,7 +116,8 @@ that will be created, updated, or deleted.
 	diffCmd.Flags().IntVar(&diffCmdParallelism, "parallelism",
 		10, "Maximum number of concurrent operations.")
 	diffCmd.Flags().BoolVar(&noMaskValues, "no-mask-deck-env-vars-value",
-		false, "do not mask DECK_ environment variable values at diff output.")
+		false, "do not mask DECK_ environment variable values, and secrets\n"+
+			"of state files, at diff output.")
 	diffCmd.Flags().StringSliceVar(&dumpConfig.SelectorTags,
 		"select-tag", []string{},
 		"only entities matching tags specified via this flag are diffed.\n"+
@@ -127,6 +146,18 @@ that will be created, updated, or deleted.
 	diffCmd.Flags().BoolVar(&dumpConfig.IncludePluginDefinitions, "include-plugin-definitions",
 		false, "allow deck to diff plugin definitions.\n"+
 			"Plugin definitions work with Konnect and Gateway versions >= 3.15.")
+	if !deprecated {
+		diffCmd.Flags().StringVar(&diffCmdOutPlan, "out-plan", "",
+			"write the computed changes, together with a fingerprint of the current\n"+
+				"state, to a plan file that can be applied with 'deck gateway sync --plan'.\n"+
+				"The plan contains the rendered target configuration; treat it as a secret.")
+		diffCmd.Flags().StringVar(&diffCmdOutputFormat, "output-format", diffOutputFormatText,
+			"format of the diff report: "+strings.Join(diffOutputFormats, ", ")+".\n"+
+				"'json-patch' reports an RFC 6902 patch per entity, empty for deletes, 'markdown' a report\n"+
+				"suitable for pull request comments and 'junit' one testcase per drifted entity.")
+		addEntityFilterFlags(diffCmd.Flags())
+		addIgnoreFieldFlags(diffCmd.Flags())
+	}
 	addDiagnosticSeverityFlags(diffCmd.Flags())
 	addSilenceEventsFlag(diffCmd.Flags())
 	return diffCmd
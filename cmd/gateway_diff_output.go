@@ -0,0 +1,380 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/kong/go-database-reconciler/pkg/diff"
+)
+
+const (
+	diffOutputFormatText      = "text"
+	diffOutputFormatJSON      = "json"
+	diffOutputFormatJSONPatch = "json-patch"
+	diffOutputFormatMarkdown  = "markdown"
+	diffOutputFormatJUnit     = "junit"
+)
+
+var diffOutputFormats = []string{
+	diffOutputFormatText,
+	diffOutputFormatJSON,
+	diffOutputFormatJSONPatch,
+	diffOutputFormatMarkdown,
+	diffOutputFormatJUnit,
+}
+
+// patchOperation is a single RFC 6902 JSON Patch operation.
+type patchOperation struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value any    `json:"value"`
+}
+
+// MarshalJSON writes the value of add, replace and test operations even when
+// it is null, false, 0 or "", as RFC 6902 requires it, and leaves it out of
+// the other operations.
+func (o patchOperation) MarshalJSON() ([]byte, error) {
+	switch o.Op {
+	case "add", "replace", "test":
+		type operation patchOperation
+		return json.Marshal(operation(o))
+	}
+	return json.Marshal(struct {
+		Op   string `json:"op"`
+		Path string `json:"path"`
+	}{o.Op, o.Path})
+}
+
+// entityPatch describes the change to a single entity as a JSON Patch that
+// turns the current entity into the target entity. A patch cannot remove the
+// whole document, so the patch of a delete is empty: the action tells it.
+type entityPatch struct {
+	Action string           `json:"action"`
+	Kind   string           `json:"kind"`
+	Name   string           `json:"name"`
+	Patch  []patchOperation `json:"patch"`
+}
+
+type jsonPatchReport struct {
+	Changes  []entityPatch `json:"changes"`
+	Summary  diff.Summary  `json:"summary"`
+	Warnings []string      `json:"warnings"`
+	Errors   []string      `json:"errors"`
+}
+
+func validateDiffOutputFormat(format string) error {
+	for _, f := range diffOutputFormats {
+		if format == f {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid --output-format %q, must be one of: %s",
+		format, strings.Join(diffOutputFormats, ", "))
+}
+
+// renderDiffReport renders the collected JSON report in the requested format.
+func renderDiffReport(report diff.JSONOutputObject, format string) (string, error) {
+	switch format {
+	case "", diffOutputFormatJSON:
+		b, err := json.MarshalIndent(report, "", "\t")
+		return string(b), err
+	case diffOutputFormatJSONPatch:
+		patches, err := entityPatches(report.Changes)
+		if err != nil {
+			return "", err
+		}
+		b, err := json.MarshalIndent(jsonPatchReport{
+			Changes:  patches,
+			Summary:  report.Summary,
+			Warnings: report.Warnings,
+			Errors:   report.Errors,
+		}, "", "\t")
+		return string(b), err
+	case diffOutputFormatMarkdown:
+		return renderMarkdownReport(report)
+	case diffOutputFormatJUnit:
+		return renderJUnitReport(report)
+	}
+	return "", fmt.Errorf("unsupported output format %q", format)
+}
+
+// entityPatches converts the changes of a report into per-entity patches,
+// ordered as creates, updates and then deletes.
+func entityPatches(changes diff.EntityChanges) ([]entityPatch, error) {
+	patches := []entityPatch{}
+	for _, group := range []struct {
+		action  string
+		changes []diff.EntityState
+	}{
+		{"create", changes.Creating},
+		{"update", changes.Updating},
+		{"delete", changes.Deleting},
+	} {
+		for _, change := range group.changes {
+			oldObj, newObj, err := entityStateObjects(change)
+			if err != nil {
+				return nil, fmt.Errorf("building patch for %s %s: %w", change.Kind, change.Name, err)
+			}
+			patch := []patchOperation{}
+			if group.action != "delete" {
+				patch = jsonPatch(oldObj, newObj)
+			}
+			patches = append(patches, entityPatch{
+				Action: group.action,
+				Kind:   change.Kind,
+				Name:   change.Name,
+				Patch:  patch,
+			})
+		}
+	}
+	return patches, nil
+}
+
+// entityStateObjects returns the old and new entity of a change as generic
+// JSON values. The syncer stores them under the "old" and "new" keys of Body.
+func entityStateObjects(change diff.EntityState) (any, any, error) {
+	if change.Body == nil {
+		return nil, nil, nil
+	}
+	b, err := json.Marshal(change.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	var body struct {
+		Old any `json:"old"`
+		New any `json:"new"`
+	}
+	if err := json.Unmarshal(b, &body); err != nil {
+		return nil, nil, err
+	}
+	return body.Old, body.New, nil
+}
+
+// currentEntityObject returns the entity as it currently is in Kong. The
+// syncer stores the deleted entity as the new object of a delete.
+func currentEntityObject(oldObj, newObj any) any {
+	if oldObj != nil {
+		return oldObj
+	}
+	return newObj
+}
+
+// jsonPatch returns the operations that turn oldObj into newObj. Objects are
+// compared field by field; arrays and scalars are replaced as a whole. A nil
+// newObj has no patch.
+func jsonPatch(oldObj, newObj any) []patchOperation {
+	ops := []patchOperation{}
+	switch {
+	case newObj == nil:
+	case oldObj == nil:
+		ops = append(ops, patchOperation{Op: "add", Path: "", Value: newObj})
+	default:
+		ops = appendPatch(ops, "", oldObj, newObj)
+	}
+	return ops
+}
+
+func appendPatch(ops []patchOperation, path string, oldObj, newObj any) []patchOperation {
+	oldMap, oldIsMap := oldObj.(map[string]any)
+	newMap, newIsMap := newObj.(map[string]any)
+	if !oldIsMap || !newIsMap {
+		if !reflect.DeepEqual(oldObj, newObj) {
+			ops = append(ops, patchOperation{Op: "replace", Path: path, Value: newObj})
+		}
+		return ops
+	}
+
+	keys := make([]string, 0, len(oldMap)+len(newMap))
+	for k := range oldMap {
+		keys = append(keys, k)
+	}
+	for k := range newMap {
+		if _, ok := oldMap[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		childPath := path + "/" + escapeJSONPointer(k)
+		oldValue, inOld := oldMap[k]
+		newValue, inNew := newMap[k]
+		switch {
+		case !inNew:
+			ops = append(ops, patchOperation{Op: "remove", Path: childPath})
+		case !inOld:
+			ops = append(ops, patchOperation{Op: "add", Path: childPath, Value: newValue})
+		default:
+			ops = appendPatch(ops, childPath, oldValue, newValue)
+		}
+	}
+	return ops
+}
+
+// escapeJSONPointer escapes a reference token as described in RFC 6901.
+func escapeJSONPointer(s string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(s)
+}
+
+func renderMarkdownReport(report diff.JSONOutputObject) (string, error) {
+	var b strings.Builder
+	b.WriteString("## decK diff\n\n")
+	b.WriteString("| Action | Count |\n|---|---|\n")
+	fmt.Fprintf(&b, "| Creating | %d |\n", report.Summary.Creating)
+	fmt.Fprintf(&b, "| Updating | %d |\n", report.Summary.Updating)
+	fmt.Fprintf(&b, "| Deleting | %d |\n", report.Summary.Deleting)
+	fmt.Fprintf(&b, "| **Total** | **%d** |\n", report.Summary.Total)
+
+	patches, err := entityPatches(report.Changes)
+	if err != nil {
+		return "", err
+	}
+	for _, section := range []struct {
+		title  string
+		action string
+	}{
+		{"Creating", "create"},
+		{"Updating", "update"},
+		{"Deleting", "delete"},
+	} {
+		var entries []entityPatch
+		for _, p := range patches {
+			if p.Action == section.action {
+				entries = append(entries, p)
+			}
+		}
+		if len(entries) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\n### %s\n\n", section.title)
+		for _, p := range entries {
+			if p.Action != "update" {
+				fmt.Fprintf(&b, "- %s %s\n", p.Kind, markdownCodeSpan(p.Name))
+				continue
+			}
+			patch, err := json.MarshalIndent(p.Patch, "", "  ")
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(&b, "- <details><summary>%s <code>%s</code></summary>\n\n", p.Kind, html.EscapeString(p.Name))
+			fmt.Fprintf(&b, "  ```json\n%s\n  ```\n  </details>\n", indentLines(string(patch), "  "))
+		}
+	}
+
+	for _, section := range []struct {
+		title    string
+		messages []string
+	}{
+		{"Warnings", report.Warnings},
+		{"Errors", report.Errors},
+	} {
+		if len(section.messages) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\n### %s\n\n", section.title)
+		for _, m := range section.messages {
+			fmt.Fprintf(&b, "- %s\n", m)
+		}
+	}
+	return b.String(), nil
+}
+
+// markdownCodeSpan returns s as a code span, delimited by more backticks than
+// s contains in a row, as CommonMark has no escapes in code spans.
+func markdownCodeSpan(s string) string {
+	longest, run := 0, 0
+	for _, c := range s {
+		if c != '`' {
+			run = 0
+			continue
+		}
+		run++
+		longest = max(longest, run)
+	}
+	fence := strings.Repeat("`", longest+1)
+	if strings.HasPrefix(s, "`") || strings.HasSuffix(s, "`") {
+		// a space is stripped on each side of the content
+		s = " " + s + " "
+	}
+	return fence + s + fence
+}
+
+func indentLines(s, prefix string) string {
+	lines := strings.Split(s, "\n")
+	for i := range lines {
+		lines[i] = prefix + lines[i]
+	}
+	return strings.Join(lines, "\n")
+}
+
+type junitTestSuite struct {
+	XMLName   xml.Name        `xml:"testsuite"`
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// renderJUnitReport renders a JUnit XML report with one failed testcase per
+// drifted entity and one errored testcase per error.
+func renderJUnitReport(report diff.JSONOutputObject) (string, error) {
+	patches, err := entityPatches(report.Changes)
+	if err != nil {
+		return "", err
+	}
+	suite := junitTestSuite{Name: "deck diff", TestCases: []junitTestCase{}}
+	for _, p := range patches {
+		patch, err := json.MarshalIndent(p.Patch, "", "  ")
+		if err != nil {
+			return "", err
+		}
+		suite.TestCases = append(suite.TestCases, junitTestCase{
+			ClassName: p.Kind,
+			Name:      p.Name,
+			Failure: &junitMessage{
+				Message: fmt.Sprintf("%s %s would be %sd", p.Kind, p.Name, p.Action),
+				Type:    p.Action,
+				Text:    string(patch),
+			},
+		})
+		suite.Failures++
+	}
+	for i, e := range report.Errors {
+		suite.TestCases = append(suite.TestCases, junitTestCase{
+			ClassName: "error",
+			Name:      fmt.Sprintf("error %d", i+1),
+			Error:     &junitMessage{Message: e, Type: "error"},
+		})
+		suite.Errors++
+	}
+	suite.Tests = len(suite.TestCases)
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "  ")
+	if err := enc.Encode(suite); err != nil {
+		return "", fmt.Errorf("rendering JUnit report: %w", err)
+	}
+	return buf.String(), nil
+}
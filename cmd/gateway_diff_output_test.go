@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"testing"
+
+	"github.com/kong/go-database-reconciler/pkg/diff"
+	"github.com/kong/go-database-reconciler/pkg/state"
+	"github.com/kong/go-kong/kong"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testDiffReport() diff.JSONOutputObject {
+	oldService := &state.Service{Service: kong.Service{
+		Name: kong.String("svc"),
+		Host: kong.String("old.example.com"),
+		Tags: kong.StringSlice("a/b"),
+	}}
+	newService := &state.Service{Service: kong.Service{
+		Name: kong.String("svc"),
+		Host: kong.String("new.example.com"),
+		Port: kong.Int(8080),
+	}}
+	return diff.JSONOutputObject{
+		Changes: diff.EntityChanges{
+			Creating: []diff.EntityState{{
+				Name: "route-1", Kind: "route",
+				Body: map[string]any{"old": nil, "new": &state.Route{Route: kong.Route{Name: kong.String("route-1")}}},
+			}},
+			Updating: []diff.EntityState{{
+				Name: "svc", Kind: "service",
+				Body: map[string]any{"old": oldService, "new": newService},
+			}},
+			Deleting: []diff.EntityState{{
+				Name: "cons", Kind: "consumer",
+				Body: map[string]any{"old": nil, "new": &state.Consumer{Consumer: kong.Consumer{Username: kong.String("cons")}}},
+			}},
+		},
+		Summary:  diff.Summary{Creating: 1, Updating: 1, Deleting: 1, Total: 3},
+		Warnings: []string{},
+		Errors:   []string{"something went wrong"},
+	}
+}
+
+func TestJSONPatch(t *testing.T) {
+	oldObj := map[string]any{"host": "a", "tags": []any{"x"}, "a/b": 1, "config": map[string]any{"k": "v"}}
+	newObj := map[string]any{"host": "b", "port": 80.0, "a/b": 1, "config": map[string]any{"k": "w"}}
+	assert.Equal(t, []patchOperation{
+		{Op: "replace", Path: "/config/k", Value: "w"},
+		{Op: "replace", Path: "/host", Value: "b"},
+		{Op: "add", Path: "/port", Value: 80.0},
+		{Op: "remove", Path: "/tags"},
+	}, jsonPatch(oldObj, newObj))
+
+	assert.Equal(t, []patchOperation{{Op: "add", Path: "", Value: newObj}}, jsonPatch(nil, newObj))
+	assert.Empty(t, jsonPatch(oldObj, nil))
+	assert.Equal(t, "/a~1b~0c", "/"+escapeJSONPointer("a/b~c"))
+}
+
+func TestPatchOperationMarshalJSON(t *testing.T) {
+	oldObj := map[string]any{"enabled": true, "port": 80.0, "path": "/a", "ca": "x", "tags": []any{"x"}}
+	newObj := map[string]any{"enabled": false, "port": 0.0, "path": "", "ca": nil}
+	b, err := json.Marshal(jsonPatch(oldObj, newObj))
+	require.NoError(t, err)
+	assert.JSONEq(t, `[
+		{"op": "replace", "path": "/ca", "value": null},
+		{"op": "replace", "path": "/enabled", "value": false},
+		{"op": "replace", "path": "/path", "value": ""},
+		{"op": "replace", "path": "/port", "value": 0},
+		{"op": "remove", "path": "/tags"}
+	]`, string(b))
+}
+
+func TestRenderDiffReport_JSONPatch(t *testing.T) {
+	out, err := renderDiffReport(testDiffReport(), diffOutputFormatJSONPatch)
+	require.NoError(t, err)
+
+	var report jsonPatchReport
+	require.NoError(t, json.Unmarshal([]byte(out), &report))
+	require.Len(t, report.Changes, 3)
+	assert.Equal(t, "create", report.Changes[0].Action)
+	assert.Equal(t, "update", report.Changes[1].Action)
+	assert.Equal(t, []patchOperation{
+		{Op: "replace", Path: "/host", Value: "new.example.com"},
+		{Op: "add", Path: "/port", Value: 8080.0},
+		{Op: "remove", Path: "/tags"},
+	}, report.Changes[1].Patch)
+	assert.Equal(t, "delete", report.Changes[2].Action)
+	assert.Empty(t, report.Changes[2].Patch, "the action tells a delete")
+	assert.Contains(t, out, `"patch": []`)
+	assert.Equal(t, []string{"something went wrong"}, report.Errors)
+}
+
+func TestRenderDiffReport_Markdown(t *testing.T) {
+	out, err := renderDiffReport(testDiffReport(), diffOutputFormatMarkdown)
+	require.NoError(t, err)
+	assert.Contains(t, out, "| **Total** | **3** |")
+	assert.Contains(t, out, "### Creating\n\n- route `route-1`\n")
+	assert.Contains(t, out, "<summary>service <code>svc</code></summary>")
+	assert.Contains(t, out, `"path": "/host"`)
+	assert.Contains(t, out, "### Errors\n\n- something went wrong\n")
+	assert.NotContains(t, out, "### Warnings")
+
+	report := testDiffReport()
+	report.Changes.Creating[0].Name = "a`b"
+	report.Changes.Updating[0].Name = "<svc>"
+	out, err = renderDiffReport(report, diffOutputFormatMarkdown)
+	require.NoError(t, err)
+	assert.Contains(t, out, "- route ``a`b``\n")
+	assert.Contains(t, out, "<summary>service <code>&lt;svc&gt;</code></summary>")
+}
+
+func TestMarkdownCodeSpan(t *testing.T) {
+	assert.Equal(t, "`svc`", markdownCodeSpan("svc"))
+	assert.Equal(t, "```a``b```", markdownCodeSpan("a``b"))
+	assert.Equal(t, "`` `svc ``", markdownCodeSpan("`svc"))
+}
+
+func TestRenderDiffReport_JUnit(t *testing.T) {
+	out, err := renderDiffReport(testDiffReport(), diffOutputFormatJUnit)
+	require.NoError(t, err)
+
+	var suite junitTestSuite
+	require.NoError(t, xml.Unmarshal([]byte(out), &suite))
+	assert.Equal(t, 4, suite.Tests)
+	assert.Equal(t, 3, suite.Failures)
+	assert.Equal(t, 1, suite.Errors)
+	assert.Equal(t, "service", suite.TestCases[1].ClassName)
+	assert.Equal(t, "svc", suite.TestCases[1].Name)
+	assert.Equal(t, "service svc would be updated", suite.TestCases[1].Failure.Message)
+}
+
+func TestDiffCmd_OutputFormat(t *testing.T) {
+	cmd := newDiffCmd(false)
+	diffCmdParallelism = 10
+	defer func() {
+		diffCmdOutputFormat = diffOutputFormatText
+		diffJSONOutput = false
+	}()
+
+	diffCmdOutputFormat = "yaml"
+	require.ErrorContains(t, cmd.PreRunE(cmd, []string{"-"}), `invalid --output-format "yaml"`)
+
+	diffCmdOutputFormat = diffOutputFormatJUnit
+	require.NoError(t, cmd.PreRunE(cmd, []string{"-"}))
+
+	diffJSONOutput = true
+	require.EqualError(t, cmd.PreRunE(cmd, []string{"-"}),
+		"--json-output cannot be used with --output-format junit")
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/kong/deck/sanitize"
@@ -58,25 +59,26 @@ func getWorkspaceClient(ctx context.Context, workspace string) (*kong.Client, er
 	return wsClient, nil
 }
 
-func getKongState(ctx context.Context, wsClient *kong.Client) (*state.KongState, error) {
+func getKongState(ctx context.Context, wsClient *kong.Client) (*state.KongState, *utils.KongRawState, error) {
 	rawState, err := dump.Get(ctx, wsClient, dumpConfig)
 	if err != nil {
-		return nil, fmt.Errorf("reading configuration from Kong: %w", err)
+		return nil, nil, fmt.Errorf("reading configuration from Kong: %w", err)
 	}
+	activeEntityFilter.filterRawState(rawState)
 	ks, err := state.Get(rawState)
 	if err != nil {
-		return nil, fmt.Errorf("building state: %w", err)
+		return nil, nil, fmt.Errorf("building state: %w", err)
 	}
-	return ks, nil
+	return ks, rawState, nil
 }
 
 func sanitizeContent(ctx context.Context, client *kong.Client,
 	ks *state.KongState, writeConfig file.WriteConfig, isKonnect bool,
-) error {
+) (*file.Content, error) {
 	writeConfig.WithID = true // always write IDs for sanitization
 	fileContent, err := file.KongStateToContent(ks, writeConfig)
 	if err != nil {
-		return fmt.Errorf("sanitizing content: %w", err)
+		return nil, fmt.Errorf("sanitizing content: %w", err)
 	}
 
 	sanitizer := sanitize.NewSanitizer(&sanitize.SanitizerOptions{
@@ -89,22 +91,76 @@ func sanitizeContent(ctx context.Context, client *kong.Client,
 
 	sanitizedContent, err := sanitizer.Sanitize()
 	if err != nil {
-		return fmt.Errorf("sanitizing content: %w", err)
+		return nil, fmt.Errorf("sanitizing content: %w", err)
+	}
+	return sanitizedContent, nil
+}
+
+// writeKongState writes a dumped state to writeConfig.Filename or, with
+// --split-by, to several files in the output directory.
+func writeKongState(ctx context.Context, client *kong.Client,
+	ks *state.KongState, writeConfig file.WriteConfig, isKonnect bool,
+) error {
+	var content *file.Content
+	var err error
+	if writeConfig.SanitizeContent {
+		content, err = sanitizeContent(ctx, client, ks, writeConfig, isKonnect)
+	} else {
+		content, err = file.KongStateToContent(ks, writeConfig)
+	}
+	if err != nil {
+		return err
+	}
+	content, err = activeDumpSecrets.externalize(ctx, client, content, writeConfig.Workspace, isKonnect)
+	if err != nil {
+		return err
 	}
 
-	return file.WriteContentToFile(sanitizedContent, writeConfig.Filename, writeConfig.FileFormat)
+	if dumpSplitBy == "" {
+		return activeDumpSecrets.writeContent(content, writeConfig.Filename, writeConfig.FileFormat)
+	}
+	dir := dumpOutputDir
+	if dumpAllWorkspaces {
+		dir = filepath.Join(dir, writeConfig.Workspace)
+	}
+	return writeSplitContent(content, dir, dumpSplitBy, writeConfig.FileFormat)
 }
 
 func executeDump(cmd *cobra.Command, _ []string) error {
 	ctx := cmd.Context()
 	dumpCmdStateFormat = getFormatFlagValue(cmd, dumpCmdStateFormat)
 
-	if yes, err := utils.ConfirmFileOverwrite(dumpCmdKongStateFile, dumpCmdStateFormat, assumeYes); err != nil {
+	confirmOverwrite := func() (bool, error) {
+		if dumpSplitBy != "" {
+			return confirmDirOverwrite(dumpOutputDir, assumeYes)
+		}
+		return utils.ConfirmFileOverwrite(dumpCmdKongStateFile, dumpCmdStateFormat, assumeYes)
+	}
+	if yes, err := confirmOverwrite(); err != nil {
 		return err
 	} else if !yes {
 		return nil
 	}
 
+	activeDumpSecrets = nil
+	if dumpExternalizeSecrets {
+		activeDumpSecrets = &dumpSecrets{}
+	}
+	if len(dumpEncryptRecipients) > 0 {
+		var err error
+		if activeDumpSecrets, err = newEncryptingDumpSecrets(dumpEncryptRecipients); err != nil {
+			return err
+		}
+	}
+	if err := dumpKongState(ctx); err != nil {
+		return err
+	}
+	return activeDumpSecrets.writeEnvFile(dumpEnvFilename())
+}
+
+// dumpKongState dumps the configuration of Konnect or Kong, as set by the
+// flags.
+func dumpKongState(ctx context.Context) error {
 	if inKonnectMode(nil) {
 		_ = sendAnalytics("dump", "", modeKonnect)
 		return dumpKonnectV2(ctx)
@@ -122,6 +178,8 @@ func executeDump(cmd *cobra.Command, _ []string) error {
 		return fmt.Errorf("reading Kong version: %w", err)
 	}
 
+	cacheSchemas(wsClient, rootConfig.Address, kongVersion)
+
 	isAIGateway, err := isAIGatewayInstance(ctx, wsClient)
 	if err != nil {
 		return fmt.Errorf("checking if Kong is an AI Gateway: %w", err)
@@ -158,8 +216,9 @@ func executeDump(cmd *cobra.Command, _ []string) error {
 			if err != nil {
 				return fmt.Errorf("getting Kong client for workspace '%s': %w", workspace, err)
 			}
+			cacheSchemas(wsClient, rootConfig.Address, kongVersion)
 
-			ks, err := getKongState(ctx, wsClient)
+			ks, rawState, err := getKongState(ctx, wsClient)
 			if err != nil {
 				return fmt.Errorf("getting Kong state for workspace '%s': %w", workspace, err)
 			}
@@ -167,15 +226,10 @@ func executeDump(cmd *cobra.Command, _ []string) error {
 			writeConfig.Workspace = workspace
 			writeConfig.Filename = workspace
 
-			if dumpConfig.SanitizeContent {
-				if err := sanitizeContent(ctx, wsClient, ks, writeConfig, false); err != nil {
-					return fmt.Errorf("sanitizing content for workspace '%s': %w", workspace, err)
-				}
-			} else {
-				if err := file.KongStateToFile(ks, writeConfig); err != nil {
-					return err
-				}
+			if err := writeKongState(ctx, wsClient, ks, writeConfig, false); err != nil {
+				return fmt.Errorf("writing configuration of workspace '%s': %w", workspace, err)
 			}
+			recordHistoryRevision(historyCommandDump, rawState, historyKongAddr(), workspace, kongVersion)
 		}
 		return nil
 	}
@@ -187,18 +241,19 @@ func executeDump(cmd *cobra.Command, _ []string) error {
 		if err != nil {
 			return err
 		}
+		cacheSchemas(wsClient, rootConfig.Address, kongVersion)
 	}
 
-	ks, err := getKongState(ctx, wsClient)
+	ks, rawState, err := getKongState(ctx, wsClient)
 	if err != nil {
 		return fmt.Errorf("getting Kong state: %w", err)
 	}
 
-	if dumpConfig.SanitizeContent {
-		return sanitizeContent(ctx, wsClient, ks, writeConfig, false)
+	if err := writeKongState(ctx, wsClient, ks, writeConfig, false); err != nil {
+		return err
 	}
-
-	return file.KongStateToFile(ks, writeConfig)
+	recordHistoryRevision(historyCommandDump, rawState, historyKongAddr(), dumpWorkspace, kongVersion)
+	return nil
 }
 
 // newDumpCmd represents the dump command
@@ -229,6 +284,15 @@ The file can then be read using the sync command or diff command to
 configure Kong.`,
 		Args: validateNoArgs,
 		RunE: execute,
+		PreRunE: func(_ *cobra.Command, _ []string) error {
+			if err := validateSplitBy(dumpSplitBy); err != nil {
+				return err
+			}
+			if dumpEnvFile != "" && !dumpExternalizeSecrets {
+				return fmt.Errorf("--env-file requires --externalize-secrets")
+			}
+			return preRunEntityFilterFlags()
+		},
 	}
 
 	dumpCmd.Flags().StringVar(&dumpCmdStateFormat, "format",
@@ -286,6 +350,40 @@ configure Kong.`,
 		dumpCmd.Flags().StringVarP(&dumpCmdKongStateFile, "output-file", "o",
 			fileOutDefault, "file to which to write Kong's configuration."+
 				"Use `-` to write to stdout.")
+		dumpCmd.Flags().StringVar(&dumpSplitBy, "split-by", "",
+			"write the configuration to several files in --output-dir instead of a single file:\n"+
+				"  service:     one file per service, with its routes and plugins, and separate\n"+
+				"               files for consumers, certificates and global plugins\n"+
+				"  tag:         one file per tag, by the first tag of each entity\n"+
+				"  entity-type: one file per entity type\n"+
+				"Top-level fields and the remaining entities are written to kong.yaml.\n"+
+				"With --all-workspaces, each workspace is written to a subdirectory.\n"+
+				"The directory can be passed to sync, diff and validate as is.")
+		dumpCmd.Flags().StringVar(&dumpOutputDir, "output-dir", "",
+			"directory to write the configuration to with --split-by.\n"+
+				"The files written to it by a previous dump are replaced. A directory that is\n"+
+				"not empty and was not written by a dump is refused.")
+		dumpCmd.Flags().BoolVar(&dumpExternalizeSecrets, "externalize-secrets", false,
+			"replace credential secrets, certificate and key PEMs, and the plugin and vault\n"+
+				"configuration fields marked as encrypted or referenceable in their schema with\n"+
+				"${{ env \"DECK_...\" | printf \"%q\" }} references, and write the values to an env file.\n"+
+				"The state files can then be committed and replayed with the env file loaded,\n"+
+				"e.g. with 'set -a; . ./.env; deck gateway sync kong.yaml' or 'deck file render'.")
+		dumpCmd.Flags().StringVar(&dumpEnvFile, "env-file", "",
+			"file to write the secrets to with --externalize-secrets.\n"+
+				"Defaults to a .env file next to the output file or in --output-dir.")
+		dumpCmd.Flags().StringSliceVar(&dumpEncryptRecipients, "encrypt-recipient", []string{},
+			"encrypt the values of credential secrets, certificate and key PEMs, and of the\n"+
+				"plugin and vault configuration fields marked as encrypted or referenceable in their\n"+
+				"schema in the state files, to an age recipient (age1...), e.g. generated with\n"+
+				"'age-keygen'. Other fields are written in clear text. Repeat for several recipients.\n"+
+				"The state files are decrypted by the other commands with --decrypt-key-file.")
+		dumpCmd.MarkFlagsMutuallyExclusive("sanitize", "externalize-secrets")
+		dumpCmd.MarkFlagsMutuallyExclusive("sanitize", "encrypt-recipient")
+		dumpCmd.MarkFlagsMutuallyExclusive("externalize-secrets", "encrypt-recipient")
+		dumpCmd.MarkFlagsRequiredTogether("split-by", "output-dir")
+		dumpCmd.MarkFlagsMutuallyExclusive("output-file", "output-dir")
+		addEntityFilterFlags(dumpCmd.Flags())
 	}
 	dumpCmd.MarkFlagsMutuallyExclusive("output-file", "all-workspaces")
 	dumpCmd.MarkFlagsMutuallyExclusive("workspace", "all-workspaces")
@@ -0,0 +1,200 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/kong/deck/sanitize"
+	"github.com/kong/go-database-reconciler/pkg/file"
+	"github.com/kong/go-database-reconciler/pkg/utils"
+	"github.com/kong/go-kong/kong"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	defaultEnvFileName  = ".env"
+	secretsEnvVarPrefix = "DECK_"
+)
+
+var (
+	dumpExternalizeSecrets bool
+	dumpEnvFile            string
+	dumpEncryptRecipients  []string
+	// activeDumpSecrets collects the secrets externalized by a dump; nil
+	// unless --externalize-secrets or --encrypt-recipient is set.
+	activeDumpSecrets *dumpSecrets
+)
+
+// dumpSecrets collects the secrets externalized from the state files of a
+// dump, which may span several workspaces, to write them to a single env
+// file. With recipients, the secrets are encrypted in the state files
+// instead, see encryptStateFile.
+type dumpSecrets struct {
+	secrets        []sanitize.Secret
+	recipients     []age.Recipient
+	recipientNames []string
+}
+
+// newEncryptingDumpSecrets returns the dumpSecrets encrypting the secrets to
+// recipients.
+func newEncryptingDumpSecrets(recipients []string) (*dumpSecrets, error) {
+	d := &dumpSecrets{}
+	for _, recipient := range recipients {
+		key, err := parseRecipient(recipient)
+		if err != nil {
+			return nil, err
+		}
+		d.recipients = append(d.recipients, key)
+		d.recipientNames = append(d.recipientNames, recipient)
+	}
+	return d, nil
+}
+
+// externalize replaces the secrets of content with placeholders. With
+// --all-workspaces, the env vars are prefixed with the workspace, so that
+// the secrets of all workspaces can share the env file.
+func (d *dumpSecrets) externalize(ctx context.Context, client *kong.Client,
+	content *file.Content, workspace string, isKonnect bool,
+) (*file.Content, error) {
+	if d == nil {
+		return content, nil
+	}
+	prefix := secretsEnvVarPrefix
+	if dumpAllWorkspaces && workspace != "" {
+		prefix += workspace + "_"
+	}
+	externalizer := sanitize.NewExternalizer(&sanitize.ExternalizerOptions{
+		Ctx:          ctx,
+		Client:       client,
+		Content:      content,
+		IsKonnect:    isKonnect,
+		EnvVarPrefix: envVarName(prefix),
+	})
+	content, secrets, err := externalizer.Externalize()
+	if err != nil {
+		return nil, fmt.Errorf("externalizing secrets: %w", err)
+	}
+	d.secrets = append(d.secrets, secrets...)
+	return content, nil
+}
+
+// writeContent writes a state file, replacing the placeholders of the
+// externalized secrets with env references, or their encrypted values.
+func (d *dumpSecrets) writeContent(content *file.Content, filename string, format file.Format) error {
+	if d == nil {
+		return file.WriteContentToFile(content, filename, format)
+	}
+	if filename == "-" {
+		c, err := marshalContent(content, format)
+		if err != nil {
+			return err
+		}
+		if c, err = d.replacePlaceholders(c, format); err != nil {
+			return err
+		}
+		if _, err := fmt.Print(string(c)); err != nil {
+			return fmt.Errorf("writing file: %w", err)
+		}
+		return nil
+	}
+
+	if err := file.WriteContentToFile(content, filename, format); err != nil {
+		return err
+	}
+	filename = utils.AddExtToFilename(filename, strings.ToLower(string(format)))
+	c, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("reading file: %w", err)
+	}
+	if c, err = d.replacePlaceholders(c, format); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filename, c, 0o600); err != nil {
+		return fmt.Errorf("writing file: %w", err)
+	}
+	return nil
+}
+
+func (d *dumpSecrets) replacePlaceholders(content []byte, format file.Format) ([]byte, error) {
+	if d.recipients == nil {
+		return sanitize.ReplacePlaceholders(content, d.secrets), nil
+	}
+	return encryptStateFile(content, format, d.secrets, d.recipients, d.recipientNames)
+}
+
+// writeEnvFile writes the externalized secrets to filename, readable by
+// the owner only. The values are single-quoted, so that a shell sourcing the
+// file reads them back as they are.
+func (d *dumpSecrets) writeEnvFile(filename string) error {
+	if d == nil || d.recipients != nil {
+		return nil
+	}
+	var b strings.Builder
+	b.WriteString("# Secrets externalized by 'deck gateway dump', referenced by the state files.\n")
+	b.WriteString("# Do not commit this file.\n")
+	for _, secret := range d.secrets {
+		fmt.Fprintf(&b, "%s=%s\n", secret.EnvVar, shellQuote(secret.Value))
+	}
+	if dir := filepath.Dir(filename); dir != "." {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return fmt.Errorf("creating env file directory: %w", err)
+		}
+	}
+	if err := os.WriteFile(filename, []byte(b.String()), 0o600); err != nil {
+		return fmt.Errorf("writing env file: %w", err)
+	}
+	return nil
+}
+
+// shellQuote single-quotes s for a POSIX shell. Within single quotes, only
+// single quotes need escaping: they end the quoting, are escaped and start it
+// again. Newlines are kept as they are.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// dumpEnvFilename returns the env file the secrets of a dump are written
+// to: --env-file if set, else a .env file next to the state files.
+func dumpEnvFilename() string {
+	switch {
+	case dumpEnvFile != "":
+		return dumpEnvFile
+	case dumpSplitBy != "":
+		return filepath.Join(dumpOutputDir, defaultEnvFileName)
+	case dumpAllWorkspaces || dumpCmdKongStateFile == "-":
+		return defaultEnvFileName
+	default:
+		return filepath.Join(filepath.Dir(dumpCmdKongStateFile), defaultEnvFileName)
+	}
+}
+
+func marshalContent(content *file.Content, format file.Format) ([]byte, error) {
+	switch format {
+	case file.YAML:
+		return yaml.Marshal(content)
+	case file.JSON:
+		return json.MarshalIndent(content, "", "  ")
+	default:
+		return nil, fmt.Errorf("unknown file format: %s", format)
+	}
+}
+
+// envVarName upper-cases name and replaces the characters not allowed in
+// env var names with underscores.
+func envVarName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
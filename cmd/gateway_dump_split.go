@@ -0,0 +1,250 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/kong/go-database-reconciler/pkg/file"
+	"github.com/kong/go-database-reconciler/pkg/utils"
+)
+
+const (
+	splitByService    = "service"
+	splitByTag        = "tag"
+	splitByEntityType = "entity-type"
+
+	// splitMainFile holds the top-level fields of a split dump, such as
+	// _info, and the entities not written to a file of their own.
+	splitMainFile = "kong"
+	// splitManifestFile lists the files written by a split dump, relative to
+	// the output directory, for the next dump to replace them and only them.
+	splitManifestFile = ".deck-dump"
+)
+
+var (
+	dumpSplitBy   string
+	dumpOutputDir string
+)
+
+// serviceSplitFiles are the files the top-level entities other than services
+// are written to with --split-by service. Entities not listed here are
+// written to the main file.
+var serviceSplitFiles = map[string]string{
+	"consumers":       "consumers",
+	"consumer_groups": "consumers",
+	"certificates":    "certificates",
+	"ca_certificates": "certificates",
+	"plugins":         "plugins",
+}
+
+func validateSplitBy(splitBy string) error {
+	switch splitBy {
+	case "", splitByService, splitByTag, splitByEntityType:
+		return nil
+	}
+	return fmt.Errorf("invalid --split-by value %q, must be one of: %s, %s, %s",
+		splitBy, splitByService, splitByTag, splitByEntityType)
+}
+
+// splitContent splits content into the files of a split dump, keyed by their
+// path relative to the output directory, without extension. Every file
+// carries the format version and workspace, so that it is valid on its own;
+// the other top-level fields are only written to the main file, as they would
+// be duplicated when the files are merged again.
+func splitContent(content *file.Content, splitBy string) map[string]*file.Content {
+	main := &file.Content{
+		FormatVersion: content.FormatVersion,
+		Transform:     content.Transform,
+		Info:          content.Info,
+		Workspace:     content.Workspace,
+		Konnect:       content.Konnect,
+		PluginConfigs: content.PluginConfigs,
+	}
+	files := map[string]*file.Content{splitMainFile: main}
+	get := func(name string) *file.Content {
+		if _, ok := files[name]; !ok {
+			files[name] = &file.Content{FormatVersion: content.FormatVersion, Workspace: content.Workspace}
+		}
+		return files[name]
+	}
+	appendEntity := func(dst *file.Content, field int, entity reflect.Value) {
+		f := reflect.ValueOf(dst).Elem().Field(field)
+		f.Set(reflect.Append(f, entity))
+	}
+
+	usedServiceFiles := map[string]bool{}
+	v := reflect.ValueOf(content).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		entities := v.Field(i)
+		if entities.Kind() != reflect.Slice || entities.Len() == 0 {
+			continue
+		}
+		key, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("json"), ",")
+		for j := 0; j < entities.Len(); j++ {
+			entity := entities.Index(j)
+			dst := main
+			switch splitBy {
+			case splitByEntityType:
+				dst = get(key)
+			case splitByTag:
+				if tag := firstEntityTag(entity); tag != "" {
+					dst = get(filepath.Join("tags", utils.NameToFilename(tag)))
+				}
+			case splitByService:
+				if key == "services" {
+					name := uniqueFileName(entityFileName(entity, fmt.Sprintf("service-%d", j+1)), usedServiceFiles)
+					dst = get(filepath.Join("services", name))
+				} else if name, ok := serviceSplitFiles[key]; ok {
+					dst = get(name)
+				}
+			}
+			appendEntity(dst, i, entity)
+		}
+	}
+	return files
+}
+
+// entityFileName returns a file name for an entity of a split dump, based on
+// its name or ID.
+func entityFileName(entity reflect.Value, fallback string) string {
+	for _, field := range []string{"Name", "ID"} {
+		if f := entity.FieldByName(field); f.IsValid() && f.Kind() == reflect.Pointer && !f.IsNil() {
+			if s, ok := f.Elem().Interface().(string); ok && s != "" {
+				return utils.NameToFilename(s)
+			}
+		}
+	}
+	return fallback
+}
+
+// uniqueFileName returns name, suffixed with a number if it is already used.
+func uniqueFileName(name string, used map[string]bool) string {
+	unique := name
+	for n := 2; used[strings.ToLower(unique)]; n++ {
+		unique = fmt.Sprintf("%s-%d", name, n)
+	}
+	used[strings.ToLower(unique)] = true
+	return unique
+}
+
+// firstEntityTag returns the first tag of an entity, if any.
+func firstEntityTag(entity reflect.Value) string {
+	tags := entity.FieldByName("Tags")
+	if !tags.IsValid() || tags.Kind() != reflect.Slice {
+		return ""
+	}
+	for i := 0; i < tags.Len(); i++ {
+		if tag, ok := tags.Index(i).Interface().(*string); ok && tag != nil && *tag != "" {
+			return *tag
+		}
+	}
+	return ""
+}
+
+// writeSplitContent writes content to dir, split into several files. The
+// files written by a previous dump to dir are removed first, so that
+// entities deleted from Kong do not linger in the directory. Other files are
+// never removed: a non-empty directory that was not written by a dump is
+// refused.
+func writeSplitContent(content *file.Content, dir, splitBy string, format file.Format) error {
+	stale, err := previousSplitFiles(dir)
+	if err != nil {
+		return err
+	}
+	for _, filename := range stale {
+		if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("removing previous dump file: %w", err)
+		}
+	}
+
+	files := splitContent(content, splitBy)
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	ext := strings.ToLower(string(format))
+	var manifest strings.Builder
+	for _, name := range names {
+		filename := filepath.Join(dir, name) + "." + ext
+		if err := os.MkdirAll(filepath.Dir(filename), 0o700); err != nil {
+			return fmt.Errorf("creating output directory: %w", err)
+		}
+		if err := activeDumpSecrets.writeContent(files[name], filename, format); err != nil {
+			return err
+		}
+		manifest.WriteString(filepath.ToSlash(name) + "." + ext + "\n")
+	}
+	if err := os.WriteFile(filepath.Join(dir, splitManifestFile), []byte(manifest.String()), 0o600); err != nil {
+		return fmt.Errorf("writing dump manifest: %w", err)
+	}
+	return nil
+}
+
+// previousSplitFiles returns the files written to dir by a previous split
+// dump, as listed by its manifest. It fails if dir is not empty and has no
+// manifest.
+func previousSplitFiles(dir string) ([]string, error) {
+	b, err := os.ReadFile(filepath.Join(dir, splitManifestFile))
+	if os.IsNotExist(err) {
+		entries, err := os.ReadDir(dir)
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading output directory: %w", err)
+		}
+		if len(entries) > 0 {
+			return nil, fmt.Errorf("output directory '%s' is not empty and was not written by "+
+				"a previous dump, refusing to write to it", dir)
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading dump manifest: %w", err)
+	}
+	var files []string
+	for _, name := range strings.Split(string(b), "\n") {
+		name = filepath.FromSlash(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		ext := strings.ToLower(filepath.Ext(name))
+		if !filepath.IsLocal(name) || (ext != ".yaml" && ext != ".yml" && ext != ".json") {
+			return nil, fmt.Errorf("invalid dump manifest %s: unexpected file '%s'",
+				filepath.Join(dir, splitManifestFile), name)
+		}
+		files = append(files, filepath.Join(dir, name))
+	}
+	return files, nil
+}
+
+// stateFilesInDir returns the YAML and JSON files in dir and its
+// subdirectories. A missing directory has no state files.
+func stateFilesInDir(dir string) ([]string, error) {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil, nil
+	}
+	return utils.ConfigFilesInDir(dir)
+}
+
+// confirmDirOverwrite asks whether the state files in dir may be replaced,
+// unless there are none or assumeYes is set.
+func confirmDirOverwrite(dir string, assumeYes bool) (bool, error) {
+	if assumeYes {
+		return true, nil
+	}
+	files, err := stateFilesInDir(dir)
+	if err != nil {
+		return false, err
+	}
+	if len(files) == 0 {
+		return true, nil
+	}
+	return utils.Confirm(fmt.Sprintf("Directory '%s' already contains %d state files. "+
+		"Do you want to replace them? ", dir, len(files)))
+}
@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/kong/go-database-reconciler/pkg/file"
+	"github.com/kong/go-kong/kong"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func splitTestContent() *file.Content {
+	return &file.Content{
+		FormatVersion: "3.0",
+		Info:          &file.Info{SelectorTags: []string{"team"}},
+		Services: []file.FService{
+			{
+				Service: kong.Service{Name: kong.String("orders"), Host: kong.String("orders.internal"),
+					Tags: kong.StringSlice("orders")},
+				Routes: []*file.FRoute{{Route: kong.Route{
+					Name: kong.String("orders-route"), Paths: kong.StringSlice("/orders"),
+				}}},
+				Plugins: []*file.FPlugin{{Plugin: kong.Plugin{Name: kong.String("rate-limiting")}}},
+			},
+			{Service: kong.Service{Name: kong.String("billing/v1"), Host: kong.String("billing.internal")}},
+		},
+		Consumers: []file.FConsumer{{Consumer: kong.Consumer{
+			Username: kong.String("alice"), Tags: kong.StringSlice("orders", "billing"),
+		}}},
+		ConsumerGroups: []file.FConsumerGroupObject{{ConsumerGroup: kong.ConsumerGroup{Name: kong.String("gold")}}},
+		Certificates: []file.FCertificate{{
+			ID: kong.String("0f6e3a2b-8c1d-4e5f-9a7b-6c5d4e3f2a1b"), Cert: kong.String("cert"), Key: kong.String("key"),
+		}},
+		Plugins:   []file.FPlugin{{Plugin: kong.Plugin{Name: kong.String("prometheus")}}},
+		Upstreams: []file.FUpstream{{Upstream: kong.Upstream{Name: kong.String("orders-upstream")}}},
+	}
+}
+
+func splitFileNames(files map[string]*file.Content) []string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestSplitContent(t *testing.T) {
+	content := splitTestContent()
+
+	files := splitContent(content, splitByService)
+	assert.Equal(t, []string{
+		"certificates", "consumers", "kong", "plugins",
+		filepath.Join("services", "billing%2Fv1"), filepath.Join("services", "orders"),
+	}, splitFileNames(files))
+	orders := files[filepath.Join("services", "orders")]
+	require.Len(t, orders.Services, 1)
+	assert.Len(t, orders.Services[0].Routes, 1, "routes stay with their service")
+	assert.Len(t, orders.Services[0].Plugins, 1, "plugins stay with their service")
+	assert.Equal(t, "3.0", orders.FormatVersion)
+	assert.Nil(t, orders.Info, "_info is only written to the main file")
+	assert.Len(t, files["consumers"].Consumers, 1)
+	assert.Len(t, files["consumers"].ConsumerGroups, 1)
+	assert.Len(t, files["plugins"].Plugins, 1)
+	assert.Len(t, files["certificates"].Certificates, 1)
+	assert.Len(t, files["kong"].Upstreams, 1)
+	assert.Equal(t, content.Info, files["kong"].Info)
+
+	files = splitContent(content, splitByTag)
+	assert.Equal(t, []string{
+		"kong", filepath.Join("tags", "orders"),
+	}, splitFileNames(files))
+	assert.Len(t, files[filepath.Join("tags", "orders")].Services, 1)
+	assert.Len(t, files[filepath.Join("tags", "orders")].Consumers, 1, "entities go to their first tag")
+	assert.Len(t, files["kong"].Services, 1)
+
+	files = splitContent(content, splitByEntityType)
+	assert.Equal(t, []string{
+		"certificates", "consumer_groups", "consumers", "kong", "plugins", "services", "upstreams",
+	}, splitFileNames(files))
+	assert.Len(t, files["services"].Services, 2)
+}
+
+func TestWriteSplitContent(t *testing.T) {
+	dir := t.TempDir()
+	ok, err := confirmDirOverwrite(dir, true)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = confirmDirOverwrite(filepath.Join(dir, "missing"), false)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	content := splitTestContent()
+	require.NoError(t, writeSplitContent(content, dir, splitByService, file.YAML))
+	assert.FileExists(t, filepath.Join(dir, "services", "orders.yaml"))
+	assert.FileExists(t, filepath.Join(dir, "services", "billing%2Fv1.yaml"))
+
+	// the directory reads back as the original content
+	readBack, err := file.GetContentFromFiles([]string{dir}, false)
+	require.NoError(t, err)
+	assert.Equal(t, content.Info, readBack.Info)
+	assert.ElementsMatch(t, content.Services, readBack.Services)
+	assert.Equal(t, content.Consumers, readBack.Consumers)
+	assert.Equal(t, content.Plugins, readBack.Plugins)
+	assert.Equal(t, content.Upstreams, readBack.Upstreams)
+
+	// files of the previous dump are replaced, other files are kept
+	notes := filepath.Join(dir, "services", "notes.yaml")
+	require.NoError(t, os.WriteFile(notes, []byte("todo: []\n"), 0o600))
+	content.Services = content.Services[:1]
+	require.NoError(t, writeSplitContent(content, dir, splitByService, file.YAML))
+	assert.FileExists(t, filepath.Join(dir, "services", "orders.yaml"))
+	assert.NoFileExists(t, filepath.Join(dir, "services", "billing%2Fv1.yaml"))
+	assert.FileExists(t, notes)
+}
+
+func TestWriteSplitContent_Refused(t *testing.T) {
+	dir := t.TempDir()
+	other := filepath.Join(dir, "other.yaml")
+	require.NoError(t, os.WriteFile(other, []byte("_format_version: \"3.0\"\n"), 0o600))
+	err := writeSplitContent(splitTestContent(), dir, splitByService, file.YAML)
+	require.ErrorContains(t, err, "is not empty and was not written by a previous dump")
+	assert.FileExists(t, other)
+
+	// a manifest only lists files of the output directory
+	dir = t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, splitManifestFile), []byte("../kong.yaml\n"), 0o600))
+	err = writeSplitContent(splitTestContent(), dir, splitByService, file.YAML)
+	require.ErrorContains(t, err, "unexpected file '../kong.yaml'")
+}
+
+func TestWorkspaceStateFiles_Directories(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, writeSplitContent(&file.Content{
+		FormatVersion: "3.0",
+		Workspace:     "team-a",
+		Services:      []file.FService{{Service: kong.Service{Name: kong.String("svc")}}},
+	}, filepath.Join(dir, "team-a"), splitByService, file.YAML))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "empty"), 0o700))
+
+	stateFiles, err := workspaceStateFiles(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []workspaceStateFile{
+		{Workspace: "team-a", Filename: filepath.Join(dir, "team-a")},
+	}, stateFiles)
+}
+
+func TestValidateSplitBy(t *testing.T) {
+	require.NoError(t, validateSplitBy(""))
+	require.NoError(t, validateSplitBy(splitByTag))
+	require.EqualError(t, validateSplitBy("route"),
+		`invalid --split-by value "route", must be one of: service, tag, entity-type`)
+}
@@ -0,0 +1,212 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kong/go-database-reconciler/pkg/file"
+	"github.com/kong/go-database-reconciler/pkg/schema"
+	"github.com/kong/go-database-reconciler/pkg/state"
+	"github.com/kong/go-kong/kong"
+	"github.com/spf13/cobra"
+)
+
+var (
+	historyWorkspace  string
+	historyFormat     string
+	historyWithID     bool
+	historyJSONOutput bool
+)
+
+// historyKongAddr returns the address the history of the configured Kong,
+// or Konnect control plane, is stored under.
+func historyKongAddr() string {
+	if inKonnectMode(nil) {
+		return strings.TrimSuffix(konnectConfig.Address, "/") + "/" + konnectControlPlane
+	}
+	return rootConfig.Address
+}
+
+func currentHistoryStore() *historyStore {
+	return openHistoryStore(historyDir, historyKongAddr(), historyWorkspace)
+}
+
+func printHistoryRevisions(w io.Writer, revs []historyRevision) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "REV\tTIME\tCOMMAND\tENTITIES\tSTATE")
+	for _, rev := range revs {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%s\n",
+			rev.Rev, rev.Time.Format(time.RFC3339), rev.Command, rev.Entities, rev.Object[:12])
+	}
+	return tw.Flush()
+}
+
+func executeHistoryList(cmd *cobra.Command, _ []string) error {
+	store := currentHistoryStore()
+	revs, err := store.list()
+	if err != nil {
+		return err
+	}
+	if len(revs) == 0 {
+		fmt.Fprintf(cmd.ErrOrStderr(), "No revisions recorded for workspace '%s' of %s.\n"+
+			"Run sync or dump with --history to record them.\n", store.workspace, store.kongAddr)
+		return nil
+	}
+	return printHistoryRevisions(cmd.OutOrStdout(), revs)
+}
+
+// loadHistoryState returns the state of the revision ref refers to.
+func loadHistoryState(store *historyStore, ref string) (historyRevision, *state.KongState, error) {
+	rev, err := store.resolve(ref)
+	if err != nil {
+		return historyRevision{}, nil, err
+	}
+	raw, err := store.load(rev)
+	if err != nil {
+		return historyRevision{}, nil, err
+	}
+	ks, err := state.Get(raw)
+	if err != nil {
+		return historyRevision{}, nil, fmt.Errorf("building state of revision %d: %w", rev.Rev, err)
+	}
+	return rev, ks, nil
+}
+
+func executeHistoryShow(_ *cobra.Command, args []string) error {
+	rev, ks, err := loadHistoryState(currentHistoryStore(), args[0])
+	if err != nil {
+		return err
+	}
+	writeConfig := file.WriteConfig{
+		Filename:    "-",
+		FileFormat:  file.Format(strings.ToUpper(historyFormat)),
+		WithID:      historyWithID,
+		KongVersion: rev.KongVersion,
+	}
+	if rev.Workspace != "default" {
+		writeConfig.Workspace = rev.Workspace
+	}
+	content, err := file.KongStateToContent(ks, writeConfig)
+	if err != nil {
+		return fmt.Errorf("building state file of revision %d: %w", rev.Rev, err)
+	}
+	return file.WriteContentToFile(content, writeConfig.Filename, writeConfig.FileFormat)
+}
+
+// offlineTransport answers the requests the differ makes to Kong without
+// contacting it: schemas are empty, as the states of revisions are read from
+// Kong and have their defaults filled already, and any other entity is not
+// found.
+type offlineTransport struct{}
+
+func (offlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	status, body := http.StatusNotFound, `{"message":"Not found"}`
+	switch {
+	case req.Method != http.MethodGet:
+	case strings.HasPrefix(req.URL.Path, "/schemas/plugins/"), strings.HasPrefix(req.URL.Path, "/schemas/partials/"):
+		status, body = http.StatusOK, `{"fields":[{"config":{"type":"record","fields":[]}}]}`
+	case strings.HasPrefix(req.URL.Path, "/schemas/"):
+		status, body = http.StatusOK, `{"fields":[]}`
+	}
+	return &http.Response{
+		StatusCode: status,
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+// newOfflineKongClient returns a client for the differ that never contacts
+// Kong, see offlineTransport.
+func newOfflineKongClient() (*kong.Client, error) {
+	return kong.NewClient(kong.String(defaultKongURL), &http.Client{Transport: offlineTransport{}})
+}
+
+// executeHistoryDiff diffs two revisions as a sync from the first to the
+// second would, without contacting Kong.
+func executeHistoryDiff(cmd *cobra.Command, args []string) error {
+	store := currentHistoryStore()
+	_, from, err := loadHistoryState(store, args[0])
+	if err != nil {
+		return err
+	}
+	_, to, err := loadHistoryState(store, args[1])
+	if err != nil {
+		return err
+	}
+	client, err := newOfflineKongClient()
+	if err != nil {
+		return err
+	}
+	schemaRegistry = schema.NewRegistry(client, false)
+	if historyJSONOutput {
+		initJSONOutput()
+	}
+	// a single worker keeps the changes in a stable order
+	_, err = performDiff(cmd.Context(), from, to, true, 1, 0, client, false, historyJSONOutput, ApplyTypeFull)
+	if err != nil {
+		return err
+	}
+	if historyJSONOutput {
+		return printJSONOutput(true)
+	}
+	return nil
+}
+
+func newHistoryCmd() *cobra.Command {
+	historyCmd := &cobra.Command{
+		Use:   "history",
+		Short: "Browse the local history of synced and dumped states",
+		Long: `The history command browses the states recorded by sync and dump
+when run with --history, for the Kong (or Konnect control plane) and
+workspace selected by the flags.
+
+Revisions are referred to by their number in 'history list', by
+'latest', or by a prefix of their state hash.`,
+	}
+	historyCmd.PersistentFlags().StringVarP(&historyWorkspace, "workspace", "w", "",
+		"workspace to browse the history of (Kong Enterprise only).")
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the recorded revisions",
+		Args:  validateNoArgs,
+		RunE:  executeHistoryList,
+	}
+
+	showCmd := &cobra.Command{
+		Use:   "show [flags] rev",
+		Short: "Print the state of a revision as a state file",
+		Args:  cobra.ExactArgs(1),
+		RunE:  executeHistoryShow,
+		PreRunE: func(_ *cobra.Command, _ []string) error {
+			if historyFormat != "yaml" && historyFormat != "json" {
+				return fmt.Errorf("invalid --format %q, must be one of: yaml, json", historyFormat)
+			}
+			return nil
+		},
+	}
+	showCmd.Flags().StringVar(&historyFormat, "format", "yaml",
+		"output file format: json or yaml.")
+	showCmd.Flags().BoolVar(&historyWithID, "with-id", false,
+		"write ID of all entities in the output.")
+
+	diffCmd := &cobra.Command{
+		Use:   "diff [flags] rev1 rev2",
+		Short: "Diff the states of two revisions",
+		Long: `The diff command shows the changes a sync from the state of
+the first revision to the state of the second would make.`,
+		Args: cobra.ExactArgs(2),
+		RunE: executeHistoryDiff,
+	}
+	diffCmd.Flags().BoolVar(&historyJSONOutput, "json-output", false,
+		"generate command execution report in a JSON format")
+
+	historyCmd.AddCommand(listCmd, showCmd, diffCmd)
+	return historyCmd
+}
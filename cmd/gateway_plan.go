@@ -0,0 +1,313 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/kong/go-database-reconciler/pkg/cprint"
+	"github.com/kong/go-database-reconciler/pkg/diff"
+	"github.com/kong/go-database-reconciler/pkg/file"
+	"github.com/kong/go-database-reconciler/pkg/state"
+)
+
+// planFormatVersion is bumped whenever the plan file layout changes in a way
+// that older decK versions cannot apply.
+const planFormatVersion = 1
+
+var (
+	diffCmdOutPlan  string
+	syncCmdPlanFile string
+
+	// activePlan is set while `gateway sync --plan` is applying a plan, so that
+	// syncContent can verify that the live state still matches the state the
+	// plan was computed against.
+	activePlan *syncPlan
+)
+
+// syncPlan is the on-disk representation of a diff that can be applied later
+// with `deck gateway sync --plan`.
+type syncPlan struct {
+	Version     int       `json:"version"`
+	DeckVersion string    `json:"deck_version"`
+	CreatedAt   time.Time `json:"created_at"`
+	Workspace   string    `json:"workspace,omitempty"`
+	// StateFingerprint is a digest of the live state the plan was computed
+	// against. Sync refuses to apply the plan when the live state no longer
+	// matches it.
+	StateFingerprint string       `json:"state_fingerprint"`
+	Summary          diff.Summary `json:"summary"`
+	// Changes holds the operations computed by the diff, with DECK_ environment
+	// variable values masked unless --no-mask-deck-env-vars-value is set.
+	Changes json.RawMessage `json:"changes"`
+	// Target is the fully rendered target configuration the plan applies.
+	Target *file.Content `json:"target"`
+	// Rules holds the rules the changes were computed with.
+	Rules *planRules `json:"rules,omitempty"`
+}
+
+// planRules are the rules that shape the changes of a diff without being
+// part of the target configuration. A plan is applied with the rules it was
+// computed with, so that it makes the changes it holds.
+type planRules struct {
+	// IgnoreFields are the fields of --ignore-field and of the
+	// _info.ignore_fields of the state files, as 'type:path'.
+	IgnoreFields []string `json:"ignore_fields,omitempty"`
+	// IncludeEntityTypes, ExcludeEntityTypes and Only are the entity filters.
+	IncludeEntityTypes []string `json:"include_entity_types,omitempty"`
+	ExcludeEntityTypes []string `json:"exclude_entity_types,omitempty"`
+	Only               []string `json:"only,omitempty"`
+	// MaxDeletesPerType and Protected are the _info.max_deletes_per_type and
+	// _info.protected of the state files.
+	MaxDeletesPerType map[string]int `json:"max_deletes_per_type,omitempty"`
+	Protected         []string       `json:"protected,omitempty"`
+}
+
+// currentPlanRules returns the rules of the running diff.
+func currentPlanRules() *planRules {
+	rules := &planRules{MaxDeletesPerType: stateFileMaxDeletesPerType}
+	for _, rule := range ignoreFieldRules() {
+		rules.IgnoreFields = append(rules.IgnoreFields, rule.String())
+	}
+	if f := activeEntityFilter; f != nil {
+		rules.IncludeEntityTypes = slices.Sorted(maps.Keys(f.include))
+		rules.ExcludeEntityTypes = slices.Sorted(maps.Keys(f.exclude))
+		for _, entityType := range slices.Sorted(maps.Keys(f.only)) {
+			for _, glob := range f.only[entityType] {
+				rules.Only = append(rules.Only, entityType+":"+glob)
+			}
+		}
+	}
+	if p := stateFileProtection; p != nil {
+		for _, tag := range slices.Sorted(maps.Keys(p.tags)) {
+			rules.Protected = append(rules.Protected, "tag:"+tag)
+		}
+		for _, kind := range slices.Sorted(maps.Keys(p.selectors)) {
+			for _, glob := range p.selectors[kind] {
+				rules.Protected = append(rules.Protected, kind+":"+glob)
+			}
+		}
+	}
+	return rules
+}
+
+// apply makes the rules apply to the sync, in place of the ones of the
+// flags.
+func (r *planRules) apply() error {
+	if r == nil {
+		r = &planRules{}
+	}
+	ignore, err := parseIgnoreFieldRules("plan ignore_fields", r.IgnoreFields)
+	if err != nil {
+		return err
+	}
+	filter, err := newEntityFilter(r.IncludeEntityTypes, r.ExcludeEntityTypes, r.Only)
+	if err != nil {
+		return err
+	}
+	protected, err := parseProtectionValues("plan protected", r.Protected)
+	if err != nil {
+		return err
+	}
+	activeIgnoreFields = ignore
+	activeEntityFilter = filter
+	setStateFileSettings(stateFileSettings{
+		maxDeletesPerType: r.MaxDeletesPerType,
+		protected:         protected,
+	})
+	return nil
+}
+
+// stateFingerprint returns a stable digest of a Kong state. The state is
+// converted to its file representation first so that the digest does not
+// depend on the order in which entities were read from Kong.
+func stateFingerprint(ks *state.KongState, kongVersion string) (string, error) {
+	// the conversion warns about exported basic-auth IDs, which is noise here
+	disableOutput := cprint.DisableOutput
+	cprint.DisableOutput = true
+	defer func() { cprint.DisableOutput = disableOutput }()
+
+	content, err := file.KongStateToContent(ks, file.WriteConfig{
+		WithID:      true,
+		KongVersion: kongVersion,
+	})
+	if err != nil {
+		return "", fmt.Errorf("building state fingerprint: %w", err)
+	}
+	b, err := json.Marshal(content)
+	if err != nil {
+		return "", fmt.Errorf("building state fingerprint: %w", err)
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// newSyncPlan assembles a plan from the changes collected in jsonOutput.
+func newSyncPlan(target *file.Content, workspace, fingerprint string) (*syncPlan, error) {
+	changes, err := json.Marshal(jsonOutput.Changes)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling plan changes: %w", err)
+	}
+	if !noMaskValues {
+		changes = []byte(maskDiffValues(string(changes)))
+	}
+	return &syncPlan{
+		Version:          planFormatVersion,
+		DeckVersion:      VERSION,
+		CreatedAt:        time.Now().UTC(),
+		Workspace:        workspace,
+		StateFingerprint: fingerprint,
+		Summary:          jsonOutput.Summary,
+		Changes:          changes,
+		Target:           target,
+		Rules:            currentPlanRules(),
+	}, nil
+}
+
+func writeSyncPlan(plan *syncPlan, filename string) error {
+	b, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling plan: %w", err)
+	}
+	// plans carry the rendered target configuration, which may include
+	// credentials, so keep them private to the current user.
+	if err := os.WriteFile(filename, append(b, '\n'), 0o600); err != nil {
+		return fmt.Errorf("writing plan file: %w", err)
+	}
+	return nil
+}
+
+func readSyncPlan(filename string) (*syncPlan, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("reading plan file: %w", err)
+	}
+	var plan syncPlan
+	if err := json.Unmarshal(b, &plan); err != nil {
+		return nil, fmt.Errorf("parsing plan file %q: %w", filename, err)
+	}
+	if plan.Version != planFormatVersion {
+		return nil, fmt.Errorf("plan file %q has unsupported version %d (expected %d)",
+			filename, plan.Version, planFormatVersion)
+	}
+	if plan.Target == nil {
+		return nil, fmt.Errorf("plan file %q does not contain a target configuration", filename)
+	}
+	if plan.StateFingerprint == "" {
+		return nil, fmt.Errorf("plan file %q does not contain a state fingerprint", filename)
+	}
+	return &plan, nil
+}
+
+// verifyPlanFingerprint returns an error when the live state has drifted
+// since the plan was produced.
+func verifyPlanFingerprint(plan *syncPlan, fingerprint string) error {
+	if plan.StateFingerprint != fingerprint {
+		return errors.New("the current state in Kong has changed since the plan was created; " +
+			"re-run 'deck gateway diff --out-plan' to produce a new plan")
+	}
+	return nil
+}
+
+// verifyPlanChanges returns an error when the changes a sync is about to make
+// differ from the changes of the plan. The fingerprint only covers the state
+// in Kong, while the changes also depend on the version of decK and on the
+// rules they are computed with.
+func verifyPlanChanges(plan *syncPlan, changes diff.EntityChanges) error {
+	b, err := json.Marshal(changes)
+	if err != nil {
+		return fmt.Errorf("marshaling changes: %w", err)
+	}
+	planned, err := planChangeSet(plan.Changes)
+	if err != nil {
+		return fmt.Errorf("reading plan changes: %w", err)
+	}
+	current, err := planChangeSet(b)
+	if err != nil {
+		return err
+	}
+	if !maps.EqualFunc(planned, current, slices.Equal[[]string]) {
+		return errors.New("the changes to apply differ from the changes of the plan; " +
+			"re-run 'deck gateway diff --out-plan' to produce a new plan")
+	}
+	return nil
+}
+
+// planChangeSet returns the changes of each action as sorted JSON documents,
+// so that changes computed in a different order compare equal. Values of
+// DECK_ environment variables and secrets are masked, as they may be in plans.
+func planChangeSet(changes []byte) (map[string][]string, error) {
+	var actions map[string][]diff.EntityState
+	if err := json.Unmarshal([]byte(maskDiffValues(string(changes))), &actions); err != nil {
+		return nil, err
+	}
+	set := map[string][]string{}
+	for action, states := range actions {
+		var docs []string
+		for _, s := range states {
+			// workspaces are created before the diff, they are not part of it
+			if s.Kind == "workspace" {
+				continue
+			}
+			b, err := json.Marshal(s)
+			if err != nil {
+				return nil, err
+			}
+			docs = append(docs, string(b))
+		}
+		if len(docs) > 0 {
+			slices.Sort(docs)
+			set[action] = docs
+		}
+	}
+	return set, nil
+}
+
+// printPlanChanges prints the collected changes in the same shape the syncer
+// uses for its console output. It is used when changes are collected for a
+// plan rather than streamed to the console.
+func printPlanChanges(changes diff.EntityChanges) {
+	for _, c := range changes.Creating {
+		cprint.CreatePrintln("creating", c.Kind, c.Name)
+	}
+	for _, c := range changes.Updating {
+		cprint.UpdatePrintln("updating", c.Kind, c.Name)
+	}
+	for _, c := range changes.Deleting {
+		cprint.DeletePrintln("deleting", c.Kind, c.Name)
+	}
+}
+
+// syncPlanMain applies a plan previously written by `deck gateway diff --out-plan`.
+func syncPlanMain(ctx context.Context, filename string, parallelism, delay int,
+	workspace string, enableJSONOutput bool,
+) error {
+	plan, err := readSyncPlan(filename)
+	if err != nil {
+		return err
+	}
+	if workspace != "" && workspace != plan.Workspace {
+		return fmt.Errorf("workspace '%v' specified via --workspace flag is different "+
+			"from workspace '%v' the plan was created for", workspace, plan.Workspace)
+	}
+	if enableJSONOutput {
+		initJSONOutput()
+	}
+
+	// the changes of the plan depend on the rules it was computed with
+	if err := plan.Rules.apply(); err != nil {
+		return fmt.Errorf("plan file %q: %w", filename, err)
+	}
+	activePlan = plan
+	defer func() { activePlan = nil }()
+
+	return syncContent(ctx, plan.Target, false, parallelism, delay, plan.Workspace,
+		enableJSONOutput, ApplyTypeFull)
+}
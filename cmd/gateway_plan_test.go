@@ -0,0 +1,296 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/kong/go-database-reconciler/pkg/diff"
+	"github.com/kong/go-database-reconciler/pkg/file"
+	"github.com/kong/go-database-reconciler/pkg/state"
+	reconcilerUtils "github.com/kong/go-database-reconciler/pkg/utils"
+	"github.com/kong/go-kong/kong"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newPlanTestState(t *testing.T, names ...string) *state.KongState {
+	t.Helper()
+	ks, err := state.NewKongState()
+	require.NoError(t, err)
+	for _, name := range names {
+		require.NoError(t, ks.Services.Add(state.Service{
+			Service: kong.Service{
+				ID:   kong.String(name + "-id"),
+				Name: kong.String(name),
+				Host: kong.String(name + ".example.com"),
+			},
+		}))
+	}
+	return ks
+}
+
+func TestStateFingerprint(t *testing.T) {
+	a, err := stateFingerprint(newPlanTestState(t, "foo", "bar"), "3.9.0")
+	require.NoError(t, err)
+	b, err := stateFingerprint(newPlanTestState(t, "bar", "foo"), "3.9.0")
+	require.NoError(t, err)
+	assert.Equal(t, a, b, "fingerprint must not depend on insertion order")
+
+	c, err := stateFingerprint(newPlanTestState(t, "foo"), "3.9.0")
+	require.NoError(t, err)
+	assert.NotEqual(t, a, c)
+}
+
+func TestSyncPlan_RoundTrip(t *testing.T) {
+	jsonOutput = diff.JSONOutputObject{
+		Changes: diff.EntityChanges{
+			Creating: []diff.EntityState{{Name: "foo", Kind: "service"}},
+			Updating: []diff.EntityState{},
+			Deleting: []diff.EntityState{},
+		},
+		Summary: diff.Summary{Creating: 1, Total: 1},
+	}
+	target := &file.Content{
+		FormatVersion: "3.0",
+		Services: []file.FService{
+			{Service: kong.Service{Name: kong.String("foo"), Host: kong.String("foo.example.com")}},
+		},
+	}
+
+	plan, err := newSyncPlan(target, "ws", "abc")
+	require.NoError(t, err)
+	filename := filepath.Join(t.TempDir(), "plan.json")
+	require.NoError(t, writeSyncPlan(plan, filename))
+
+	got, err := readSyncPlan(filename)
+	require.NoError(t, err)
+	assert.Equal(t, "ws", got.Workspace)
+	assert.Equal(t, "abc", got.StateFingerprint)
+	assert.Equal(t, diff.Summary{Creating: 1, Total: 1}, got.Summary)
+	require.Len(t, got.Target.Services, 1)
+	assert.Equal(t, "foo", *got.Target.Services[0].Name)
+
+	require.NoError(t, verifyPlanFingerprint(got, "abc"))
+	require.Error(t, verifyPlanFingerprint(got, "def"))
+}
+
+func TestReadSyncPlan_Invalid(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "plan.json")
+	require.NoError(t, writeSyncPlan(&syncPlan{Version: planFormatVersion + 1}, filename))
+	_, err := readSyncPlan(filename)
+	require.ErrorContains(t, err, "unsupported version")
+
+	require.NoError(t, writeSyncPlan(&syncPlan{Version: planFormatVersion, StateFingerprint: "abc"}, filename))
+	_, err = readSyncPlan(filename)
+	require.ErrorContains(t, err, "does not contain a target configuration")
+}
+
+func TestSyncCmd_PlanWithStateFiles(t *testing.T) {
+	cmd := newSyncCmd(false)
+	syncCmdParallelism = 10
+	syncCmdPlanFile = "plan.json"
+	defer func() { syncCmdPlanFile = "" }()
+	err := cmd.PreRunE(cmd, []string{"kong.yaml"})
+	require.EqualError(t, err, "state files cannot be specified together with --plan")
+}
+
+func TestSyncCmd_PlanWithRuleFlags(t *testing.T) {
+	cmd := newSyncCmd(false)
+	syncCmdParallelism = 10
+	syncCmdPlanFile = "plan.json"
+	ignoreFields = []string{"target:weight"}
+	defer func() { syncCmdPlanFile, ignoreFields = "", nil }()
+	err := cmd.PreRunE(cmd, nil)
+	require.ErrorContains(t, err, "cannot be used with --plan")
+}
+
+// planTestKong serves the admin API of a Kong with a service and a route,
+// and records the changes made to it.
+type planTestKong struct {
+	*httptest.Server
+	mu      sync.Mutex
+	changes []string
+	bodies  map[string]map[string]any
+}
+
+func newPlanTestKong(t *testing.T) *planTestKong {
+	t.Helper()
+	const (
+		serviceID = "5b1484f2-5209-49d9-b43e-92ba09dd9d52"
+		routeID   = "e3f8b8a4-3b1a-4a8c-9b4e-8d2c3c1f0a11"
+	)
+	k := &planTestKong{bodies: map[string]map[string]any{}}
+	k.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != http.MethodGet {
+			body, _ := io.ReadAll(r.Body)
+			var object map[string]any
+			_ = json.Unmarshal(body, &object)
+			k.mu.Lock()
+			k.changes = append(k.changes, r.Method+" "+r.URL.Path)
+			k.bodies[r.URL.Path] = object
+			k.mu.Unlock()
+			if r.Method == http.MethodDelete {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			_, _ = w.Write(body)
+			return
+		}
+		switch {
+		case r.URL.Path == "/":
+			_, _ = w.Write([]byte(`{"version": "3.9.0", "configuration": {"database": "postgres"}}`))
+		case r.URL.Path == "/services":
+			_, _ = w.Write([]byte(`{"data": [{"id": "` + serviceID + `", "name": "svc",
+				"host": "old.example.com", "port": 80, "protocol": "http", "retries": 7}], "next": null}`))
+		case r.URL.Path == "/routes":
+			_, _ = w.Write([]byte(`{"data": [{"id": "` + routeID + `", "name": "r1", "paths": ["/r1"],
+				"service": {"id": "` + serviceID + `"}}], "next": null}`))
+		case strings.HasPrefix(r.URL.Path, "/schemas/"):
+			_, _ = w.Write([]byte(`{"fields": []}`))
+		default:
+			_, _ = w.Write([]byte(`{"data": [], "next": null}`))
+		}
+	}))
+	t.Cleanup(k.Close)
+	return k
+}
+
+func TestSyncPlan_AppliedWithItsRules(t *testing.T) {
+	kong := newPlanTestKong(t)
+	defer func(config reconcilerUtils.KongClientConfig, analytics bool) {
+		rootConfig, disableAnalytics = config, analytics
+		activeIgnoreFields, activeEntityFilter, diffCmdOutPlan = nil, nil, ""
+		setStateFileSettings(stateFileSettings{})
+	}(rootConfig, disableAnalytics)
+	rootConfig = reconcilerUtils.KongClientConfig{Address: kong.URL, HTTPClient: kong.Client()}
+	disableAnalytics = true
+
+	dir := t.TempDir()
+	stateFile := filepath.Join(dir, "kong.yaml")
+	require.NoError(t, os.WriteFile(stateFile, []byte(`_format_version: "3.0"
+_info:
+  ignore_fields:
+  - service:retries
+services:
+- name: svc
+  host: new.example.com
+  retries: 5
+`), 0o600))
+
+	// the routes of Kong are filtered out, they are not deleted
+	var err error
+	activeEntityFilter, err = newEntityFilter(nil, []string{"routes"}, nil)
+	require.NoError(t, err)
+	planFile := filepath.Join(dir, "plan.json")
+	diffCmdOutPlan = planFile
+	ctx := context.Background()
+	require.NoError(t, syncMain(ctx, []string{stateFile}, true, 1, 0, "", true, ApplyTypeFull))
+	diffCmdOutPlan = ""
+	require.Empty(t, kong.changes)
+
+	plan, err := readSyncPlan(planFile)
+	require.NoError(t, err)
+	assert.Equal(t, &planRules{
+		IgnoreFields:       []string{"services:retries"},
+		ExcludeEntityTypes: []string{"routes"},
+	}, plan.Rules)
+
+	// the plan is applied by another run of decK, without the rules
+	activeIgnoreFields, activeEntityFilter = nil, nil
+	setStateFileSettings(stateFileSettings{})
+	require.NoError(t, syncPlanMain(ctx, planFile, 1, 0, "", true))
+
+	require.Len(t, kong.changes, 1)
+	assert.Equal(t, "PUT /services/5b1484f2-5209-49d9-b43e-92ba09dd9d52", kong.changes[0])
+	service := kong.bodies["/services/5b1484f2-5209-49d9-b43e-92ba09dd9d52"]
+	assert.Equal(t, "new.example.com", service["host"])
+	assert.InDelta(t, 7, service["retries"], 0)
+}
+
+func TestSyncPlan_RefusedWhenChangesDiffer(t *testing.T) {
+	kong := newPlanTestKong(t)
+	defer func(config reconcilerUtils.KongClientConfig, analytics bool) {
+		rootConfig, disableAnalytics = config, analytics
+		activeIgnoreFields, activeEntityFilter, diffCmdOutPlan = nil, nil, ""
+		setStateFileSettings(stateFileSettings{})
+	}(rootConfig, disableAnalytics)
+	rootConfig = reconcilerUtils.KongClientConfig{Address: kong.URL, HTTPClient: kong.Client()}
+	disableAnalytics = true
+
+	dir := t.TempDir()
+	stateFile := filepath.Join(dir, "kong.yaml")
+	require.NoError(t, os.WriteFile(stateFile, []byte(`_format_version: "3.0"
+services:
+- name: svc
+  host: new.example.com
+  retries: 5
+`), 0o600))
+	var err error
+	activeEntityFilter, err = newEntityFilter(nil, []string{"routes"}, nil)
+	require.NoError(t, err)
+	activeIgnoreFields, err = parseIgnoreFieldRules("--ignore-field", []string{"service:retries"})
+	require.NoError(t, err)
+	planFile := filepath.Join(dir, "plan.json")
+	diffCmdOutPlan = planFile
+	ctx := context.Background()
+	require.NoError(t, syncMain(ctx, []string{stateFile}, true, 1, 0, "", true, ApplyTypeFull))
+	diffCmdOutPlan = ""
+
+	// the state in Kong is the same, but the changes are computed without the
+	// ignored field
+	plan, err := readSyncPlan(planFile)
+	require.NoError(t, err)
+	plan.Rules.IgnoreFields = nil
+	require.NoError(t, writeSyncPlan(plan, planFile))
+
+	err = syncPlanMain(ctx, planFile, 1, 0, "", true)
+	require.ErrorContains(t, err, "differ from the changes of the plan")
+	assert.Empty(t, kong.changes)
+}
+
+func TestVerifyPlanChanges(t *testing.T) {
+	jsonOutput = diff.JSONOutputObject{
+		Changes: diff.EntityChanges{
+			Creating: []diff.EntityState{
+				{Name: "workspace", Kind: "workspace"},
+				{Name: "a", Kind: "service", Body: map[string]any{"host": "a.example.com"}},
+				{Name: "b", Kind: "service", Body: map[string]any{"host": "b.example.com"}},
+			},
+		},
+	}
+	plan, err := newSyncPlan(&file.Content{}, "", "abc")
+	require.NoError(t, err)
+
+	// the order of the changes does not matter
+	require.NoError(t, verifyPlanChanges(plan, diff.EntityChanges{
+		Creating: []diff.EntityState{
+			{Name: "b", Kind: "service", Body: map[string]any{"host": "b.example.com"}},
+			{Name: "a", Kind: "service", Body: map[string]any{"host": "a.example.com"}},
+		},
+		Updating: []diff.EntityState{},
+	}))
+
+	err = verifyPlanChanges(plan, diff.EntityChanges{
+		Creating: []diff.EntityState{
+			{Name: "a", Kind: "service", Body: map[string]any{"host": "a.example.com"}},
+			{Name: "b", Kind: "service", Body: map[string]any{"host": "c.example.com"}},
+		},
+	})
+	require.ErrorContains(t, err, "differ from the changes of the plan")
+
+	err = verifyPlanChanges(plan, diff.EntityChanges{
+		Creating: []diff.EntityState{
+			{Name: "a", Kind: "service", Body: map[string]any{"host": "a.example.com"}},
+		},
+	})
+	require.ErrorContains(t, err, "differ from the changes of the plan")
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/kong/go-database-reconciler/pkg/diff"
 	"github.com/kong/go-database-reconciler/pkg/state"
 	"github.com/kong/go-database-reconciler/pkg/utils"
 	"github.com/kong/go-kong/kong"
@@ -97,6 +98,15 @@ Use this command with extreme care as it's equivalent to running
 By default, this command will ask for confirmation.`,
 		Args: validateNoArgs,
 		RunE: execute,
+		PreRunE: func(_ *cobra.Command, _ []string) error {
+			if err := preRunProtectFlags(); err != nil {
+				return err
+			}
+			if err := preRunSyncEventsFlags(); err != nil {
+				return err
+			}
+			return preRunEntityFilterFlags()
+		},
 	}
 
 	resetCmd.Flags().BoolVarP(&resetCmdForce, "force", "f",
@@ -123,6 +133,11 @@ By default, this command will ask for confirmation.`,
 		false, "generate command execution report in a JSON format")
 	resetCmd.Flags().BoolVar(&skipPluginDefinitions, "skip-plugin-definitions",
 		false, "do not reset plugin definitions.")
+	if !deprecated {
+		addEntityFilterFlags(resetCmd.Flags())
+		addProtectFlags(resetCmd.Flags())
+		addSyncEventsFlags(resetCmd.Flags())
+	}
 
 	return resetCmd
 }
@@ -147,7 +162,19 @@ func getClientForReset(
 	return kongClient, nil
 }
 
+// workspaceReset is a workspace to reset, with its current state and the
+// changes resetting it makes.
+type workspaceReset struct {
+	workspace string
+	client    *kong.Client
+	current   *utils.KongRawState
+	changes   diff.EntityChanges
+}
+
+// performReset resets workspaces. The changes of all workspaces are checked
+// against the protected entities before any of them is reset.
 func performReset(ctx context.Context, workspaces []string, isKonnect bool) error {
+	resets := make([]workspaceReset, 0, len(workspaces))
 	for _, ws := range workspaces {
 		konnectConfig.WorkspaceName = ws
 		if ws == "default" {
@@ -158,18 +185,41 @@ func performReset(ctx context.Context, workspaces []string, isKonnect bool) erro
 			return fmt.Errorf("getting client for workspace '%s': %w", ws, err)
 		}
 
-		currentState, err := fetchCurrentState(ctx, client, dumpConfig)
+		currentRawState, err := fetchCurrentRawState(ctx, client, dumpConfig)
 		if err != nil {
 			return fmt.Errorf("fetching state for workspace '%s': %w", ws, err)
 		}
+		reset := workspaceReset{workspace: ws, client: client, current: currentRawState}
+		if activeProtection != nil || activeSyncEvents.needsTotal() {
+			reset.changes, err = previewChanges(ctx, currentRawState, &utils.KongRawState{}, 10,
+				client, isKonnect, ApplyTypeFull)
+			if err != nil {
+				return fmt.Errorf("computing changes for workspace '%s': %w", ws, err)
+			}
+			if err := activeProtection.check(reset.changes); err != nil {
+				return reportProtectedEntities(fmt.Errorf("resetting workspace '%s': %w", ws, err), resetJSONOutput)
+			}
+		}
+		resets = append(resets, reset)
+	}
+
+	for _, reset := range resets {
+		if activeSyncEvents.needsTotal() {
+			activeSyncEvents.setTotal(reset.changes)
+		}
+		currentState, err := state.Get(reset.current)
+		if err != nil {
+			return fmt.Errorf("building state for workspace '%s': %w", reset.workspace, err)
+		}
 		targetState, err := state.NewKongState()
 		if err != nil {
 			return err
 		}
 		// Perform the diff/reset
-		_, err = performDiff(ctx, currentState, targetState, false, 10, 0, client, isKonnect, resetJSONOutput, ApplyTypeFull)
+		_, err = performDiff(ctx, currentState, targetState, false, 10, 0, reset.client, isKonnect,
+			resetJSONOutput, ApplyTypeFull)
 		if err != nil {
-			return fmt.Errorf("resetting workspace '%s': %w", ws, err)
+			return fmt.Errorf("resetting workspace '%s': %w", reset.workspace, err)
 		}
 	}
 	return nil
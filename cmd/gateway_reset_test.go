@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	reconcilerUtils "github.com/kong/go-database-reconciler/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newResetTestKong returns a fake Admin API with a service in the default
+// workspace and another one in the team workspace, and the non-GET requests
+// it receives.
+func newResetTestKong(t *testing.T) (*httptest.Server, func() []string) {
+	t.Helper()
+	var mu sync.Mutex
+	var changes []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != http.MethodGet {
+			mu.Lock()
+			changes = append(changes, r.Method+" "+r.URL.Path)
+			mu.Unlock()
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		switch {
+		case r.URL.Path == "/" || r.URL.Path == "/team/kong":
+			_, _ = w.Write([]byte(`{"version": "3.9.0", "configuration": {"database": "postgres"}}`))
+		case r.URL.Path == "/services":
+			_, _ = w.Write([]byte(`{"data": [{"id": "0b2a5e1c-6f3e-4a55-9c8e-2f1f6f0d9a01", "name": "svc",
+				"host": "svc.example.com", "port": 80, "protocol": "http"}], "next": null}`))
+		case r.URL.Path == "/team/services":
+			_, _ = w.Write([]byte(`{"data": [{"id": "7d0c1b6e-2c4f-4f0e-8a57-3b9d2e6c5f02", "name": "protected-svc",
+				"host": "team.example.com", "port": 80, "protocol": "http"}], "next": null}`))
+		case strings.Contains(r.URL.Path, "/schemas/"):
+			_, _ = w.Write([]byte(`{"fields": []}`))
+		default:
+			_, _ = w.Write([]byte(`{"data": [], "next": null}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string{}, changes...)
+	}
+}
+
+func TestPerformReset_ProtectionCheckedForAllWorkspacesFirst(t *testing.T) {
+	server, changes := newResetTestKong(t)
+	defer func(config reconcilerUtils.KongClientConfig) {
+		rootConfig, activeProtection = config, nil
+	}(rootConfig)
+	rootConfig = reconcilerUtils.KongClientConfig{Address: server.URL, HTTPClient: server.Client()}
+
+	var err error
+	activeProtection, err = newEntityProtection(nil, []string{"service:protected-*"})
+	require.NoError(t, err)
+	err = performReset(context.Background(), []string{"", "team"}, false)
+	require.ErrorContains(t, err, "resetting workspace 'team': refusing to change 1 protected entities")
+	assert.Empty(t, changes(), "the default workspace is not reset either")
+
+	activeProtection = nil
+	require.NoError(t, performReset(context.Background(), []string{"", "team"}, false))
+	assert.ElementsMatch(t, []string{
+		"DELETE /services/0b2a5e1c-6f3e-4a55-9c8e-2f1f6f0d9a01",
+		"DELETE /team/services/7d0c1b6e-2c4f-4f0e-8a57-3b9d2e6c5f02",
+	}, changes())
+}
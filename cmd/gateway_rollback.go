@@ -0,0 +1,359 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/blang/semver/v4"
+	"github.com/kong/go-database-reconciler/pkg/cprint"
+	"github.com/kong/go-database-reconciler/pkg/dump"
+	"github.com/kong/go-database-reconciler/pkg/file"
+	"github.com/kong/go-database-reconciler/pkg/state"
+	"github.com/kong/go-kong/kong"
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	defaultSnapshotDir = ".deck/snapshots"
+	snapshotTimeFormat = "20060102T150405.000000000Z"
+	// legacySnapshotTimeFormat is the format of the snapshots written before
+	// they had sub-second precision.
+	legacySnapshotTimeFormat = "20060102T150405Z"
+)
+
+var (
+	syncCmdSnapshot        bool
+	syncCmdSnapshotDir     string
+	syncCmdRollbackOnError bool
+
+	rollbackCmdParallelism int
+	rollbackCmdSnapshotDir string
+	rollbackWorkspace      string
+	rollbackJSONOutput     bool
+	rollbackCmdSnapshot    string
+)
+
+// snapshotFilename returns the path of a new snapshot for a workspace.
+func snapshotFilename(dir, workspace string, now time.Time) string {
+	if workspace == "" {
+		workspace = "default"
+	}
+	return filepath.Join(dir, workspace+"-"+now.UTC().Format(snapshotTimeFormat)+".yaml")
+}
+
+// snapshotKey is the field of snapshots holding their snapshotInfo.
+const snapshotKey = "_snapshot"
+
+// snapshotInfo describes the sync a snapshot was taken before.
+type snapshotInfo struct {
+	// KongAddr is the address of the Kong the snapshot was taken from, see
+	// historyKongAddr.
+	KongAddr string `json:"kong_addr"`
+	// Rules are the ignored fields and entity filters of the sync, which
+	// the snapshot is restored with.
+	Rules *planRules `json:"rules,omitempty"`
+}
+
+// snapshotStoreDir returns the directory of the snapshots of the Kong at
+// kongAddr, keyed like the history, see openHistoryStore.
+func snapshotStoreDir(root, kongAddr string) string {
+	return filepath.Join(root, kongAddrKey(kongAddr))
+}
+
+// writeSnapshot writes the current state to the snapshot directory of the
+// Kong of info so that a sync can be rolled back to it. Entity IDs are
+// always kept, so that a rollback restores the entities as they were rather
+// than recreating them.
+func writeSnapshot(ks *state.KongState, root string, info snapshotInfo, writeConfig file.WriteConfig) (string, error) {
+	dir := snapshotStoreDir(root, info.KongAddr)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("creating snapshot directory: %w", err)
+	}
+	writeConfig.WithID = true
+	content, err := file.KongStateToContent(ks, writeConfig)
+	if err != nil {
+		return "", fmt.Errorf("building snapshot: %w", err)
+	}
+	header, err := yaml.Marshal(map[string]snapshotInfo{snapshotKey: info})
+	if err != nil {
+		return "", fmt.Errorf("building snapshot: %w", err)
+	}
+	b, err := marshalContent(content, file.YAML)
+	if err != nil {
+		return "", fmt.Errorf("building snapshot: %w", err)
+	}
+	return createSnapshotFile(dir, writeConfig.Workspace, time.Now(), append(header, b...))
+}
+
+// readSnapshot reads a snapshot and its snapshotInfo, which is nil for the
+// snapshots written before they had one.
+func readSnapshot(filename string) (*snapshotInfo, *file.Content, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading snapshot: %w", err)
+	}
+	data, err := unmarshalStateDocument(b)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading snapshot %s: %w", filename, err)
+	}
+	document, ok := data.(map[string]interface{})
+	if !ok {
+		return nil, nil, fmt.Errorf("reading snapshot %s: not a state file", filename)
+	}
+	var info *snapshotInfo
+	if header, ok := document[snapshotKey]; ok {
+		delete(document, snapshotKey)
+		if b, err = json.Marshal(header); err == nil {
+			err = json.Unmarshal(b, &info)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading snapshot %s: invalid %s field: %w", filename, snapshotKey, err)
+		}
+	}
+	if b, err = json.Marshal(document); err != nil {
+		return nil, nil, fmt.Errorf("reading snapshot %s: %w", filename, err)
+	}
+	// snapshots hold the configuration of Kong as is, they are not templates
+	content, err := file.GetContentFromReader(bytes.NewReader(b), file.EnvVarsSkip)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading snapshot %s: %w", filename, err)
+	}
+	return info, content, nil
+}
+
+// checkAddr refuses to restore a snapshot to a Kong other than the one it was
+// taken from.
+func (i *snapshotInfo) checkAddr(filename, kongAddr string) error {
+	if i == nil || kongAddrKey(i.KongAddr) == kongAddrKey(kongAddr) {
+		return nil
+	}
+	return fmt.Errorf("snapshot %s was taken from %s, it cannot be restored to %s",
+		filename, i.KongAddr, kongAddr)
+}
+
+// createSnapshotFile writes a new snapshot taken at now. Snapshots are never
+// overwritten: if a snapshot was taken at the same time, the time of the new
+// one is moved forward, so that it sorts as the latest.
+func createSnapshotFile(dir, workspace string, now time.Time, content []byte) (string, error) {
+	for {
+		filename := snapshotFilename(dir, workspace, now)
+		f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if errors.Is(err, fs.ErrExist) {
+			now = now.Add(time.Nanosecond)
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("writing snapshot: %w", err)
+		}
+		_, err = f.Write(content)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return "", fmt.Errorf("writing snapshot: %w", err)
+		}
+		return filename, nil
+	}
+}
+
+// latestSnapshot returns the most recent snapshot in dir, optionally limited
+// to the snapshots of a single workspace.
+func latestSnapshot(dir, workspace string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", fmt.Errorf("reading snapshot directory: %w", err)
+	}
+
+	type snapshot struct {
+		name    string
+		takenAt time.Time
+	}
+	var snapshots []snapshot
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || filepath.Ext(name) != ".yaml" {
+			continue
+		}
+		base := strings.TrimSuffix(name, ".yaml")
+		sep := strings.LastIndex(base, "-")
+		if sep < 0 {
+			continue
+		}
+		if workspace != "" && base[:sep] != workspace {
+			continue
+		}
+		takenAt, err := time.Parse(snapshotTimeFormat, base[sep+1:])
+		if err != nil {
+			if takenAt, err = time.Parse(legacySnapshotTimeFormat, base[sep+1:]); err != nil {
+				continue
+			}
+		}
+		snapshots = append(snapshots, snapshot{name: name, takenAt: takenAt})
+	}
+	if len(snapshots) == 0 {
+		return "", fmt.Errorf("no snapshots found in %s", dir)
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].takenAt.After(snapshots[j].takenAt)
+	})
+	return filepath.Join(dir, snapshots[0].name), nil
+}
+
+// rollbackFailedSync restores the snapshot taken before a failed sync. The
+// returned error always wraps syncErr so that the command still fails.
+func rollbackFailedSync(ctx context.Context, syncErr error, snapshot string, client *kong.Client,
+	kongVersion semver.Version, parallelism, delay int, isKonnect, enableJSONOutput bool,
+) error {
+	if !enableJSONOutput {
+		fmt.Fprintf(os.Stderr, "Sync failed, rolling back to snapshot %s\n", snapshot)
+	}
+
+	// the rollback is reported through the warnings of the JSON report,
+	// rather than mixing its changes with the ones of the failed sync
+	report := jsonOutput
+	err := restoreSnapshot(ctx, snapshot, client, kongVersion, parallelism, delay, isKonnect, enableJSONOutput)
+	jsonOutput = report
+
+	if err != nil {
+		return errors.Join(syncErr, fmt.Errorf("rolling back to snapshot %s: %w", snapshot, err))
+	}
+	if enableJSONOutput {
+		jsonOutput.Warnings = append(jsonOutput.Warnings,
+			fmt.Sprintf("sync failed and was rolled back to snapshot %s", snapshot))
+	}
+	return fmt.Errorf("sync failed and was rolled back to snapshot %s: %w", snapshot, syncErr)
+}
+
+func restoreSnapshot(ctx context.Context, snapshot string, client *kong.Client,
+	kongVersion semver.Version, parallelism, delay int, isKonnect, enableJSONOutput bool,
+) error {
+	_, content, err := readSnapshot(snapshot)
+	if err != nil {
+		return err
+	}
+	currentRawState, err := dump.Get(ctx, client, dumpConfig)
+	if err != nil {
+		return err
+	}
+	unfilteredState, currentState, err := activeEntityFilter.currentStates(currentRawState)
+	if err != nil {
+		return err
+	}
+	rawState, err := file.Get(ctx, content, file.RenderConfig{
+		CurrentState:     unfilteredState,
+		KongVersion:      kongVersion,
+		DiagnosticPolicy: diagnosticPolicy,
+	}, dumpConfig, client)
+	if err != nil {
+		return err
+	}
+	activeEntityFilter.filterRawState(rawState)
+	targetState, err := state.Get(rawState)
+	if err != nil {
+		return err
+	}
+	_, err = performDiff(ctx, currentState, targetState, false, parallelism, delay, client, isKonnect,
+		enableJSONOutput, ApplyTypeFull)
+	return err
+}
+
+func executeRollback(cmd *cobra.Command, _ []string) error {
+	kongAddr := historyKongAddr()
+	snapshot := rollbackCmdSnapshot
+	if snapshot == "" {
+		var err error
+		snapshot, err = latestSnapshot(snapshotStoreDir(rollbackCmdSnapshotDir, kongAddr), rollbackWorkspace)
+		if err != nil {
+			return err
+		}
+	}
+	info, content, err := readSnapshot(snapshot)
+	if err != nil {
+		return err
+	}
+	if err := info.checkAddr(snapshot, kongAddr); err != nil {
+		return err
+	}
+	if info != nil {
+		if len(entityFilterIncludeTypes) > 0 || len(entityFilterExcludeTypes) > 0 || len(entityFilterOnly) > 0 {
+			return fmt.Errorf("--include-entity-type, --exclude-entity-type and --only cannot be used to "+
+				"roll back to snapshot %s, it is restored with the entity filters of the sync it was taken before",
+				snapshot)
+		}
+		// the snapshot only holds the entities the sync could change
+		if err := info.Rules.apply(); err != nil {
+			return fmt.Errorf("snapshot %s: %w", snapshot, err)
+		}
+	} else {
+		setStateFileSettings(stateFileSettings{})
+	}
+
+	if rollbackJSONOutput {
+		initJSONOutput()
+	} else {
+		cprint.UpdatePrintln("Rolling back to snapshot", snapshot)
+	}
+	return syncContent(cmd.Context(), content, false,
+		rollbackCmdParallelism, 0, rollbackWorkspace, rollbackJSONOutput, ApplyTypeFull)
+}
+
+func newRollbackCmd() *cobra.Command {
+	rollbackCmd := &cobra.Command{
+		Use:   "rollback [flags] [snapshot]",
+		Short: "Restore Kong's configuration from a snapshot taken by sync",
+		Long: `The rollback command syncs Kong back to a snapshot taken by
+'deck gateway sync --snapshot' before it changed the configuration.
+
+When no snapshot file is given, the most recent snapshot of the Kong
+in the snapshot directory is used. Snapshots are restored with the
+ignored fields and entity filters of the sync they were taken before,
+and only to the Kong they were taken from.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: executeRollback,
+		PreRunE: func(_ *cobra.Command, args []string) error {
+			rollbackCmdSnapshot = ""
+			if len(args) > 0 {
+				rollbackCmdSnapshot = args[0]
+			}
+			if err := checkParallelism(rollbackCmdParallelism); err != nil {
+				return err
+			}
+			if err := preRunEntityFilterFlags(); err != nil {
+				return err
+			}
+			return preRunSilenceEventsFlag()
+		},
+	}
+
+	rollbackCmd.Flags().StringVar(&rollbackCmdSnapshotDir, "snapshot-dir", defaultSnapshotDir,
+		"directory to look up the most recent snapshot of the Kong in.")
+	rollbackCmd.Flags().StringVarP(&rollbackWorkspace, "workspace", "w", "",
+		"Roll back a specific workspace (Kong Enterprise only).\n"+
+			"When no snapshot is given, only snapshots of this workspace are considered.")
+	rollbackCmd.Flags().IntVar(&rollbackCmdParallelism, "parallelism",
+		10, "Maximum number of concurrent operations.")
+	rollbackCmd.Flags().BoolVar(&dumpConfig.SkipConsumers, "skip-consumers",
+		false, "do not roll back consumers, consumer-groups or "+
+			"any plugins associated with them.\n"+
+			"Use this when the snapshot was taken by a sync using --skip-consumers.")
+	rollbackCmd.Flags().BoolVar(&rollbackJSONOutput, "json-output",
+		false, "generate command execution report in a JSON format")
+	// snapshots written before they recorded the entity filters of their
+	// sync only contain the filtered entities, so the same filters must be
+	// used to roll them back
+	addEntityFilterFlags(rollbackCmd.Flags())
+	addSilenceEventsFlag(rollbackCmd.Flags())
+
+	return rollbackCmd
+}
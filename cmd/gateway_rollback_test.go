@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kong/go-database-reconciler/pkg/file"
+	reconcilerUtils "github.com/kong/go-database-reconciler/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotFilename(t *testing.T) {
+	now := time.Date(2024, 5, 1, 10, 30, 0, 1500, time.UTC)
+	assert.Equal(t, filepath.Join("snaps", "default-20240501T103000.000001500Z.yaml"),
+		snapshotFilename("snaps", "", now))
+	assert.Equal(t, filepath.Join("snaps", "team-a-20240501T103000.000001500Z.yaml"),
+		snapshotFilename("snaps", "team-a", now))
+}
+
+func TestCreateSnapshotFileNeverOverwrites(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC)
+	first, err := createSnapshotFile(dir, "", now, []byte("first"))
+	require.NoError(t, err)
+	second, err := createSnapshotFile(dir, "", now, []byte("second"))
+	require.NoError(t, err)
+	assert.NotEqual(t, first, second)
+
+	b, err := os.ReadFile(first)
+	require.NoError(t, err)
+	assert.Equal(t, "first", string(b))
+	latest, err := latestSnapshot(dir, "")
+	require.NoError(t, err)
+	assert.Equal(t, second, latest)
+}
+
+func TestLatestSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"default-20240501T103000Z.yaml",
+		"default-20240502T090000Z.yaml",
+		"default-20240502T090000.500000000Z.yaml",
+		"team-a-20240503T080000Z.yaml",
+		"notes.txt",
+		"not-a-snapshot.yaml",
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("{}"), 0o600))
+	}
+
+	got, err := latestSnapshot(dir, "")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "team-a-20240503T080000Z.yaml"), got)
+
+	got, err = latestSnapshot(dir, "default")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "default-20240502T090000.500000000Z.yaml"), got)
+
+	_, err = latestSnapshot(dir, "team-b")
+	require.ErrorContains(t, err, "no snapshots found")
+}
+
+func TestWriteSnapshot(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "snapshots")
+	snapshot, err := writeSnapshot(newPlanTestState(t, "foo"), root, snapshotInfo{
+		KongAddr: "http://localhost:8001",
+		Rules:    &planRules{ExcludeEntityTypes: []string{"routes"}},
+	}, file.WriteConfig{
+		Workspace:   "team-a",
+		KongVersion: "3.9.0",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(root, "localhost_8001"), filepath.Dir(snapshot))
+
+	got, err := latestSnapshot(snapshotStoreDir(root, "https://localhost:8001"), "team-a")
+	require.NoError(t, err)
+	assert.Equal(t, snapshot, got)
+
+	info, content, err := readSnapshot(snapshot)
+	require.NoError(t, err)
+	assert.Equal(t, &snapshotInfo{
+		KongAddr: "http://localhost:8001",
+		Rules:    &planRules{ExcludeEntityTypes: []string{"routes"}},
+	}, info)
+	assert.Equal(t, "team-a", content.Workspace)
+	require.Len(t, content.Services, 1)
+	assert.Equal(t, "foo-id", *content.Services[0].ID, "snapshots must keep entity IDs")
+
+	require.NoError(t, info.checkAddr(snapshot, "http://localhost:8001/"))
+	require.EqualError(t, info.checkAddr(snapshot, "http://kong-2:8001"), "snapshot "+snapshot+
+		" was taken from http://localhost:8001, it cannot be restored to http://kong-2:8001")
+}
+
+func TestReadSnapshot_WithoutInfo(t *testing.T) {
+	snapshot := filepath.Join(t.TempDir(), "default-20240501T103000Z.yaml")
+	require.NoError(t, os.WriteFile(snapshot, []byte("_format_version: \"3.0\"\nservices:\n"+
+		"- name: svc\n  host: ${{ env \"DECK_HOST\" }}\n"), 0o600))
+	info, content, err := readSnapshot(snapshot)
+	require.NoError(t, err)
+	assert.Nil(t, info)
+	require.NoError(t, info.checkAddr(snapshot, "http://kong-2:8001"))
+	assert.Equal(t, `${{ env "DECK_HOST" }}`, *content.Services[0].Host, "snapshots are not templates")
+}
+
+func TestRollback_RestoredWithTheRulesOfTheSync(t *testing.T) {
+	kong := newPlanTestKong(t)
+	defer func(config reconcilerUtils.KongClientConfig, analytics bool) {
+		rootConfig, disableAnalytics = config, analytics
+		activeIgnoreFields, activeEntityFilter = nil, nil
+		rollbackCmdSnapshot, rollbackCmdSnapshotDir = "", ""
+		setStateFileSettings(stateFileSettings{})
+	}(rootConfig, disableAnalytics)
+	rootConfig = reconcilerUtils.KongClientConfig{Address: kong.URL, HTTPClient: kong.Client()}
+	disableAnalytics = true
+
+	// the snapshot of a sync excluding routes, which has no routes
+	root := t.TempDir()
+	dir := snapshotStoreDir(root, kong.URL)
+	require.NoError(t, os.MkdirAll(dir, 0o700))
+	header := "_snapshot:\n  kong_addr: " + kong.URL + "\n  rules:\n    exclude_entity_types:\n    - routes\n"
+	state := `_format_version: "3.0"
+services:
+- id: 5b1484f2-5209-49d9-b43e-92ba09dd9d52
+  name: svc
+  host: restored.example.com
+  port: 80
+  protocol: http
+  retries: 7
+`
+	_, err := createSnapshotFile(dir, "", time.Now(), []byte(header+state))
+	require.NoError(t, err)
+
+	cmd := newRollbackCmd()
+	cmd.SetContext(context.Background())
+	rollbackCmdSnapshotDir, rollbackCmdParallelism = root, 1
+	require.NoError(t, executeRollback(cmd, nil))
+	assert.Equal(t, []string{"PUT /services/5b1484f2-5209-49d9-b43e-92ba09dd9d52"}, kong.changes,
+		"the routes of Kong are not deleted")
+
+	other := filepath.Join(t.TempDir(), "default-20240501T103000Z.yaml")
+	require.NoError(t, os.WriteFile(other,
+		[]byte("_snapshot:\n  kong_addr: http://kong-2:8001\n"+state), 0o600))
+	rollbackCmdSnapshot = other
+	require.ErrorContains(t, executeRollback(cmd, nil), "was taken from http://kong-2:8001")
+}
+
+func TestRollbackCmd_ZeroParallelism(t *testing.T) {
+	cmd := newRollbackCmd()
+	rollbackCmdParallelism = 0
+	err := cmd.PreRunE(cmd, []string{"snapshot.yaml"})
+	require.EqualError(t, err, "--parallelism cannot be less than 1, got 0")
+}
@@ -17,6 +17,16 @@ var (
 var syncCmdKongStateFile []string
 
 func executeSync(cmd *cobra.Command, _ []string) error {
+	if syncCmdTargetsFile != "" {
+		return syncTargetsMain(cmd, syncCmdTargetsFile, syncCmdKongStateFile, syncJSONOutput)
+	}
+	if syncAllWorkspaces {
+		return syncAllWorkspacesMain(cmd, syncCmdKongStateFile[0], syncCmdWorkspaceParallelism, syncJSONOutput)
+	}
+	if syncCmdPlanFile != "" {
+		return syncPlanMain(cmd.Context(), syncCmdPlanFile, syncCmdParallelism,
+			syncCmdDBUpdateDelay, syncWorkspace, syncJSONOutput)
+	}
 	return syncMain(cmd.Context(), syncCmdKongStateFile, false,
 		syncCmdParallelism, syncCmdDBUpdateDelay, syncWorkspace, syncJSONOutput, ApplyTypeFull)
 }
@@ -28,6 +38,30 @@ func newSyncCmd(deprecated bool) *cobra.Command {
 	execute := executeSync
 	argsValidator := cobra.MinimumNArgs(0)
 	preRun := func(_ *cobra.Command, args []string) error {
+		if syncCmdPlanFile != "" && len(args) > 0 {
+			return fmt.Errorf("state files cannot be specified together with --plan")
+		}
+		if syncCmdPlanFile != "" && (len(ignoreFields) > 0 || len(entityFilterIncludeTypes) > 0 ||
+			len(entityFilterExcludeTypes) > 0 || len(entityFilterOnly) > 0) {
+			return fmt.Errorf("--ignore-field, --include-entity-type, --exclude-entity-type and --only " +
+				"cannot be used with --plan, the plan is applied with the ones it was created with")
+		}
+		if syncAllWorkspaces {
+			if len(args) != 1 {
+				return fmt.Errorf("--all-workspaces requires exactly one directory " +
+					"with the state files of all workspaces")
+			}
+			if syncWorkspace != "" || syncCmdPlanFile != "" {
+				return fmt.Errorf("--all-workspaces cannot be used with --workspace or --plan")
+			}
+			if syncCmdWorkspaceParallelism < 1 {
+				return fmt.Errorf("--workspace-parallelism cannot be less than 1, got %d",
+					syncCmdWorkspaceParallelism)
+			}
+		}
+		if syncCmdTargetsFile != "" && (syncAllWorkspaces || syncCmdPlanFile != "") {
+			return fmt.Errorf("--targets cannot be used with --all-workspaces or --plan")
+		}
 		syncCmdKongStateFile = args
 		if len(syncCmdKongStateFile) == 0 {
 			syncCmdKongStateFile = []string{"-"}
@@ -38,6 +72,18 @@ func newSyncCmd(deprecated bool) *cobra.Command {
 		if err := checkParallelism(syncCmdParallelism); err != nil {
 			return err
 		}
+		if err := preRunEntityFilterFlags(); err != nil {
+			return err
+		}
+		if err := preRunIgnoreFieldFlags(); err != nil {
+			return err
+		}
+		if err := preRunProtectFlags(); err != nil {
+			return err
+		}
+		if err := preRunSyncEventsFlags(); err != nil {
+			return err
+		}
 		return preRunSilenceEventsFlag()
 	}
 
@@ -94,7 +140,8 @@ to get Kong's state in sync with the input state.`,
 	syncCmd.Flags().IntVar(&syncCmdParallelism, "parallelism",
 		10, "Maximum number of concurrent operations.")
 	syncCmd.Flags().BoolVar(&noMaskValues, "no-mask-deck-env-vars-value",
-		false, "do not mask DECK_ environment variable values at diff output.")
+		false, "do not mask DECK_ environment variable values, and secrets\n"+
+			"of state files, at diff output.")
 	syncCmd.Flags().StringSliceVar(&dumpConfig.SelectorTags,
 		"select-tag", []string{},
 		"only entities matching tags specified via this flag are synced.\n"+
@@ -127,6 +174,49 @@ to get Kong's state in sync with the input state.`,
 	syncCmd.Flags().BoolVar(&dumpConfig.IncludePluginDefinitions, "include-plugin-definitions",
 		false, "allow deck to sync plugin definitions.\n"+
 			"Plugin definitions work with Konnect and Gateway versions >= 3.15.")
+	if !deprecated {
+		syncCmd.Flags().StringVar(&syncCmdPlanFile, "plan", "",
+			"apply a plan file created with 'deck gateway diff --out-plan'.\n"+
+				"The sync is refused if the state in Kong, or the changes to make, changed\n"+
+				"since the plan was created.\n"+
+				"The plan is applied with the ignored fields and entity filters it was created with.")
+		syncCmd.Flags().BoolVar(&syncCmdSnapshot, "snapshot",
+			false, "write a snapshot of the current configuration to --snapshot-dir\n"+
+				"before making any changes. Use 'deck gateway rollback' to restore it.")
+		syncCmd.Flags().StringVar(&syncCmdSnapshotDir, "snapshot-dir",
+			defaultSnapshotDir, "directory to write snapshots to, in a subdirectory per Kong address.")
+		syncCmd.Flags().BoolVar(&syncCmdRollbackOnError, "rollback-on-error",
+			false, "restore the snapshot taken before the sync if the sync fails.\n"+
+				"Implies --snapshot.")
+		syncCmd.Flags().BoolVar(&syncAllWorkspaces, "all-workspaces",
+			false, "sync all workspaces from a directory written by 'deck gateway dump --all-workspaces',\n"+
+				"with one state file or directory per workspace (Kong Enterprise only).\n"+
+				"Missing workspaces are created. The sync fails if any workspace fails.")
+		syncCmd.Flags().IntVar(&syncCmdWorkspaceParallelism, "workspace-parallelism",
+			4, "maximum number of workspaces synced concurrently with --all-workspaces.")
+		syncCmd.Flags().StringVar(&syncCmdTargetsFile, "targets", "",
+			"sync several Kong clusters, listed in this YAML file with their connection\n"+
+				"settings, wave by wave. The targets of a wave are synced concurrently and\n"+
+				"health-checked with 'deck gateway ping' before the next wave starts.\n"+
+				"The sync halts at the first wave that fails.")
+		syncCmd.Flags().IntVar(&syncCmdMaxDeletes, "max-deletes",
+			-1, "abort the sync before making any change if it would delete more\n"+
+				"than this many entities. A negative value disables the limit.")
+		syncCmd.Flags().IntVar(&syncCmdMaxChanges, "max-changes",
+			-1, "abort the sync before making any change if it would create, update\n"+
+				"and delete more than this many entities in total. A negative value disables the limit.")
+		syncCmd.Flags().Float64Var(&syncCmdMaxDeletePercent, "max-delete-percent",
+			-1, "abort the sync before making any change if it would delete more than\n"+
+				"this percentage of the entities currently in Kong. A negative value disables the limit.")
+		syncCmd.Flags().StringToIntVar(&syncCmdMaxDeletesPerType, "max-deletes-per-type",
+			nil, "abort the sync before making any change if it would delete more entities\n"+
+				"of a kind than given, e.g. 'route=10,service=2'. Kinds are named as in the diff output.\n"+
+				"Limits can also be set in the _info.max_deletes_per_type of state files.")
+		addEntityFilterFlags(syncCmd.Flags())
+		addIgnoreFieldFlags(syncCmd.Flags())
+		addProtectFlags(syncCmd.Flags())
+		addSyncEventsFlags(syncCmd.Flags())
+	}
 	addDiagnosticSeverityFlags(syncCmd.Flags())
 	addSilenceEventsFlag(syncCmd.Flags())
 	return syncCmd
@@ -0,0 +1,176 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/kong/go-database-reconciler/pkg/diff"
+	"github.com/kong/go-database-reconciler/pkg/state"
+	reconcilerUtils "github.com/kong/go-database-reconciler/pkg/utils"
+	"github.com/kong/go-kong/kong"
+)
+
+var (
+	syncCmdMaxDeletes        = -1
+	syncCmdMaxChanges        = -1
+	syncCmdMaxDeletePercent  = -1.0
+	syncCmdMaxDeletesPerType map[string]int
+)
+
+// changeBudget limits how many changes a sync may make. Negative limits
+// are unlimited.
+type changeBudget struct {
+	MaxDeletes        int
+	MaxChanges        int
+	MaxDeletePercent  float64
+	MaxDeletesPerType map[string]int
+	// StateFileMaxDeletesPerType are the limits of the
+	// _info.max_deletes_per_type of the state files.
+	StateFileMaxDeletesPerType map[string]int
+}
+
+func syncChangeBudget() changeBudget {
+	return changeBudget{
+		MaxDeletes:        syncCmdMaxDeletes,
+		MaxChanges:        syncCmdMaxChanges,
+		MaxDeletePercent:  syncCmdMaxDeletePercent,
+		MaxDeletesPerType: syncCmdMaxDeletesPerType,
+
+		StateFileMaxDeletesPerType: stateFileMaxDeletesPerType,
+	}
+}
+
+func (b changeBudget) enabled() bool {
+	return b.MaxDeletes >= 0 || b.MaxChanges >= 0 || b.MaxDeletePercent >= 0 ||
+		len(b.MaxDeletesPerType) > 0 || len(b.StateFileMaxDeletesPerType) > 0
+}
+
+// check returns an error describing every limit that the changes exceed.
+// currentEntities is the number of entities in the current state and is the
+// base for --max-delete-percent.
+func (b changeBudget) check(changes diff.EntityChanges, currentEntities int) error {
+	deletes := len(changes.Deleting)
+	total := len(changes.Creating) + len(changes.Updating) + deletes
+
+	var violations []string
+	if b.MaxDeletes >= 0 && deletes > b.MaxDeletes {
+		violations = append(violations,
+			fmt.Sprintf("%d deletes exceed --max-deletes %d", deletes, b.MaxDeletes))
+	}
+	if b.MaxChanges >= 0 && total > b.MaxChanges {
+		violations = append(violations,
+			fmt.Sprintf("%d changes exceed --max-changes %d", total, b.MaxChanges))
+	}
+	if b.MaxDeletePercent >= 0 && deletes > 0 {
+		percent := 100.0
+		if currentEntities > 0 {
+			percent = float64(deletes) * 100 / float64(currentEntities)
+		}
+		if percent > b.MaxDeletePercent {
+			violations = append(violations,
+				fmt.Sprintf("deleting %d of %d entities (%.1f%%) exceeds --max-delete-percent %g",
+					deletes, currentEntities, percent, b.MaxDeletePercent))
+		}
+	}
+
+	deletesPerKind := map[string]int{}
+	for _, d := range changes.Deleting {
+		deletesPerKind[d.Kind]++
+	}
+	for _, perType := range []struct {
+		source string
+		limits map[string]int
+		format string
+	}{
+		{"--max-deletes-per-type", b.MaxDeletesPerType, "%s=%d"},
+		{"_info.max_deletes_per_type", b.StateFileMaxDeletesPerType, "%s: %d"},
+	} {
+		kinds := make([]string, 0, len(perType.limits))
+		for kind := range perType.limits {
+			kinds = append(kinds, kind)
+		}
+		sort.Strings(kinds)
+		for _, kind := range kinds {
+			limit := perType.limits[kind]
+			n := 0
+			for k, count := range deletesPerKind {
+				if budgetKindMatches(kind, k) {
+					n += count
+				}
+			}
+			if n > limit {
+				violations = append(violations, fmt.Sprintf("%d %s deletes exceed %s "+perType.format,
+					n, kind, perType.source, kind, limit))
+			}
+		}
+	}
+
+	if len(violations) == 0 {
+		return nil
+	}
+	return fmt.Errorf("change budget exceeded, no changes were made: %s", strings.Join(violations, "; "))
+}
+
+// budgetKindMatches reports whether a kind given in --max-deletes-per-type
+// refers to an entity kind of the diff. Kinds are matched case-insensitively,
+// ignoring the difference between '-' and '_' and a plural 's'.
+func budgetKindMatches(budgetKind, kind string) bool {
+	normalize := func(s string) string {
+		return strings.ReplaceAll(strings.ToLower(s), "_", "-")
+	}
+	budgetKind, kind = normalize(budgetKind), normalize(kind)
+	return budgetKind == kind || budgetKind == kind+"s"
+}
+
+// rawEntityCount returns the number of entities in a raw state.
+func rawEntityCount(raw *reconcilerUtils.KongRawState) int {
+	if raw == nil {
+		return 0
+	}
+	count := 0
+	v := reflect.ValueOf(raw).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if v.Field(i).Kind() == reflect.Slice {
+			count += v.Field(i).Len()
+		}
+	}
+	return count
+}
+
+// previewChanges computes the diff between the raw current and target states
+// without applying it, so that it can be checked before a sync makes any
+// change. Fresh states are built from the raw states, as computing a diff
+// updates the current state in place.
+func previewChanges(ctx context.Context, currentRaw, targetRaw *reconcilerUtils.KongRawState,
+	parallelism int, client *kong.Client, isKonnect bool, applyType ApplyType,
+) (diff.EntityChanges, error) {
+	currentState, err := state.Get(currentRaw)
+	if err != nil {
+		return diff.EntityChanges{}, fmt.Errorf("building current state: %w", err)
+	}
+	targetState, err := state.Get(targetRaw)
+	if err != nil {
+		return diff.EntityChanges{}, fmt.Errorf("building target state: %w", err)
+	}
+	s, err := diff.NewSyncer(diff.SyncerOpts{
+		CurrentState:       currentState,
+		TargetState:        targetState,
+		KongClient:         client,
+		NoMaskValues:       noMaskValues,
+		IsKonnect:          isKonnect,
+		NoDeletes:          applyType == ApplyTypePartial,
+		SkipSchemaDefaults: isKonnect && skipDefaultsFill,
+		SchemaRegistry:     schemaRegistry,
+	})
+	if err != nil {
+		return diff.EntityChanges{}, err
+	}
+	_, errs, changes := s.Solve(ctx, parallelism, true, true)
+	if errs != nil {
+		return diff.EntityChanges{}, fmt.Errorf("computing changes: %w", reconcilerUtils.ErrArray{Errors: errs})
+	}
+	return changes, nil
+}
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kong/go-database-reconciler/pkg/diff"
+	reconcilerUtils "github.com/kong/go-database-reconciler/pkg/utils"
+	"github.com/kong/go-kong/kong"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChangeBudget_Check(t *testing.T) {
+	changes := diff.EntityChanges{
+		Creating: []diff.EntityState{{Kind: "service"}},
+		Updating: []diff.EntityState{{Kind: "route"}},
+		Deleting: []diff.EntityState{{Kind: "route"}, {Kind: "route"}, {Kind: "key-auth"}},
+	}
+	unlimited := changeBudget{MaxDeletes: -1, MaxChanges: -1, MaxDeletePercent: -1}
+	assert.False(t, unlimited.enabled())
+	require.NoError(t, unlimited.check(changes, 10))
+
+	budget := unlimited
+	budget.MaxDeletes = 3
+	budget.MaxChanges = 5
+	budget.MaxDeletePercent = 30
+	budget.MaxDeletesPerType = map[string]int{"routes": 2, "key_auth": 1}
+	assert.True(t, budget.enabled())
+	require.NoError(t, budget.check(changes, 10))
+
+	budget.MaxDeletes = 2
+	budget.MaxChanges = 4
+	budget.MaxDeletePercent = 25
+	budget.MaxDeletesPerType = map[string]int{"route": 1, "service": 0}
+	require.EqualError(t, budget.check(changes, 10), "change budget exceeded, no changes were made: "+
+		"3 deletes exceed --max-deletes 2; "+
+		"5 changes exceed --max-changes 4; "+
+		"deleting 3 of 10 entities (30.0%) exceeds --max-delete-percent 25; "+
+		"2 route deletes exceed --max-deletes-per-type route=1")
+
+	budget = unlimited
+	budget.StateFileMaxDeletesPerType = map[string]int{"routes": 1}
+	assert.True(t, budget.enabled())
+	require.EqualError(t, budget.check(changes, 10), "change budget exceeded, no changes were made: "+
+		"2 routes deletes exceed _info.max_deletes_per_type routes: 1")
+}
+
+func TestRawEntityCount(t *testing.T) {
+	assert.Equal(t, 0, rawEntityCount(nil))
+	assert.Equal(t, 3, rawEntityCount(&reconcilerUtils.KongRawState{
+		Services:  []*kong.Service{{}, {}},
+		Consumers: []*kong.Consumer{{}},
+	}))
+}
+
+func TestPreviewChanges(t *testing.T) {
+	currentRaw := &reconcilerUtils.KongRawState{Services: []*kong.Service{
+		{ID: kong.String("a-id"), Name: kong.String("a"), Host: kong.String("a.example.com")},
+		{ID: kong.String("b-id"), Name: kong.String("b"), Host: kong.String("b.example.com")},
+	}}
+	targetRaw := &reconcilerUtils.KongRawState{Services: []*kong.Service{
+		{ID: kong.String("a-id"), Name: kong.String("a"), Host: kong.String("a.example.com")},
+	}}
+	changes, err := previewChanges(context.Background(), currentRaw, targetRaw, 1, nil, false, ApplyTypeFull)
+	require.NoError(t, err)
+	require.Len(t, changes.Deleting, 1)
+	assert.Equal(t, "b", changes.Deleting[0].Name)
+	assert.Empty(t, changes.Creating)
+	assert.Empty(t, changes.Updating)
+
+	// the raw states are left intact and yield the same diff again
+	changes, err = previewChanges(context.Background(), currentRaw, targetRaw, 1, nil, false, ApplyTypeFull)
+	require.NoError(t, err)
+	require.Len(t, changes.Deleting, 1)
+
+	// partial applies never delete
+	changes, err = previewChanges(context.Background(), currentRaw, targetRaw, 1, nil, false, ApplyTypePartial)
+	require.NoError(t, err)
+	assert.Empty(t, changes.Deleting)
+}
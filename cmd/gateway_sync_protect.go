@@ -0,0 +1,246 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/kong/go-database-reconciler/pkg/diff"
+	"github.com/spf13/pflag"
+)
+
+var (
+	protectTags      []string
+	protectSelectors []string
+	// activeProtection is built from the flags above by preRunProtectFlags;
+	// nil means no entity is protected.
+	activeProtection *entityProtection
+	// stateFileProtection protects the entities of the _info.protected of
+	// the state files being synced.
+	stateFileProtection *entityProtection
+)
+
+// entityProtection describes entities that must never be modified or deleted.
+type entityProtection struct {
+	tags map[string]bool
+	// selectors holds name/ID globs per entity kind.
+	selectors map[string][]string
+}
+
+// protectedChange is a change to a protected entity.
+type protectedChange struct {
+	Action string
+	Kind   string
+	Name   string
+	Reason string
+}
+
+// protectedEntitiesError is returned when a sync would change protected
+// entities.
+type protectedEntitiesError struct {
+	Changes []protectedChange
+}
+
+func (e *protectedEntitiesError) Messages() []string {
+	messages := make([]string, 0, len(e.Changes))
+	for _, c := range e.Changes {
+		messages = append(messages, fmt.Sprintf("protected entity: %s %s would be %sd (%s)",
+			c.Kind, c.Name, c.Action, c.Reason))
+	}
+	return messages
+}
+
+func (e *protectedEntitiesError) Error() string {
+	return fmt.Sprintf("refusing to change %d protected entities, no changes were made:\n%s",
+		len(e.Changes), strings.Join(e.Messages(), "\n"))
+}
+
+func addProtectFlags(set *pflag.FlagSet) {
+	set.StringSliceVar(&protectTags, "protect-tag", []string{},
+		"refuse to modify or delete entities carrying any of these tags.")
+	set.StringArrayVar(&protectSelectors, "protect", []string{},
+		"refuse to modify or delete entities matching 'kind:glob', e.g. 'plugin:key-auth*'\n"+
+			"or 'consumer:admin-*'. Kinds are named as in the diff output; the glob is\n"+
+			"matched against the entity's name as shown in the diff, its name and its ID.\n"+
+			"Can be repeated. Entities can also be protected in the _info.protected of state\n"+
+			"files, as a list of 'kind:glob' selectors and 'tag:name' tags.")
+}
+
+func preRunProtectFlags() error {
+	protection, err := newEntityProtection(protectTags, protectSelectors)
+	if err != nil {
+		return err
+	}
+	activeProtection = protection
+	return nil
+}
+
+// newEntityProtection validates the protect flags. It returns nil when no
+// entity is protected.
+func newEntityProtection(tags, selectors []string) (*entityProtection, error) {
+	return parseEntityProtection("--protect", tags, selectors)
+}
+
+// parseStateFileProtection parses the _info.protected of a state file: a
+// list of 'kind:glob' selectors, and of 'tag:name' tags.
+func parseStateFileProtection(value interface{}) (*entityProtection, error) {
+	list, _ := value.([]interface{})
+	if value != nil && list == nil {
+		return nil, fmt.Errorf("_info.protected must be a list of 'kind:glob' and 'tag:name' strings")
+	}
+	values := make([]string, 0, len(list))
+	for _, v := range list {
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("_info.protected must be a list of 'kind:glob' and 'tag:name' strings")
+		}
+		values = append(values, s)
+	}
+	return parseProtectionValues("_info.protected", values)
+}
+
+// parseProtectionValues parses a list of 'kind:glob' selectors, and of
+// 'tag:name' tags.
+func parseProtectionValues(source string, values []string) (*entityProtection, error) {
+	var tags, selectors []string
+	for _, s := range values {
+		if tag, ok := strings.CutPrefix(s, "tag:"); ok {
+			if tag == "" {
+				return nil, fmt.Errorf("invalid %s value %q, expected 'tag:name'", source, s)
+			}
+			tags = append(tags, tag)
+			continue
+		}
+		selectors = append(selectors, s)
+	}
+	return parseEntityProtection(source, tags, selectors)
+}
+
+func parseEntityProtection(source string, tags, selectors []string) (*entityProtection, error) {
+	if len(tags) == 0 && len(selectors) == 0 {
+		return nil, nil
+	}
+	p := &entityProtection{tags: map[string]bool{}, selectors: map[string][]string{}}
+	for _, tag := range tags {
+		p.tags[tag] = true
+	}
+	for _, s := range selectors {
+		kind, glob, ok := strings.Cut(s, ":")
+		if !ok || kind == "" || glob == "" {
+			return nil, fmt.Errorf("invalid %s value %q, expected 'kind:glob'", source, s)
+		}
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, fmt.Errorf("invalid %s glob %q: %w", source, glob, err)
+		}
+		p.selectors[kind] = append(p.selectors[kind], glob)
+	}
+	return p, nil
+}
+
+// merge returns the protection of both p and other.
+func (p *entityProtection) merge(other *entityProtection) *entityProtection {
+	if p == nil {
+		return other
+	}
+	if other == nil {
+		return p
+	}
+	merged := &entityProtection{tags: map[string]bool{}, selectors: map[string][]string{}}
+	for _, protection := range []*entityProtection{p, other} {
+		for tag := range protection.tags {
+			merged.tags[tag] = true
+		}
+		for kind, globs := range protection.selectors {
+			merged.selectors[kind] = append(merged.selectors[kind], globs...)
+		}
+	}
+	return merged
+}
+
+// syncProtection returns the protection of the flags and of the state files.
+func syncProtection() *entityProtection {
+	return activeProtection.merge(stateFileProtection)
+}
+
+// check returns a protectedEntitiesError if any of the updates or deletes
+// touches a protected entity.
+func (p *entityProtection) check(changes diff.EntityChanges) error {
+	if p == nil {
+		return nil
+	}
+	var violations []protectedChange
+	for _, group := range []struct {
+		action  string
+		changes []diff.EntityState
+	}{
+		{"update", changes.Updating},
+		{"delete", changes.Deleting},
+	} {
+		for _, change := range group.changes {
+			if reason := p.protects(change); reason != "" {
+				violations = append(violations, protectedChange{
+					Action: group.action,
+					Kind:   change.Kind,
+					Name:   change.Name,
+					Reason: reason,
+				})
+			}
+		}
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+	return &protectedEntitiesError{Changes: violations}
+}
+
+// protects returns why the entity changed by change is protected, or an
+// empty string if it is not.
+func (p *entityProtection) protects(change diff.EntityState) string {
+	oldObj, newObj, err := entityStateObjects(change)
+	if err != nil {
+		return ""
+	}
+	current, _ := currentEntityObject(oldObj, newObj).(map[string]any)
+
+	if tags, ok := current["tags"].([]any); ok {
+		for _, tag := range tags {
+			if s, ok := tag.(string); ok && p.tags[s] {
+				return fmt.Sprintf("tag '%s'", s)
+			}
+		}
+	}
+
+	candidates := []string{change.Name}
+	for _, field := range []string{"id", "name", "username"} {
+		if s, ok := current[field].(string); ok {
+			candidates = append(candidates, s)
+		}
+	}
+	for kind, globs := range p.selectors {
+		if !budgetKindMatches(kind, change.Kind) {
+			continue
+		}
+		for _, glob := range globs {
+			for _, c := range candidates {
+				if ok, _ := path.Match(glob, c); ok {
+					return fmt.Sprintf("matches '%s:%s'", kind, glob)
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// reportProtectedEntities adds the protected entities to the JSON report, as
+// errors of their own, before returning err.
+func reportProtectedEntities(err error, enableJSONOutput bool) error {
+	var protectedErr *protectedEntitiesError
+	if enableJSONOutput && errors.As(err, &protectedErr) {
+		jsonOutput.Errors = append(jsonOutput.Errors, protectedErr.Messages()...)
+		if printErr := printJSONOutput(false); printErr != nil {
+			return printErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/kong/go-database-reconciler/pkg/diff"
+	reconcilerUtils "github.com/kong/go-database-reconciler/pkg/utils"
+	"github.com/kong/go-kong/kong"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewEntityProtection(t *testing.T) {
+	p, err := newEntityProtection(nil, nil)
+	require.NoError(t, err)
+	assert.Nil(t, p)
+	require.NoError(t, p.check(diff.EntityChanges{Deleting: []diff.EntityState{{Kind: "service"}}}))
+
+	_, err = newEntityProtection(nil, []string{"admin"})
+	require.EqualError(t, err, `invalid --protect value "admin", expected 'kind:glob'`)
+
+	_, err = newEntityProtection(nil, []string{"consumer:[admin"})
+	require.ErrorContains(t, err, `invalid --protect glob "[admin"`)
+}
+
+func TestParseStateFileProtection(t *testing.T) {
+	p, err := parseStateFileProtection([]interface{}{"tag:critical", "consumer:admin-*", "plugin:key-auth"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"critical": true}, p.tags)
+	assert.Equal(t, map[string][]string{"consumer": {"admin-*"}, "plugin": {"key-auth"}}, p.selectors)
+
+	_, err = parseStateFileProtection("consumer:admin-*")
+	require.EqualError(t, err, "_info.protected must be a list of 'kind:glob' and 'tag:name' strings")
+	_, err = parseStateFileProtection([]interface{}{"admin"})
+	require.EqualError(t, err, `invalid _info.protected value "admin", expected 'kind:glob'`)
+	_, err = parseStateFileProtection([]interface{}{"tag:"})
+	require.EqualError(t, err, `invalid _info.protected value "tag:", expected 'tag:name'`)
+
+	// protections of the flags and of the state files add up
+	flags, err := newEntityProtection([]string{"keep"}, []string{"consumer:bob"})
+	require.NoError(t, err)
+	merged := flags.merge(p)
+	assert.Equal(t, map[string]bool{"critical": true, "keep": true}, merged.tags)
+	assert.Equal(t, []string{"bob", "admin-*"}, merged.selectors["consumer"])
+	assert.Same(t, flags, flags.merge(nil))
+	var none *entityProtection
+	assert.Same(t, p, none.merge(p))
+}
+
+func TestEntityProtection_Check(t *testing.T) {
+	currentRaw := &reconcilerUtils.KongRawState{
+		Services: []*kong.Service{
+			{ID: kong.String("a-id"), Name: kong.String("a"), Host: kong.String("a.example.com")},
+			{
+				ID: kong.String("b-id"), Name: kong.String("b"), Host: kong.String("b.example.com"),
+				Tags: kong.StringSlice("keep"),
+			},
+		},
+		Consumers: []*kong.Consumer{
+			{ID: kong.String("c1"), Username: kong.String("admin-alice")},
+			{ID: kong.String("c2"), Username: kong.String("bob")},
+		},
+		Plugins: []*kong.Plugin{
+			{ID: kong.String("p1"), Name: kong.String("key-auth")},
+		},
+	}
+	targetRaw := &reconcilerUtils.KongRawState{
+		Services: []*kong.Service{
+			{ID: kong.String("a-id"), Name: kong.String("a"), Host: kong.String("new.example.com")},
+		},
+	}
+	changes, err := previewChanges(context.Background(), currentRaw, targetRaw, 1, nil, false, ApplyTypeFull)
+	require.NoError(t, err)
+
+	p, err := newEntityProtection([]string{"keep"}, []string{"consumer:admin-*", "plugins:key-auth*"})
+	require.NoError(t, err)
+	err = p.check(changes)
+
+	var protectedErr *protectedEntitiesError
+	require.True(t, errors.As(err, &protectedErr))
+	assert.ElementsMatch(t, []string{
+		"protected entity: service b would be deleted (tag 'keep')",
+		"protected entity: consumer admin-alice would be deleted (matches 'consumer:admin-*')",
+		"protected entity: plugin key-auth (global) would be deleted (matches 'plugins:key-auth*')",
+	}, protectedErr.Messages())
+	assert.Contains(t, err.Error(), "refusing to change 3 protected entities, no changes were made")
+
+	// the same protection, split between the flags and a state file
+	flags, err := newEntityProtection(nil, []string{"consumer:admin-*"})
+	require.NoError(t, err)
+	_, settings, err := readStateFiles([]string{writeTestFile(t, "kong.yaml", `_format_version: "3.0"
+_info:
+  protected:
+  - tag:keep
+  - plugins:key-auth*
+`)})
+	require.NoError(t, err)
+	err = flags.merge(settings.protected).check(changes)
+	require.True(t, errors.As(err, &protectedErr))
+	assert.Len(t, protectedErr.Messages(), 3)
+
+	// updates are refused as well, creates are not
+	p, err = newEntityProtection(nil, []string{"service:a-id"})
+	require.NoError(t, err)
+	err = p.check(changes)
+	require.True(t, errors.As(err, &protectedErr))
+	assert.Equal(t, []string{
+		"protected entity: service a would be updated (matches 'service:a-id')",
+	}, protectedErr.Messages())
+
+	require.NoError(t, p.check(diff.EntityChanges{Creating: []diff.EntityState{{
+		Kind: "service", Name: "a",
+		Body: map[string]any{"old": nil, "new": map[string]any{"id": "a-id"}},
+	}}}))
+}
@@ -0,0 +1,452 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/kong/go-database-reconciler/pkg/diff"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"sigs.k8s.io/yaml"
+)
+
+var syncCmdTargetsFile string
+
+const (
+	targetStatusSynced    = "synced"
+	targetStatusFailed    = "failed"
+	targetStatusUnhealthy = "unhealthy"
+	targetStatusSkipped   = "skipped"
+)
+
+// syncTargetsFile is the file given with 'deck gateway sync --targets'.
+type syncTargetsFile struct {
+	Policy  syncWavePolicy `json:"policy"`
+	Targets []syncTarget   `json:"targets"`
+}
+
+// syncWavePolicy controls how the waves of a multi-target sync are run.
+type syncWavePolicy struct {
+	// Parallelism is the maximum number of targets of a wave that are synced
+	// concurrently. Zero syncs all targets of a wave at once.
+	Parallelism int `json:"parallelism"`
+	// Pause is the time to wait between two waves, after the health checks.
+	Pause string `json:"pause"`
+	// SkipHealthChecks disables pinging the targets of a wave once synced.
+	SkipHealthChecks bool `json:"skip-health-checks"`
+}
+
+// syncTarget is a Kong cluster to sync. The connection settings are named
+// after the global flags they replace.
+type syncTarget struct {
+	Name              string   `json:"name"`
+	Wave              int      `json:"wave"`
+	KongAddr          string   `json:"kong-addr"`
+	Headers           []string `json:"headers"`
+	KongAdminToken    string   `json:"kong-admin-token"`
+	TLSSkipVerify     bool     `json:"tls-skip-verify"`
+	TLSServerName     string   `json:"tls-server-name"`
+	CACert            string   `json:"ca-cert"`
+	CACertFile        string   `json:"ca-cert-file"`
+	TLSClientCert     string   `json:"tls-client-cert"`
+	TLSClientCertFile string   `json:"tls-client-cert-file"`
+	TLSClientKey      string   `json:"tls-client-key"`
+	TLSClientKeyFile  string   `json:"tls-client-key-file"`
+	SkipWorkspaceCRUD bool     `json:"skip-workspace-crud"`
+	KongCookieJarPath string   `json:"kong-cookie-jar-path"`
+	Timeout           int      `json:"timeout"`
+}
+
+// syncTargetConnectionFlags are the flags of the connection settings of
+// targets, see syncTarget.flags. They are taken from the targets file only.
+var syncTargetConnectionFlags = []string{
+	"kong-addr", "headers", "kong-admin-token", "tls-skip-verify", "tls-server-name",
+	"ca-cert", "ca-cert-file", "tls-client-cert", "tls-client-cert-file", "tls-client-key",
+	"tls-client-key-file", "skip-workspace-crud", "kong-cookie-jar-path", "timeout",
+}
+
+// flags returns the target's connection settings as decK flags.
+func (t syncTarget) flags() childFlags {
+	var flags childFlags
+	for _, f := range []struct {
+		name  string
+		value string
+	}{
+		{"kong-addr", t.KongAddr},
+		{"kong-admin-token", t.KongAdminToken},
+		{"tls-server-name", t.TLSServerName},
+		{"ca-cert", t.CACert},
+		{"ca-cert-file", t.CACertFile},
+		{"tls-client-cert", t.TLSClientCert},
+		{"tls-client-cert-file", t.TLSClientCertFile},
+		{"tls-client-key", t.TLSClientKey},
+		{"tls-client-key-file", t.TLSClientKeyFile},
+		{"kong-cookie-jar-path", t.KongCookieJarPath},
+	} {
+		if f.value != "" {
+			flags.add(f.name, f.value)
+		}
+	}
+	if len(t.Headers) > 0 {
+		flags.add("headers", t.Headers...)
+	}
+	if t.TLSSkipVerify {
+		flags.add("tls-skip-verify", "true")
+	}
+	if t.SkipWorkspaceCRUD {
+		flags.add("skip-workspace-crud", "true")
+	}
+	if t.Timeout > 0 {
+		flags.add("timeout", strconv.Itoa(t.Timeout))
+	}
+	return flags
+}
+
+// readSyncTargetsFile reads and validates a targets file.
+func readSyncTargetsFile(filename string) (syncTargetsFile, time.Duration, error) {
+	var targets syncTargetsFile
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return targets, 0, fmt.Errorf("reading targets file: %w", err)
+	}
+	if err := yaml.UnmarshalStrict(b, &targets); err != nil {
+		return targets, 0, fmt.Errorf("parsing targets file %s: %w", filename, err)
+	}
+
+	var pause time.Duration
+	if targets.Policy.Pause != "" {
+		pause, err = time.ParseDuration(targets.Policy.Pause)
+		if err != nil || pause < 0 {
+			return targets, 0, fmt.Errorf("invalid policy.pause %q, expected a duration such as '30s'",
+				targets.Policy.Pause)
+		}
+	}
+	if targets.Policy.Parallelism < 0 {
+		return targets, 0, fmt.Errorf("policy.parallelism cannot be negative, got %d",
+			targets.Policy.Parallelism)
+	}
+	if len(targets.Targets) == 0 {
+		return targets, 0, fmt.Errorf("no targets defined in %s", filename)
+	}
+	seen := map[string]bool{}
+	for i, t := range targets.Targets {
+		if t.Name == "" {
+			return targets, 0, fmt.Errorf("target #%d has no name", i+1)
+		}
+		if seen[t.Name] {
+			return targets, 0, fmt.Errorf("target '%s' is defined more than once", t.Name)
+		}
+		seen[t.Name] = true
+		if t.Wave < 1 {
+			return targets, 0, fmt.Errorf("target '%s': wave must be at least 1, got %d", t.Name, t.Wave)
+		}
+		if t.KongAddr == "" {
+			return targets, 0, fmt.Errorf("target '%s': kong-addr is required", t.Name)
+		}
+	}
+	return targets, pause, nil
+}
+
+// syncWaves groups the targets by wave, in the order of the waves. Targets
+// keep the order of the targets file within a wave.
+func syncWaves(targets []syncTarget) [][]syncTarget {
+	byWave := map[int][]syncTarget{}
+	for _, t := range targets {
+		byWave[t.Wave] = append(byWave[t.Wave], t)
+	}
+	waveNumbers := make([]int, 0, len(byWave))
+	for wave := range byWave {
+		waveNumbers = append(waveNumbers, wave)
+	}
+	sort.Ints(waveNumbers)
+	waves := make([][]syncTarget, 0, len(waveNumbers))
+	for _, wave := range waveNumbers {
+		waves = append(waves, byWave[wave])
+	}
+	return waves
+}
+
+// targetSyncResult is the outcome of syncing a single target.
+type targetSyncResult struct {
+	Target   string       `json:"target"`
+	Wave     int          `json:"wave"`
+	Status   string       `json:"status"`
+	Summary  diff.Summary `json:"summary"`
+	Warnings []string     `json:"warnings"`
+	Errors   []string     `json:"errors"`
+}
+
+// targetSyncReport is the consolidated report of a multi-target sync.
+type targetSyncReport struct {
+	Targets []targetSyncResult `json:"targets"`
+	Summary diff.Summary       `json:"summary"`
+	Failed  []string           `json:"failed"`
+	// HaltedAtWave is the wave that failed, zero if all waves succeeded.
+	HaltedAtWave int `json:"halted_at_wave,omitempty"`
+}
+
+// targetRunner syncs and health-checks single targets.
+type targetRunner struct {
+	sync func(ctx context.Context, target syncTarget) targetSyncResult
+	ping func(ctx context.Context, target syncTarget) error
+	logf func(format string, a ...any)
+}
+
+// syncTargets syncs the targets wave by wave. The targets of a wave are
+// synced concurrently, at most parallelism at a time, then health-checked.
+// The first wave with a failed sync or health check halts the rollout; the
+// targets of the later waves are skipped.
+func syncTargets(ctx context.Context, targets syncTargetsFile, pause time.Duration,
+	runner targetRunner,
+) targetSyncReport {
+	waves := syncWaves(targets.Targets)
+	report := targetSyncReport{Targets: []targetSyncResult{}, Failed: []string{}}
+	for i, wave := range waves {
+		waveNumber := wave[0].Wave
+		if report.HaltedAtWave != 0 {
+			for _, t := range wave {
+				report.Targets = append(report.Targets, targetSyncResult{
+					Target: t.Name, Wave: waveNumber, Status: targetStatusSkipped,
+					Warnings: []string{}, Errors: []string{},
+				})
+			}
+			continue
+		}
+
+		runner.logf("wave %d: syncing %s\n", waveNumber, targetNames(wave))
+		results := runTargets(ctx, len(wave), targets.Policy.Parallelism,
+			func(ctx context.Context, i int) targetSyncResult {
+				result := runner.sync(ctx, wave[i])
+				result.Target, result.Wave = wave[i].Name, waveNumber
+				result.Status = targetStatusSynced
+				if len(result.Errors) > 0 {
+					result.Status = targetStatusFailed
+				}
+				return result
+			})
+		if !slices.ContainsFunc(results, isFailedTarget) && !targets.Policy.SkipHealthChecks {
+			runner.logf("wave %d: checking health of %s\n", waveNumber, targetNames(wave))
+			synced := results
+			results = runTargets(ctx, len(wave), targets.Policy.Parallelism,
+				func(ctx context.Context, i int) targetSyncResult {
+					result := synced[i]
+					if err := runner.ping(ctx, wave[i]); err != nil {
+						result.Status = targetStatusUnhealthy
+						result.Errors = append(result.Errors, fmt.Sprintf("health check failed: %v", err))
+					}
+					return result
+				})
+		}
+
+		for _, result := range results {
+			report.Summary.Creating += result.Summary.Creating
+			report.Summary.Updating += result.Summary.Updating
+			report.Summary.Deleting += result.Summary.Deleting
+			report.Summary.Total += result.Summary.Total
+			if isFailedTarget(result) {
+				report.Failed = append(report.Failed, result.Target)
+			}
+		}
+		report.Targets = append(report.Targets, results...)
+		if len(report.Failed) > 0 {
+			report.HaltedAtWave = waveNumber
+			runner.logf("wave %d: failed, halting\n", waveNumber)
+			continue
+		}
+
+		if pause > 0 && i < len(waves)-1 {
+			runner.logf("wave %d: done, pausing for %s\n", waveNumber, pause)
+			select {
+			case <-ctx.Done():
+			case <-time.After(pause):
+			}
+		}
+	}
+	return report
+}
+
+// runTargets runs fn for n targets, at most parallelism at a time (all at
+// once if parallelism is zero), and returns the results in order.
+func runTargets(ctx context.Context, n, parallelism int,
+	fn func(ctx context.Context, i int) targetSyncResult,
+) []targetSyncResult {
+	if parallelism == 0 {
+		parallelism = n
+	}
+	results := make([]targetSyncResult, n)
+	sem := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i] = fn(ctx, i)
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+func isFailedTarget(result targetSyncResult) bool {
+	return result.Status == targetStatusFailed || result.Status == targetStatusUnhealthy
+}
+
+func targetNames(targets []syncTarget) string {
+	names := make([]string, 0, len(targets))
+	for _, t := range targets {
+		names = append(names, t.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// targetChildFlags returns the flags of set that were given on the command
+// line, except for the skipped ones and the connection settings, merged
+// with the connection settings of a target.
+func targetChildFlags(set *pflag.FlagSet, t syncTarget, skip ...string) childFlags {
+	flags := changedFlags(set, append(slices.Clone(syncTargetConnectionFlags), skip...)...)
+	flags.merge(t.flags())
+	return flags
+}
+
+// targetChildEnv returns the environment of the child decK process of a
+// target: environ without the environment variables of the connection
+// settings, and the ones of flags.
+func targetChildEnv(environ []string, flags childFlags) []string {
+	env := make([]string, 0, len(environ)+len(flags.env))
+	for _, kv := range environ {
+		name, _, _ := strings.Cut(kv, "=")
+		if !slices.ContainsFunc(syncTargetConnectionFlags, func(flag string) bool {
+			return name == "DECK_"+envVarName(flag)
+		}) {
+			env = append(env, kv)
+		}
+	}
+	return append(env, flags.env...)
+}
+
+// newProcessTargetRunner syncs and pings each target in a separate decK
+// process, for the same reason as newProcessWorkspaceSyncer. The flags the
+// sync command was invoked with are passed on, except for the connection
+// settings, which are taken from the targets file only.
+func newProcessTargetRunner(cmd *cobra.Command, stateFiles []string) (targetRunner, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return targetRunner{}, fmt.Errorf("locating decK executable: %w", err)
+	}
+	// read state from stdin once, as it is passed on to every target
+	var stdin []byte
+	if slices.Contains(stateFiles, "-") {
+		stdin, err = io.ReadAll(os.Stdin)
+		if err != nil {
+			return targetRunner{}, fmt.Errorf("reading state from stdin: %w", err)
+		}
+	}
+	// strip the name of the root command
+	command := strings.Fields(cmd.CommandPath())[1:]
+	pingCommand := append(slices.Clone(command[:len(command)-1]), "ping")
+
+	return targetRunner{
+		sync: func(ctx context.Context, t syncTarget) targetSyncResult {
+			flags := targetChildFlags(cmd.Flags(), t, "targets", "json-output")
+			args := append(slices.Clone(command), flags.args...)
+			args = append(args, "--json-output")
+			args = append(args, stateFiles...)
+
+			output, errs := runSyncProcess(ctx, executable, args, targetChildEnv(os.Environ(), flags),
+				bytes.NewReader(stdin))
+			return targetSyncResult{
+				Summary:  output.Summary,
+				Warnings: append([]string{}, output.Warnings...),
+				Errors:   append([]string{}, errs...),
+			}
+		},
+		ping: func(ctx context.Context, t syncTarget) error {
+			flags := targetChildFlags(cmd.InheritedFlags(), t)
+			args := append(slices.Clone(pingCommand), flags.args...)
+			if syncWorkspace != "" {
+				args = append(args, "--workspace", syncWorkspace)
+			}
+
+			var stderr bytes.Buffer
+			child := exec.CommandContext(ctx, executable, args...)
+			child.Env = targetChildEnv(os.Environ(), flags)
+			child.Stderr = &stderr
+			if err := child.Run(); err != nil {
+				return errors.New(childProcessError(err, stderr.String()))
+			}
+			return nil
+		},
+		logf: func(format string, a ...any) {
+			fmt.Fprintf(os.Stderr, format, a...)
+		},
+	}, nil
+}
+
+func syncTargetsMain(cmd *cobra.Command, targetsFile string, stateFiles []string, enableJSONOutput bool) error {
+	targets, pause, err := readSyncTargetsFile(targetsFile)
+	if err != nil {
+		return err
+	}
+	runner, err := newProcessTargetRunner(cmd, stateFiles)
+	if err != nil {
+		return err
+	}
+	report := syncTargets(cmd.Context(), targets, pause, runner)
+
+	if enableJSONOutput {
+		b, err := json.MarshalIndent(report, "", "\t")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+	} else {
+		printTargetSyncReport(report)
+	}
+	if report.HaltedAtWave != 0 {
+		return fmt.Errorf("sync halted at wave %d, failed targets: %s",
+			report.HaltedAtWave, strings.Join(report.Failed, ", "))
+	}
+	return nil
+}
+
+func printTargetSyncReport(report targetSyncReport) {
+	for _, result := range report.Targets {
+		switch result.Status {
+		case targetStatusSkipped:
+			color.New(color.FgYellow).Printf("target %s (wave %d): skipped\n", result.Target, result.Wave)
+			continue
+		case targetStatusFailed, targetStatusUnhealthy:
+			color.New(color.FgRed).Printf("target %s (wave %d): %s\n", result.Target, result.Wave, result.Status)
+			for _, e := range result.Errors {
+				fmt.Printf("  %s\n", e)
+			}
+		default:
+			fmt.Printf("target %s (wave %d): created %d, updated %d, deleted %d\n", result.Target, result.Wave,
+				result.Summary.Creating, result.Summary.Updating, result.Summary.Deleting)
+		}
+		for _, w := range result.Warnings {
+			fmt.Printf("  warning: %s\n", w)
+		}
+	}
+	printFn := color.New(color.FgGreen, color.Bold).PrintfFunc()
+	printFn("Summary:\n")
+	printFn("  Targets: %d (%d failed)\n", len(report.Targets), len(report.Failed))
+	printFn("  Created: %d\n", report.Summary.Creating)
+	printFn("  Updated: %d\n", report.Summary.Updating)
+	printFn("  Deleted: %d\n", report.Summary.Deleting)
+}
@@ -0,0 +1,186 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kong/go-database-reconciler/pkg/diff"
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadSyncTargetsFile(t *testing.T) {
+	write := func(content string) string {
+		filename := filepath.Join(t.TempDir(), "targets.yaml")
+		require.NoError(t, os.WriteFile(filename, []byte(content), 0o600))
+		return filename
+	}
+
+	targets, pause, err := readSyncTargetsFile(write(`
+policy:
+  pause: 1m
+  parallelism: 2
+targets:
+- name: dev
+  wave: 1
+  kong-addr: http://dev:8001
+  kong-admin-token: secret
+  headers: [a:1, b:2]
+  tls-skip-verify: true
+- name: prod-eu
+  wave: 2
+  kong-addr: https://eu:8444
+  ca-cert-file: ca.pem
+  timeout: 30
+`))
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, pause)
+	assert.Equal(t, 2, targets.Policy.Parallelism)
+	require.Len(t, targets.Targets, 2)
+	// secrets are passed on in the environment
+	dev := targets.Targets[0].flags()
+	assert.Equal(t, []string{"--kong-addr=http://dev:8001", "--tls-skip-verify=true"}, dev.args)
+	assert.Equal(t, []string{"DECK_KONG_ADMIN_TOKEN=secret", "DECK_HEADERS=a:1\nb:2"}, dev.env)
+	assert.Equal(t, []string{"kong-addr", "kong-admin-token", "headers", "tls-skip-verify"}, dev.names)
+	prod := targets.Targets[1].flags()
+	assert.Equal(t, []string{
+		"--kong-addr=https://eu:8444", "--ca-cert-file=ca.pem", "--timeout=30",
+	}, prod.args)
+	assert.Empty(t, prod.env)
+	assert.Equal(t, []string{"kong-addr", "ca-cert-file", "timeout"}, prod.names)
+
+	for content, expected := range map[string]string{
+		"targets: []":                               "no targets defined",
+		"targets:\n- wave: 1\n  kong-addr: x":       "target #1 has no name",
+		"targets:\n- name: a\n  kong-addr: x":       "target 'a': wave must be at least 1, got 0",
+		"targets:\n- name: a\n  wave: 1":            "target 'a': kong-addr is required",
+		"targets:\n- name: a\n  wave: 1\n  kong: x": `unknown field "kong"`,
+		"policy:\n  pause: soon\ntargets: []":       `invalid policy.pause "soon"`,
+		"targets:\n- {name: a, wave: 1, kong-addr: x}\n- {name: a, wave: 2, kong-addr: y}": "target 'a' is defined more than once",
+	} {
+		_, _, err := readSyncTargetsFile(write(content))
+		assert.ErrorContains(t, err, expected, content)
+	}
+}
+
+func TestSyncTargets(t *testing.T) {
+	targets := syncTargetsFile{Targets: []syncTarget{
+		{Name: "prod-us", Wave: 3},
+		{Name: "dev", Wave: 1},
+		{Name: "prod-eu", Wave: 3},
+		{Name: "staging", Wave: 2},
+	}}
+
+	var mu sync.Mutex
+	var calls []string
+	record := func(call string) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, call)
+	}
+	runner := targetRunner{
+		sync: func(_ context.Context, target syncTarget) targetSyncResult {
+			record("sync " + target.Name)
+			return targetSyncResult{Summary: diff.Summary{Creating: 1, Total: 1}}
+		},
+		ping: func(_ context.Context, target syncTarget) error {
+			record("ping " + target.Name)
+			return nil
+		},
+		logf: func(string, ...any) {},
+	}
+
+	report := syncTargets(context.Background(), targets, 0, runner)
+	assert.Zero(t, report.HaltedAtWave)
+	assert.Empty(t, report.Failed)
+	assert.Equal(t, diff.Summary{Creating: 4, Total: 4}, report.Summary)
+	require.Len(t, calls, 8)
+	assert.Equal(t, []string{"sync dev", "ping dev", "sync staging", "ping staging"}, calls[:4])
+	assert.ElementsMatch(t, []string{"sync prod-us", "sync prod-eu"}, calls[4:6])
+	assert.ElementsMatch(t, []string{"ping prod-us", "ping prod-eu"}, calls[6:])
+
+	// a failed health check halts the sync before the next wave
+	calls = nil
+	runner.ping = func(_ context.Context, target syncTarget) error {
+		record("ping " + target.Name)
+		if target.Name == "staging" {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	report = syncTargets(context.Background(), targets, 0, runner)
+	assert.Equal(t, []string{"sync dev", "ping dev", "sync staging", "ping staging"}, calls)
+	assert.Equal(t, 2, report.HaltedAtWave)
+	assert.Equal(t, []string{"staging"}, report.Failed)
+	require.Len(t, report.Targets, 4)
+	assert.Equal(t, targetStatusSynced, report.Targets[0].Status)
+	assert.Equal(t, targetStatusUnhealthy, report.Targets[1].Status)
+	assert.Equal(t, []string{"health check failed: connection refused"}, report.Targets[1].Errors)
+	assert.Equal(t, targetStatusSkipped, report.Targets[2].Status)
+	assert.Equal(t, targetStatusSkipped, report.Targets[3].Status)
+
+	// a failed sync halts the sync without a health check
+	calls = nil
+	runner.sync = func(_ context.Context, target syncTarget) targetSyncResult {
+		record("sync " + target.Name)
+		return targetSyncResult{Errors: []string{"boom"}}
+	}
+	targets.Policy.SkipHealthChecks = true
+	report = syncTargets(context.Background(), targets, 0, runner)
+	assert.Equal(t, []string{"sync dev"}, calls)
+	assert.Equal(t, 1, report.HaltedAtWave)
+	assert.Equal(t, targetStatusFailed, report.Targets[0].Status)
+}
+
+func TestSyncCmd_TargetsFlags(t *testing.T) {
+	cmd := newSyncCmd(false)
+	syncCmdParallelism = 10
+	syncCmdTargetsFile = "targets.yaml"
+	syncAllWorkspaces = true
+	defer func() {
+		syncCmdTargetsFile = ""
+		syncAllWorkspaces = false
+	}()
+
+	require.EqualError(t, cmd.PreRunE(cmd, []string{"dir"}),
+		"--targets cannot be used with --all-workspaces or --plan")
+
+	syncAllWorkspaces = false
+	require.NoError(t, cmd.PreRunE(cmd, []string{"kong.yaml"}))
+}
+
+func TestTargetChildFlags(t *testing.T) {
+	set := pflag.NewFlagSet("sync", pflag.ContinueOnError)
+	set.String("kong-addr", "", "")
+	set.StringSlice("headers", nil, "")
+	set.String("kong-admin-token", "", "")
+	set.Bool("tls-skip-verify", false, "")
+	set.Int("parallelism", 10, "")
+	set.String("targets", "", "")
+	require.NoError(t, set.Parse([]string{
+		"--kong-addr=http://global:8001", "--headers=x-global:1", "--kong-admin-token=global-token",
+		"--tls-skip-verify", "--parallelism=2", "--targets=targets.yaml",
+	}))
+
+	// the connection settings of the command line are left out, set or not
+	// by the target
+	flags := targetChildFlags(set, syncTarget{Name: "a", KongAddr: "http://a:8001"}, "targets")
+	assert.Equal(t, []string{"--parallelism=2", "--kong-addr=http://a:8001"}, flags.args)
+	assert.Empty(t, flags.env)
+
+	flags = targetChildFlags(set, syncTarget{Name: "b", KongAddr: "http://b:8001", KongAdminToken: "b-token"})
+	assert.Equal(t, []string{"--parallelism=2", "--targets=targets.yaml", "--kong-addr=http://b:8001"}, flags.args)
+	assert.Equal(t, []string{"DECK_KONG_ADMIN_TOKEN=b-token"}, flags.env)
+
+	env := targetChildEnv([]string{
+		"PATH=/bin", "DECK_KONG_ADDR=http://global:8001", "DECK_HEADERS=x-global:1",
+		"DECK_KONG_ADMIN_TOKEN=global-token", "DECK_ANALYTICS=off",
+	}, flags)
+	assert.Equal(t, []string{"PATH=/bin", "DECK_ANALYTICS=off", "DECK_KONG_ADMIN_TOKEN=b-token"}, env)
+}
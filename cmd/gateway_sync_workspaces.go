@@ -0,0 +1,357 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fatih/color"
+	"github.com/kong/go-database-reconciler/pkg/diff"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	syncAllWorkspaces           bool
+	syncCmdWorkspaceParallelism int
+)
+
+// workspaceStateFile is a state file holding the configuration of one workspace.
+type workspaceStateFile struct {
+	Workspace string
+	Filename  string
+}
+
+// workspaceSyncResult is the outcome of syncing a single workspace.
+type workspaceSyncResult struct {
+	Workspace string       `json:"workspace"`
+	Filename  string       `json:"file"`
+	Summary   diff.Summary `json:"summary"`
+	Warnings  []string     `json:"warnings"`
+	Errors    []string     `json:"errors"`
+}
+
+// workspaceSyncReport is the consolidated report of a multi-workspace sync.
+type workspaceSyncReport struct {
+	Workspaces []workspaceSyncResult `json:"workspaces"`
+	Summary    diff.Summary          `json:"summary"`
+	Failed     []string              `json:"failed"`
+}
+
+// workspaceSyncer syncs the state file of a single workspace.
+type workspaceSyncer func(ctx context.Context, stateFile workspaceStateFile) workspaceSyncResult
+
+// workspaceStateFiles returns the YAML and JSON state files in dir, as written
+// by 'deck gateway dump --all-workspaces'. Subdirectories holding state files,
+// as written with --split-by, are treated as the state of one workspace each.
+// The workspace of a file is taken from its _workspace field, falling back to
+// the file or directory name.
+func workspaceStateFiles(dir string) ([]workspaceStateFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading state directory: %w", err)
+	}
+	seen := map[string]string{}
+	var stateFiles []workspaceStateFile
+	for _, entry := range entries {
+		filename := filepath.Join(dir, entry.Name())
+		if entry.IsDir() {
+			files, err := stateFilesInDir(filename)
+			if err != nil {
+				return nil, err
+			}
+			if len(files) == 0 {
+				continue
+			}
+		} else {
+			ext := strings.ToLower(filepath.Ext(entry.Name()))
+			if ext != ".yaml" && ext != ".yml" && ext != ".json" {
+				continue
+			}
+		}
+		workspace, err := stateFilesWorkspace(filename)
+		if err != nil {
+			return nil, fmt.Errorf("reading state file %s: %w", filename, err)
+		}
+		if workspace == "" && entry.IsDir() {
+			workspace = entry.Name()
+		} else if workspace == "" {
+			workspace = strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		}
+		if other, ok := seen[workspace]; ok {
+			return nil, fmt.Errorf("workspace '%s' is defined by both %s and %s", workspace, other, filename)
+		}
+		seen[workspace] = filename
+		stateFiles = append(stateFiles, workspaceStateFile{Workspace: workspace, Filename: filename})
+	}
+	if len(stateFiles) == 0 {
+		return nil, fmt.Errorf("no state files found in %s", dir)
+	}
+	sort.Slice(stateFiles, func(i, j int) bool {
+		return stateFiles[i].Workspace < stateFiles[j].Workspace
+	})
+	return stateFiles, nil
+}
+
+// stateFilesWorkspace returns the _workspace field of the state files of a
+// file or directory. The files are neither rendered nor decrypted, this is
+// left to the sync of the workspace.
+func stateFilesWorkspace(fileOrDir string) (string, error) {
+	names, err := stateFileNames(fileOrDir)
+	if err != nil {
+		return "", err
+	}
+	for _, name := range names {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			return "", err
+		}
+		var header struct {
+			Workspace string `json:"_workspace"`
+		}
+		if err := yaml.Unmarshal(b, &header); err != nil {
+			return "", err
+		}
+		if header.Workspace != "" {
+			return header.Workspace, nil
+		}
+	}
+	return "", nil
+}
+
+// syncWorkspaces syncs all state files, at most parallelism at a time, and
+// consolidates the results in the order of stateFiles.
+func syncWorkspaces(ctx context.Context, stateFiles []workspaceStateFile, parallelism int,
+	syncer workspaceSyncer,
+) workspaceSyncReport {
+	results := make([]workspaceSyncResult, len(stateFiles))
+	sem := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for i, stateFile := range stateFiles {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i] = syncer(ctx, stateFile)
+		}()
+	}
+	wg.Wait()
+
+	report := workspaceSyncReport{Workspaces: results, Failed: []string{}}
+	for _, result := range results {
+		report.Summary.Creating += result.Summary.Creating
+		report.Summary.Updating += result.Summary.Updating
+		report.Summary.Deleting += result.Summary.Deleting
+		report.Summary.Total += result.Summary.Total
+		if len(result.Errors) > 0 {
+			report.Failed = append(report.Failed, result.Workspace)
+		}
+	}
+	return report
+}
+
+// workspaceSyncArgs returns the flags the sync command was invoked with, so
+// that they can be passed on to the sync of each workspace. Flags that are
+// set per workspace are left out.
+func workspaceSyncArgs(cmd *cobra.Command) childFlags {
+	return changedFlags(cmd.Flags(), "all-workspaces", "workspace-parallelism", "workspace", "json-output")
+}
+
+// secretFlagEnv maps the flags holding secrets to the environment variables
+// they are passed on to child decK processes in, as the command line of a
+// process can be read by the other users of the host.
+var secretFlagEnv = map[string]string{
+	"kong-admin-token": "DECK_KONG_ADMIN_TOKEN",
+	"konnect-token":    "DECK_KONNECT_TOKEN",
+	"headers":          "DECK_HEADERS",
+	"tls-client-key":   "DECK_TLS_CLIENT_KEY",
+}
+
+// childFlags are flags passed on to a child decK process.
+type childFlags struct {
+	names []string
+	args  []string
+	// env holds the flags of secretFlagEnv, as environment variables.
+	env []string
+}
+
+// add adds a flag with all of its values.
+func (f *childFlags) add(name string, values ...string) {
+	f.names = append(f.names, name)
+	if envVar, ok := secretFlagEnv[name]; ok {
+		// one value per line, as header values may contain spaces
+		f.env = append(f.env, envVar+"="+strings.Join(values, "\n"))
+		return
+	}
+	for _, v := range values {
+		f.args = append(f.args, "--"+name+"="+v)
+	}
+}
+
+// merge adds the flags of other.
+func (f *childFlags) merge(other childFlags) {
+	f.names = append(f.names, other.names...)
+	f.args = append(f.args, other.args...)
+	f.env = append(f.env, other.env...)
+}
+
+// changedFlags returns the flags of set that were given on the command
+// line, except for the skipped ones, as flags for a child decK process.
+func changedFlags(set *pflag.FlagSet, skip ...string) childFlags {
+	var flags childFlags
+	set.Visit(func(f *pflag.Flag) {
+		if slices.Contains(skip, f.Name) {
+			return
+		}
+		if values, ok := f.Value.(pflag.SliceValue); ok {
+			flags.add(f.Name, values.GetSlice()...)
+			return
+		}
+		// maps print as '[k=v,...]', which they cannot be set from: they
+		// are passed on as one flag per key instead
+		if f.Value.Type() == "stringToInt" {
+			values, _ := set.GetStringToInt(f.Name)
+			var pairs []string
+			for _, k := range slices.Sorted(maps.Keys(values)) {
+				pairs = append(pairs, fmt.Sprintf("%s=%d", k, values[k]))
+			}
+			flags.add(f.Name, pairs...)
+			return
+		}
+		flags.add(f.Name, f.Value.String())
+	})
+	return flags
+}
+
+// newProcessWorkspaceSyncer syncs each workspace in a separate decK process.
+// The sync code path relies on package-level state (dump configuration,
+// JSON report, schema registry), which rules out syncing several workspaces
+// concurrently within one process.
+func newProcessWorkspaceSyncer(cmd *cobra.Command) (workspaceSyncer, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("locating decK executable: %w", err)
+	}
+	// strip the name of the root command
+	command := strings.Fields(cmd.CommandPath())[1:]
+	flags := workspaceSyncArgs(cmd)
+
+	return func(ctx context.Context, stateFile workspaceStateFile) workspaceSyncResult {
+		result := workspaceSyncResult{
+			Workspace: stateFile.Workspace,
+			Filename:  stateFile.Filename,
+			Warnings:  []string{},
+			Errors:    []string{},
+		}
+		args := append(append([]string{}, command...), flags.args...)
+		args = append(args, "--workspace", stateFile.Workspace, "--json-output", stateFile.Filename)
+
+		output, errs := runSyncProcess(ctx, executable, args, append(os.Environ(), flags.env...), nil)
+		result.Summary = output.Summary
+		result.Warnings = append(result.Warnings, output.Warnings...)
+		result.Errors = append(result.Errors, errs...)
+		return result
+	}, nil
+}
+
+// runSyncProcess runs a 'deck gateway sync --json-output' child process and
+// returns its report. The returned errors include the ones of the report and
+// the error the process exited with. env is the environment of the process.
+func runSyncProcess(ctx context.Context, executable string, args, env []string,
+	stdin io.Reader,
+) (diff.JSONOutputObject, []string) {
+	var stdout, stderr bytes.Buffer
+	child := exec.CommandContext(ctx, executable, args...)
+	child.Env = env
+	child.Stdin = stdin
+	child.Stdout = &stdout
+	child.Stderr = &stderr
+	runErr := child.Run()
+
+	var output diff.JSONOutputObject
+	var errs []string
+	if err := json.Unmarshal(bytes.TrimSpace(stdout.Bytes()), &output); err == nil {
+		errs = append(errs, output.Errors...)
+	} else if runErr == nil {
+		errs = append(errs, fmt.Sprintf("parsing sync output: %v", err))
+	}
+	if runErr != nil {
+		errs = append(errs, childProcessError(runErr, stderr.String()))
+	}
+	return output, errs
+}
+
+// childProcessError returns the error message of a failed decK child process.
+func childProcessError(runErr error, stderr string) string {
+	msg := strings.TrimPrefix(strings.TrimSpace(stderr), "Error: ")
+	if msg == "" {
+		msg = runErr.Error()
+	}
+	return msg
+}
+
+func syncAllWorkspacesMain(cmd *cobra.Command, dir string, parallelism int, enableJSONOutput bool) error {
+	if inKonnectMode(nil) {
+		return errors.New("--all-workspaces is not supported with Konnect")
+	}
+	stateFiles, err := workspaceStateFiles(dir)
+	if err != nil {
+		return err
+	}
+	syncer, err := newProcessWorkspaceSyncer(cmd)
+	if err != nil {
+		return err
+	}
+	report := syncWorkspaces(cmd.Context(), stateFiles, parallelism, syncer)
+
+	if enableJSONOutput {
+		b, err := json.MarshalIndent(report, "", "\t")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+	} else {
+		printWorkspaceSyncReport(report)
+	}
+	if len(report.Failed) > 0 {
+		return fmt.Errorf("syncing %d of %d workspaces failed: %s",
+			len(report.Failed), len(report.Workspaces), strings.Join(report.Failed, ", "))
+	}
+	return nil
+}
+
+func printWorkspaceSyncReport(report workspaceSyncReport) {
+	for _, result := range report.Workspaces {
+		if len(result.Errors) > 0 {
+			color.New(color.FgRed).Printf("workspace %s: failed\n", result.Workspace)
+			for _, e := range result.Errors {
+				fmt.Printf("  %s\n", e)
+			}
+			continue
+		}
+		fmt.Printf("workspace %s: created %d, updated %d, deleted %d\n", result.Workspace,
+			result.Summary.Creating, result.Summary.Updating, result.Summary.Deleting)
+		for _, w := range result.Warnings {
+			fmt.Printf("  warning: %s\n", w)
+		}
+	}
+	printFn := color.New(color.FgGreen, color.Bold).PrintfFunc()
+	printFn("Summary:\n")
+	printFn("  Workspaces: %d (%d failed)\n", len(report.Workspaces), len(report.Failed))
+	printFn("  Created: %d\n", report.Summary.Creating)
+	printFn("  Updated: %d\n", report.Summary.Updating)
+	printFn("  Deleted: %d\n", report.Summary.Deleting)
+}
@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kong/go-database-reconciler/pkg/diff"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorkspaceStateFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		// secrets are only resolved when the workspace is synced
+		"team-b.yaml": "_format_version: \"3.0\"\n_workspace: team-b\nconsumers:\n- username: a\n" +
+			"  keyauth_credentials:\n  - key: ${{ file \"/missing\" }}\n",
+		"default.json": `{"_format_version": "3.0"}`,
+		"renamed.yaml": "_format_version: \"3.0\"\n_workspace: team-a\n",
+		"notes.txt":    "not a state file",
+	}
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600))
+	}
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "nested.yaml"), 0o700))
+
+	stateFiles, err := workspaceStateFiles(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []workspaceStateFile{
+		{Workspace: "default", Filename: filepath.Join(dir, "default.json")},
+		{Workspace: "team-a", Filename: filepath.Join(dir, "renamed.yaml")},
+		{Workspace: "team-b", Filename: filepath.Join(dir, "team-b.yaml")},
+	}, stateFiles)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "team-a.yaml"), []byte("_format_version: \"3.0\"\n"), 0o600))
+	_, err = workspaceStateFiles(dir)
+	require.ErrorContains(t, err, "workspace 'team-a' is defined by both")
+
+	_, err = workspaceStateFiles(t.TempDir())
+	require.ErrorContains(t, err, "no state files found")
+}
+
+func TestSyncWorkspaces(t *testing.T) {
+	stateFiles := []workspaceStateFile{
+		{Workspace: "a"}, {Workspace: "b"}, {Workspace: "c"}, {Workspace: "d"},
+	}
+	var running, maxRunning atomic.Int32
+	syncer := func(_ context.Context, stateFile workspaceStateFile) workspaceSyncResult {
+		n := running.Add(1)
+		for {
+			m := maxRunning.Load()
+			if n <= m || maxRunning.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		running.Add(-1)
+
+		result := workspaceSyncResult{
+			Workspace: stateFile.Workspace,
+			Summary:   diff.Summary{Creating: 1, Deleting: 2, Total: 3},
+		}
+		if stateFile.Workspace == "c" {
+			result.Errors = []string{"boom"}
+		}
+		return result
+	}
+
+	report := syncWorkspaces(context.Background(), stateFiles, 2, syncer)
+	assert.LessOrEqual(t, maxRunning.Load(), int32(2))
+	require.Len(t, report.Workspaces, 4)
+	for i, result := range report.Workspaces {
+		assert.Equal(t, stateFiles[i].Workspace, result.Workspace, "results keep the order of the state files")
+	}
+	assert.Equal(t, diff.Summary{Creating: 4, Deleting: 8, Total: 12}, report.Summary)
+	assert.Equal(t, []string{"c"}, report.Failed)
+}
+
+func TestWorkspaceSyncArgs(t *testing.T) {
+	root := &cobra.Command{Use: "deck"}
+	root.PersistentFlags().String("kong-addr", "", "")
+	root.PersistentFlags().StringSlice("headers", nil, "")
+	root.PersistentFlags().String("kong-admin-token", "", "")
+	cmd := &cobra.Command{Use: "sync", Run: func(*cobra.Command, []string) {}}
+	cmd.Flags().Bool("all-workspaces", false, "")
+	cmd.Flags().Int("workspace-parallelism", 4, "")
+	cmd.Flags().StringSlice("select-tag", nil, "")
+	cmd.Flags().Int("parallelism", 10, "")
+	cmd.Flags().StringToInt("max-deletes-per-type", nil, "")
+	root.AddCommand(cmd)
+
+	root.SetArgs([]string{
+		"sync", "--kong-addr", "http://kong:8001", "--headers", "a:1", "--headers", "Authorization:Bearer x",
+		"--kong-admin-token", "secret", "--all-workspaces", "--workspace-parallelism", "2", "--select-tag", "x,y",
+		"--max-deletes-per-type", "route=10,service=2", "--max-deletes-per-type", "plugin=0", "dir",
+	})
+	require.NoError(t, root.Execute())
+
+	flags := workspaceSyncArgs(cmd)
+	assert.ElementsMatch(t, []string{
+		"--kong-addr=http://kong:8001",
+		"--select-tag=x",
+		"--select-tag=y",
+		"--max-deletes-per-type=plugin=0",
+		"--max-deletes-per-type=route=10",
+		"--max-deletes-per-type=service=2",
+	}, flags.args)
+	// secrets are not on the command line of the child
+	assert.ElementsMatch(t, []string{
+		"DECK_HEADERS=a:1\nAuthorization:Bearer x",
+		"DECK_KONG_ADMIN_TOKEN=secret",
+	}, flags.env)
+
+	// the child parses the flags back to the same values
+	child := pflag.NewFlagSet("child", pflag.ContinueOnError)
+	limits := child.StringToInt("max-deletes-per-type", nil, "")
+	child.String("kong-addr", "", "")
+	child.StringSlice("select-tag", nil, "")
+	require.NoError(t, child.Parse(flags.args))
+	assert.Equal(t, map[string]int{"plugin": 0, "route": 10, "service": 2}, *limits)
+
+	// and reads the secrets from its environment
+	defer viper.Reset()
+	viper.SetEnvPrefix("deck")
+	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+	viper.AutomaticEnv()
+	for _, env := range flags.env {
+		name, value, _ := strings.Cut(env, "=")
+		t.Setenv(name, value)
+	}
+	assert.Equal(t, []string{"a:1", "Authorization:Bearer x"}, headersSetting())
+	assert.Equal(t, "secret", viper.GetString("kong-admin-token"))
+}
+
+func TestSyncCmd_AllWorkspacesFlags(t *testing.T) {
+	cmd := newSyncCmd(false)
+	syncCmdParallelism = 10
+	syncAllWorkspaces = true
+	syncCmdWorkspaceParallelism = 4
+	defer func() {
+		syncAllWorkspaces = false
+		syncWorkspace = ""
+	}()
+
+	require.ErrorContains(t, cmd.PreRunE(cmd, []string{}), "--all-workspaces requires exactly one directory")
+
+	syncWorkspace = "team-a"
+	require.EqualError(t, cmd.PreRunE(cmd, []string{"dir"}),
+		"--all-workspaces cannot be used with --workspace or --plan")
+	syncWorkspace = ""
+
+	syncCmdWorkspaceParallelism = 0
+	require.EqualError(t, cmd.PreRunE(cmd, []string{"dir"}),
+		"--workspace-parallelism cannot be less than 1, got 0")
+
+	syncCmdWorkspaceParallelism = 4
+	require.NoError(t, cmd.PreRunE(cmd, []string{"dir"}))
+}
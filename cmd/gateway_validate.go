@@ -36,7 +36,8 @@ func executeValidate(cmd *cobra.Command, _ []string) error {
 	_ = sendAnalytics("validate", "", mode)
 	// read target file
 	// this does json schema validation as well
-	targetContent, err := file.GetContentFromFiles(validateCmdKongStateFile, false)
+	// ignore rules only apply to diffs
+	targetContent, _, err := readStateFiles(validateCmdKongStateFile)
 	if err != nil {
 		return err
 	}
@@ -172,7 +173,16 @@ func executeValidate(cmd *cobra.Command, _ []string) error {
 	}
 
 	if validateOnline {
-		if errs := validateWithKong(ctx, kongClient, ks, targetContent.FormatVersion); len(errs) != 0 {
+		errs := validateWithKong(ctx, kongClient, ks, targetContent.FormatVersion)
+		if mode != modeKonnect {
+			storeSchemas(ctx, kongClient, ks)
+		}
+		if len(errs) != 0 {
+			return validate.ErrorsWrapper{Errors: errs}
+		}
+	}
+	if validateKongVersion != "" {
+		if errs := validateWithSchemas(ks); len(errs) != 0 {
 			return validate.ErrorsWrapper{Errors: errs}
 		}
 	}
@@ -232,7 +242,9 @@ this command unless --online flag is used.
 		}
 	} else {
 		preRun = func(_ *cobra.Command, args []string) error {
-			validateOnline = online
+			// with --kong-version, the stored schemas of that version are
+			// used instead of Kong
+			validateOnline = online && validateKongVersion == ""
 			validateCmdKongStateFile = args
 			if len(validateCmdKongStateFile) == 0 {
 				validateCmdKongStateFile = []string{"-"}
@@ -270,7 +282,9 @@ this command unless --online flag is used.
 			short = short + " (online)"
 			long = long + "Validates against the Kong API, via communication with Kong. This increases the\n" +
 				"time for validation but catches significant errors. No resource is created in Kong.\n" +
-				"For offline validation see 'deck file validate'.\n"
+				"For offline validation see 'deck file validate'.\n\n" +
+				"The schemas fetched from Kong are cached, so that later validations can use them\n" +
+				"without Kong with --kong-version.\n"
 		} else {
 			short = short + " (locally)"
 			long = long + "No communication takes places between decK and Kong during the execution of\n" +
@@ -310,6 +324,11 @@ this command unless --online flag is used.
 		10, "Maximum number of concurrent requests to Kong.")
 	validateCmd.Flags().BoolVar(&validateKonnectCompatibility, "konnect-compatibility",
 		false, "validate that the state file(s) are ready to be deployed to Konnect")
+	if !deprecated {
+		validateCmd.Flags().StringVar(&validateKongVersion, "kong-version", "",
+			"validate offline against the schemas of a Kong version, such as 3.10 or 3.10-enterprise.\n"+
+				"The schemas are cached by 'deck cache schemas pull' and online validations against Kong.")
+	}
 	addDiagnosticSeverityFlags(validateCmd.Flags())
 
 	validateCmd.MarkFlagsMutuallyExclusive("konnect-compatibility", "workspace")
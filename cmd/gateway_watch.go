@@ -0,0 +1,355 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kong/go-database-reconciler/pkg/cprint"
+	"github.com/kong/go-database-reconciler/pkg/diff"
+	"github.com/spf13/cobra"
+)
+
+var (
+	watchCmdKongStateFile []string
+	watchCmdParallelism   int
+	watchCmdInterval      time.Duration
+	watchCmdMetricsAddr   string
+	watchCmdWebhookURL    string
+	watchWorkspace        string
+)
+
+// driftChange identifies a single entity that differs from the declared state.
+type driftChange struct {
+	Action string `json:"action"`
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+}
+
+// driftReport is the outcome of a single drift check.
+type driftReport struct {
+	Workspace string
+	CheckedAt time.Time
+	Summary   diff.Summary
+	Changes   []driftChange
+	Err       error
+}
+
+// key identifies the set of drifted entities, so that repeated checks finding
+// the same drift do not trigger repeated webhook calls.
+func (r driftReport) key() string {
+	parts := make([]string, 0, len(r.Changes))
+	for _, c := range r.Changes {
+		parts = append(parts, c.Action+"/"+c.Kind+"/"+c.Name)
+	}
+	sort.Strings(parts)
+	return r.Workspace + "\n" + strings.Join(parts, "\n")
+}
+
+// driftEvent is the payload posted to the webhook when drift is detected.
+type driftEvent struct {
+	Workspace  string        `json:"workspace"`
+	DetectedAt time.Time     `json:"detected_at"`
+	Summary    diff.Summary  `json:"summary"`
+	Changes    []driftChange `json:"changes"`
+}
+
+func driftChanges(changes diff.EntityChanges) []driftChange {
+	res := []driftChange{}
+	for _, group := range []struct {
+		action  string
+		changes []diff.EntityState
+	}{
+		{"create", changes.Creating},
+		{"update", changes.Updating},
+		{"delete", changes.Deleting},
+	} {
+		for _, c := range group.changes {
+			res = append(res, driftChange{Action: group.action, Kind: c.Kind, Name: c.Name})
+		}
+	}
+	return res
+}
+
+// driftMetrics holds the outcome of the drift checks and serves it in the
+// Prometheus text exposition format.
+type driftMetrics struct {
+	mu          sync.Mutex
+	workspace   string
+	checks      int
+	checkErrors int
+	lastCheck   time.Time
+	entities    map[driftChange]int
+}
+
+func newDriftMetrics() *driftMetrics {
+	return &driftMetrics{entities: map[driftChange]int{}}
+}
+
+func (m *driftMetrics) record(report driftReport) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.checks++
+	m.lastCheck = report.CheckedAt
+	if report.Err != nil {
+		// keep reporting the last known drift
+		m.checkErrors++
+		return
+	}
+	m.workspace = report.Workspace
+	m.entities = map[driftChange]int{}
+	for _, c := range report.Changes {
+		m.entities[driftChange{Action: c.Action, Kind: c.Kind}]++
+	}
+}
+
+func (m *driftMetrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ws := `workspace="` + escapeLabelValue(m.workspace) + `"`
+	var b strings.Builder
+
+	b.WriteString("# HELP deck_drift_entities Number of entities that differ from the declared state.\n")
+	b.WriteString("# TYPE deck_drift_entities gauge\n")
+	keys := make([]driftChange, 0, len(m.entities))
+	for k := range m.entities {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Kind != keys[j].Kind {
+			return keys[i].Kind < keys[j].Kind
+		}
+		return keys[i].Action < keys[j].Action
+	})
+	total := 0
+	for _, k := range keys {
+		fmt.Fprintf(&b, "deck_drift_entities{%s,kind=\"%s\",action=\"%s\"} %d\n",
+			ws, escapeLabelValue(k.Kind), escapeLabelValue(k.Action), m.entities[k])
+		total += m.entities[k]
+	}
+
+	b.WriteString("# HELP deck_drift_entities_total Total number of entities that differ from the declared state.\n")
+	b.WriteString("# TYPE deck_drift_entities_total gauge\n")
+	fmt.Fprintf(&b, "deck_drift_entities_total{%s} %d\n", ws, total)
+	b.WriteString("# HELP deck_drift_checks_total Number of drift checks performed.\n")
+	b.WriteString("# TYPE deck_drift_checks_total counter\n")
+	fmt.Fprintf(&b, "deck_drift_checks_total{%s} %d\n", ws, m.checks)
+	b.WriteString("# HELP deck_drift_check_errors_total Number of drift checks that failed.\n")
+	b.WriteString("# TYPE deck_drift_check_errors_total counter\n")
+	fmt.Fprintf(&b, "deck_drift_check_errors_total{%s} %d\n", ws, m.checkErrors)
+	if !m.lastCheck.IsZero() {
+		b.WriteString("# HELP deck_drift_last_check_timestamp_seconds Time of the last drift check.\n")
+		b.WriteString("# TYPE deck_drift_last_check_timestamp_seconds gauge\n")
+		fmt.Fprintf(&b, "deck_drift_last_check_timestamp_seconds{%s} %d\n", ws, m.lastCheck.Unix())
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = io.WriteString(w, b.String())
+}
+
+func escapeLabelValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func postDriftEvent(ctx context.Context, client *http.Client, url string, event driftEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("marshaling drift event: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("creating webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("posting drift event: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("posting drift event: webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// runWatch runs check every interval until ctx is cancelled, recording each
+// outcome in metrics and posting newly detected drift to webhookURL.
+func runWatch(ctx context.Context, check func(context.Context) driftReport, interval time.Duration,
+	metrics *driftMetrics, webhookURL string, log io.Writer,
+) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	lastKey := ""
+	for {
+		report := check(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		metrics.record(report)
+
+		timestamp := report.CheckedAt.UTC().Format(time.RFC3339)
+		switch {
+		case report.Err != nil:
+			fmt.Fprintf(log, "%s drift check failed: %v\n", timestamp, report.Err)
+		case report.Summary.Total == 0 && len(report.Changes) == 0:
+			fmt.Fprintf(log, "%s no drift detected\n", timestamp)
+			lastKey = ""
+		default:
+			fmt.Fprintf(log, "%s drift detected: creating %d, updating %d, deleting %d\n", timestamp,
+				report.Summary.Creating, report.Summary.Updating, report.Summary.Deleting)
+			key := report.key()
+			if webhookURL != "" && key != lastKey {
+				err := postDriftEvent(ctx, client, webhookURL, driftEvent{
+					Workspace:  report.Workspace,
+					DetectedAt: report.CheckedAt,
+					Summary:    report.Summary,
+					Changes:    report.Changes,
+				})
+				if err != nil {
+					fmt.Fprintf(log, "%s %v\n", timestamp, err)
+					// retry on the next check
+					key = ""
+				}
+			}
+			lastKey = key
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkDrift diffs the state files against Kong, without printing the diff.
+func checkDrift(ctx context.Context) driftReport {
+	report := driftReport{CheckedAt: time.Now()}
+
+	targetContent, settings, err := readStateFiles(watchCmdKongStateFile)
+	if err != nil {
+		report.Err = err
+		return report
+	}
+	setStateFileSettings(settings)
+	report.Workspace = watchWorkspace
+	if report.Workspace == "" {
+		report.Workspace = targetContent.Workspace
+	}
+	if report.Workspace == "" {
+		report.Workspace = "default"
+	}
+
+	disableOutput := cprint.DisableOutput
+	cprint.DisableOutput = true
+	defer func() { cprint.DisableOutput = disableOutput }()
+
+	initJSONOutput()
+	err = syncContent(ctx, targetContent, true, watchCmdParallelism, 0, watchWorkspace, true, ApplyTypeFull)
+	if err == nil && len(jsonOutput.Errors) > 0 {
+		err = errors.New(strings.Join(jsonOutput.Errors, "; "))
+	}
+	report.Err = err
+	report.Summary = jsonOutput.Summary
+	report.Changes = driftChanges(jsonOutput.Changes)
+	return report
+}
+
+func executeWatch(cmd *cobra.Command, _ []string) error {
+	ctx := cmd.Context()
+	metrics := newDriftMetrics()
+
+	// analytics are sent once for the command, not for each check
+	_ = sendAnalytics("watch", "", getMode(nil))
+	defer func(analytics bool) { disableAnalytics = analytics }(disableAnalytics)
+	disableAnalytics = true
+
+	if watchCmdMetricsAddr != "" {
+		listener, err := net.Listen("tcp", watchCmdMetricsAddr)
+		if err != nil {
+			return fmt.Errorf("starting metrics server: %w", err)
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics)
+		server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				fmt.Fprintf(os.Stderr, "metrics server: %v\n", err)
+			}
+		}()
+		defer server.Close()
+		fmt.Fprintf(os.Stderr, "Serving metrics on http://%s/metrics\n", listener.Addr())
+	}
+
+	runWatch(ctx, checkDrift, watchCmdInterval, metrics, watchCmdWebhookURL, os.Stderr)
+	return nil
+}
+
+func newWatchCmd() *cobra.Command {
+	watchCmd := &cobra.Command{
+		Use:   "watch [flags] kong-state-files...",
+		Short: "Periodically diff Kong against the state files to detect drift",
+		Long: `The watch command runs until interrupted and periodically diffs
+the entities in Kong with the ones in the state files, to detect changes
+made outside of decK.
+
+The outcome of each check is exposed as Prometheus metrics and, when a
+webhook URL is given, newly detected drift is posted to it as JSON.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: executeWatch,
+		PreRunE: func(_ *cobra.Command, args []string) error {
+			watchCmdKongStateFile = args
+			if watchCmdInterval <= 0 {
+				return fmt.Errorf("--interval must be greater than 0, got %s", watchCmdInterval)
+			}
+			if err := checkParallelism(watchCmdParallelism); err != nil {
+				return err
+			}
+			if err := preRunEntityFilterFlags(); err != nil {
+				return err
+			}
+			return preRunIgnoreFieldFlags()
+		},
+	}
+
+	watchCmd.Flags().DurationVar(&watchCmdInterval, "interval",
+		5*time.Minute, "time between two drift checks.")
+	watchCmd.Flags().StringVar(&watchCmdMetricsAddr, "metrics-address",
+		"127.0.0.1:9102", "address to serve Prometheus metrics on at /metrics.\n"+
+			"Use ':9102' to serve them on all interfaces, or an empty string to\n"+
+			"disable the metrics endpoint.")
+	watchCmd.Flags().StringVar(&watchCmdWebhookURL, "webhook-url",
+		"", "URL to POST a JSON event to when new drift is detected.")
+	watchCmd.Flags().StringVarP(&watchWorkspace, "workspace", "w",
+		"", "Watch a specific workspace (Kong Enterprise only).\n"+
+			"This takes precedence over _workspace fields in state files.")
+	watchCmd.Flags().IntVar(&watchCmdParallelism, "parallelism",
+		10, "Maximum number of concurrent operations.")
+	watchCmd.Flags().BoolVar(&dumpConfig.SkipConsumers, "skip-consumers",
+		false, "do not diff consumers or "+
+			"any plugins associated with consumers")
+	watchCmd.Flags().StringSliceVar(&dumpConfig.SelectorTags,
+		"select-tag", []string{},
+		"only entities matching tags specified via this flag are diffed.\n"+
+			"When this setting has multiple tag values, entities must match each of them.")
+	watchCmd.Flags().BoolVar(&dumpConfig.SkipCACerts, "skip-ca-certificates",
+		false, "do not diff CA certificates.")
+	addEntityFilterFlags(watchCmd.Flags())
+	addIgnoreFieldFlags(watchCmd.Flags())
+
+	return watchCmd
+}
@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kong/go-database-reconciler/pkg/diff"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDriftMetrics(t *testing.T) {
+	metrics := newDriftMetrics()
+	metrics.record(driftReport{
+		Workspace: "team-a",
+		CheckedAt: time.Unix(1700000000, 0),
+		Summary:   diff.Summary{Creating: 1, Updating: 2, Total: 3},
+		Changes: []driftChange{
+			{Action: "update", Kind: "service", Name: "a"},
+			{Action: "update", Kind: "service", Name: "b"},
+			{Action: "create", Kind: "route", Name: "c"},
+		},
+	})
+	metrics.record(driftReport{CheckedAt: time.Unix(1700000060, 0), Err: errors.New("boom")})
+
+	rec := httptest.NewRecorder()
+	metrics.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+
+	assert.Contains(t, body, `deck_drift_entities{workspace="team-a",kind="route",action="create"} 1`)
+	assert.Contains(t, body, `deck_drift_entities{workspace="team-a",kind="service",action="update"} 2`)
+	assert.Contains(t, body, `deck_drift_entities_total{workspace="team-a"} 3`)
+	assert.Contains(t, body, `deck_drift_checks_total{workspace="team-a"} 2`)
+	assert.Contains(t, body, `deck_drift_check_errors_total{workspace="team-a"} 1`)
+	assert.Contains(t, body, `deck_drift_last_check_timestamp_seconds{workspace="team-a"} 1700000060`)
+}
+
+func TestEscapeLabelValue(t *testing.T) {
+	assert.Equal(t, `a\"b\\c\nd`, escapeLabelValue("a\"b\\c\nd"))
+}
+
+func TestRunWatch_Webhook(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		events []driftEvent
+	)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		var event driftEvent
+		require.NoError(t, json.Unmarshal(body, &event))
+		mu.Lock()
+		events = append(events, event)
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer webhook.Close()
+
+	drift := driftReport{
+		Workspace: "default",
+		Summary:   diff.Summary{Updating: 1, Total: 1},
+		Changes:   []driftChange{{Action: "update", Kind: "service", Name: "svc"}},
+	}
+	otherDrift := drift
+	otherDrift.Changes = []driftChange{{Action: "update", Kind: "service", Name: "other"}}
+	reports := []driftReport{drift, drift, {Workspace: "default"}, drift, otherDrift}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	checks := 0
+	check := func(context.Context) driftReport {
+		if checks == len(reports) {
+			cancel()
+			return driftReport{}
+		}
+		report := reports[checks]
+		report.CheckedAt = time.Now()
+		checks++
+		return report
+	}
+
+	var log bytes.Buffer
+	metrics := newDriftMetrics()
+	runWatch(ctx, check, time.Millisecond, metrics, webhook.URL, &log)
+
+	// the same drift is only reported again once it was resolved in between
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, events, 3)
+	assert.Equal(t, "svc", events[0].Changes[0].Name)
+	assert.Equal(t, "svc", events[1].Changes[0].Name)
+	assert.Equal(t, "other", events[2].Changes[0].Name)
+	assert.Contains(t, log.String(), "no drift detected")
+	assert.Contains(t, log.String(), "drift detected: creating 0, updating 1, deleting 0")
+}
+
+func TestPostDriftEvent_ErrorStatus(t *testing.T) {
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer webhook.Close()
+
+	err := postDriftEvent(context.Background(), webhook.Client(), webhook.URL, driftEvent{})
+	require.EqualError(t, err, "posting drift event: webhook returned status 500")
+}
+
+func TestWatchCmd_InvalidInterval(t *testing.T) {
+	cmd := newWatchCmd()
+	watchCmdInterval = 0
+	err := cmd.PreRunE(cmd, []string{"kong.yaml"})
+	require.EqualError(t, err, "--interval must be greater than 0, got 0s")
+}
+
+func TestWatchCmd_MetricsServedOnLoopbackByDefault(t *testing.T) {
+	cmd := newWatchCmd()
+	assert.Equal(t, "127.0.0.1:9102", cmd.Flags().Lookup("metrics-address").DefValue)
+}
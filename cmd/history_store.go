@@ -0,0 +1,398 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	reconcilerUtils "github.com/kong/go-database-reconciler/pkg/utils"
+)
+
+const (
+	defaultHistoryDir = ".deck/history"
+
+	historyCommandSync = "sync"
+	historyCommandDump = "dump"
+
+	historyRevisionsFile = "revisions.ndjson"
+	historyObjectsDir    = "objects"
+	historySaltFile      = "salt"
+
+	historyRedactedPrefix = "redacted:"
+)
+
+var (
+	// recordHistory and historyDir are set from the root flags, or the
+	// config file and environment, by initConfig.
+	recordHistory bool
+	historyDir    string
+)
+
+var historyKeyInvalidChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// historySecretFields are the fields of credentials and keys that are never
+// stored in the history, by entity list of the raw state. Nested fields are
+// dotted.
+var historySecretFields = map[string][]string{
+	"KeyAuths":     {"key"},
+	"HMACAuths":    {"secret"},
+	"JWTAuths":     {"secret"},
+	"BasicAuths":   {"password"},
+	"Oauth2Creds":  {"client_secret"},
+	"Certificates": {"key", "key_alt"},
+	"Keys":         {"jwk", "pem.private_key"},
+}
+
+// historySecretConfigKey matches the keys of the configuration of plugins,
+// partials and vaults that hold secrets, such as client_secret or
+// api_key.
+var historySecretConfigKey = regexp.MustCompile(`(?i)(password|passwd|secret|token|private_key|api_?key)`)
+
+// historyConfigEntities are the entity lists of the raw state whose config
+// is searched for secrets.
+var historyConfigEntities = []string{"Plugins", "Partials", "Vaults"}
+
+// historyRevision is an entry of the revision log of a history store. The
+// state itself is stored as an object, addressed by the SHA-256 of its
+// content, so that unchanged states are stored once.
+type historyRevision struct {
+	// Rev is the position of the revision in the log, starting at 1. It is
+	// not stored, as concurrent processes may append to the same log.
+	Rev         int       `json:"-"`
+	Time        time.Time `json:"time"`
+	Command     string    `json:"command"`
+	Object      string    `json:"object"`
+	KongAddr    string    `json:"kong_addr"`
+	Workspace   string    `json:"workspace"`
+	KongVersion string    `json:"kong_version,omitempty"`
+	Entities    int       `json:"entities"`
+}
+
+// historyStore is the history of the states of a single workspace of a
+// single Kong, stored in a directory of the history directory.
+type historyStore struct {
+	dir       string
+	kongAddr  string
+	workspace string
+}
+
+// kongAddrKey returns the directory name of the stores of the Kong at
+// kongAddr: its address without scheme, e.g. localhost_8001.
+func kongAddrKey(kongAddr string) string {
+	addr := kongAddr
+	if _, rest, ok := strings.Cut(addr, "://"); ok {
+		addr = rest
+	}
+	return strings.Trim(historyKeyInvalidChars.ReplaceAllString(addr, "_"), "_")
+}
+
+// openHistoryStore returns the store of a workspace of the Kong at
+// kongAddr. The store is created when the first revision is recorded.
+func openHistoryStore(root, kongAddr, workspace string) *historyStore {
+	if workspace == "" {
+		workspace = "default"
+	}
+	return &historyStore{
+		dir:       filepath.Join(root, kongAddrKey(kongAddr), historyKeyInvalidChars.ReplaceAllString(workspace, "_")),
+		kongAddr:  kongAddr,
+		workspace: workspace,
+	}
+}
+
+// record stores a state and appends a revision for it to the log. Secrets
+// of the state are redacted, see redactHistoryState.
+func (s *historyStore) record(command string, raw *reconcilerUtils.KongRawState,
+	kongVersion string, now time.Time,
+) (historyRevision, error) {
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return historyRevision{}, fmt.Errorf("encoding state: %w", err)
+	}
+	salt, err := s.salt()
+	if err != nil {
+		return historyRevision{}, err
+	}
+	if b, err = redactHistoryState(b, salt); err != nil {
+		return historyRevision{}, fmt.Errorf("encoding state: %w", err)
+	}
+	sum := sha256.Sum256(b)
+	object := hex.EncodeToString(sum[:])
+	if err := s.writeObject(object, b); err != nil {
+		return historyRevision{}, err
+	}
+
+	rev := historyRevision{
+		Time:        now.UTC(),
+		Command:     command,
+		Object:      object,
+		KongAddr:    s.kongAddr,
+		Workspace:   s.workspace,
+		KongVersion: kongVersion,
+		Entities:    rawEntityCount(raw),
+	}
+	line, err := json.Marshal(rev)
+	if err != nil {
+		return historyRevision{}, fmt.Errorf("encoding revision: %w", err)
+	}
+	f, err := os.OpenFile(filepath.Join(s.dir, historyRevisionsFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return historyRevision{}, fmt.Errorf("opening revision log: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return historyRevision{}, fmt.Errorf("writing revision log: %w", err)
+	}
+	return rev, nil
+}
+
+// salt returns the random salt secrets are hashed with, creating it with the
+// store.
+func (s *historyStore) salt() ([]byte, error) {
+	filename := filepath.Join(s.dir, historySaltFile)
+	salt, err := os.ReadFile(filename)
+	if err == nil {
+		return salt, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("reading history salt: %w", err)
+	}
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return nil, fmt.Errorf("creating history directory: %w", err)
+	}
+	salt = make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("creating history salt: %w", err)
+	}
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if errors.Is(err, fs.ErrExist) {
+		// created by a concurrent process
+		return s.salt()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("creating history salt: %w", err)
+	}
+	_, err = f.Write(salt)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("creating history salt: %w", err)
+	}
+	return salt, nil
+}
+
+// redactHistoryState replaces the secrets of an encoded raw state with
+// salted hashes of them, so that the history never holds credentials in
+// clear while diffs of revisions still show which of them changed.
+func redactHistoryState(b []byte, salt []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	var raw map[string]interface{}
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, err
+	}
+	redact := func(value interface{}) interface{} {
+		mac := hmac.New(sha256.New, salt)
+		fmt.Fprint(mac, value)
+		return historyRedactedPrefix + hex.EncodeToString(mac.Sum(nil))[:32]
+	}
+
+	for list, fields := range historySecretFields {
+		entities, _ := raw[list].([]interface{})
+		for _, entity := range entities {
+			for _, field := range fields {
+				redactHistoryField(entity, strings.Split(field, "."), redact)
+			}
+		}
+	}
+	for _, list := range historyConfigEntities {
+		entities, _ := raw[list].([]interface{})
+		for _, entity := range entities {
+			if e, ok := entity.(map[string]interface{}); ok {
+				redactHistoryConfig(e["config"], false, redact)
+			}
+		}
+	}
+	return json.Marshal(raw)
+}
+
+func redactHistoryField(v interface{}, path []string, redact func(interface{}) interface{}) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return
+	}
+	value, ok := m[path[0]]
+	if !ok || value == nil {
+		return
+	}
+	if len(path) > 1 {
+		redactHistoryField(value, path[1:], redact)
+		return
+	}
+	m[path[0]] = redact(value)
+}
+
+// redactHistoryConfig redacts the strings of the secret keys of a config, at
+// any depth. secret is set for the values of a secret key.
+func redactHistoryConfig(v interface{}, secret bool, redact func(interface{}) interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = redactHistoryConfig(value, secret || historySecretConfigKey.MatchString(key), redact)
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = redactHistoryConfig(value, secret, redact)
+		}
+	case string:
+		if secret {
+			return redact(v)
+		}
+	}
+	return v
+}
+
+func (s *historyStore) objectPath(object string) string {
+	return filepath.Join(s.dir, historyObjectsDir, object[:2], object+".json.gz")
+}
+
+func (s *historyStore) writeObject(object string, b []byte) error {
+	filename := s.objectPath(object)
+	if _, err := os.Stat(filename); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0o700); err != nil {
+		return fmt.Errorf("creating history directory: %w", err)
+	}
+	// write to a temporary file first, so that a partially written object is
+	// never mistaken for a stored one
+	tmp, err := os.CreateTemp(filepath.Dir(filename), object+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("writing history object: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	zw := gzip.NewWriter(tmp)
+	if _, err := zw.Write(b); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("writing history object: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("writing history object: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing history object: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		return fmt.Errorf("writing history object: %w", err)
+	}
+	return nil
+}
+
+// list returns the revisions of the store, oldest first.
+func (s *historyStore) list() ([]historyRevision, error) {
+	f, err := os.Open(filepath.Join(s.dir, historyRevisionsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening revision log: %w", err)
+	}
+	defer f.Close()
+
+	var revs []historyRevision
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var rev historyRevision
+		if err := json.Unmarshal(scanner.Bytes(), &rev); err != nil {
+			return nil, fmt.Errorf("reading revision log: line %d: %w", len(revs)+1, err)
+		}
+		rev.Rev = len(revs) + 1
+		revs = append(revs, rev)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading revision log: %w", err)
+	}
+	return revs, nil
+}
+
+// resolve returns the revision ref refers to: a revision number, 'latest',
+// or a prefix of the object of a revision. The latest revision matching the
+// prefix is returned, as all of them have the same state.
+func (s *historyStore) resolve(ref string) (historyRevision, error) {
+	revs, err := s.list()
+	if err != nil {
+		return historyRevision{}, err
+	}
+	if len(revs) == 0 {
+		return historyRevision{}, fmt.Errorf("no revisions recorded for workspace '%s' of %s in %s",
+			s.workspace, s.kongAddr, s.dir)
+	}
+	if ref == "latest" {
+		return revs[len(revs)-1], nil
+	}
+	if n, err := strconv.Atoi(ref); err == nil {
+		if n < 1 || n > len(revs) {
+			return historyRevision{}, fmt.Errorf("revision %d does not exist, revisions are 1 to %d", n, len(revs))
+		}
+		return revs[n-1], nil
+	}
+	if len(ref) >= 4 {
+		for i := len(revs) - 1; i >= 0; i-- {
+			if strings.HasPrefix(revs[i].Object, ref) {
+				return revs[i], nil
+			}
+		}
+	}
+	return historyRevision{}, fmt.Errorf("unknown revision '%s'", ref)
+}
+
+// load returns the state of a revision.
+func (s *historyStore) load(rev historyRevision) (*reconcilerUtils.KongRawState, error) {
+	f, err := os.Open(s.objectPath(rev.Object))
+	if err != nil {
+		return nil, fmt.Errorf("reading state of revision %d: %w", rev.Rev, err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("reading state of revision %d: %w", rev.Rev, err)
+	}
+	var raw reconcilerUtils.KongRawState
+	if err := json.NewDecoder(zr).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("reading state of revision %d: %w", rev.Rev, err)
+	}
+	return &raw, nil
+}
+
+// recordHistoryRevision records a synced or dumped state when --history is
+// set. Failing to record does not fail the command, as Kong has already
+// been changed or read by then.
+func recordHistoryRevision(command string, raw *reconcilerUtils.KongRawState,
+	kongAddr, workspace, kongVersion string,
+) {
+	if !recordHistory {
+		return
+	}
+	store := openHistoryStore(historyDir, kongAddr, workspace)
+	if _, err := store.record(command, raw, kongVersion, time.Now()); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: recording history: %v\n", err)
+	}
+}
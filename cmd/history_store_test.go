@@ -0,0 +1,194 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	reconcilerUtils "github.com/kong/go-database-reconciler/pkg/utils"
+	"github.com/kong/go-kong/kong"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func historyTestState(services ...string) *reconcilerUtils.KongRawState {
+	raw := &reconcilerUtils.KongRawState{}
+	for _, name := range services {
+		raw.Services = append(raw.Services, &kong.Service{
+			ID:   kong.String("id-" + name),
+			Name: kong.String(name),
+			Host: kong.String(name + ".internal"),
+		})
+	}
+	return raw
+}
+
+func TestHistoryStore(t *testing.T) {
+	root := t.TempDir()
+	store := openHistoryStore(root, "https://kong.example.com:8001", "")
+	assert.Equal(t, filepath.Join(root, "kong.example.com_8001", "default"), store.dir)
+
+	_, err := store.resolve("latest")
+	require.ErrorContains(t, err, "no revisions recorded for workspace 'default'")
+
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	first, err := store.record(historyCommandSync, historyTestState("orders"), "3.9.0", now)
+	require.NoError(t, err)
+	_, err = store.record(historyCommandDump, historyTestState("orders", "users"), "3.9.0", now.Add(time.Hour))
+	require.NoError(t, err)
+	// an unchanged state is stored once
+	_, err = store.record(historyCommandSync, historyTestState("orders"), "3.9.0", now.Add(2*time.Hour))
+	require.NoError(t, err)
+	objects, err := filepath.Glob(filepath.Join(store.dir, historyObjectsDir, "*", "*.json.gz"))
+	require.NoError(t, err)
+	assert.Len(t, objects, 2)
+	info, err := os.Stat(filepath.Join(store.dir, historyRevisionsFile))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	revs, err := store.list()
+	require.NoError(t, err)
+	require.Len(t, revs, 3)
+	assert.Equal(t, []int{1, 2, 3}, []int{revs[0].Rev, revs[1].Rev, revs[2].Rev})
+	assert.Equal(t, historyCommandDump, revs[1].Command)
+	assert.Equal(t, 2, revs[1].Entities)
+
+	rev, err := store.resolve("2")
+	require.NoError(t, err)
+	assert.Equal(t, revs[1], rev)
+	rev, err = store.resolve("latest")
+	require.NoError(t, err)
+	assert.Equal(t, 3, rev.Rev)
+	rev, err = store.resolve(first.Object[:8])
+	require.NoError(t, err)
+	assert.Equal(t, 3, rev.Rev, "the latest revision with the state is returned")
+	_, err = store.resolve("4")
+	require.EqualError(t, err, "revision 4 does not exist, revisions are 1 to 3")
+	_, err = store.resolve("abc")
+	require.EqualError(t, err, "unknown revision 'abc'")
+
+	raw, err := store.load(revs[1])
+	require.NoError(t, err)
+	assert.Equal(t, historyTestState("orders", "users"), raw)
+}
+
+// restoreSchemaRegistry restores the schema registry history diffs replace.
+func restoreSchemaRegistry(t *testing.T) {
+	t.Helper()
+	previous := schemaRegistry
+	t.Cleanup(func() { schemaRegistry = previous })
+}
+
+func TestExecuteHistoryDiff(t *testing.T) {
+	restoreSchemaRegistry(t)
+	defer func(dir, workspace string) { historyDir, historyWorkspace = dir, workspace }(historyDir, historyWorkspace)
+	historyDir, historyWorkspace = t.TempDir(), ""
+
+	store := currentHistoryStore()
+	now := time.Now()
+	_, err := store.record(historyCommandSync, historyTestState("orders"), "", now)
+	require.NoError(t, err)
+	_, err = store.record(historyCommandSync, historyTestState("orders", "users"), "", now)
+	require.NoError(t, err)
+
+	cmd := &cobra.Command{}
+	cmd.SetContext(context.Background())
+	require.NoError(t, executeHistoryDiff(cmd, []string{"1", "2"}))
+	require.ErrorContains(t, executeHistoryDiff(cmd, []string{"1", "5"}), "revision 5 does not exist")
+}
+
+func TestExecuteHistoryDiffPluginConfig(t *testing.T) {
+	restoreSchemaRegistry(t)
+	defer func(dir, workspace string, jsonOutput bool) {
+		historyDir, historyWorkspace, historyJSONOutput = dir, workspace, jsonOutput
+	}(historyDir, historyWorkspace, historyJSONOutput)
+	historyDir, historyWorkspace, historyJSONOutput = t.TempDir(), "", true
+
+	withPlugin := func(minute int) *reconcilerUtils.KongRawState {
+		raw := historyTestState("orders")
+		raw.Plugins = []*kong.Plugin{{
+			ID:      kong.String("id-rate-limiting"),
+			Name:    kong.String("rate-limiting"),
+			Service: &kong.Service{ID: kong.String("id-orders")},
+			Config:  kong.Configuration{"minute": minute, "policy": "local"},
+			Enabled: kong.Bool(true),
+		}}
+		return raw
+	}
+	store := currentHistoryStore()
+	now := time.Now()
+	_, err := store.record(historyCommandSync, withPlugin(10), "", now)
+	require.NoError(t, err)
+	_, err = store.record(historyCommandSync, withPlugin(20), "", now)
+	require.NoError(t, err)
+
+	cmd := &cobra.Command{}
+	cmd.SetContext(context.Background())
+	require.NoError(t, executeHistoryDiff(cmd, []string{"1", "2"}))
+	require.Len(t, jsonOutput.Changes.Updating, 1)
+	assert.Equal(t, "rate-limiting for service orders", jsonOutput.Changes.Updating[0].Name)
+	assert.Empty(t, jsonOutput.Changes.Creating)
+	assert.Empty(t, jsonOutput.Changes.Deleting)
+}
+
+func TestHistoryStoreRedactsSecrets(t *testing.T) {
+	store := openHistoryStore(t.TempDir(), "http://localhost:8001", "")
+	consumer := &kong.Consumer{ID: kong.String("id-alice"), Username: kong.String("alice")}
+	withSecrets := func(key string) *reconcilerUtils.KongRawState {
+		raw := historyTestState("orders")
+		raw.Consumers = []*kong.Consumer{consumer}
+		raw.KeyAuths = []*kong.KeyAuth{{ID: kong.String("id-key"), Key: kong.String(key), Consumer: consumer}}
+		raw.BasicAuths = []*kong.BasicAuthOptions{{BasicAuth: kong.BasicAuth{
+			ID: kong.String("id-basic"), Username: kong.String("alice"), Password: kong.String("hunter2"),
+			Consumer: consumer,
+		}}}
+		raw.Plugins = []*kong.Plugin{{
+			ID:   kong.String("id-oidc"),
+			Name: kong.String("openid-connect"),
+			Config: kong.Configuration{
+				"client_id":     []interface{}{"orders"},
+				"client_secret": []interface{}{"oidc-secret"},
+				"session":       map[string]interface{}{"redis_password": "redis-secret", "redis_port": 6379},
+			},
+		}}
+		return raw
+	}
+
+	first, err := store.record(historyCommandSync, withSecrets("alice-key"), "", time.Now())
+	require.NoError(t, err)
+	var contents []string
+	objects, err := filepath.Glob(filepath.Join(store.dir, historyObjectsDir, "*", "*.json.gz"))
+	require.NoError(t, err)
+	for _, object := range objects {
+		b, err := os.ReadFile(object)
+		require.NoError(t, err)
+		contents = append(contents, string(b))
+	}
+	raw, err := store.load(first)
+	require.NoError(t, err)
+	b, err := json.Marshal(raw)
+	require.NoError(t, err)
+	contents = append(contents, string(b))
+	for _, content := range contents {
+		for _, secret := range []string{"alice-key", "hunter2", "oidc-secret", "redis-secret"} {
+			assert.NotContains(t, content, secret)
+		}
+	}
+	assert.Contains(t, *raw.KeyAuths[0].Key, historyRedactedPrefix)
+	assert.Equal(t, []interface{}{"orders"}, raw.Plugins[0].Config["client_id"])
+	assert.Equal(t, json.Number("6379"), json.Number(fmt.Sprint(
+		raw.Plugins[0].Config["session"].(map[string]interface{})["redis_port"])))
+
+	// an unchanged state is stored once, a changed secret is a new state
+	again, err := store.record(historyCommandSync, withSecrets("alice-key"), "", time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, first.Object, again.Object)
+	changed, err := store.record(historyCommandSync, withSecrets("rotated-key"), "", time.Now())
+	require.NoError(t, err)
+	assert.NotEqual(t, first.Object, changed.Object)
+}
@@ -43,7 +43,8 @@ func newKonnectDiffCmd() *cobra.Command {
 	konnectDiffCmd.Flags().IntVar(&konnectDiffCmdParallelism, "parallelism",
 		100, "Maximum number of concurrent operations.")
 	konnectDiffCmd.Flags().BoolVar(&noMaskValues, "no-mask-deck-env-vars-value",
-		false, "do not mask DECK_ environment variable values at diff output.")
+		false, "do not mask DECK_ environment variable values, and secrets\n"+
+			"of state files, at diff output.")
 	konnectDiffCmd.Flags().BoolVar(&konnectDiffCmdNonZeroExitCode, "non-zero-exit-code",
 		false, "return exit code 2 if there is a diff present,\n"+
 			"exit code 0 if no diff is found,\n"+
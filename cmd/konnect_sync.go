@@ -35,7 +35,8 @@ to get Konnect's state in sync with the input state.` + konnectAlphaState,
 	konnectSyncCmd.Flags().IntVar(&konnectDiffCmdParallelism, "parallelism",
 		100, "Maximum number of concurrent operations.")
 	konnectSyncCmd.Flags().BoolVar(&noMaskValues, "no-mask-deck-env-vars-value",
-		false, "do not mask DECK_ environment variable values at diff output.")
+		false, "do not mask DECK_ environment variable values, and secrets\n"+
+			"of state files, at diff output.")
 	addDiagnosticSeverityFlags(konnectSyncCmd.Flags())
 	addSilenceEventsFlag(konnectSyncCmd.Flags())
 	return konnectSyncCmd
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net/url"
 	"os"
+	"slices"
 	"strings"
 
 	"github.com/fatih/color"
@@ -96,7 +97,9 @@ It can be used to export, import, or sync entities to Kong.`,
 
 	rootCmd.PersistentFlags().StringSlice("headers", []string{},
 		"HTTP headers (key:value) to inject in all requests to Kong's Admin API.\n"+
-			"This flag can be specified multiple times to inject multiple headers.")
+			"This flag can be specified multiple times to inject multiple headers.\n"+
+			"This value can also be set using DECK_HEADERS environment variable,\n"+
+			"with one header per line.")
 	viper.BindPFlag("headers",
 		rootCmd.PersistentFlags().Lookup("headers"))
 
@@ -218,6 +221,38 @@ It can be used to export, import, or sync entities to Kong.`,
 	viper.BindPFlag("kong-admin-token",
 		rootCmd.PersistentFlags().Lookup("kong-admin-token"))
 
+	rootCmd.PersistentFlags().Bool("history", false,
+		"record the state of every sync and dump in a local history,\n"+
+			"to browse with 'deck gateway history'. Credentials and other secrets are\n"+
+			"stored as salted hashes, never in clear.\n"+
+			"This value can also be set using DECK_HISTORY environment variable.")
+	viper.BindPFlag("history",
+		rootCmd.PersistentFlags().Lookup("history"))
+
+	rootCmd.PersistentFlags().String("history-dir", defaultHistoryDir,
+		"directory of the local history of synced and dumped states.")
+	viper.BindPFlag("history-dir",
+		rootCmd.PersistentFlags().Lookup("history-dir"))
+
+	rootCmd.PersistentFlags().Bool("schema-cache", false,
+		"Cache the schemas fetched from Kong on disk, by Admin API address and Kong version,\n"+
+			"and reuse them in later validate, diff, sync and dump runs. Schemas of custom plugins\n"+
+			"are not cached. Manage the cache with 'deck cache schemas'.")
+	viper.BindPFlag("schema-cache",
+		rootCmd.PersistentFlags().Lookup("schema-cache"))
+
+	rootCmd.PersistentFlags().String("schema-cache-dir", "",
+		"directory of the schema cache (default is $HOME/.deck/cache/schemas).")
+	viper.BindPFlag("schema-cache-dir",
+		rootCmd.PersistentFlags().Lookup("schema-cache-dir"))
+
+	rootCmd.PersistentFlags().String("decrypt-key-file", "",
+		"file with the age identities (AGE-SECRET-KEY-1...) decrypting the state files\n"+
+			"encrypted by 'deck gateway dump --encrypt-recipient'.\n"+
+			"The identities can also be set using DECK_DECRYPT_KEY environment variable.")
+	viper.BindPFlag("decrypt-key-file",
+		rootCmd.PersistentFlags().Lookup("decrypt-key-file"))
+
 	rootCmd.AddCommand(newVersionCmd())
 	rootCmd.AddCommand(newCompletionCmd())
 	rootCmd.AddCommand(newSyncCmd(true))            // deprecated, to exist under the `gateway` subcommand only
@@ -238,6 +273,9 @@ It can be used to export, import, or sync entities to Kong.`,
 		gatewayCmd.AddCommand(newDumpCmd(false))
 		gatewayCmd.AddCommand(newDiffCmd(false))
 		gatewayCmd.AddCommand(newApplyCmd())
+		gatewayCmd.AddCommand(newRollbackCmd())
+		gatewayCmd.AddCommand(newWatchCmd())
+		gatewayCmd.AddCommand(newHistoryCmd())
 	}
 	{
 		fileCmd := newFileSubCmd()
@@ -249,6 +287,9 @@ It can be used to export, import, or sync entities to Kong.`,
 		fileCmd.AddCommand(newMergeCmd())
 		fileCmd.AddCommand(newPatchCmd())
 		fileCmd.AddCommand(newOpenapi2KongCmd())
+		fileCmd.AddCommand(newKong2OpenAPICmd())
+		fileCmd.AddCommand(newConsumersCmd())
+		fileCmd.AddCommand(newFilePipelineCmd())
 		fileCmd.AddCommand(newOpenapi2MCPCmd())
 		fileCmd.AddCommand(newFileRenderCmd())
 		fileCmd.AddCommand(newLintCmd())
@@ -266,6 +307,11 @@ It can be used to export, import, or sync entities to Kong.`,
 		aiCmd.AddCommand(newAiDumpCmd())
 		aiCmd.AddCommand(newAiSyncCmd())
 	}
+	{
+		cacheCmd := newCacheSubCmd()
+		rootCmd.AddCommand(cacheCmd)
+		cacheCmd.AddCommand(newCacheSchemasCmd())
+	}
 	return rootCmd
 }
 
@@ -325,13 +371,18 @@ func initConfig() {
 	}
 
 	rootConfig.Address = viper.GetString("kong-addr")
+	recordHistory = viper.GetBool("history")
+	historyDir = viper.GetString("history-dir")
+	useSchemaCache = viper.GetBool("schema-cache")
+	schemaCacheDir = viper.GetString("schema-cache-dir")
+	decryptKeyFile = viper.GetString("decrypt-key-file")
 
 	tlsServerName := viper.GetString("tls-server-name")
 	tlsSkipVerify := viper.GetBool("tls-skip-verify")
 	tlsCACert := caCertContent
 
 	rootConfig.Headers = extendHeaders(
-		viper.GetStringSlice("headers"),
+		headersSetting(),
 		header{name: "Kong-Admin-Token", value: viper.GetString("kong-admin-token")},
 	)
 
@@ -429,12 +480,27 @@ func initKonnectConfig() error {
 	konnectConfig.Token = token
 	konnectConfig.Debug = (viper.GetInt("verbose") >= 1)
 	konnectConfig.Address = viper.GetString("konnect-addr")
-	konnectConfig.Headers = extendHeaders(viper.GetStringSlice("headers"))
+	konnectConfig.Headers = extendHeaders(headersSetting())
 	konnectControlPlane = viper.GetString("konnect-control-plane-name")
 	konnectRuntimeGroup = viper.GetString("konnect-runtime-group-name")
 	return nil
 }
 
+// headersSetting returns the headers of --headers, DECK_HEADERS or the
+// config file. DECK_HEADERS holds headers separated by spaces or, for header
+// values containing spaces, one header per line.
+func headersSetting() []string {
+	headers := viper.GetStringSlice("headers")
+	env := os.Getenv("DECK_HEADERS")
+	if !strings.Contains(env, "\n") || !slices.Equal(headers, strings.Fields(env)) {
+		return headers
+	}
+	// the headers were read from DECK_HEADERS
+	return slices.DeleteFunc(strings.Split(env, "\n"), func(h string) bool {
+		return strings.TrimSpace(h) == ""
+	})
+}
+
 type header struct {
 	name  string
 	value string
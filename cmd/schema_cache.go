@@ -0,0 +1,185 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/kong/deck/schemas"
+	"github.com/kong/go-kong/kong"
+)
+
+var (
+	// useSchemaCache and schemaCacheDir are set from the root flags, or the
+	// config file.
+	useSchemaCache bool
+	schemaCacheDir string
+)
+
+// schemaEndpoint matches the Admin API endpoints schemas are fetched from,
+// with an optional workspace: /schemas/<entity> and
+// /schemas/<plugins|partials|vaults>/<name>.
+var schemaEndpoint = regexp.MustCompile(`/schemas/(?:(plugins|partials|vaults)/)?([A-Za-z0-9._-]+)$`)
+
+// schemaStoreDir returns the directory the schemas of Kong versions are
+// cached in.
+func schemaStoreDir() string {
+	if schemaCacheDir != "" {
+		return schemaCacheDir
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = "."
+	}
+	return filepath.Join(home, ".deck", "cache", "schemas")
+}
+
+// schemaClustersDir is the directory of the schema cache holding the schemas
+// cached from each Kong, next to the schemas stored for offline validation.
+const schemaClustersDir = "clusters"
+
+var schemaCacheKeyInvalidChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// clusterSchemaStore returns the store of the schemas cached from the Kong
+// at kongAddr, running kongVersion. Schemas are cached by Admin API address
+// and full version, as Kongs of the same minor version, or patched Kongs,
+// may serve different schemas.
+func clusterSchemaStore(kongAddr, kongVersion string) *schemas.Store {
+	key := func(s string) string {
+		if _, rest, ok := strings.Cut(s, "://"); ok {
+			s = rest
+		}
+		return strings.Trim(schemaCacheKeyInvalidChars.ReplaceAllString(s, "_"), "_")
+	}
+	return schemas.NewStore(filepath.Join(schemaStoreDir(), schemaClustersDir, key(kongAddr), key(kongVersion)))
+}
+
+// cacheSchemas makes client read the schemas of entities, bundled plugins,
+// partials and vaults from the schema cache, and cache the ones it fetches
+// from the Kong at kongAddr.
+func cacheSchemas(client *kong.Client, kongAddr, kongVersion string) {
+	if !useSchemaCache {
+		return
+	}
+	v, err := schemas.VersionOf(kongVersion)
+	if err != nil {
+		// schemas of unknown versions are not cached
+		return
+	}
+	store := clusterSchemaStore(kongAddr, kongVersion)
+	client.SetDoer(schemaCacheDoer(client, store, v, bundledPlugins(client, kongVersion), client.Doer()))
+}
+
+// bundledPlugins returns a function reporting whether a plugin is bundled
+// with the Kong of client. The plugins available on Kong are fetched once,
+// when first needed. Plugins bundled with Kong 3 have the version of Kong,
+// custom plugins have their own.
+func bundledPlugins(client *kong.Client, kongVersion string) func(ctx context.Context, name string) bool {
+	var (
+		once    sync.Once
+		bundled map[string]bool
+	)
+	return func(ctx context.Context, name string) bool {
+		once.Do(func() {
+			bundled = map[string]bool{}
+			kongV, err := kong.ParseSemanticVersion(kongVersion)
+			if err != nil {
+				return
+			}
+			root, err := client.Root(ctx)
+			if err != nil {
+				return
+			}
+			plugins, _ := root["plugins"].(map[string]interface{})
+			available, _ := plugins["available_on_server"].(map[string]interface{})
+			for plugin, info := range available {
+				info, _ := info.(map[string]interface{})
+				version, _ := info["version"].(string)
+				v, err := kong.ParseSemanticVersion(version)
+				bundled[plugin] = err == nil && v.Major() == kongV.Major() &&
+					v.Minor() == kongV.Minor() && v.Patch() == kongV.Patch()
+			}
+		})
+		return bundled[name]
+	}
+}
+
+// schemaCacheDoer wraps the request dispatching of client to serve schema
+// requests from store. The schemas of plugins are only cached when
+// cachePlugin, if set, reports that they can be.
+func schemaCacheDoer(client *kong.Client, store *schemas.Store, v schemas.Version,
+	cachePlugin func(ctx context.Context, name string) bool, next kong.Doer,
+) kong.Doer {
+	var warnOnce sync.Once
+	return func(ctx context.Context, httpClient *http.Client, req *http.Request) (*http.Response, error) {
+		do := func() (*http.Response, error) {
+			if next != nil {
+				return next(ctx, httpClient, req)
+			}
+			return client.DoRAW(ctx, req)
+		}
+		m := schemaEndpoint.FindStringSubmatch(req.URL.Path)
+		if req.Method != http.MethodGet || m == nil || req.URL.RawQuery != "" {
+			return do()
+		}
+		kind, name := m[1], m[2]
+		if kind == "" {
+			kind = schemas.KindEntity
+		}
+
+		if schema, err := store.Get(v, kind, name); err == nil {
+			return schemaResponse(req, schema)
+		}
+		resp, err := do()
+		if err != nil || resp.StatusCode != http.StatusOK {
+			return resp, err
+		}
+		if kind == schemas.KindPlugin && cachePlugin != nil && !cachePlugin(ctx, name) {
+			return resp, nil
+		}
+		b, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("reading schema: %w", err)
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(b))
+
+		var schema kong.Schema
+		if err := json.Unmarshal(b, &schema); err == nil {
+			if err := store.Put(v, kind, name, schema); err != nil {
+				warnOnce.Do(func() {
+					fmt.Fprintf(os.Stderr, "Warning: caching schemas: %v\n", err)
+				})
+			}
+		}
+		return resp, nil
+	}
+}
+
+// schemaResponse returns a response to req with a cached schema, as Kong
+// would have responded.
+func schemaResponse(req *http.Request, schema kong.Schema) (*http.Response, error) {
+	b, err := json.Marshal(schema)
+	if err != nil {
+		return nil, fmt.Errorf("encoding schema: %w", err)
+	}
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(b)),
+		ContentLength: int64(len(b)),
+		Request:       req,
+	}, nil
+}
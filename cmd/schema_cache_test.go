@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/kong/deck/schemas"
+	"github.com/kong/go-database-reconciler/pkg/schema"
+	"github.com/kong/go-kong/kong"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newSchemaTestKong returns a Kong serving its root and the schemas of
+// services, of the bundled cors plugin and of a custom plugin, counting the
+// schema requests.
+func newSchemaTestKong(t *testing.T, requests *atomic.Int32) *kong.Client {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/":
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"version": "3.10.0.1-enterprise-edition",
+				"plugins": map[string]interface{}{
+					"available_on_server": map[string]interface{}{
+						"cors":      map[string]interface{}{"version": "3.10.0"},
+						"my-plugin": map[string]interface{}{"version": "1.0.0"},
+					},
+				},
+			})
+			return
+		case "/schemas/services", "/schemas/plugins/cors", "/schemas/plugins/my-plugin":
+			requests.Add(1)
+			_, _ = w.Write([]byte(`{"fields": [{"name": {"type": "string"}}]}`))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"message": "Not found"}`))
+	}))
+	t.Cleanup(server.Close)
+	client, err := kong.NewClient(kong.String(server.URL), server.Client())
+	require.NoError(t, err)
+	return client
+}
+
+func TestSchemaCache(t *testing.T) {
+	defer func(use bool, dir string) { useSchemaCache, schemaCacheDir = use, dir }(useSchemaCache, schemaCacheDir)
+	useSchemaCache, schemaCacheDir = true, t.TempDir()
+	ctx := context.Background()
+	const kongVersion = "3.10.0.1-enterprise-edition"
+
+	run := func(kongAddr string) int32 {
+		var requests atomic.Int32
+		client := newSchemaTestKong(t, &requests)
+		cacheSchemas(client, kongAddr, kongVersion)
+
+		s, err := schema.FetchEntitySchema(ctx, client, false, "services")
+		require.NoError(t, err)
+		assert.Equal(t, kong.Schema{"fields": []interface{}{
+			map[string]interface{}{"name": map[string]interface{}{"type": "string"}},
+		}}, s)
+		_, err = schema.FetchPluginSchema(ctx, client, "cors")
+		require.NoError(t, err)
+		_, err = schema.FetchPluginSchema(ctx, client, "my-plugin")
+		require.NoError(t, err)
+		// not found schemas are not cached
+		s, err = schema.FetchEntitySchema(ctx, client, false, "routes")
+		require.NoError(t, err)
+		assert.Nil(t, s)
+		return requests.Load()
+	}
+	assert.Equal(t, int32(3), run("http://kong-a:8001"))
+	// the schema of the custom plugin is fetched again
+	assert.Equal(t, int32(1), run("http://kong-a:8001"))
+	// schemas are cached by Kong
+	assert.Equal(t, int32(3), run("http://kong-b:8001"))
+
+	store := clusterSchemaStore("http://kong-a:8001", kongVersion)
+	assert.Equal(t, filepath.Join(schemaCacheDir, "clusters", "kong-a_8001", "3.10.0.1-enterprise-edition"), store.Dir())
+	v := schemas.Version{Major: 3, Minor: 10, Edition: schemas.EditionEnterprise}
+	names, err := store.Names(v, schemas.KindPlugin)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cors"}, names)
+
+	// the schemas stored for offline validation are not used
+	versions, err := schemas.NewStore(schemaCacheDir).Versions()
+	require.NoError(t, err)
+	assert.Empty(t, versions)
+	cleared, err := clearClusterSchemas(schemas.NewStore(schemaCacheDir))
+	require.NoError(t, err)
+	assert.True(t, cleared)
+	assert.NoDirExists(t, filepath.Join(schemaCacheDir, "clusters"))
+}
+
+func TestCacheSchemasPullAndClear(t *testing.T) {
+	var requests atomic.Int32
+	store := schemas.NewStore(t.TempDir())
+	v, pulled, err := pullSchemas(context.Background(), newSchemaTestKong(t, &requests), store)
+	require.NoError(t, err)
+	assert.Equal(t, schemas.Version{Major: 3, Minor: 10, Edition: schemas.EditionEnterprise}, v)
+	assert.Equal(t, map[string]int{schemas.KindEntity: 1, schemas.KindPlugin: 2}, pulled)
+
+	oss := schemas.Version{Major: 3, Minor: 9, Edition: schemas.EditionOSS}
+	require.NoError(t, store.Put(oss, schemas.KindEntity, "services", kong.Schema{}))
+
+	removed, err := clearSchemas(store, []string{"3.10"})
+	require.NoError(t, err)
+	assert.Equal(t, []schemas.Version{v}, removed)
+	removed, err = clearSchemas(store, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []schemas.Version{oss}, removed)
+	versions, err := store.Versions()
+	require.NoError(t, err)
+	assert.Empty(t, versions)
+}
@@ -0,0 +1,326 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/kong/deck/sanitize"
+	"github.com/kong/go-database-reconciler/pkg/file"
+	"sigs.k8s.io/yaml"
+)
+
+// State files encrypted by 'deck gateway dump --encrypt-recipient' keep their
+// structure and non-secret fields in clear text. Each secret value is
+// replaced with an armored age file, encrypted to all the recipients, which
+// 'age --decrypt' can read. The age file holds the value together with its
+// location in the state file, e.g. consumers[username=alice].keyauth_credentials[0].key,
+// entities being identified by their id, name or username, so that encrypted
+// values cannot be moved between fields or entities. The recipients are listed
+// in the _encryption field of the file:
+//
+//	_encryption:
+//	  recipients:
+//	  - age1...
+const (
+	stateEncryptionKey = "_encryption"
+
+	// decryptKeyEnvVar holds identities, as an alternative to
+	// --decrypt-key-file.
+	decryptKeyEnvVar = "DECK_DECRYPT_KEY"
+)
+
+// errNotEncrypted is returned for files that are not encrypted.
+var errNotEncrypted = errors.New("not an encrypted state file")
+
+var (
+	// decryptKeyFile is set from the root flags, or the config file.
+	decryptKeyFile string
+
+	stateEncryptedExpr = regexp.MustCompile(`(?m)^(?:` + stateEncryptionKey + `|\s*"` + stateEncryptionKey + `")\s*:`)
+)
+
+// stateEncryptionInfoBlock is the _encryption field of an encrypted file.
+type stateEncryptionInfoBlock struct {
+	Recipients []string `json:"recipients"`
+}
+
+// stateEncryptedValue is the content of the age file of an encrypted value.
+type stateEncryptedValue struct {
+	Path  string `json:"path"`
+	Value string `json:"value"`
+}
+
+// parseRecipient parses an age recipient (age1...).
+func parseRecipient(recipient string) (age.Recipient, error) {
+	recipients, err := age.ParseRecipients(strings.NewReader(recipient))
+	if err != nil || len(recipients) != 1 {
+		return nil, fmt.Errorf("invalid recipient '%s': expected an age recipient (age1...)", recipient)
+	}
+	return recipients[0], nil
+}
+
+// loadDecryptionIdentities returns the age identities of --decrypt-key-file,
+// or else of DECK_DECRYPT_KEY.
+func loadDecryptionIdentities() ([]age.Identity, error) {
+	if decryptKeyFile != "" {
+		f, err := os.Open(decryptKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading decryption key file: %w", err)
+		}
+		defer f.Close()
+		identities, err := age.ParseIdentities(f)
+		if err != nil {
+			return nil, fmt.Errorf("reading decryption key file '%s': %w", decryptKeyFile, err)
+		}
+		return identities, nil
+	}
+	if key := os.Getenv(decryptKeyEnvVar); key != "" {
+		identities, err := age.ParseIdentities(strings.NewReader(key))
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", decryptKeyEnvVar, err)
+		}
+		return identities, nil
+	}
+	return nil, fmt.Errorf("a key is required to decrypt it, set --decrypt-key-file or %s", decryptKeyEnvVar)
+}
+
+// encryptStateFile replaces the placeholders of secrets in a marshaled state
+// file with their values encrypted to recipients. The file is re-marshaled in
+// format.
+func encryptStateFile(content []byte, format file.Format, secrets []sanitize.Secret,
+	recipients []age.Recipient, recipientNames []string,
+) ([]byte, error) {
+	data, err := unmarshalStateDocument(content)
+	if err != nil {
+		return nil, fmt.Errorf("encrypting state file: %w", err)
+	}
+	values := make(map[string]string, len(secrets))
+	for _, secret := range secrets {
+		values[secret.Placeholder()] = secret.Value
+	}
+	isSecret := func(leaf string) bool {
+		_, ok := values[leaf]
+		return ok
+	}
+
+	var encryptErr error
+	result := walkStateLeaves(data, "", isSecret, func(path, leaf string) interface{} {
+		if encryptErr != nil {
+			return leaf
+		}
+		var enc string
+		if enc, encryptErr = encryptStateValue(path, values[leaf], recipients); encryptErr != nil {
+			return leaf
+		}
+		return enc
+	})
+	if encryptErr != nil {
+		return nil, fmt.Errorf("encrypting state file: %w", encryptErr)
+	}
+
+	document := result.(map[string]interface{})
+	document[stateEncryptionKey] = stateEncryptionInfoBlock{Recipients: recipientNames}
+	if format == file.JSON {
+		return json.MarshalIndent(document, "", "  ")
+	}
+	return yaml.Marshal(document)
+}
+
+// isEncryptedStateFile reports whether content may be an encrypted state
+// file, without parsing it.
+func isEncryptedStateFile(content []byte) bool {
+	return stateEncryptedExpr.Match(content)
+}
+
+// isEncryptedStateValue reports whether a value of a state file is an
+// armored age file.
+func isEncryptedStateValue(value string) bool {
+	return strings.HasPrefix(value, armor.Header)
+}
+
+// decryptStateFile decrypts an encrypted state file with identities and
+// returns it as YAML, without its _encryption field. The decrypted values are
+// masked in diffs, see maskSecret.
+func decryptStateFile(content []byte, identities []age.Identity) ([]byte, error) {
+	data, err := unmarshalStateDocument(content)
+	if err != nil {
+		return nil, err
+	}
+	document, ok := data.(map[string]interface{})
+	if !ok || document[stateEncryptionKey] == nil {
+		return nil, errNotEncrypted
+	}
+	delete(document, stateEncryptionKey)
+
+	var decryptErr error
+	result := walkStateLeaves(document, "", isEncryptedStateValue, func(path, leaf string) interface{} {
+		if decryptErr != nil {
+			return leaf
+		}
+		var value string
+		if value, decryptErr = decryptStateValue(path, leaf, identities); decryptErr != nil {
+			return leaf
+		}
+		return maskSecret(value)
+	})
+	if decryptErr != nil {
+		return nil, decryptErr
+	}
+	return yaml.Marshal(result)
+}
+
+// decryptStateFiles decrypts the encrypted files in place. The identities are
+// only loaded if there is an encrypted file.
+func decryptStateFiles(files []stateFile) error {
+	var identities []age.Identity
+	for i, f := range files {
+		if !isEncryptedStateFile(f.content) {
+			continue
+		}
+		name := f.name
+		if name == "-" {
+			name = "stdin"
+		}
+		if identities == nil {
+			var err error
+			if identities, err = loadDecryptionIdentities(); err != nil {
+				return fmt.Errorf("'%s' is encrypted: %w", name, err)
+			}
+		}
+		decrypted, err := decryptStateFile(f.content, identities)
+		if err != nil {
+			if errors.Is(err, errNotEncrypted) {
+				continue
+			}
+			return fmt.Errorf("decrypting '%s': %w", name, err)
+		}
+		files[i].content = decrypted
+		files[i].modified = true
+	}
+	return nil
+}
+
+func unmarshalStateDocument(content []byte) (interface{}, error) {
+	b, err := yaml.YAMLToJSON(content)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	// numbers are kept as they are
+	decoder.UseNumber()
+	var data interface{}
+	if err := decoder.Decode(&data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// stateEntityKeys are the fields identifying the entities of lists in the
+// paths of encrypted values, in order of preference.
+var stateEntityKeys = []string{"id", "name", "username"}
+
+// walkStateLeaves replaces the string leaves of data for which isSecret
+// returns true with the values f returns for them. The path of a leaf is made
+// of the object keys leading to it and of the entities of the lists, as
+// identified by the first of stateEntityKeys they have in clear text, or else
+// by their index, e.g. consumers[username=alice].keyauth_credentials[0].key.
+func walkStateLeaves(data interface{}, path string, isSecret func(string) bool,
+	f func(path, leaf string) interface{},
+) interface{} {
+	switch v := data.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			childPath := key
+			if path != "" {
+				childPath = path + "." + key
+			}
+			v[key] = walkStateLeaves(child, childPath, isSecret, f)
+		}
+		return v
+	case []interface{}:
+		for i, child := range v {
+			v[i] = walkStateLeaves(child, path+"["+stateEntityKey(child, i, isSecret)+"]", isSecret, f)
+		}
+		return v
+	case string:
+		if !isSecret(v) {
+			return v
+		}
+		return f(path, v)
+	default:
+		return data
+	}
+}
+
+// stateEntityKey identifies the item i of a list in the path of encrypted
+// values.
+func stateEntityKey(item interface{}, i int, isSecret func(string) bool) string {
+	if entity, ok := item.(map[string]interface{}); ok {
+		for _, key := range stateEntityKeys {
+			if value, ok := entity[key].(string); ok && value != "" && !isSecret(value) {
+				return key + "=" + value
+			}
+		}
+	}
+	return strconv.Itoa(i)
+}
+
+// encryptStateValue returns value and its path encrypted to recipients, as
+// an armored age file.
+func encryptStateValue(path, value string, recipients []age.Recipient) (string, error) {
+	payload, err := json.Marshal(stateEncryptedValue{Path: path, Value: value})
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	armored := armor.NewWriter(&b)
+	w, err := age.Encrypt(armored, recipients...)
+	if err != nil {
+		return "", err
+	}
+	if _, err := w.Write(payload); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	if err := armored.Close(); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// decryptStateValue decrypts the armored age file enc found at path, and
+// checks that it was encrypted for this path.
+func decryptStateValue(path, enc string, identities []age.Identity) (string, error) {
+	r, err := age.Decrypt(armor.NewReader(strings.NewReader(enc)), identities...)
+	if err != nil {
+		var noMatch *age.NoIdentityMatchError
+		if errors.As(err, &noMatch) {
+			return "", fmt.Errorf("none of the decryption keys is a recipient of the value at '%s'", path)
+		}
+		return "", fmt.Errorf("decrypting the value at '%s': %w", path, err)
+	}
+	payload, err := io.ReadAll(r)
+	if err != nil {
+		return "", fmt.Errorf("decrypting the value at '%s': %w", path, err)
+	}
+	var value stateEncryptedValue
+	if err := json.Unmarshal(payload, &value); err != nil {
+		return "", fmt.Errorf("decrypting the value at '%s': %w", path, err)
+	}
+	if value.Path != path {
+		return "", fmt.Errorf("the value at '%s' was encrypted for '%s', "+
+			"encrypted values cannot be moved", path, value.Path)
+	}
+	return value.Value, nil
+}
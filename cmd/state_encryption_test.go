@@ -0,0 +1,216 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/kong/deck/sanitize"
+	"github.com/kong/go-database-reconciler/pkg/file"
+	"github.com/kong/go-kong/kong"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/yaml"
+)
+
+// newTestAgeKey returns a recipient and its identity, as age-keygen
+// generates them.
+func newTestAgeKey(t *testing.T) (string, string) {
+	t.Helper()
+	identity, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	return identity.Recipient().String(), identity.String()
+}
+
+// setDecryptKeyFile writes identities to a key file and sets it as
+// --decrypt-key-file.
+func setDecryptKeyFile(t *testing.T, identities ...string) {
+	t.Helper()
+	keyFile := filepath.Join(t.TempDir(), "keys.txt")
+	require.NoError(t, os.WriteFile(keyFile,
+		[]byte("# created: 2026-10-18\n"+strings.Join(identities, "\n")+"\n"), 0o600))
+	previous := decryptKeyFile
+	decryptKeyFile = keyFile
+	t.Cleanup(func() { decryptKeyFile = previous })
+}
+
+func TestParseAgeKeys(t *testing.T) {
+	recipient, identity := newTestAgeKey(t)
+	_, err := parseRecipient(recipient)
+	require.NoError(t, err)
+	_, err = parseRecipient(strings.Replace(recipient, "age1", "age2", 1))
+	require.ErrorContains(t, err, "expected an age recipient")
+
+	setDecryptKeyFile(t, identity)
+	identities, err := loadDecryptionIdentities()
+	require.NoError(t, err)
+	assert.Len(t, identities, 1)
+
+	setDecryptKeyFile(t, recipient)
+	_, err = loadDecryptionIdentities()
+	require.ErrorContains(t, err, "error at line 2")
+}
+
+func TestEncryptStateFile(t *testing.T) {
+	resetMaskedSecrets(t)
+	recipient, identity := newTestAgeKey(t)
+	otherRecipient, otherIdentity := newTestAgeKey(t)
+	_, strangerIdentity := newTestAgeKey(t)
+	d, err := newEncryptingDumpSecrets([]string{recipient, otherRecipient})
+	require.NoError(t, err)
+
+	secrets := []sanitize.Secret{
+		{EnvVar: "DECK_ALICE_KEY", Value: "alice-key"},
+		{EnvVar: "DECK_BOB_KEY", Value: "bob-key"},
+	}
+	content := []byte(`_format_version: "3.0"
+consumers:
+- username: alice
+  keyauth_credentials:
+  - key: ` + secrets[0].Placeholder() + `
+- username: bob
+  keyauth_credentials:
+  - key: ` + secrets[1].Placeholder() + `
+services:
+- name: orders
+  port: 8443
+`)
+	encrypted, err := encryptStateFile(content, file.YAML, secrets, d.recipients, d.recipientNames)
+	require.NoError(t, err)
+	assert.NotContains(t, string(encrypted), "alice-key")
+	assert.Contains(t, string(encrypted), "  username: alice\n")
+	assert.Contains(t, string(encrypted), "  port: 8443\n")
+	assert.Contains(t, string(encrypted), "  - "+otherRecipient+"\n")
+	assert.Contains(t, string(encrypted), "  - key: |\n      "+armor.Header+"\n")
+	assert.True(t, isEncryptedStateFile(encrypted))
+	assert.False(t, isEncryptedStateFile(content))
+
+	for _, key := range []string{identity, otherIdentity} {
+		decrypted, err := decryptStateFile(encrypted, parseTestIdentities(t, key))
+		require.NoError(t, err)
+		assert.Contains(t, string(decrypted), "- key: alice-key\n")
+		assert.Contains(t, string(decrypted), "- key: bob-key\n")
+		assert.NotContains(t, string(decrypted), stateEncryptionKey)
+	}
+
+	identities := parseTestIdentities(t, identity)
+	_, err = decryptStateFile(encrypted, parseTestIdentities(t, strangerIdentity))
+	require.EqualError(t, err, "none of the decryption keys is a recipient of the value at "+
+		"'consumers[username=alice].keyauth_credentials[0].key'")
+
+	// edit changes the parsed encrypted file, and returns it re-marshaled
+	edit := func(t *testing.T, f func(consumers []interface{})) []byte {
+		var document map[string]interface{}
+		require.NoError(t, yaml.Unmarshal(encrypted, &document))
+		f(document["consumers"].([]interface{}))
+		b, err := yaml.Marshal(document)
+		require.NoError(t, err)
+		return b
+	}
+	credential := func(consumers []interface{}, i int) map[string]interface{} {
+		consumer := consumers[i].(map[string]interface{})
+		return consumer["keyauth_credentials"].([]interface{})[0].(map[string]interface{})
+	}
+
+	t.Run("swapped values", func(t *testing.T) {
+		swapped := edit(t, func(consumers []interface{}) {
+			alice, bob := credential(consumers, 0), credential(consumers, 1)
+			alice["key"], bob["key"] = bob["key"], alice["key"]
+		})
+		_, err := decryptStateFile(swapped, identities)
+		require.ErrorContains(t, err, "the value at 'consumers[username=")
+		require.ErrorContains(t, err, "encrypted values cannot be moved")
+	})
+
+	t.Run("renamed entity", func(t *testing.T) {
+		renamed := edit(t, func(consumers []interface{}) {
+			consumers[1].(map[string]interface{})["username"] = "mallory"
+		})
+		_, err := decryptStateFile(renamed, identities)
+		require.EqualError(t, err, "the value at 'consumers[username=mallory].keyauth_credentials[0].key' "+
+			"was encrypted for 'consumers[username=bob].keyauth_credentials[0].key', "+
+			"encrypted values cannot be moved")
+	})
+
+	t.Run("moved value", func(t *testing.T) {
+		moved := edit(t, func(consumers []interface{}) {
+			alice := credential(consumers, 0)
+			alice["id"], alice["key"] = alice["key"], "clear-text"
+		})
+		_, err := decryptStateFile(moved, identities)
+		require.EqualError(t, err, "the value at 'consumers[username=alice].keyauth_credentials[0].id' "+
+			"was encrypted for 'consumers[username=alice].keyauth_credentials[0].key', "+
+			"encrypted values cannot be moved")
+	})
+}
+
+// parseTestIdentities parses the identities of a key file.
+func parseTestIdentities(t *testing.T, key string) []age.Identity {
+	t.Helper()
+	identities, err := age.ParseIdentities(strings.NewReader(key))
+	require.NoError(t, err)
+	return identities
+}
+
+func TestDumpEncryptedRoundTrip(t *testing.T) {
+	resetMaskedSecrets(t)
+	recipient, identity := newTestAgeKey(t)
+	content := &file.Content{
+		FormatVersion: "3.0",
+		Consumers: []file.FConsumer{{
+			Consumer: kong.Consumer{Username: kong.String("alice")},
+			KeyAuths: []*kong.KeyAuth{{Key: kong.String(`it's a "key"`)}},
+		}},
+	}
+
+	for _, format := range []file.Format{file.YAML, file.JSON} {
+		t.Run(string(format), func(t *testing.T) {
+			dir := t.TempDir()
+			secrets, err := newEncryptingDumpSecrets([]string{recipient})
+			require.NoError(t, err)
+			externalized, err := secrets.externalize(context.Background(), nil, content.DeepCopy(), "", false)
+			require.NoError(t, err)
+			filename := filepath.Join(dir, "kong."+strings.ToLower(string(format)))
+			require.NoError(t, secrets.writeContent(externalized, filename, format))
+			require.NoError(t, secrets.writeEnvFile(filepath.Join(dir, defaultEnvFileName)))
+			_, err = os.Stat(filepath.Join(dir, defaultEnvFileName))
+			assert.True(t, os.IsNotExist(err), "no env file is written")
+
+			b, err := os.ReadFile(filename)
+			require.NoError(t, err)
+			assert.NotContains(t, string(b), "it's")
+			assert.Contains(t, string(b), "alice")
+
+			_, _, err = readStateFiles([]string{filename})
+			require.ErrorContains(t, err, "is encrypted: a key is required to decrypt it")
+
+			setDecryptKeyFile(t, identity)
+			read, _, err := readStateFiles([]string{filename})
+			require.NoError(t, err)
+			require.Len(t, read.Consumers, 1)
+			assert.Equal(t, `it's a "key"`, *read.Consumers[0].KeyAuths[0].Key)
+		})
+	}
+}
+
+func TestDecryptKeyEnvVar(t *testing.T) {
+	resetMaskedSecrets(t)
+	recipient, identity := newTestAgeKey(t)
+	d, err := newEncryptingDumpSecrets([]string{recipient})
+	require.NoError(t, err)
+	secret := sanitize.Secret{EnvVar: "DECK_KEY", Value: "s3cr3t"}
+	encrypted, err := encryptStateFile([]byte("_format_version: \"3.0\"\nconsumers:\n- username: a\n"+
+		"  keyauth_credentials:\n  - key: "+secret.Placeholder()+"\n"),
+		file.YAML, []sanitize.Secret{secret}, d.recipients, d.recipientNames)
+	require.NoError(t, err)
+
+	files := []stateFile{{name: "kong.yaml", content: encrypted}}
+	t.Setenv(decryptKeyEnvVar, identity)
+	require.NoError(t, decryptStateFiles(files))
+	assert.True(t, files[0].modified)
+	assert.Contains(t, string(files[0].content), "key: s3cr3t")
+}
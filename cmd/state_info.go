@@ -0,0 +1,270 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+
+	yamlv3 "gopkg.in/yaml.v3"
+	"sigs.k8s.io/yaml"
+)
+
+// stateFileMaxDeletesPerType holds the limits of the
+// _info.max_deletes_per_type of the state files being synced.
+var stateFileMaxDeletesPerType map[string]int
+
+// stateFileSettings are the settings of the _info of state files that the
+// file format of decK does not declare. They are taken out of the files
+// before the files are read.
+type stateFileSettings struct {
+	// ignoreFields are the rules of _info.ignore_fields.
+	ignoreFields []ignoreFieldRule
+	// maxDeletesPerType are the limits of _info.max_deletes_per_type.
+	maxDeletesPerType map[string]int
+	// protected are the entities of _info.protected.
+	protected *entityProtection
+}
+
+// stateFileSettingKeys are the keys of _info holding stateFileSettings.
+var stateFileSettingKeys = []string{"ignore_fields", "max_deletes_per_type", "protected"}
+
+// merge adds the settings of another state file. The lowest limit declared
+// for a kind wins.
+func (s *stateFileSettings) merge(other stateFileSettings) {
+	s.ignoreFields = append(s.ignoreFields, other.ignoreFields...)
+	s.protected = s.protected.merge(other.protected)
+	for kind, limit := range other.maxDeletesPerType {
+		if current, ok := s.maxDeletesPerType[kind]; ok && current <= limit {
+			continue
+		}
+		if s.maxDeletesPerType == nil {
+			s.maxDeletesPerType = map[string]int{}
+		}
+		s.maxDeletesPerType[kind] = limit
+	}
+}
+
+// setStateFileSettings makes the settings of the state files being synced
+// apply to the sync.
+func setStateFileSettings(settings stateFileSettings) {
+	stateFileIgnoreFields = settings.ignoreFields
+	stateFileMaxDeletesPerType = settings.maxDeletesPerType
+	stateFileProtection = settings.protected
+}
+
+// takeStateFileSettings returns the settings of the _info of a state file,
+// and the file without them. The returned file is nil when the file declares
+// no settings.
+//
+// The file is not re-serialized: the settings are blanked out of it, keeping
+// its lines, so that the rest of the file is read as written and errors still
+// refer to its lines.
+func takeStateFileSettings(b []byte) (stateFileSettings, []byte, error) {
+	var settings stateFileSettings
+	var document struct {
+		Info map[string]interface{} `json:"_info"`
+	}
+	if err := yaml.Unmarshal(b, &document); err != nil {
+		// reported when the file is read
+		return settings, nil, nil
+	}
+	info := document.Info
+	if !slices.ContainsFunc(stateFileSettingKeys, func(key string) bool {
+		_, ok := info[key]
+		return ok
+	}) {
+		return settings, nil, nil
+	}
+
+	var err error
+	if value, ok := info["ignore_fields"]; ok {
+		if settings.ignoreFields, err = parseStateFileIgnoreFields(value); err != nil {
+			return settings, nil, err
+		}
+	}
+	if value, ok := info["max_deletes_per_type"]; ok {
+		if settings.maxDeletesPerType, err = parseStateFileMaxDeletesPerType(value); err != nil {
+			return settings, nil, err
+		}
+	}
+	if value, ok := info["protected"]; ok {
+		if settings.protected, err = parseStateFileProtection(value); err != nil {
+			return settings, nil, err
+		}
+	}
+
+	var stripped []byte
+	if json.Valid(b) {
+		stripped, err = blankJSONStateFileSettings(b)
+	} else {
+		stripped, err = blankYAMLStateFileSettings(b)
+	}
+	if err != nil {
+		return settings, nil, fmt.Errorf("reading _info: %w", err)
+	}
+	return settings, stripped, nil
+}
+
+// blankYAMLStateFileSettings blanks the lines of the settings of the _info of
+// a YAML state file. _info must be a block mapping.
+func blankYAMLStateFileSettings(b []byte) ([]byte, error) {
+	var root yamlv3.Node
+	if err := yamlv3.Unmarshal(b, &root); err != nil {
+		return nil, err
+	}
+	if len(root.Content) == 0 || root.Content[0].Kind != yamlv3.MappingNode {
+		return nil, fmt.Errorf("the file must be an object")
+	}
+	lines := bytes.SplitAfter(b, []byte("\n"))
+	document := root.Content[0]
+	for i := 0; i < len(document.Content); i += 2 {
+		if document.Content[i].Value != "_info" {
+			continue
+		}
+		info := document.Content[i+1]
+		if info.Kind != yamlv3.MappingNode || info.Style&yamlv3.FlowStyle != 0 {
+			return nil, fmt.Errorf("settings can only be declared in a block mapping")
+		}
+		// the last entry runs up to the next field of the file
+		end := len(lines) + 1
+		if i+2 < len(document.Content) {
+			end = document.Content[i+2].Line
+		}
+		empty := true
+		for j := len(info.Content) - 2; j >= 0; j -= 2 {
+			key := info.Content[j]
+			if slices.Contains(stateFileSettingKeys, key.Value) {
+				for line := key.Line; line < end; line++ {
+					lines[line-1] = lineEnding(lines[line-1])
+				}
+			} else {
+				empty = false
+			}
+			end = key.Line
+		}
+		if empty {
+			lines[document.Content[i].Line-1] = lineEnding(lines[document.Content[i].Line-1])
+		}
+	}
+	return bytes.Join(lines, nil), nil
+}
+
+// blankJSONStateFileSettings removes the members of the settings of the _info
+// of a JSON state file, with their commas, keeping the lines of the file.
+func blankJSONStateFileSettings(b []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return nil, fmt.Errorf("the file must be an object")
+	}
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		if key != "_info" {
+			var value json.RawMessage
+			if err := decoder.Decode(&value); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+			return nil, fmt.Errorf("_info must be an object")
+		}
+
+		// a member spans from the end of the previous one, or the opening
+		// brace, to the end of its value
+		type member struct {
+			start, end int
+			setting    bool
+		}
+		var members []member
+		start := int(decoder.InputOffset())
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			var value json.RawMessage
+			if err := decoder.Decode(&value); err != nil {
+				return nil, err
+			}
+			end := int(decoder.InputOffset())
+			members = append(members, member{start, end, slices.Contains(stateFileSettingKeys, key.(string))})
+			start = end
+		}
+
+		var spans [][2]int
+		kept := slices.IndexFunc(members, func(m member) bool { return !m.setting })
+		switch {
+		case kept < 0:
+			return removeSpans(b, [][2]int{{members[0].start, members[len(members)-1].end}}), nil
+		case kept > 0:
+			// the members before the first kept one go with the comma that
+			// follows them
+			comma := members[kept].start + bytes.IndexByte(b[members[kept].start:], ',')
+			spans = append(spans, [2]int{members[0].start, comma + 1})
+		}
+		for _, m := range members[kept+1:] {
+			if m.setting {
+				// with the comma that precedes it
+				spans = append(spans, [2]int{m.start, m.end})
+			}
+		}
+		return removeSpans(b, spans), nil
+	}
+	return b, nil
+}
+
+// lineEnding returns the line ending of a line, to blank it.
+func lineEnding(line []byte) []byte {
+	return line[len(bytes.TrimRight(line, "\r\n")):]
+}
+
+// removeSpans removes the ordered spans of b, except for their line breaks.
+func removeSpans(b []byte, spans [][2]int) []byte {
+	stripped := make([]byte, 0, len(b))
+	last := 0
+	for _, span := range spans {
+		stripped = append(stripped, b[last:span[0]]...)
+		lineBreaks := bytes.Count(b[span[0]:span[1]], []byte("\n"))
+		stripped = append(stripped, bytes.Repeat([]byte("\n"), lineBreaks)...)
+		last = span[1]
+	}
+	return append(stripped, b[last:]...)
+}
+
+func parseStateFileIgnoreFields(value interface{}) ([]ignoreFieldRule, error) {
+	list, _ := value.([]interface{})
+	if value != nil && list == nil {
+		return nil, fmt.Errorf("_info.ignore_fields must be a list of 'type:path' strings")
+	}
+	values := make([]string, 0, len(list))
+	for _, v := range list {
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("_info.ignore_fields must be a list of 'type:path' strings")
+		}
+		values = append(values, s)
+	}
+	return parseIgnoreFieldRules("_info.ignore_fields", values)
+}
+
+func parseStateFileMaxDeletesPerType(value interface{}) (map[string]int, error) {
+	limits, _ := value.(map[string]interface{})
+	if value != nil && limits == nil {
+		return nil, fmt.Errorf("_info.max_deletes_per_type must map entity kinds to limits, e.g. 'route: 10'")
+	}
+	parsed := make(map[string]int, len(limits))
+	for kind, limit := range limits {
+		n, ok := limit.(float64)
+		if !ok || n < 0 || n != math.Trunc(n) || n > math.MaxInt32 {
+			return nil, fmt.Errorf("invalid _info.max_deletes_per_type limit for %q, "+
+				"expected a non-negative integer", kind)
+		}
+		parsed[kind] = int(n)
+	}
+	return parsed, nil
+}
@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/kong/go-database-reconciler/pkg/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTakeStateFileSettings(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+		tags    []string
+	}{
+		{
+			name: "yaml",
+			content: `# orders
+_format_version: "3.0"
+_info:
+  ignore_fields:
+  - target:weight # managed by the autoscaler
+  select_tags: [team-a]
+  max_deletes_per_type:
+    route: 10
+services:
+- name: orders
+  host: orders # internal
+`,
+			want: `# orders
+_format_version: "3.0"
+_info:
+
+
+  select_tags: [team-a]
+
+
+services:
+- name: orders
+  host: orders # internal
+`,
+			tags: []string{"team-a"},
+		},
+		{
+			name: "yaml, only settings",
+			content: `_format_version: "3.0"
+_info:
+  protected:
+  - service:orders
+services:
+- name: orders
+  host: orders
+`,
+			want: `_format_version: "3.0"
+
+
+
+services:
+- name: orders
+  host: orders
+`,
+		},
+		{
+			name: "json, settings first",
+			content: `{
+  "_format_version": "3.0",
+  "_info": {
+    "max_deletes_per_type": {"route": 10},
+    "ignore_fields": ["target:weight"],
+    "select_tags": ["team-a"]
+  }
+}
+`,
+			want: `{
+  "_format_version": "3.0",
+  "_info": {
+
+
+    "select_tags": ["team-a"]
+  }
+}
+`,
+			tags: []string{"team-a"},
+		},
+		{
+			name:    "json, settings last",
+			content: `{"_format_version": "3.0", "_info": {"select_tags": ["team-a"], "protected": ["service:orders"]}}`,
+			want:    `{"_format_version": "3.0", "_info": {"select_tags": ["team-a"]}}`,
+			tags:    []string{"team-a"},
+		},
+		{
+			name:    "json, only settings",
+			content: `{"_format_version": "3.0", "_info": {"protected": ["service:orders"]}}`,
+			want:    `{"_format_version": "3.0", "_info": {}}`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			settings, stripped, err := takeStateFileSettings([]byte(tc.content))
+			require.NoError(t, err)
+			assert.True(t, len(settings.ignoreFields) > 0 || settings.maxDeletesPerType != nil ||
+				settings.protected != nil)
+			// the file is blanked, not re-serialized
+			assert.Equal(t, tc.want, string(stripped))
+
+			content, err := file.GetContentFromReader(bytes.NewReader(stripped), file.EnvVarsSkip)
+			require.NoError(t, err)
+			if tc.tags != nil {
+				assert.Equal(t, tc.tags, content.Info.SelectorTags)
+			}
+		})
+	}
+
+	_, stripped, err := takeStateFileSettings([]byte("_format_version: \"3.0\"\nservices:\n- name: protected\n"))
+	require.NoError(t, err)
+	assert.Nil(t, stripped, "files without settings are read as they are")
+
+	_, _, err = takeStateFileSettings([]byte("_info: {protected: [service:orders]}\n"))
+	require.ErrorContains(t, err, "settings can only be declared in a block mapping")
+}
@@ -0,0 +1,312 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kong/go-database-reconciler/pkg/diff"
+)
+
+// stateSecretExpr matches template expressions calling the secret functions
+// of state files, see stateSecrets.
+var stateSecretExpr = regexp.MustCompile(`\$\{\{[^}]*\b(file|vault|sops)\b`)
+
+// maskedSecretValue replaces the resolved secrets in diffs, as the values of
+// DECK_ environment variables are.
+const maskedSecretValue = "[masked]"
+
+// maskedSecrets are the resolved secrets, masked in diffs. They are kept in
+// the process, and never exported to the environment of child processes.
+var (
+	maskedSecrets   []string
+	maskedSecretsMu sync.Mutex
+)
+
+// usesStateSecrets reports whether a state file calls secret functions.
+func usesStateSecrets(content []byte) bool {
+	for _, line := range strings.Split(string(content), "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "#") && stateSecretExpr.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// stateSecrets resolves the secrets state files refer to:
+//
+//	${{ file "/run/secrets/db-password" }}   the content of a file
+//	${{ vault "secret/orders#password" }}    a field of a Vault KV secret
+//	${{ sops "secrets.enc.yaml" "db.password" }}
+//	                                          a value of a SOPS encrypted file
+//
+// Vault is reached with VAULT_ADDR, VAULT_TOKEN (or ~/.vault-token) and
+// VAULT_NAMESPACE. SOPS files are decrypted with the sops binary, which reads
+// the age key of SOPS_AGE_KEY_FILE or SOPS_AGE_KEY.
+type stateSecrets struct {
+	// mock resolves secrets to their references, like file.EnvVarsMock does
+	// for environment variables.
+	mock bool
+
+	vaultSecrets map[string]map[string]interface{}
+	sopsFiles    map[string]map[string]interface{}
+	client       *http.Client
+}
+
+func (s *stateSecrets) funcs() map[string]interface{} {
+	return map[string]interface{}{
+		"file":  s.file,
+		"vault": s.vault,
+		"sops":  s.sops,
+	}
+}
+
+// file returns the content of the file filename, without its trailing line
+// break.
+func (s *stateSecrets) file(filename string) (string, error) {
+	if s.mock {
+		return "file:" + filename, nil
+	}
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return "", fmt.Errorf("reading secret file: %w", err)
+	}
+	value := strings.TrimSuffix(strings.TrimSuffix(string(b), "\n"), "\r")
+	return maskSecret(value), nil
+}
+
+// vault returns a field of a secret of a KV secrets engine of Vault, the
+// reference being mount/path#field. KV version 2 is tried first.
+func (s *stateSecrets) vault(ref string) (string, error) {
+	if s.mock {
+		return "vault:" + ref, nil
+	}
+	path, field, ok := strings.Cut(ref, "#")
+	mount, secretPath, hasPath := strings.Cut(path, "/")
+	if !ok || field == "" || !hasPath || mount == "" || secretPath == "" {
+		return "", fmt.Errorf("invalid Vault secret reference '%s', expected mount/path#field", ref)
+	}
+
+	secret, ok := s.vaultSecrets[path]
+	if !ok {
+		var err error
+		if secret, err = s.readVaultSecret(mount, secretPath); err != nil {
+			return "", fmt.Errorf("reading Vault secret '%s': %w", path, err)
+		}
+		if s.vaultSecrets == nil {
+			s.vaultSecrets = map[string]map[string]interface{}{}
+		}
+		s.vaultSecrets[path] = secret
+	}
+	value, ok := secret[field]
+	if !ok {
+		return "", fmt.Errorf("the Vault secret '%s' has no field '%s'", path, field)
+	}
+	return secretString(ref, value)
+}
+
+func (s *stateSecrets) readVaultSecret(mount, path string) (map[string]interface{}, error) {
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return nil, fmt.Errorf("VAULT_ADDR is not set")
+	}
+	token := os.Getenv("VAULT_TOKEN")
+	if token == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			if b, err := os.ReadFile(filepath.Join(home, ".vault-token")); err == nil {
+				token = strings.TrimSpace(string(b))
+			}
+		}
+	}
+	if token == "" {
+		return nil, fmt.Errorf("VAULT_TOKEN is not set")
+	}
+
+	var response struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	found, err := s.vaultGet(addr, token, mount+"/data/"+path, &response)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		// KV version 2 nests the secret and its metadata
+		data, _ := response.Data["data"].(map[string]interface{})
+		if data == nil {
+			return nil, fmt.Errorf("secret not found")
+		}
+		return data, nil
+	}
+	if found, err = s.vaultGet(addr, token, mount+"/"+path, &response); err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("secret not found")
+	}
+	return response.Data, nil
+}
+
+// vaultGet reads the Vault API path into v. It returns false if the path does
+// not exist.
+func (s *stateSecrets) vaultGet(addr, token, path string, v interface{}) (bool, error) {
+	client := s.client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	endpoint, err := url.JoinPath(addr, "v1", path)
+	if err != nil {
+		return false, err
+	}
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if namespace := os.Getenv("VAULT_NAMESPACE"); namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("unexpected response from Vault: %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return false, fmt.Errorf("decoding Vault response: %w", err)
+	}
+	return true, nil
+}
+
+// sops returns the value at the dotted key path of a SOPS encrypted file,
+// list items being referred to by their index.
+func (s *stateSecrets) sops(filename, key string) (string, error) {
+	ref := filename + "#" + key
+	if s.mock {
+		return "sops:" + ref, nil
+	}
+	data, ok := s.sopsFiles[filename]
+	if !ok {
+		var err error
+		if data, err = decryptSopsFile(filename); err != nil {
+			return "", err
+		}
+		if s.sopsFiles == nil {
+			s.sopsFiles = map[string]map[string]interface{}{}
+		}
+		s.sopsFiles[filename] = data
+	}
+
+	var value interface{} = data
+	for _, field := range strings.Split(key, ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			value, ok = v[field]
+		case []interface{}:
+			i, err := strconv.Atoi(field)
+			ok = err == nil && i >= 0 && i < len(v)
+			if ok {
+				value = v[i]
+			}
+		default:
+			ok = false
+		}
+		if !ok {
+			return "", fmt.Errorf("the SOPS file '%s' has no value at '%s'", filename, key)
+		}
+	}
+	return secretString(ref, value)
+}
+
+func decryptSopsFile(filename string) (map[string]interface{}, error) {
+	sopsPath, err := exec.LookPath("sops")
+	if err != nil {
+		return nil, fmt.Errorf("the sops binary is required to decrypt '%s': %w", filename, err)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(sopsPath, "--decrypt", "--output-type", "json", filename)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("decrypting SOPS file '%s': %w: %s",
+			filename, err, strings.TrimSpace(stderr.String()))
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal(stdout.Bytes(), &data); err != nil {
+		return nil, fmt.Errorf("decrypting SOPS file '%s': %w", filename, err)
+	}
+	return data, nil
+}
+
+// secretString returns a secret value as a string, and masks it.
+func secretString(ref string, value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return maskSecret(v), nil
+	case float64, bool, json.Number:
+		return maskSecret(fmt.Sprint(v)), nil
+	default:
+		return "", fmt.Errorf("secret '%s' is not a string, number or boolean", ref)
+	}
+}
+
+// maskSecret records value for diffs to mask it, unless
+// --no-mask-deck-env-vars-value is given, see maskDiffValues.
+func maskSecret(value string) string {
+	maskedSecretsMu.Lock()
+	defer maskedSecretsMu.Unlock()
+	if value != "" && !slices.Contains(maskedSecrets, value) {
+		maskedSecrets = append(maskedSecrets, value)
+	}
+	return value
+}
+
+// maskResolvedSecrets replaces the resolved secrets in s, as they are or as
+// escaped in JSON strings, the longest first.
+func maskResolvedSecrets(s string) string {
+	maskedSecretsMu.Lock()
+	secrets := slices.Clone(maskedSecrets)
+	maskedSecretsMu.Unlock()
+	slices.SortFunc(secrets, func(a, b string) int { return len(b) - len(a) })
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, maskedSecretValue)
+		if escaped, err := json.Marshal(secret); err == nil {
+			s = strings.ReplaceAll(s, string(escaped[1:len(escaped)-1]), maskedSecretValue)
+		}
+	}
+	return s
+}
+
+// maskDiffValues masks the values of DECK_ environment variables, and the
+// resolved secrets of state files, in diff output.
+func maskDiffValues(s string) string {
+	return maskResolvedSecrets(diff.MaskEnvVarValue(s))
+}
+
+// maskingPrintln returns println masking the diffs it prints, unless
+// --no-mask-deck-env-vars-value is given. The syncer masks the values of
+// DECK_ environment variables only.
+func maskingPrintln(println func(a ...any)) func(a ...any) {
+	return func(a ...any) {
+		if noMaskValues {
+			println(a...)
+			return
+		}
+		println(maskResolvedSecrets(strings.TrimSuffix(fmt.Sprintln(a...), "\n")))
+	}
+}
@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// resetMaskedSecrets forgets the secrets a test resolves.
+func resetMaskedSecrets(t *testing.T) {
+	t.Helper()
+	t.Cleanup(func() {
+		maskedSecretsMu.Lock()
+		defer maskedSecretsMu.Unlock()
+		maskedSecrets = nil
+	})
+}
+
+func TestUsesStateSecrets(t *testing.T) {
+	assert.True(t, usesStateSecrets([]byte(`password: ${{ file "/run/secrets/db" }}`)))
+	assert.True(t, usesStateSecrets([]byte(`password: ${{vault "kv/db#password"}}`)))
+	assert.False(t, usesStateSecrets([]byte(`# password: ${{ sops "s.yaml" "db" }}`)))
+	assert.False(t, usesStateSecrets([]byte(`password: ${{ env "DECK_FILE" }}`)))
+}
+
+func TestStateSecretsFile(t *testing.T) {
+	resetMaskedSecrets(t)
+	secret := filepath.Join(t.TempDir(), "db-password")
+	require.NoError(t, os.WriteFile(secret, []byte("s3cr3t-from-file\n"), 0o600))
+
+	tmpl := &stateTemplate{}
+	rendered, err := tmpl.render("kong.yaml", []byte(`password: ${{ file "`+secret+`" }}`))
+	require.NoError(t, err)
+	assert.Equal(t, "password: s3cr3t-from-file", string(rendered))
+	assert.Equal(t, "password: [masked]", maskDiffValues(string(rendered)))
+	for _, env := range os.Environ() {
+		assert.NotContains(t, env, "s3cr3t-from-file", "secrets are not exported to child processes")
+	}
+
+	tmpl = &stateTemplate{mockEnvVars: true}
+	rendered, err = tmpl.render("kong.yaml", []byte(`password: ${{ file "`+secret+`" }}`))
+	require.NoError(t, err)
+	assert.Equal(t, "password: file:"+secret, string(rendered))
+
+	_, err = (&stateTemplate{}).render("kong.yaml", []byte(`password: ${{ file "missing" }}`))
+	require.ErrorContains(t, err, "reading secret file")
+}
+
+func TestMaskResolvedSecrets(t *testing.T) {
+	resetMaskedSecrets(t)
+	maskSecret("it's a \"secret\"")
+	maskSecret("secret")
+	assert.Equal(t, `{"key": "[masked]", "other": "[masked]"}`,
+		maskResolvedSecrets(`{"key": "it's a \"secret\"", "other": "secret"}`))
+
+	var printed []any
+	println := maskingPrintln(func(a ...any) { printed = a })
+	println("updating consumer", "alice", `{"key": "secret"}`)
+	assert.Equal(t, []any{`updating consumer alice {"key": "[masked]"}`}, printed)
+
+	noMaskValues = true
+	defer func() { noMaskValues = false }()
+	println("secret")
+	assert.Equal(t, []any{"secret"}, printed)
+}
+
+func TestStateSecretsVault(t *testing.T) {
+	resetMaskedSecrets(t)
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path)
+		if r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/orders":
+			_, _ = w.Write([]byte(`{"data": {"data": {"password": "kv2-password", "port": 5432}}}`))
+		case "/v1/kv/orders":
+			_, _ = w.Write([]byte(`{"data": {"password": "kv1-password"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	t.Setenv("VAULT_ADDR", server.URL)
+	t.Setenv("VAULT_TOKEN", "token")
+
+	secrets := &stateSecrets{}
+	value, err := secrets.vault("secret/orders#password")
+	require.NoError(t, err)
+	assert.Equal(t, "kv2-password", value)
+	value, err = secrets.vault("secret/orders#port")
+	require.NoError(t, err)
+	assert.Equal(t, "5432", value)
+	value, err = secrets.vault("kv/orders#password")
+	require.NoError(t, err)
+	assert.Equal(t, "kv1-password", value)
+	// secrets are read once
+	assert.Equal(t, []string{"/v1/secret/data/orders", "/v1/kv/data/orders", "/v1/kv/orders"}, requests)
+
+	_, err = secrets.vault("secret/orders#user")
+	require.EqualError(t, err, "the Vault secret 'secret/orders' has no field 'user'")
+	_, err = secrets.vault("secret/orders")
+	require.ErrorContains(t, err, "expected mount/path#field")
+	_, err = secrets.vault("secret/missing#password")
+	require.EqualError(t, err, "reading Vault secret 'secret/missing': secret not found")
+
+	t.Setenv("VAULT_TOKEN", "other")
+	_, err = (&stateSecrets{}).vault("secret/orders#password")
+	require.ErrorContains(t, err, "unexpected response from Vault: 403 Forbidden")
+}
+
+func TestStateSecretsSops(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the sops stub is a shell script")
+	}
+	resetMaskedSecrets(t)
+	// a stub of sops, checking it is given the age key of the environment
+	bin := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(bin, "sops"), []byte(`#!/bin/sh
+[ "$SOPS_AGE_KEY_FILE" = "keys.txt" ] || { echo "no age key" >&2; exit 1; }
+[ "$4" = "secrets.enc.yaml" ] || { echo "$4: no such file" >&2; exit 1; }
+echo '{"db": {"password": "sops-password", "hosts": ["a", "b"]}}'
+`), 0o700))
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	secrets := &stateSecrets{}
+	_, err := secrets.sops("secrets.enc.yaml", "db.password")
+	require.ErrorContains(t, err, "no age key")
+
+	t.Setenv("SOPS_AGE_KEY_FILE", "keys.txt")
+	value, err := secrets.sops("secrets.enc.yaml", "db.password")
+	require.NoError(t, err)
+	assert.Equal(t, "sops-password", value)
+	value, err = secrets.sops("secrets.enc.yaml", "db.hosts.1")
+	require.NoError(t, err)
+	assert.Equal(t, "b", value)
+
+	_, err = secrets.sops("secrets.enc.yaml", "db.user")
+	require.EqualError(t, err, "the SOPS file 'secrets.enc.yaml' has no value at 'db.user'")
+	_, err = secrets.sops("secrets.enc.yaml", "db")
+	require.EqualError(t, err, "secret 'secrets.enc.yaml#db' is not a string, number or boolean")
+	_, err = secrets.sops("other.enc.yaml", "db.password")
+	require.ErrorContains(t, err, "other.enc.yaml: no such file")
+}
+
+func TestReadStateFilesResolvesSecrets(t *testing.T) {
+	resetMaskedSecrets(t)
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "key-secret")
+	require.NoError(t, os.WriteFile(secret, []byte("s3cr3t"), 0o600))
+	state := filepath.Join(dir, "kong.yaml")
+	require.NoError(t, os.WriteFile(state, []byte(`_format_version: "3.0"
+consumers:
+- username: ${{ env "DECK_CONSUMER" }}
+  keyauth_credentials:
+  - key: ${{ file "`+secret+`" }}
+`), 0o600))
+	t.Setenv("DECK_CONSUMER", "alice")
+
+	content, _, err := readStateFiles([]string{state})
+	require.NoError(t, err)
+	require.Len(t, content.Consumers, 1)
+	assert.Equal(t, "alice", *content.Consumers[0].Username)
+	require.Len(t, content.Consumers[0].KeyAuths, 1)
+	assert.Equal(t, "s3cr3t", *content.Consumers[0].KeyAuths[0].Key)
+}
@@ -0,0 +1,364 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/kong/go-apiops/filebasics"
+)
+
+// stateEnvVarPrefix is the prefix of the environment variables state files
+// can read, as the reconciler requires.
+const stateEnvVarPrefix = "DECK_"
+
+// stateTemplateExpr matches template expressions, which are not evaluated on
+// YAML comment lines.
+var stateTemplateExpr = regexp.MustCompile(`\$\{\{[^}]*\}\}`)
+
+// stateTemplate renders state files as templates with values, before they
+// are read. The templates use the syntax of the environment variable
+// substitution of the reconciler, ${{ ... }}, with its functions, and the
+// values under .Values.
+type stateTemplate struct {
+	values map[string]interface{}
+	// mockEnvVars renders the names of environment variables instead of their
+	// values, like file.EnvVarsMock.
+	mockEnvVars bool
+	// strict fails on references to undefined values, and, see unusedValues,
+	// on values no template refers to.
+	strict bool
+	// refs are the paths of the values the rendered templates refer to.
+	refs [][]string
+	// secrets resolves the secret functions, see stateSecrets.
+	secrets *stateSecrets
+}
+
+// loadTemplateValues reads values files, the values of later files override
+// those of earlier ones. Objects are merged key by key, other values are
+// replaced.
+func loadTemplateValues(filenames []string) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	for _, filename := range filenames {
+		fileValues, err := filebasics.DeserializeFile(filename)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read values file '%s'; %w", filename, err)
+		}
+		values = mergeTemplateValues(values, fileValues)
+	}
+	return values, nil
+}
+
+func mergeTemplateValues(values, overrides map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		result[key] = value
+	}
+	for key, override := range overrides {
+		existing, wasObject := result[key].(map[string]interface{})
+		object, isObject := override.(map[string]interface{})
+		if wasObject && isObject {
+			result[key] = mergeTemplateValues(existing, object)
+			continue
+		}
+		result[key] = override
+	}
+	return result
+}
+
+func (t *stateTemplate) funcs() template.FuncMap {
+	env := func(key string) (string, error) {
+		if !strings.HasPrefix(key, stateEnvVarPrefix) {
+			return "", fmt.Errorf("environment variables in the state file must "+
+				"be prefixed with '%s', found: '%s'", stateEnvVarPrefix, key)
+		}
+		if t.mockEnvVars {
+			return key, nil
+		}
+		value, exists := os.LookupEnv(key)
+		if !exists {
+			return "", fmt.Errorf("environment variable '%s' present in state file but not set", key)
+		}
+		return value, nil
+	}
+	funcs := template.FuncMap{
+		"env":     env,
+		"toBool":  strconv.ParseBool,
+		"toInt":   strconv.Atoi,
+		"toFloat": func(s string) (float64, error) { return strconv.ParseFloat(s, 64) },
+		"indent": func(spaces int, v string) string {
+			return strings.ReplaceAll(v, "\n", "\n"+strings.Repeat(" ", spaces))
+		},
+	}
+	if t.secrets == nil {
+		t.secrets = &stateSecrets{mock: t.mockEnvVars}
+	}
+	for name, f := range t.secrets.funcs() {
+		funcs[name] = f
+	}
+	if t.mockEnvVars {
+		funcs["toBool"] = func(string) (bool, error) { return false, nil }
+		funcs["toInt"] = func(string) (int, error) { return 42, nil }
+		funcs["toFloat"] = func(string) (float64, error) { return 42, nil }
+	}
+	return funcs
+}
+
+// render renders the state file name.
+func (t *stateTemplate) render(name string, content []byte) ([]byte, error) {
+	// expressions on YAML comment lines are kept as they are, as the
+	// reconciler does
+	placeholders := map[string]string{}
+	lines := strings.Split(string(content), "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			lines[i] = stateTemplateExpr.ReplaceAllStringFunc(line, func(expr string) string {
+				placeholder := fmt.Sprintf("__DECK_TEMPLATE_%d__", len(placeholders))
+				placeholders[placeholder] = expr
+				return placeholder
+			})
+		}
+	}
+
+	missingKey := "missingkey=default"
+	if t.strict {
+		missingKey = "missingkey=error"
+	}
+	tmpl, err := template.New(filepath.Base(name)).Funcs(t.funcs()).Delims("${{", "}}").
+		Option(missingKey).Parse(strings.Join(lines, "\n"))
+	if err != nil {
+		return nil, fmt.Errorf("parsing template: %w", err)
+	}
+	for _, defined := range tmpl.Templates() {
+		if defined.Tree != nil {
+			t.refs = append(t.refs, templateValueRefs(defined.Tree.Root)...)
+		}
+	}
+
+	var buffer bytes.Buffer
+	if err := tmpl.Execute(&buffer, map[string]interface{}{"Values": t.values}); err != nil {
+		return nil, fmt.Errorf("rendering template: %w", err)
+	}
+	rendered := buffer.String()
+	if !t.strict {
+		// undefined values render empty
+		rendered = strings.ReplaceAll(rendered, "<no value>", "")
+	}
+	for placeholder, expr := range placeholders {
+		rendered = strings.ReplaceAll(rendered, placeholder, expr)
+	}
+	return []byte(rendered), nil
+}
+
+// templateValueRefs returns the paths of the values node refers to, as
+// .Values.a.b or $.Values.a.b.
+func templateValueRefs(node parse.Node) [][]string {
+	var refs [][]string
+	var walk func(parse.Node)
+	walkBranch := func(branch *parse.BranchNode) {
+		walk(branch.Pipe)
+		walk(branch.List)
+		if branch.ElseList != nil {
+			walk(branch.ElseList)
+		}
+	}
+	walk = func(node parse.Node) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, child := range n.Nodes {
+				walk(child)
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe)
+		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
+			for _, cmd := range n.Cmds {
+				walk(cmd)
+			}
+		case *parse.CommandNode:
+			for _, arg := range n.Args {
+				walk(arg)
+			}
+		case *parse.ChainNode:
+			walk(n.Node)
+		case *parse.IfNode:
+			walkBranch(&n.BranchNode)
+		case *parse.RangeNode:
+			walkBranch(&n.BranchNode)
+		case *parse.WithNode:
+			walkBranch(&n.BranchNode)
+		case *parse.TemplateNode:
+			walk(n.Pipe)
+		case *parse.FieldNode:
+			if len(n.Ident) > 0 && n.Ident[0] == "Values" {
+				refs = append(refs, n.Ident[1:])
+			}
+		case *parse.VariableNode:
+			if len(n.Ident) > 1 && n.Ident[0] == "$" && n.Ident[1] == "Values" {
+				refs = append(refs, n.Ident[2:])
+			}
+		}
+	}
+	walk(node)
+	return refs
+}
+
+// unusedValues returns the values that none of the rendered templates refer
+// to, as dotted paths. A value is used when a template refers to it, to one of
+// the objects it is in, or to a value in it.
+func (t *stateTemplate) unusedValues() []string {
+	var unused []string
+	var walk func(path []string, value interface{})
+	walk = func(path []string, value interface{}) {
+		if object, ok := value.(map[string]interface{}); ok && len(object) > 0 {
+			for key, child := range object {
+				walk(append(append([]string{}, path...), key), child)
+			}
+			return
+		}
+		for _, ref := range t.refs {
+			if isPathPrefix(ref, path) || isPathPrefix(path, ref) {
+				return
+			}
+		}
+		unused = append(unused, strings.Join(path, "."))
+	}
+	for key, value := range t.values {
+		walk([]string{key}, value)
+	}
+	sort.Strings(unused)
+	return unused
+}
+
+func isPathPrefix(prefix, path []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// stateFile is the content of a state file.
+type stateFile struct {
+	name    string
+	content []byte
+	// modified is set when content differs from the file, which must then be
+	// staged to be read.
+	modified bool
+}
+
+// readStateFileContents reads state files, or the state files in
+// directories, "-" being stdin. Encrypted files are decrypted, see
+// decryptStateFile.
+func readStateFileContents(filenames []string) ([]stateFile, error) {
+	var files []stateFile
+	for _, fileOrDir := range filenames {
+		names, err := stateFileNames(fileOrDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			var b []byte
+			if name == "-" {
+				b, err = io.ReadAll(os.Stdin)
+			} else {
+				b, err = os.ReadFile(name)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("reading state file: %w", err)
+			}
+			files = append(files, stateFile{name: name, content: b})
+		}
+	}
+	if err := decryptStateFiles(files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// renderFiles renders files in place, see render.
+func (t *stateTemplate) renderFiles(files []stateFile) error {
+	for i := range files {
+		rendered, err := t.render(files[i].name, files[i].content)
+		if err != nil {
+			return fmt.Errorf("reading file %s: %w", files[i].name, err)
+		}
+		files[i].content = rendered
+		files[i].modified = true
+	}
+	return nil
+}
+
+// stagedFileName returns the name of the i-th file staged into dir, stdin
+// being staged as stdin.yaml.
+func stagedFileName(dir string, i int, name string) string {
+	if name == "-" {
+		name = "stdin.yaml"
+	}
+	return filepath.Join(dir, fmt.Sprintf("%d-%s", i, filepath.Base(name)))
+}
+
+// renderStateTemplates renders state files, or the state files in
+// directories, "-" being stdin, into a temporary directory. The files are
+// rendered when t has values or is strict, or when they refer to secrets, see
+// stateSecrets; otherwise only stdin is staged, as it can only be read once.
+// It returns the files, whether they were rendered, and a function removing
+// the staged files.
+func renderStateTemplates(filenames []string, t *stateTemplate) ([]string, bool, func(), error) {
+	files, err := readStateFileContents(filenames)
+	if err != nil {
+		return nil, false, nil, err
+	}
+	render := t.values != nil || t.strict
+	for _, f := range files {
+		render = render || usesStateSecrets(f.content)
+	}
+	if render {
+		if err := t.renderFiles(files); err != nil {
+			return nil, false, nil, err
+		}
+		if t.strict {
+			if unused := t.unusedValues(); len(unused) > 0 {
+				return nil, false, nil, fmt.Errorf("values not used by the state files: %s",
+					strings.Join(unused, ", "))
+			}
+		}
+	}
+
+	dir, err := os.MkdirTemp("", "deck-render-")
+	if err != nil {
+		return nil, false, nil, fmt.Errorf("rendering state files: %w", err)
+	}
+	cleanup := func() { _ = os.RemoveAll(dir) }
+	staged := make([]string, 0, len(files))
+	for i, f := range files {
+		if !render && !f.modified && f.name != "-" {
+			staged = append(staged, f.name)
+			continue
+		}
+		filename := stagedFileName(dir, i, f.name)
+		if err := os.WriteFile(filename, f.content, 0o600); err != nil {
+			cleanup()
+			return nil, false, nil, fmt.Errorf("rendering state files: %w", err)
+		}
+		staged = append(staged, filename)
+	}
+	return staged, render, cleanup, nil
+}
@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const stateTemplateTest = `_format_version: "3.0"
+# ${{ .Values.commented }}
+services:
+- name: orders
+  url: ${{ .Values.orders.url }}
+  tags: [${{ env "DECK_TEAM" }}]
+  routes:
+${{- range .Values.regions }}
+  - name: orders-${{ .name }}
+${{- end }}
+${{- if .Values.rateLimit }}
+  plugins:
+  - name: rate-limiting
+${{- end }}
+`
+
+func TestLoadTemplateValues(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "base.yaml")
+	prod := filepath.Join(dir, "prod.yaml")
+	require.NoError(t, os.WriteFile(base, []byte("orders: {url: http://a, timeout: 5}\nregions: [eu, us]\n"), 0o600))
+	require.NoError(t, os.WriteFile(prod, []byte("orders: {url: http://b}\nregions: [eu]\n"), 0o600))
+
+	values, err := loadTemplateValues([]string{base, prod})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"orders":  map[string]interface{}{"url": "http://b", "timeout": float64(5)},
+		"regions": []interface{}{"eu"},
+	}, values)
+}
+
+func TestStateTemplateRender(t *testing.T) {
+	tmpl := &stateTemplate{
+		values: map[string]interface{}{
+			"orders": map[string]interface{}{"url": "http://orders"},
+			"regions": []interface{}{
+				map[string]interface{}{"name": "eu"},
+				map[string]interface{}{"name": "us"},
+			},
+		},
+		mockEnvVars: true,
+	}
+	rendered, err := tmpl.render("kong.yaml", []byte(stateTemplateTest))
+	require.NoError(t, err)
+	assert.Equal(t, `_format_version: "3.0"
+# ${{ .Values.commented }}
+services:
+- name: orders
+  url: http://orders
+  tags: [DECK_TEAM]
+  routes:
+  - name: orders-eu
+  - name: orders-us
+`, string(rendered))
+}
+
+func TestStateTemplateStrict(t *testing.T) {
+	values := map[string]interface{}{
+		"orders":    map[string]interface{}{"url": "http://orders", "timeout": 5},
+		"regions":   []interface{}{map[string]interface{}{"name": "eu"}},
+		"rateLimit": 10,
+		"unused":    map[string]interface{}{},
+	}
+
+	tmpl := &stateTemplate{values: values, mockEnvVars: true, strict: true}
+	_, err := tmpl.render("kong.yaml", []byte(stateTemplateTest))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"orders.timeout", "unused"}, tmpl.unusedValues())
+
+	delete(values, "rateLimit")
+	tmpl = &stateTemplate{values: values, mockEnvVars: true, strict: true}
+	_, err = tmpl.render("kong.yaml", []byte(stateTemplateTest))
+	require.ErrorContains(t, err, `map has no entry for key "rateLimit"`)
+}
+
+func TestRenderStateTemplates(t *testing.T) {
+	dir := t.TempDir()
+	state := filepath.Join(dir, "kong.yaml")
+	require.NoError(t, os.WriteFile(state, []byte(stateTemplateTest), 0o600))
+
+	tmpl := &stateTemplate{
+		values: map[string]interface{}{
+			"orders":  map[string]interface{}{"url": "http://orders"},
+			"regions": []interface{}{},
+			"extra":   true,
+		},
+		mockEnvVars: true,
+		strict:      true,
+	}
+	_, _, _, err := renderStateTemplates([]string{dir}, tmpl)
+	require.ErrorContains(t, err, "reading file "+state)
+
+	tmpl.values["rateLimit"] = 0
+	_, _, _, err = renderStateTemplates([]string{dir}, tmpl)
+	require.EqualError(t, err, "values not used by the state files: extra")
+
+	tmpl.strict = false
+	files, rendered, cleanup, err := renderStateTemplates([]string{dir}, tmpl)
+	require.NoError(t, err)
+	defer cleanup()
+	assert.True(t, rendered)
+	require.Len(t, files, 1)
+	b, err := os.ReadFile(files[0])
+	require.NoError(t, err)
+	assert.Contains(t, string(b), "url: http://orders\n")
+}
@@ -0,0 +1,369 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kong/go-database-reconciler/pkg/cprint"
+	"github.com/kong/go-database-reconciler/pkg/diff"
+	"github.com/kong/go-kong/kong"
+	"github.com/spf13/pflag"
+)
+
+const (
+	eventsFormatText   = "text"
+	eventsFormatNDJSON = "ndjson"
+)
+
+var (
+	syncEventsFormat     string
+	syncEventsFile       string
+	syncProgressInterval time.Duration
+	// activeSyncEvents is built from the flags above by preRunSyncEventsFlags;
+	// nil means neither events nor progress are reported.
+	activeSyncEvents *syncEventRecorder
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// syncEvent is the result of a single change made by a sync.
+type syncEvent struct {
+	Time       time.Time `json:"time"`
+	Op         string    `json:"op"`
+	EntityType string    `json:"entity_type"`
+	ID         string    `json:"id,omitempty"`
+	Name       string    `json:"name,omitempty"`
+	DurationMS int64     `json:"duration_ms"`
+	Result     string    `json:"result"`
+	Status     int       `json:"status,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// syncEventRecorder writes an event per change made by a sync, as reported by
+// the syncer, and reports its progress periodically.
+type syncEventRecorder struct {
+	filename         string
+	progressInterval time.Duration
+	// progress is written to progressOut, events to eventsOut.
+	progressOut io.Writer
+	eventsOut   io.Writer
+	now         func() time.Time
+
+	mu      sync.Mutex
+	started time.Time
+	total   int
+	done    int
+	// requestStarts are the times the first write request for an entity ID
+	// was sent, to report the duration of its change.
+	requestStarts map[string]time.Time
+}
+
+func addSyncEventsFlags(set *pflag.FlagSet) {
+	set.StringVar(&syncEventsFormat, "events-format", eventsFormatText,
+		"format of the events emitted while syncing, one of: text, ndjson.\n"+
+			"With ndjson, one JSON object is written per change made to Kong\n"+
+			"(op, entity type, name/ID, duration, result and error) to stderr or --events-file.\n"+
+			"While events or progress are reported, updates are printed without their diff.")
+	set.StringVar(&syncEventsFile, "events-file", "",
+		"append ndjson events to this file instead of writing them to stderr.")
+	set.DurationVar(&syncProgressInterval, "progress-interval", 0,
+		"print a progress line with the number of operations done and the ETA\n"+
+			"to stderr at this interval, e.g. '10s'. Disabled by default.")
+}
+
+func preRunSyncEventsFlags() error {
+	recorder, err := newSyncEventRecorder(syncEventsFormat, syncEventsFile, syncProgressInterval)
+	if err != nil {
+		return err
+	}
+	activeSyncEvents = recorder
+	return nil
+}
+
+// newSyncEventRecorder validates the events flags. It returns nil when
+// neither events nor progress are requested.
+func newSyncEventRecorder(format, filename string, progressInterval time.Duration) (*syncEventRecorder, error) {
+	switch format {
+	case eventsFormatText, eventsFormatNDJSON:
+	default:
+		return nil, fmt.Errorf("invalid --events-format %q, must be one of: %s, %s",
+			format, eventsFormatText, eventsFormatNDJSON)
+	}
+	if filename != "" && format != eventsFormatNDJSON {
+		return nil, fmt.Errorf("--events-file requires --events-format %s", eventsFormatNDJSON)
+	}
+	if progressInterval < 0 {
+		return nil, fmt.Errorf("--progress-interval cannot be negative, got %s", progressInterval)
+	}
+	if format == eventsFormatText && progressInterval == 0 {
+		return nil, nil
+	}
+	r := &syncEventRecorder{
+		filename:         filename,
+		progressInterval: progressInterval,
+		progressOut:      os.Stderr,
+		now:              time.Now,
+	}
+	if format == eventsFormatNDJSON && filename == "" {
+		r.eventsOut = os.Stderr
+	}
+	return r, nil
+}
+
+// needsTotal reports whether the number of planned operations is needed
+// to report progress.
+func (r *syncEventRecorder) needsTotal() bool {
+	return r != nil && r.progressInterval > 0
+}
+
+// setTotal sets the number of operations the sync is expected to make.
+func (r *syncEventRecorder) setTotal(changes diff.EntityChanges) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.total = len(changes.Creating) + len(changes.Updating) + len(changes.Deleting)
+}
+
+// track times the changes client makes to Kong, and reports the progress of
+// the sync, until the returned function is called. The changes are recorded
+// with recordAction.
+func (r *syncEventRecorder) track(client *kong.Client) (func(), error) {
+	if r == nil {
+		return func() {}, nil
+	}
+	var eventsFile *os.File
+	if r.filename != "" {
+		var err error
+		eventsFile, err = os.OpenFile(r.filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("opening events file: %w", err)
+		}
+		r.eventsOut = eventsFile
+	}
+
+	r.mu.Lock()
+	r.started = r.now()
+	r.done = 0
+	r.requestStarts = map[string]time.Time{}
+	r.mu.Unlock()
+
+	previous := client.Doer()
+	client.SetDoer(r.doer(client, previous))
+
+	stopProgress := make(chan struct{})
+	var wg sync.WaitGroup
+	if r.progressInterval > 0 {
+		wg.Go(func() {
+			ticker := time.NewTicker(r.progressInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-stopProgress:
+					return
+				case <-ticker.C:
+					fmt.Fprintln(r.progressOut, r.progressLine())
+				}
+			}
+		})
+	}
+
+	return func() {
+		close(stopProgress)
+		wg.Wait()
+		client.SetDoer(previous)
+		if eventsFile != nil {
+			r.mu.Lock()
+			r.eventsOut = nil
+			r.mu.Unlock()
+			_ = eventsFile.Close()
+		}
+	}, nil
+}
+
+// doer wraps the request dispatching of client to record when the writes for
+// each entity start.
+func (r *syncEventRecorder) doer(client *kong.Client, next kong.Doer) kong.Doer {
+	return func(ctx context.Context, httpClient *http.Client, req *http.Request) (*http.Response, error) {
+		if req.Method != http.MethodGet {
+			id := entityIDFromPath(req.URL.Path)
+			if id == "" && req.GetBody != nil {
+				if body, err := req.GetBody(); err == nil {
+					id = entityID(body)
+					_ = body.Close()
+				}
+			}
+			if id != "" {
+				r.mu.Lock()
+				if _, ok := r.requestStarts[id]; !ok {
+					r.requestStarts[id] = r.now()
+				}
+				r.mu.Unlock()
+			}
+		}
+		if next != nil {
+			return next(ctx, httpClient, req)
+		}
+		return client.DoRAW(ctx, req)
+	}
+}
+
+// recordAction records the result of a change made by the syncer.
+func (r *syncEventRecorder) recordAction(action diff.EntityAction) {
+	if r == nil || action.Action == "" {
+		return
+	}
+	event := syncEvent{
+		Time:       r.now(),
+		Op:         string(action.Action),
+		EntityType: action.Entity.Kind,
+		Name:       action.Entity.Name,
+		Result:     "ok",
+	}
+	event.ID = entityID(entityJSON(action.Entity.New))
+	if event.ID == "" {
+		event.ID = entityID(entityJSON(action.Entity.Old))
+	}
+	if action.Error != nil {
+		event.Result, event.Error = "error", action.Error.Error()
+		var apiErr *kong.APIError
+		if errors.As(action.Error, &apiErr) {
+			event.Status = apiErr.Code()
+		}
+	}
+
+	r.mu.Lock()
+	if start, ok := r.requestStarts[event.ID]; ok && event.ID != "" {
+		event.DurationMS = event.Time.Sub(start).Milliseconds()
+		delete(r.requestStarts, event.ID)
+	}
+	r.mu.Unlock()
+	r.record(event)
+}
+
+func (r *syncEventRecorder) record(event syncEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.done++
+	if r.eventsOut == nil {
+		return
+	}
+	b, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	_, _ = r.eventsOut.Write(append(b, '\n'))
+}
+
+// progressLine describes how many operations are done and, if the total is
+// known, when the sync is expected to finish.
+func (r *syncEventRecorder) progressLine() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	elapsed := r.now().Sub(r.started).Round(time.Second)
+	if r.total == 0 {
+		return fmt.Sprintf("progress: %d operations done, elapsed %s", r.done, elapsed)
+	}
+	done := min(r.done, r.total)
+	eta := "unknown"
+	if done > 0 {
+		remaining := time.Duration(float64(r.now().Sub(r.started)) / float64(done) * float64(r.total-done))
+		eta = remaining.Round(time.Second).String()
+	}
+	return fmt.Sprintf("progress: %d/%d operations (%.1f%%), elapsed %s, ETA %s",
+		done, r.total, float64(done)*100/float64(r.total), elapsed, eta)
+}
+
+// entityIDFromPath returns the ID of the entity addressed by an Admin API
+// path, e.g. the ID of '/services/<id>', if any. Workspace and Konnect path
+// prefixes are skipped as only the last segment is looked at.
+func entityIDFromPath(p string) string {
+	segments := strings.Split(strings.Trim(p, "/"), "/")
+	if last := segments[len(segments)-1]; len(segments) > 1 && uuidPattern.MatchString(last) {
+		return last
+	}
+	return ""
+}
+
+// entityJSON returns an entity of the syncer as JSON.
+func entityJSON(entity any) io.Reader {
+	if entity == nil {
+		return strings.NewReader("")
+	}
+	b, err := json.Marshal(entity)
+	if err != nil {
+		return strings.NewReader("")
+	}
+	return bytes.NewReader(b)
+}
+
+// entityID returns the ID of the entity in a JSON body, if any.
+func entityID(body io.Reader) string {
+	var entity map[string]any
+	if err := json.NewDecoder(body).Decode(&entity); err != nil {
+		return ""
+	}
+	id, _ := entity["id"].(string)
+	return id
+}
+
+// consumeActions records the changes the syncer s reports until its result
+// channel is closed, and prints them as it would have: without the diff of
+// updates, as the syncer does not report it. With collect, the changes are
+// returned by the returned function instead, for the JSON report.
+func (r *syncEventRecorder) consumeActions(s *diff.Syncer, collect bool) func() diff.EntityChanges {
+	changes := diff.EntityChanges{
+		Creating: []diff.EntityState{},
+		Updating: []diff.EntityState{},
+		Deleting: []diff.EntityState{},
+	}
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		for action := range s.GetResultChan() {
+			if action.Action == "" {
+				continue
+			}
+			r.recordAction(action)
+			item := diff.EntityState{
+				Body: map[string]any{"old": action.Entity.Old, "new": action.Entity.New},
+				Name: action.Entity.Name,
+				Kind: action.Entity.Kind,
+			}
+			switch action.Action {
+			case diff.CreateAction:
+				if collect {
+					changes.Creating = append(changes.Creating, item)
+				} else {
+					maskingPrintln(cprint.CreatePrintln)("creating", item.Kind, item.Name)
+				}
+			case diff.UpdateAction:
+				if collect {
+					changes.Updating = append(changes.Updating, item)
+				} else {
+					maskingPrintln(cprint.UpdatePrintln)("updating", item.Kind, item.Name)
+				}
+			case diff.DeleteAction:
+				if collect {
+					changes.Deleting = append(changes.Deleting, item)
+				} else {
+					maskingPrintln(cprint.DeletePrintln)("deleting", item.Kind, item.Name)
+				}
+			}
+		}
+	})
+	return func() diff.EntityChanges {
+		wg.Wait()
+		return changes
+	}
+}
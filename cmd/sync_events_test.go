@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kong/go-database-reconciler/pkg/diff"
+	"github.com/kong/go-database-reconciler/pkg/state"
+	reconcilerUtils "github.com/kong/go-database-reconciler/pkg/utils"
+	"github.com/kong/go-kong/kong"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSyncEventRecorder(t *testing.T) {
+	r, err := newSyncEventRecorder(eventsFormatText, "", 0)
+	require.NoError(t, err)
+	assert.Nil(t, r)
+	assert.False(t, r.needsTotal())
+
+	r, err = newSyncEventRecorder(eventsFormatText, "", time.Second)
+	require.NoError(t, err)
+	assert.True(t, r.needsTotal())
+
+	_, err = newSyncEventRecorder("json", "", 0)
+	require.EqualError(t, err, `invalid --events-format "json", must be one of: text, ndjson`)
+
+	_, err = newSyncEventRecorder(eventsFormatText, "events.ndjson", 0)
+	require.EqualError(t, err, "--events-file requires --events-format ndjson")
+
+	_, err = newSyncEventRecorder(eventsFormatNDJSON, "", -time.Second)
+	require.EqualError(t, err, "--progress-interval cannot be negative, got -1s")
+}
+
+func TestSyncEventRecorder_RecordAction(t *testing.T) {
+	const serviceID = "3f1c4e0a-9b2d-4c7e-8f6a-1d2e3c4b5a69"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"id": "` + serviceID + `", "name": "svc"}`))
+	}))
+	defer server.Close()
+	client, err := kong.NewClient(kong.String(server.URL), server.Client())
+	require.NoError(t, err)
+
+	var events bytes.Buffer
+	r, err := newSyncEventRecorder(eventsFormatNDJSON, "", 0)
+	require.NoError(t, err)
+	r.eventsOut = &events
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { return now }
+
+	stop, err := r.track(client)
+	require.NoError(t, err)
+	service := &state.Service{Service: kong.Service{ID: kong.String(serviceID), Name: kong.String("svc")}}
+	_, err = client.Services.Create(context.Background(), &service.Service)
+	require.NoError(t, err)
+	now = now.Add(1500 * time.Millisecond)
+	r.recordAction(diff.EntityAction{
+		Action: diff.CreateAction,
+		Entity: diff.Entity{Name: "svc", Kind: "service", New: service},
+	})
+	r.recordAction(diff.EntityAction{
+		Action: diff.DeleteAction,
+		Entity: diff.Entity{Name: "svc", Kind: "service", Old: service, New: service},
+		Error:  kong.NewAPIError(http.StatusNotFound, "Not found"),
+	})
+	// deletes skipped by the syncer are not changes
+	r.recordAction(diff.EntityAction{Entity: diff.Entity{Name: "svc", Kind: "service", Old: service}})
+	stop()
+	assert.Nil(t, client.Doer(), "the original dispatching is restored")
+
+	var recorded []syncEvent
+	for _, line := range strings.Split(strings.TrimSpace(events.String()), "\n") {
+		var e syncEvent
+		require.NoError(t, json.Unmarshal([]byte(line), &e))
+		e.Time = time.Time{}
+		recorded = append(recorded, e)
+	}
+	assert.Equal(t, []syncEvent{
+		{Op: "create", EntityType: "service", ID: serviceID, Name: "svc", DurationMS: 1500, Result: "ok"},
+		{Op: "delete", EntityType: "service", ID: serviceID, Name: "svc", Result: "error", Status: 404,
+			Error: "HTTP status 404 (message: \"Not found\")"},
+	}, recorded)
+	assert.Equal(t, 2, r.done)
+}
+
+func TestPerformReset_SyncEvents(t *testing.T) {
+	server, _ := newResetTestKong(t)
+	defer func(config reconcilerUtils.KongClientConfig) {
+		rootConfig, activeSyncEvents = config, nil
+	}(rootConfig)
+	rootConfig = reconcilerUtils.KongClientConfig{Address: server.URL, HTTPClient: server.Client()}
+
+	var err error
+	activeSyncEvents, err = newSyncEventRecorder(eventsFormatNDJSON, "", time.Hour)
+	require.NoError(t, err)
+	var events bytes.Buffer
+	activeSyncEvents.eventsOut = &events
+	require.NoError(t, performReset(context.Background(), []string{""}, false))
+
+	var e syncEvent
+	require.NoError(t, json.Unmarshal(events.Bytes(), &e))
+	assert.Equal(t, "delete", e.Op)
+	assert.Equal(t, "service", e.EntityType)
+	assert.Equal(t, "0b2a5e1c-6f3e-4a55-9c8e-2f1f6f0d9a01", e.ID)
+	assert.Equal(t, "svc", e.Name)
+	assert.Equal(t, "ok", e.Result)
+	assert.Equal(t, 1, activeSyncEvents.done)
+	assert.Equal(t, 1, activeSyncEvents.total)
+}
+
+func TestSyncEventRecorder_ProgressLine(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	r := &syncEventRecorder{started: now, now: func() time.Time { return now }}
+	now = now.Add(time.Minute)
+	r.done = 30
+	assert.Equal(t, "progress: 30 operations done, elapsed 1m0s", r.progressLine())
+
+	r.setTotal(diff.EntityChanges{
+		Creating: make([]diff.EntityState, 60),
+		Updating: make([]diff.EntityState, 20),
+		Deleting: make([]diff.EntityState, 20),
+	})
+	assert.Equal(t, "progress: 30/100 operations (30.0%), elapsed 1m0s, ETA 2m20s", r.progressLine())
+
+	r.done = 0
+	assert.Equal(t, "progress: 0/100 operations (0.0%), elapsed 1m0s, ETA unknown", r.progressLine())
+}
+
+func TestEntityIDFromPath(t *testing.T) {
+	id := "3f1c4e0a-9b2d-4c7e-8f6a-1d2e3c4b5a69"
+	for p, expected := range map[string]string{
+		"/services/" + id: id,
+		"/team-a/consumers/" + id + "/key-auth/" + id:      id,
+		"/v2/control-planes/cp/core-entities/routes/" + id: id,
+		"/consumer_groups/" + id + "/consumers":            "",
+		"/services/svc":                                    "",
+	} {
+		assert.Equal(t, expected, entityIDFromPath(p), p)
+	}
+}
@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+
+	"github.com/kong/deck/schemas"
+	"github.com/kong/deck/validate"
+	"github.com/kong/go-database-reconciler/pkg/schema"
+	"github.com/kong/go-database-reconciler/pkg/state"
+	reconcilerUtils "github.com/kong/go-database-reconciler/pkg/utils"
+	"github.com/kong/go-kong/kong"
+)
+
+var validateKongVersion string
+
+// validateWithSchemas validates a state against the stored schemas of the
+// Kong version set by --kong-version.
+func validateWithSchemas(ks *state.KongState) []error {
+	store := schemas.NewStore(schemaStoreDir())
+	v, err := schemas.ParseVersion(validateKongVersion)
+	if err != nil {
+		return []error{err}
+	}
+	if v, err = store.Resolve(v); err != nil {
+		return []error{fmt.Errorf("%w, run 'deck cache schemas pull' against Kong %s to cache its schemas", err, validateKongVersion)}
+	}
+	validator := validate.NewOfflineValidator(validate.OfflineValidatorOpts{
+		State:                ks,
+		Store:                store,
+		Version:              v,
+		RBACResourcesOnly:    validateCmdRBACResourcesOnly,
+		OnlineEntitiesFilter: validateCmdOnlineEntitiesFilter,
+	})
+	return validator.Validate()
+}
+
+// storeSchemas caches the schemas of the entities of a state, fetched from
+// Kong, for later offline validation. Failing to cache them does not fail
+// the validation.
+func storeSchemas(ctx context.Context, client *kong.Client, ks *state.KongState) {
+	if !useSchemaCache {
+		return
+	}
+	if err := fetchSchemas(ctx, client, ks); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: storing schemas for offline validation: %v\n", err)
+	}
+}
+
+// fetchSchemas fetches the schemas of the entities of a state through the
+// schema cache, which stores the ones not cached yet.
+func fetchSchemas(ctx context.Context, client *kong.Client, ks *state.KongState) error {
+	root, err := client.Root(ctx)
+	if err != nil {
+		return fmt.Errorf("fetching Kong version: %w", err)
+	}
+	v, err := schemas.VersionOf(kong.VersionFromInfo(root))
+	if err != nil {
+		return err
+	}
+	client.SetDoer(schemaCacheDoer(client, schemas.NewStore(schemaStoreDir()), v, nil, client.Doer()))
+
+	for fieldName, entityType := range validate.EntityMap {
+		entities, err := reconcilerUtils.CallGetAll(reflectField(ks, fieldName))
+		if err != nil || entities.Len() == 0 {
+			continue
+		}
+		if _, err := schema.FetchEntitySchema(ctx, client, false, entityType); err != nil {
+			return fmt.Errorf("fetching schema of %s: %w", entityType, err)
+		}
+	}
+
+	fetched := map[string]bool{}
+	fetch := func(kind string, name *string, fetchSchema func(string) error) error {
+		if name == nil || fetched[kind+"/"+*name] {
+			return nil
+		}
+		fetched[kind+"/"+*name] = true
+		if err := fetchSchema(*name); err != nil {
+			return fmt.Errorf("fetching schema of %s/%s: %w", kind, *name, err)
+		}
+		return nil
+	}
+	plugins, _ := ks.Plugins.GetAll()
+	for _, p := range plugins {
+		if err := fetch(schemas.KindPlugin, p.Name, func(name string) error {
+			_, err := schema.FetchPluginSchema(ctx, client, name)
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+	vaults, _ := ks.Vaults.GetAll()
+	for _, vault := range vaults {
+		if err := fetch(schemas.KindVault, vault.Name, func(name string) error {
+			_, err := schema.FetchVaultSchema(ctx, client, name, false)
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+	partials, _ := ks.Partials.GetAll()
+	for _, partial := range partials {
+		if err := fetch(schemas.KindPartial, partial.Type, func(name string) error {
+			_, err := schema.FetchPartialSchema(ctx, client, name)
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func reflectField(ks *state.KongState, name string) interface{} {
+	return reflect.ValueOf(ks).Elem().FieldByName(name).Interface()
+}
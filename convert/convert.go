@@ -92,108 +92,115 @@ func Convert(
 		return err
 	}
 
-	switch {
-	case from == FormatKongGateway && to == FormatKonnect:
-		if len(inputFilenames) > 1 {
-			return fmt.Errorf("only one input file can be provided when converting from Kong to Konnect format")
-		}
-		outputContent, err = convertKongGatewayToKonnect(inputContent)
-		if err != nil {
-			return err
-		}
-
-	case from == FormatKongGateway2x && to == FormatKongGateway3x:
-		if len(inputFilenames) > 1 {
-			return fmt.Errorf("only one input file can be provided when converting from Kong 2.x to Kong 3.x format")
-		}
-		outputContent, err = convertKongGateway2xTo3x(inputContent, inputFilenames[0], true)
-		if err != nil {
-			return err
-		}
-
-	case from == FormatDistributed && to == FormatKongGateway,
-		from == FormatDistributed && to == FormatKongGateway2x,
-		from == FormatDistributed && to == FormatKongGateway3x:
+	if from == FormatDistributed &&
+		(to == FormatKongGateway || to == FormatKongGateway2x || to == FormatKongGateway3x) {
 		outputContent, err = convertDistributedToKong(inputContent, outputFilename, outputFormat, to, diagnosticPolicy)
 		if err != nil {
 			return err
 		}
+		return file.WriteContentToFile(outputContent, outputFilename, outputFormat)
+	}
 
-	case from == FormatKongGatewayVersion28x && to == FormatKongGatewayVersion34x:
-		if len(inputFilenames) > 1 {
-			return fmt.Errorf("only one input file can be provided when converting from Kong 2.x to Kong 3.x format")
-		}
-		outputContent, err = convertKongGateway28xTo34x(inputContent, inputFilenames[0])
-		if err != nil {
+	if len(inputFilenames) > 1 {
+		if err := singleInputConversion(from, to); err != nil {
 			return err
 		}
-
-		stateFileBytes, err := filebasics.ReadFile(inputFilenames[0])
+	}
+	var stateFileBytes []byte
+	if conversionRuleset(from, to) != "" {
+		stateFileBytes, err = filebasics.ReadFile(inputFilenames[0])
 		if err != nil {
 			return fmt.Errorf("failed to read input file '%s'; %w", inputFilenames[0], err)
 		}
+	}
+	outputContent, err = ConvertContent(inputContent, stateFileBytes, inputFilenames[0], from, to)
+	if err != nil {
+		return err
+	}
 
-		lintErrs, err := lint.WithContent(stateFileBytes, []byte(ruleset28to34), "error", false)
-		if err != nil {
-			return err
-		}
+	err = file.WriteContentToFile(outputContent, outputFilename, outputFormat)
+	return err
+}
 
-		_, err = lint.GetLintOutput(lintErrs, "plain", "-")
-		if err != nil {
-			return err
-		}
+// singleInputConversion returns an error for the conversions that take a
+// single input file.
+func singleInputConversion(from, to Format) error {
+	switch {
+	case from == FormatKongGateway && to == FormatKonnect:
+		return fmt.Errorf("only one input file can be provided when converting from Kong to Konnect format")
+	case from == FormatKongGateway2x && to == FormatKongGateway3x,
+		from == FormatKongGatewayVersion28x && to == FormatKongGatewayVersion34x:
+		return fmt.Errorf("only one input file can be provided when converting from Kong 2.x to Kong 3.x format")
+	case from == FormatKongGatewayVersion34x && to == FormatKongGatewayVersion310x:
+		return fmt.Errorf("only one input file can be provided when converting from Kong 3.4 to Kong 3.10 format")
+	case from == FormatKongGatewayVersion310x && to == FormatKongGatewayVersion314x:
+		return fmt.Errorf("only one input file can be provided when converting from Kong 3.10 to Kong 3.14 format")
+	}
+	return nil
+}
 
+// conversionRuleset returns the lint ruleset that reports the configuration
+// a conversion cannot convert automatically, if any.
+func conversionRuleset(from, to Format) string {
+	switch {
+	case from == FormatKongGatewayVersion28x && to == FormatKongGatewayVersion34x:
+		return ruleset28to34
 	case from == FormatKongGatewayVersion34x && to == FormatKongGatewayVersion310x:
-		if len(inputFilenames) > 1 {
-			return fmt.Errorf("only one input file can be provided when converting from Kong 3.4 to Kong 3.10 format")
-		}
-		outputContent, err = convertKongGateway34xTo310x(inputContent)
-		if err != nil {
-			return err
-		}
+		return ruleset34to310
+	case from == FormatKongGatewayVersion310x && to == FormatKongGatewayVersion314x:
+		return ruleset310to314
+	}
+	return ""
+}
 
-		stateFileBytes, err := filebasics.ReadFile(inputFilenames[0])
-		if err != nil {
-			return fmt.Errorf("failed to read input file '%s'; %w", inputFilenames[0], err)
-		}
+// ConvertContent converts the content of a single state file from one format
+// to another. stateFileBytes are the raw bytes of the file, they are linted for
+// the configuration that cannot be converted automatically. filename is only
+// used in messages. Conversions from the distributed format need the files
+// themselves and are not supported.
+func ConvertContent(
+	input *file.Content,
+	stateFileBytes []byte,
+	filename string,
+	from Format,
+	to Format,
+) (*file.Content, error) {
+	var outputContent *file.Content
+	var err error
 
-		lintErrs, err := lint.WithContent(stateFileBytes, []byte(ruleset34to310), "error", false)
-		if err != nil {
-			return err
-		}
+	switch {
+	case from == FormatKongGateway && to == FormatKonnect:
+		outputContent, err = convertKongGatewayToKonnect(input)
 
-		_, err = lint.GetLintOutput(lintErrs, "plain", "-")
-		if err != nil {
-			return err
-		}
+	case from == FormatKongGateway2x && to == FormatKongGateway3x:
+		outputContent, err = convertKongGateway2xTo3x(input, filename, true)
+
+	case from == FormatKongGatewayVersion28x && to == FormatKongGatewayVersion34x:
+		outputContent, err = convertKongGateway28xTo34x(input, filename)
+
+	case from == FormatKongGatewayVersion34x && to == FormatKongGatewayVersion310x:
+		outputContent, err = convertKongGateway34xTo310x(input)
 
 	case from == FormatKongGatewayVersion310x && to == FormatKongGatewayVersion314x:
-		if len(inputFilenames) > 1 {
-			return fmt.Errorf("only one input file can be provided when converting from Kong 3.10 to Kong 3.14 format")
-		}
-		outputContent = convertKongGateway310xTo314x(inputContent)
+		outputContent = convertKongGateway310xTo314x(input)
 
-		stateFileBytes, err := filebasics.ReadFile(inputFilenames[0])
-		if err != nil {
-			return fmt.Errorf("failed to read input file '%s'; %w", inputFilenames[0], err)
-		}
+	default:
+		return nil, fmt.Errorf("cannot convert from '%s' to '%s' format", from, to)
+	}
+	if err != nil {
+		return nil, err
+	}
 
-		lintErrs, err := lint.WithContent(stateFileBytes, []byte(ruleset310to314), "error", false)
+	if ruleset := conversionRuleset(from, to); ruleset != "" {
+		lintErrs, err := lint.WithContent(stateFileBytes, []byte(ruleset), "error", false)
 		if err != nil {
-			return err
+			return nil, err
 		}
-
-		_, err = lint.GetLintOutput(lintErrs, "plain", "-")
-		if err != nil {
-			return err
+		if _, err = lint.GetLintOutput(lintErrs, "plain", "-"); err != nil {
+			return nil, err
 		}
-
-	default:
-		return fmt.Errorf("cannot convert from '%s' to '%s' format", from, to)
 	}
-
-	err = file.WriteContentToFile(outputContent, outputFilename, outputFormat)
-	return err
+	return outputContent, nil
 }
 
 func convertKongGateway2xTo3x(input *file.Content, filename string, printFinalWarning bool) (*file.Content, error) {
@@ -0,0 +1,556 @@
+package kong2openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/kong/go-database-reconciler/pkg/file"
+	"github.com/kong/go-kong/kong"
+)
+
+const (
+	// OpenAPIVersion is the version of the generated documents.
+	OpenAPIVersion = "3.0.3"
+
+	defaultTitle   = "Kong API"
+	defaultVersion = "1.0.0"
+)
+
+// allMethods are the operations generated for routes that match any method.
+var allMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+var (
+	// pathCapture matches the path parameter captures emitted by openapi2kong.
+	pathCapture = regexp.MustCompile(`\(\?<([A-Za-z][A-Za-z0-9_]*)>\[\^#\?/\][+*]\)`)
+	// escapedChar matches the characters escaped by openapi2kong.
+	escapedChar = regexp.MustCompile(`\\([().+?*\[$])`)
+	// regexChars are the characters left in a path that cannot be expressed
+	// in an OpenAPI path template.
+	regexChars = regexp.MustCompile(`[\\^$|()\[\]{}.*+?]`)
+)
+
+// Options are the options of Convert.
+type Options struct {
+	// Title and Version of the info section, defaults are used when empty.
+	Title   string
+	Version string
+}
+
+// Convert generates an OpenAPI document from the services and routes of a
+// decK state file. Routes that cannot be expressed in OpenAPI are skipped,
+// and reported in the returned warnings.
+func Convert(content *file.Content, opts Options) (map[string]interface{}, []string, error) {
+	c := &converter{
+		content:      content,
+		paths:        map[string]map[string]interface{}{},
+		pathServers:  map[string]string{},
+		operationIDs: map[string]bool{},
+		schemes:      map[string]interface{}{},
+		services:     map[string]*file.FService{},
+	}
+	if err := c.convert(); err != nil {
+		return nil, nil, err
+	}
+
+	title, version := opts.Title, opts.Version
+	if title == "" {
+		title = defaultTitle
+	}
+	if version == "" {
+		version = defaultVersion
+	}
+	doc := map[string]interface{}{
+		"openapi": OpenAPIVersion,
+		"info":    map[string]interface{}{"title": title, "version": version},
+		"paths":   c.paths,
+	}
+	if len(c.servers) > 0 {
+		servers := make([]interface{}, 0, len(c.servers))
+		for _, server := range c.servers {
+			servers = append(servers, map[string]interface{}{"url": server})
+		}
+		doc["servers"] = servers
+	}
+	if len(c.schemes) > 0 {
+		doc["components"] = map[string]interface{}{"securitySchemes": c.schemes}
+	}
+	return doc, c.warnings, nil
+}
+
+type converter struct {
+	content *file.Content
+
+	paths map[string]map[string]interface{}
+	// the server of the service of the routes of each path
+	pathServers map[string]string
+	schemes     map[string]interface{}
+	servers     []string
+	warnings    []string
+	// operationIDs are the operation IDs in use, which must be unique
+	operationIDs map[string]bool
+	// services by name and ID, to resolve the services of top-level routes
+	services map[string]*file.FService
+}
+
+// route is a route along with the entities its plugins are inherited from.
+type route struct {
+	*file.FRoute
+	service *file.FService
+}
+
+func (c *converter) convert() error {
+	var routes []route
+	for i := range c.content.Services {
+		service := &c.content.Services[i]
+		for _, key := range []*string{service.Name, service.ID} {
+			if key != nil {
+				c.services[*key] = service
+			}
+		}
+		for _, r := range service.Routes {
+			routes = append(routes, route{FRoute: r, service: service})
+		}
+	}
+	for i := range c.content.Routes {
+		r := &c.content.Routes[i]
+		var service *file.FService
+		if r.Service != nil {
+			service = c.lookupService(r.Service)
+			if service == nil {
+				return fmt.Errorf("route %s: service %s not found", routeName(r), entityName(r.Service.Name, r.Service.ID))
+			}
+		}
+		routes = append(routes, route{FRoute: r, service: service})
+	}
+
+	multipleServers := c.collectServers(routes)
+	for _, r := range routes {
+		if err := c.addRoute(r, multipleServers); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *converter) lookupService(ref *kong.Service) *file.FService {
+	for _, key := range []*string{ref.ID, ref.Name} {
+		if key != nil {
+			if service, ok := c.services[*key]; ok {
+				return service
+			}
+		}
+	}
+	return nil
+}
+
+// collectServers collects the URLs of the services with routes, and
+// returns whether there is more than one, in which case the path items
+// declare the server of their service.
+func (c *converter) collectServers(routes []route) bool {
+	for _, r := range routes {
+		if r.service == nil {
+			continue
+		}
+		if server := serviceURL(r.service); !slices.Contains(c.servers, server) {
+			c.servers = append(c.servers, server)
+		}
+	}
+	return len(c.servers) > 1
+}
+
+func (c *converter) warn(format string, args ...interface{}) {
+	c.warnings = append(c.warnings, fmt.Sprintf(format, args...))
+}
+
+func (c *converter) addRoute(r route, multipleServers bool) error {
+	name := routeName(r.FRoute)
+	if len(r.Paths) == 0 {
+		c.warn("route %s: skipped, routes without paths cannot be expressed in OpenAPI", name)
+		return nil
+	}
+	if r.service == nil {
+		c.warn("route %s: skipped, routes without a service have no server", name)
+		return nil
+	}
+
+	methods := make([]string, 0, len(r.Methods))
+	for _, method := range r.Methods {
+		methods = append(methods, strings.ToLower(*method))
+	}
+	if len(methods) == 0 {
+		methods = allMethods
+	}
+
+	for _, routePath := range r.Paths {
+		path, params, ok := templatePath(*routePath)
+		if !ok {
+			c.warn("route %s: path %q skipped, the regex cannot be expressed as an OpenAPI path", name, *routePath)
+			continue
+		}
+		server := serviceURL(r.service)
+		item := c.paths[path]
+		if item == nil {
+			item = map[string]interface{}{}
+			if multipleServers {
+				item["servers"] = []interface{}{map[string]interface{}{"url": server}}
+			}
+			c.paths[path] = item
+			c.pathServers[path] = server
+		} else if c.pathServers[path] != server {
+			c.warn("route %s: path %q skipped, it is already used by a route of another service", name, *routePath)
+			continue
+		}
+
+		for _, method := range methods {
+			if _, exists := item[method]; exists {
+				c.warn("route %s: %s %s skipped, it is already used by another route", name, strings.ToUpper(method), path)
+				continue
+			}
+			operation, err := c.operation(r, method, params, len(methods) > 1)
+			if err != nil {
+				return fmt.Errorf("route %s: %w", name, err)
+			}
+			item[method] = operation
+		}
+	}
+	return nil
+}
+
+func (c *converter) operation(r route, method string, pathParams []string,
+	qualifyID bool,
+) (map[string]interface{}, error) {
+	operation := map[string]interface{}{}
+	if r.Name != nil {
+		operationID := *r.Name
+		if qualifyID {
+			operationID += "_" + method
+		}
+		operation["operationId"] = c.uniqueOperationID(operationID)
+	}
+	if r.service.Name != nil {
+		operation["tags"] = []interface{}{*r.service.Name}
+	}
+
+	parameters := []interface{}{}
+	validator := c.plugin(r, "request-validator")
+	documented := map[string]bool{}
+	if validator != nil {
+		params, err := validatorParameters(validator.Config)
+		if err != nil {
+			return nil, err
+		}
+		for _, param := range params {
+			p := param.(map[string]interface{})
+			documented[fmt.Sprint(p["in"], "/", p["name"])] = true
+			parameters = append(parameters, param)
+		}
+	}
+	for _, name := range pathParams {
+		if !documented["path/"+name] {
+			parameters = append(parameters, map[string]interface{}{
+				"name":     name,
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]interface{}{"type": "string"},
+			})
+		}
+	}
+	if len(parameters) > 0 {
+		operation["parameters"] = parameters
+	}
+
+	if validator != nil {
+		body, err := validatorRequestBody(validator.Config)
+		if err != nil {
+			return nil, err
+		}
+		if body != nil {
+			operation["requestBody"] = body
+		}
+	}
+
+	if security := c.security(r); security != nil {
+		operation["security"] = security
+	}
+	operation["responses"] = map[string]interface{}{
+		"default": map[string]interface{}{"description": "Response of the upstream service"},
+	}
+	return operation, nil
+}
+
+// uniqueOperationID returns id, followed by a number if it is in use
+// already, as happens for the operations of routes with several paths.
+func (c *converter) uniqueOperationID(id string) string {
+	unique := id
+	for i := 2; c.operationIDs[unique]; i++ {
+		unique = id + "_" + strconv.Itoa(i)
+	}
+	c.operationIDs[unique] = true
+	return unique
+}
+
+// plugin returns the enabled plugin called name that applies to a route:
+// the plugin of the route, else of its service, else the global one.
+func (c *converter) plugin(r route, name string) *kong.Plugin {
+	find := func(plugins []*file.FPlugin) *kong.Plugin {
+		for _, p := range plugins {
+			if p.Name != nil && *p.Name == name && (p.Enabled == nil || *p.Enabled) && p.Consumer == nil &&
+				p.ConsumerGroup == nil {
+				return &p.Plugin
+			}
+		}
+		return nil
+	}
+	var routePlugins, servicePlugins, globalPlugins []*file.FPlugin
+	for i := range c.content.Plugins {
+		p := &c.content.Plugins[i]
+		switch {
+		case p.Route != nil:
+			if refersTo(p.Route.ID, p.Route.Name, r.ID, r.Name) {
+				routePlugins = append(routePlugins, p)
+			}
+		case p.Service != nil:
+			if refersTo(p.Service.ID, p.Service.Name, r.service.ID, r.service.Name) {
+				servicePlugins = append(servicePlugins, p)
+			}
+		default:
+			globalPlugins = append(globalPlugins, p)
+		}
+	}
+	for _, plugins := range [][]*file.FPlugin{
+		r.Plugins, routePlugins, r.service.Plugins, servicePlugins, globalPlugins,
+	} {
+		if p := find(plugins); p != nil {
+			return p
+		}
+	}
+	return nil
+}
+
+// security returns the security requirements of the authentication plugins
+// that apply to a route, registering their security schemes.
+func (c *converter) security(r route) []interface{} {
+	var requirements []interface{}
+	if p := c.plugin(r, "key-auth"); p != nil {
+		for _, scheme := range keyAuthSchemes(p.Config) {
+			requirements = append(requirements, map[string]interface{}{c.addScheme("key-auth", scheme): []interface{}{}})
+		}
+	}
+	if p := c.plugin(r, "basic-auth"); p != nil {
+		scheme := map[string]interface{}{"type": "http", "scheme": "basic"}
+		requirements = append(requirements, map[string]interface{}{c.addScheme("basic-auth", scheme): []interface{}{}})
+	}
+	if p := c.plugin(r, "openid-connect"); p != nil {
+		issuer, _ := p.Config["issuer"].(string)
+		if issuer == "" {
+			c.warn("route %s: openid-connect plugin without issuer skipped", routeName(r.FRoute))
+		} else {
+			scheme := map[string]interface{}{"type": "openIdConnect", "openIdConnectUrl": issuer}
+			scopes := []interface{}{}
+			if required, ok := p.Config["scopes_required"].([]interface{}); ok {
+				scopes = append(scopes, required...)
+			}
+			requirements = append(requirements, map[string]interface{}{c.addScheme("openid-connect", scheme): scopes})
+		}
+	}
+	return requirements
+}
+
+// addScheme registers a security scheme under base, or base followed by a
+// number when a different scheme is registered under base already, and
+// returns its name.
+func (c *converter) addScheme(base string, scheme map[string]interface{}) string {
+	for i := 1; ; i++ {
+		name := base
+		if i > 1 {
+			name += "-" + strconv.Itoa(i)
+		}
+		existing, ok := c.schemes[name]
+		if !ok {
+			c.schemes[name] = scheme
+			return name
+		}
+		if jsonEqual(existing, scheme) {
+			return name
+		}
+	}
+}
+
+// keyAuthSchemes returns an apiKey scheme per key name and location a
+// key-auth plugin accepts keys in. Keys in the body cannot be expressed.
+func keyAuthSchemes(config kong.Configuration) []map[string]interface{} {
+	names := []interface{}{"apikey"}
+	if configured, ok := config["key_names"].([]interface{}); ok && len(configured) > 0 {
+		names = configured
+	}
+	var schemes []map[string]interface{}
+	for _, location := range []string{"header", "query"} {
+		if enabled, ok := config["key_in_"+location].(bool); ok && !enabled {
+			continue
+		}
+		for _, name := range names {
+			schemes = append(schemes, map[string]interface{}{"type": "apiKey", "name": name, "in": location})
+		}
+	}
+	return schemes
+}
+
+// validatorParameters returns the parameters of a request-validator config,
+// with their schemas decoded.
+func validatorParameters(config kong.Configuration) ([]interface{}, error) {
+	configured, _ := config["parameter_schema"].([]interface{})
+	params := make([]interface{}, 0, len(configured))
+	for _, c := range configured {
+		p, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		param := map[string]interface{}{}
+		for _, key := range []string{"name", "in", "required", "style", "explode"} {
+			if v, ok := p[key]; ok && v != nil {
+				param[key] = v
+			}
+		}
+		if s, ok := p["schema"].(string); ok {
+			schema, err := decodeSchema(s)
+			if err != nil {
+				return nil, fmt.Errorf("request-validator schema of parameter %v: %w", p["name"], err)
+			}
+			param["schema"] = schema
+		}
+		params = append(params, param)
+	}
+	return params, nil
+}
+
+// validatorRequestBody returns the request body of a request-validator
+// config, or nil if the config does not validate the body.
+func validatorRequestBody(config kong.Configuration) (map[string]interface{}, error) {
+	s, _ := config["body_schema"].(string)
+	if s == "" {
+		return nil, nil
+	}
+	schema, err := decodeSchema(s)
+	if err != nil {
+		return nil, fmt.Errorf("request-validator body schema: %w", err)
+	}
+	if len(schema) == 0 {
+		return nil, nil
+	}
+	contentTypes := []interface{}{"application/json"}
+	if configured, ok := config["allowed_content_types"].([]interface{}); ok && len(configured) > 0 {
+		contentTypes = configured
+	}
+	content := map[string]interface{}{}
+	for _, contentType := range contentTypes {
+		content[fmt.Sprint(contentType)] = map[string]interface{}{"schema": schema}
+	}
+	return map[string]interface{}{"required": true, "content": content}, nil
+}
+
+func decodeSchema(s string) (map[string]interface{}, error) {
+	var schema map[string]interface{}
+	if err := json.Unmarshal([]byte(s), &schema); err != nil {
+		return nil, fmt.Errorf("decoding JSON schema: %w", err)
+	}
+	return schema, nil
+}
+
+// templatePath converts a route path to an OpenAPI path template, returning
+// the names of its path parameters. Regex paths are only converted when
+// they are made of literal segments and parameter captures, as openapi2kong
+// generates them.
+func templatePath(path string) (string, []string, bool) {
+	if !strings.HasPrefix(path, "~") {
+		return path, nil, true
+	}
+	path = strings.TrimSuffix(strings.TrimPrefix(path, "~"), "$")
+
+	var params []string
+	var b strings.Builder
+	last := 0
+	for _, match := range pathCapture.FindAllStringSubmatchIndex(path, -1) {
+		literal, ok := unescapeLiteral(path[last:match[0]])
+		if !ok {
+			return "", nil, false
+		}
+		name := path[match[2]:match[3]]
+		b.WriteString(literal + "{" + name + "}")
+		params = append(params, name)
+		last = match[1]
+	}
+	literal, ok := unescapeLiteral(path[last:])
+	if !ok {
+		return "", nil, false
+	}
+	b.WriteString(literal)
+	return b.String(), params, true
+}
+
+func unescapeLiteral(s string) (string, bool) {
+	if regexChars.MatchString(escapedChar.ReplaceAllString(s, "")) {
+		return "", false
+	}
+	return escapedChar.ReplaceAllString(s, "$1"), true
+}
+
+// serviceURL returns the URL of a service.
+func serviceURL(service *file.FService) string {
+	if service.URL != nil {
+		return *service.URL
+	}
+	protocol := "http"
+	if service.Protocol != nil {
+		protocol = *service.Protocol
+	}
+	u := url.URL{Scheme: protocol}
+	if service.Host != nil {
+		u.Host = *service.Host
+	}
+	if service.Port != nil && !isDefaultPort(protocol, *service.Port) {
+		u.Host += ":" + strconv.Itoa(*service.Port)
+	}
+	if service.Path != nil {
+		u.Path = *service.Path
+	}
+	return u.String()
+}
+
+func isDefaultPort(protocol string, port int) bool {
+	switch protocol {
+	case "http", "ws":
+		return port == 80
+	case "https", "wss":
+		return port == 443
+	}
+	return false
+}
+
+func refersTo(refID, refName, id, name *string) bool {
+	return (refID != nil && id != nil && *refID == *id) || (refName != nil && name != nil && *refName == *name)
+}
+
+func routeName(r *file.FRoute) string {
+	return entityName(r.Name, r.ID)
+}
+
+func entityName(name, id *string) string {
+	switch {
+	case name != nil:
+		return *name
+	case id != nil:
+		return *id
+	}
+	return "<unnamed>"
+}
+
+func jsonEqual(a, b interface{}) bool {
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(ja) == string(jb)
+}
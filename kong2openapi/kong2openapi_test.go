@@ -0,0 +1,150 @@
+package kong2openapi
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/kong/go-apiops/openapi2kong"
+	"github.com/kong/go-database-reconciler/pkg/file"
+	"github.com/kong/go-kong/kong"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// openapi2kongContent converts an OpenAPI spec to a decK file.
+func openapi2kongContent(t *testing.T, filename string) *file.Content {
+	spec, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	result, err := openapi2kong.Convert(spec, openapi2kong.O2kOptions{SkipID: true})
+	require.NoError(t, err)
+	b, err := json.Marshal(result)
+	require.NoError(t, err)
+	var content file.Content
+	require.NoError(t, json.Unmarshal(b, &content))
+	return &content
+}
+
+func Test_ConvertOpenapi2kongRoundTrip(t *testing.T) {
+	doc, warnings, err := Convert(openapi2kongContent(t, "testdata/openapi.yaml"), Options{Title: "Orders"})
+	require.NoError(t, err)
+	assert.Empty(t, warnings)
+
+	assert.Equal(t, []interface{}{map[string]interface{}{"url": "https://orders.internal/api"}}, doc["servers"])
+	assert.Equal(t, map[string]interface{}{"securitySchemes": map[string]interface{}{
+		"key-auth": map[string]interface{}{"type": "apiKey", "name": "x-api-key", "in": "header"},
+	}}, doc["components"])
+
+	paths := doc["paths"].(map[string]map[string]interface{})
+	require.Len(t, paths, 2)
+
+	list := paths["/orders"]["get"].(map[string]interface{})
+	assert.Equal(t, "orders_list-orders", list["operationId"])
+	assert.Equal(t, []interface{}{map[string]interface{}{"key-auth": []interface{}{}}}, list["security"])
+	require.Len(t, list["parameters"], 1)
+	limit := list["parameters"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "limit", limit["name"])
+	assert.Equal(t, "query", limit["in"])
+	assert.Equal(t, map[string]interface{}{"type": "integer"}, limit["schema"])
+
+	create := paths["/orders"]["post"].(map[string]interface{})
+	body := create["requestBody"].(map[string]interface{})["content"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"schema": map[string]interface{}{
+		"type":       "object",
+		"required":   []interface{}{"item"},
+		"properties": map[string]interface{}{"item": map[string]interface{}{"type": "string"}},
+	}}, body["application/json"])
+
+	items := paths["/orders/{orderid}/items.json"]["get"].(map[string]interface{})
+	require.Len(t, items["parameters"], 1)
+	orderID := items["parameters"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "orderid", orderID["name"])
+	assert.Equal(t, "path", orderID["in"])
+	assert.Equal(t, true, orderID["required"])
+}
+
+func Test_ConvertServersAndSecurity(t *testing.T) {
+	content := &file.Content{
+		Services: []file.FService{
+			{
+				Service: kong.Service{Name: kong.String("users"), Host: kong.String("users.internal"), Port: kong.Int(80)},
+				Routes: []*file.FRoute{{
+					Route: kong.Route{Name: kong.String("users"), Paths: kong.StringSlice("/users", "~/users/\\d+$")},
+				}},
+				Plugins: []*file.FPlugin{{Plugin: kong.Plugin{Name: kong.String("basic-auth")}}},
+			},
+			{
+				Service: kong.Service{
+					Name: kong.String("billing"), Protocol: kong.String("https"),
+					Host: kong.String("billing.internal"), Port: kong.Int(8443), Path: kong.String("/v1"),
+				},
+			},
+		},
+		Routes: []file.FRoute{{
+			Route: kong.Route{
+				Name: kong.String("invoices"), Paths: kong.StringSlice("/invoices"), Methods: kong.StringSlice("GET"),
+				Service: &kong.Service{Name: kong.String("billing")},
+			},
+		}},
+		Plugins: []file.FPlugin{
+			{Plugin: kong.Plugin{
+				Name:   kong.String("openid-connect"),
+				Config: kong.Configuration{"issuer": "https://idp.example.com", "scopes_required": []interface{}{"billing"}},
+				Route:  &kong.Route{Name: kong.String("invoices")},
+			}},
+			{Plugin: kong.Plugin{Name: kong.String("key-auth"), Enabled: kong.Bool(false)}},
+		},
+	}
+
+	doc, warnings, err := Convert(content, Options{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		`route users: path "~/users/\\d+$" skipped, the regex cannot be expressed as an OpenAPI path`,
+	}, warnings)
+	assert.Equal(t, map[string]interface{}{"title": "Kong API", "version": "1.0.0"}, doc["info"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"url": "http://users.internal"},
+		map[string]interface{}{"url": "https://billing.internal:8443/v1"},
+	}, doc["servers"])
+
+	paths := doc["paths"].(map[string]map[string]interface{})
+	assert.Equal(t, []interface{}{map[string]interface{}{"url": "http://users.internal"}}, paths["/users"]["servers"])
+	assert.Len(t, paths["/users"], len(allMethods)+1, "routes without methods match all methods")
+	assert.Equal(t, "users_get", paths["/users"]["get"].(map[string]interface{})["operationId"])
+	assert.Equal(t, []interface{}{map[string]interface{}{"basic-auth": []interface{}{}}},
+		paths["/users"]["get"].(map[string]interface{})["security"])
+
+	invoices := paths["/invoices"]["get"].(map[string]interface{})
+	assert.Equal(t, "invoices", invoices["operationId"])
+	assert.Equal(t, []interface{}{map[string]interface{}{"openid-connect": []interface{}{"billing"}}},
+		invoices["security"], "disabled plugins are ignored")
+	assert.Equal(t, map[string]interface{}{"type": "openIdConnect", "openIdConnectUrl": "https://idp.example.com"},
+		doc["components"].(map[string]interface{})["securitySchemes"].(map[string]interface{})["openid-connect"])
+}
+
+func Test_templatePath(t *testing.T) {
+	tests := []struct {
+		path   string
+		want   string
+		params []string
+		ok     bool
+	}{
+		{path: "/plain", want: "/plain", ok: true},
+		{path: "~/users/(?<id>[^#?/]+)$", want: "/users/{id}", params: []string{"id"}, ok: true},
+		{
+			path: "~/a/(?<x>[^#?/]*)/b\\.json/(?<y>[^#?/]+)$", want: "/a/{x}/b.json/{y}",
+			params: []string{"x", "y"}, ok: true,
+		},
+		{path: "~/v\\(1\\)$", want: "/v(1)", ok: true},
+		{path: "~/users/.*$"},
+		{path: "~/users/(\\d+)$"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, params, ok := templatePath(tt.path)
+			require.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.params, params)
+		})
+	}
+}
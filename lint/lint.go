@@ -2,6 +2,7 @@ package lint
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"strings"
 
@@ -138,12 +139,11 @@ func WithContent(
 	return lintErrs, nil
 }
 
+// GetLintOutput writes the lint results in the JSON or YAML format to the
+// output file, or in plain text to stdout. It reports whether any result is a
+// failure.
 func GetLintOutput(lintErrs map[string]interface{}, cmdLintFormat, cmdLintOutputFilename string) (bool, error) {
 	outputFormat := strings.ToUpper(cmdLintFormat)
-	totalCount := lintErrs["total_count"].(int)
-	failingCount := lintErrs["fail_count"].(int)
-	silenceErrors := false
-
 	switch outputFormat {
 	case strings.ToUpper(string(filebasics.OutputFormatJSON)):
 		fallthrough
@@ -151,25 +151,44 @@ func GetLintOutput(lintErrs map[string]interface{}, cmdLintFormat, cmdLintOutput
 		if err := filebasics.WriteSerializedFile(
 			cmdLintOutputFilename, lintErrs, filebasics.OutputFormat(outputFormat),
 		); err != nil {
-			return silenceErrors, fmt.Errorf("error writing lint results: %w", err)
+			return false, fmt.Errorf("error writing lint results: %w", err)
+		}
+		return lintErrs["fail_count"].(int) > 0, nil
+	default:
+		return WriteLintOutput(os.Stdout, lintErrs, cmdLintFormat)
+	}
+}
+
+// WriteLintOutput writes the lint results to w, in the JSON, YAML or plain
+// text format. It reports whether any result is a failure.
+func WriteLintOutput(w io.Writer, lintErrs map[string]interface{}, cmdLintFormat string) (bool, error) {
+	outputFormat := strings.ToUpper(cmdLintFormat)
+	totalCount := lintErrs["total_count"].(int)
+	failingCount := lintErrs["fail_count"].(int)
+
+	switch outputFormat {
+	case strings.ToUpper(string(filebasics.OutputFormatJSON)):
+		fallthrough
+	case strings.ToUpper(string(filebasics.OutputFormatYaml)):
+		b, err := filebasics.Serialize(lintErrs, filebasics.OutputFormat(outputFormat))
+		if err != nil {
+			return false, fmt.Errorf("error writing lint results: %w", err)
+		}
+		if _, err := w.Write(b); err != nil {
+			return false, fmt.Errorf("error writing lint results: %w", err)
 		}
 	case strings.ToUpper(plainTextFormat):
 		if totalCount > 0 {
-			fmt.Printf("Linting Violations: %d\n", totalCount)
-			fmt.Printf("Failures: %d\n\n", failingCount)
+			fmt.Fprintf(w, "Linting Violations: %d\n", totalCount)
+			fmt.Fprintf(w, "Failures: %d\n\n", failingCount)
 			for _, violation := range lintErrs["results"].([]Result) {
-				fmt.Printf("[%s][%d:%d] %s\n",
+				fmt.Fprintf(w, "[%s][%d:%d] %s\n",
 					violation.Severity, violation.Line, violation.Column, violation.Message,
 				)
 			}
 		}
 	default:
-		return silenceErrors, fmt.Errorf("invalid output format: %s", cmdLintFormat)
+		return false, fmt.Errorf("invalid output format: %s", cmdLintFormat)
 	}
-
-	if failingCount > 0 {
-		silenceErrors = true
-	}
-
-	return silenceErrors, nil
+	return failingCount > 0, nil
 }
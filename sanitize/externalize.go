@@ -0,0 +1,343 @@
+package sanitize
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/kong/go-database-reconciler/pkg/file"
+	"github.com/kong/go-kong/kong"
+)
+
+// Secret is a sensitive value moved out of a state file into an
+// environment variable.
+type Secret struct {
+	EnvVar string
+	Value  string
+}
+
+// Placeholder is the value written to the state file in place of the secret
+// until ReplacePlaceholders turns it into an env reference.
+func (s Secret) Placeholder() string {
+	return "__SECRET_" + s.EnvVar + "__"
+}
+
+// secretFields are the fields holding secrets of the entities with a fixed
+// schema, keyed by type name. The label is added to the env var name of the
+// secrets to tell them apart from the ones of other credentials of the
+// same consumer.
+var secretFields = map[string]struct {
+	label  string
+	fields []string
+}{
+	"KeyAuth":          {"key_auth", []string{"Key"}},
+	"BasicAuth":        {"basic_auth", []string{"Password"}},
+	"HMACAuth":         {"hmac_auth", []string{"Secret"}},
+	"JWTAuth":          {"jwt", []string{"Secret"}},
+	"Oauth2Credential": {"oauth2", []string{"ClientSecret"}},
+	"FCertificate":     {"", []string{"Cert", "Key"}},
+	"Key":              {"", []string{"JWK"}},
+	"PEM":              {"", []string{"PrivateKey"}},
+}
+
+// ownerLabels are the entities the env var names of the secrets nested in
+// them start with, keyed by type name.
+var ownerLabels = map[string]string{
+	"FService":             "service",
+	"FRoute":               "route",
+	"FConsumer":            "consumer",
+	"FConsumerGroupObject": "consumer_group",
+	"FCertificate":         "certificate",
+	"FKey":                 "key",
+}
+
+var envVarInvalidChars = regexp.MustCompile(`[^A-Z0-9]+`)
+
+// Externalizer replaces the secrets in a state file with placeholders and
+// collects their values: credential secrets, certificate and key PEMs, and
+// the plugin, partial and vault configuration fields marked as encrypted or
+// referenceable in their schema.
+type Externalizer struct {
+	sanitizer *Sanitizer
+	content   *file.Content
+	prefix    string
+	secrets   []Secret
+	used      map[string]bool
+}
+
+type ExternalizerOptions struct {
+	Ctx       context.Context
+	Client    *kong.Client
+	Content   *file.Content
+	IsKonnect bool
+	// EnvVarPrefix is the prefix of the env vars, e.g. 'DECK_'.
+	EnvVarPrefix string
+}
+
+func NewExternalizer(opts *ExternalizerOptions) *Externalizer {
+	return &Externalizer{
+		// the sanitizer is only used to fetch and cache schemas
+		sanitizer: NewSanitizer(&SanitizerOptions{
+			Ctx:       opts.Ctx,
+			Client:    opts.Client,
+			Content:   opts.Content,
+			IsKonnect: opts.IsKonnect,
+		}),
+		content: opts.Content,
+		prefix:  opts.EnvVarPrefix,
+		used:    map[string]bool{},
+	}
+}
+
+// Externalize replaces the secrets of the content with placeholders and
+// returns them, in the order they appear in the content.
+func (e *Externalizer) Externalize() (*file.Content, []Secret, error) {
+	if err := e.walk(reflect.ValueOf(e.content).Elem(), ""); err != nil {
+		return nil, nil, err
+	}
+	return e.content, e.secrets, nil
+}
+
+func (e *Externalizer) walk(v reflect.Value, owner string) error {
+	//nolint:exhaustive
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return e.walk(v.Elem(), owner)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := e.walk(v.Index(i), owner); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		typeName := v.Type().Name()
+		if label, ok := ownerLabels[typeName]; ok {
+			owner = label + "_" + entityIdentity(v)
+		}
+		switch typeName {
+		case Plugin, ConsumerGroupPlugin, Partial, Vault:
+			return e.externalizeConfig(v, owner)
+		}
+		if secret, ok := secretFields[typeName]; ok {
+			for _, field := range secret.fields {
+				f := v.FieldByName(field)
+				jsonName, _, _ := strings.Cut(fieldTag(v.Type(), field), ",")
+				if f.Kind() == reflect.Ptr && !f.IsNil() && f.CanSet() {
+					value := f.Elem().String()
+					if e.externalizable(value) {
+						f.Set(reflect.ValueOf(kong.String(e.add(value, owner, secret.label, jsonName))))
+					}
+				}
+			}
+		}
+		for i := 0; i < v.NumField(); i++ {
+			if err := e.walk(v.Field(i), owner); err != nil {
+				return err
+			}
+		}
+	default:
+		// No secrets in other kinds
+	}
+	return nil
+}
+
+// externalizeConfig externalizes the configuration fields of a plugin,
+// partial or vault that are marked as encrypted or referenceable in its
+// schema.
+func (e *Externalizer) externalizeConfig(v reflect.Value, owner string) error {
+	config, ok := v.FieldByName("Config").Interface().(kong.Configuration)
+	if !ok || len(config) == 0 {
+		return nil
+	}
+	typeName := v.Type().Name()
+	specificName, schema, err := e.sanitizer.fetchEntitySchema(typeName, v)
+	if err != nil {
+		kind := strings.ToLower(typeName)
+		if typeName == ConsumerGroupPlugin {
+			kind = "consumer group plugin"
+		}
+		return fmt.Errorf("fetching schema of %s %q: %w", kind, entityIdentity(v), err)
+	}
+	configFields := configSchemaFields(schema)
+	if configFields == nil {
+		return nil
+	}
+
+	var parts []string
+	switch typeName {
+	case Vault:
+		parts = []string{"vault", stringField(v, "Prefix")}
+	case Partial:
+		parts = []string{"partial", stringField(v, "Name")}
+	default:
+		parts = []string{"plugin", specificName}
+		if instanceName := stringField(v, "InstanceName"); instanceName != "" {
+			parts = append(parts, instanceName)
+		} else if owner != "" {
+			parts = append(parts, owner)
+		}
+	}
+	e.externalizeRecord(config, configFields, parts)
+	return nil
+}
+
+// externalizeRecord externalizes the values of record whose field schemas
+// are marked as encrypted or referenceable, recursing into nested records.
+func (e *Externalizer) externalizeRecord(record map[string]interface{}, fields []interface{}, parts []string) {
+	for _, field := range fields {
+		fieldMap, ok := field.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for name, schema := range fieldMap {
+			fieldSchema, ok := schema.(map[string]interface{})
+			if !ok || record[name] == nil {
+				continue
+			}
+			record[name] = e.externalizeValue(record[name], fieldSchema, append(parts[:len(parts):len(parts)], name))
+		}
+	}
+}
+
+func (e *Externalizer) externalizeValue(value interface{}, schema map[string]interface{}, parts []string) interface{} {
+	if isSecretSchema(schema) {
+		if s, ok := value.(string); ok && e.externalizable(s) {
+			return e.add(s, parts...)
+		}
+		return value
+	}
+	switch schema["type"] {
+	case "record":
+		nested, ok := value.(map[string]interface{})
+		fields, hasFields := schema["fields"].([]interface{})
+		if ok && hasFields {
+			e.externalizeRecord(nested, fields, parts)
+		}
+	case typeArray, typeSet:
+		elements, ok := schema["elements"].(map[string]interface{})
+		values, isSlice := value.([]interface{})
+		if ok && isSlice {
+			for i := range values {
+				values[i] = e.externalizeValue(values[i], elements, append(parts[:len(parts):len(parts)], fmt.Sprint(i+1)))
+			}
+		}
+	case "map":
+		valueSchema, ok := schema["values"].(map[string]interface{})
+		values, isMap := value.(map[string]interface{})
+		if ok && isMap {
+			keys := make([]string, 0, len(values))
+			for k := range values {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				values[k] = e.externalizeValue(values[k], valueSchema, append(parts[:len(parts):len(parts)], k))
+			}
+		}
+	}
+	return value
+}
+
+// externalizable reports whether value is a secret to externalize. Empty
+// values and references to a Kong vault are kept in the state file.
+func (e *Externalizer) externalizable(value string) bool {
+	return value != "" && !strings.HasPrefix(value, "{vault://")
+}
+
+// add records a secret and returns its placeholder. Its env var name is
+// built from parts and made unique with a numeric suffix.
+func (e *Externalizer) add(value string, parts ...string) string {
+	var name string
+	for _, part := range parts {
+		part = strings.Trim(envVarInvalidChars.ReplaceAllString(strings.ToUpper(part), "_"), "_")
+		if part != "" {
+			name += "_" + part
+		}
+	}
+	name = e.prefix + strings.TrimPrefix(name, "_")
+	unique := name
+	for n := 2; e.used[unique]; n++ {
+		unique = fmt.Sprintf("%s_%d", name, n)
+	}
+	e.used[unique] = true
+
+	secret := Secret{EnvVar: unique, Value: value}
+	e.secrets = append(e.secrets, secret)
+	return secret.Placeholder()
+}
+
+// ReplacePlaceholders replaces the placeholders of secrets in a marshaled
+// JSON or YAML state file with env references. The references quote the
+// values of the secrets as they are rendered, so that the env vars hold the
+// values as they are and are rendered into a valid string in both formats.
+func ReplacePlaceholders(content []byte, secrets []Secret) []byte {
+	for _, secret := range secrets {
+		reference := []byte(`${{ env "` + secret.EnvVar + `" | printf "%q" }}`)
+		placeholder := []byte(secret.Placeholder())
+		content = bytes.ReplaceAll(content, append([]byte(`"`), append(placeholder, '"')...), reference)
+		content = bytes.ReplaceAll(content, placeholder, reference)
+	}
+	return content
+}
+
+// configSchemaFields returns the fields of the config record of an entity
+// schema, if any.
+func configSchemaFields(schema kong.Schema) []interface{} {
+	fields, _ := schema["fields"].([]interface{})
+	for _, field := range fields {
+		fieldMap, ok := field.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if config, ok := fieldMap["config"].(map[string]interface{}); ok {
+			configFields, _ := config["fields"].([]interface{})
+			return configFields
+		}
+	}
+	return nil
+}
+
+func isSecretSchema(schema map[string]interface{}) bool {
+	encrypted, _ := schema["encrypted"].(bool)
+	referenceable, _ := schema["referenceable"].(bool)
+	return (encrypted || referenceable) && schema["type"] == typeString
+}
+
+// entityIdentity returns the name, or another identifying field, of an
+// entity, to use in env var names.
+func entityIdentity(v reflect.Value) string {
+	if snis := v.FieldByName("SNIs"); snis.IsValid() && snis.Len() > 0 {
+		if name := stringField(snis.Index(0), "Name"); name != "" {
+			return name
+		}
+	}
+	for _, field := range []string{"Name", "Username", "CustomID", "KID", "ID"} {
+		if s := stringField(v, field); s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+func stringField(v reflect.Value, name string) string {
+	f := v.FieldByName(name)
+	if f.IsValid() && f.Kind() == reflect.Ptr && !f.IsNil() && f.Elem().Kind() == reflect.String {
+		return f.Elem().String()
+	}
+	return ""
+}
+
+func fieldTag(t reflect.Type, name string) string {
+	f, ok := t.FieldByName(name)
+	if !ok {
+		return strings.ToLower(name)
+	}
+	return f.Tag.Get("json")
+}
@@ -0,0 +1,125 @@
+package sanitize
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kong/go-database-reconciler/pkg/file"
+	"github.com/kong/go-kong/kong"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPluginSchema = `{"fields": [
+	{"protocols": {"type": "set", "elements": {"type": "string"}}},
+	{"config": {"type": "record", "fields": [
+		{"client_id": {"type": "string"}},
+		{"client_secret": {"type": "string", "encrypted": true, "referenceable": true}},
+		{"redis": {"type": "record", "fields": [
+			{"host": {"type": "string"}},
+			{"password": {"type": "string", "referenceable": true}}
+		]}},
+		{"headers": {"type": "array", "elements": {"type": "string", "referenceable": true}}}
+	]}}
+]}`
+
+func Test_Externalize(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/schemas/plugins/openid-connect" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(testPluginSchema))
+	}))
+	defer server.Close()
+	client, err := kong.NewClient(kong.String(server.URL), server.Client())
+	require.NoError(t, err)
+
+	content := &file.Content{
+		Services: []file.FService{{
+			Service: kong.Service{Name: kong.String("orders")},
+			Plugins: []*file.FPlugin{{Plugin: kong.Plugin{
+				Name: kong.String("openid-connect"),
+				Config: kong.Configuration{
+					"client_id":     "orders",
+					"client_secret": "s3cr3t",
+					"redis":         map[string]interface{}{"host": "redis", "password": "{vault://env/redis}"},
+					"headers":       []interface{}{"a:1", "b:2"},
+				},
+			}}},
+		}},
+		Consumers: []file.FConsumer{{
+			Consumer: kong.Consumer{Username: kong.String("alice")},
+			KeyAuths: []*kong.KeyAuth{{Key: kong.String("k1")}, {Key: kong.String("k2")}},
+			HMACAuths: []*kong.HMACAuth{{
+				Username: kong.String("alice-hmac"), Secret: kong.String("hmac-secret"),
+			}},
+		}},
+		Certificates: []file.FCertificate{{
+			Cert: kong.String("-----BEGIN CERTIFICATE-----\nMII\n"), Key: kong.String("-----BEGIN KEY-----\nMII\n"),
+			SNIs: []kong.SNI{{Name: kong.String("example.com")}},
+		}},
+		Keys: []file.FKey{{Key: kong.Key{
+			Name: kong.String("signing"), PEM: &kong.PEM{PublicKey: kong.String("pub"), PrivateKey: kong.String("priv")},
+		}}},
+	}
+
+	externalizer := NewExternalizer(&ExternalizerOptions{
+		Ctx:          context.Background(),
+		Client:       client,
+		Content:      content,
+		EnvVarPrefix: "DECK_",
+	})
+	result, secrets, err := externalizer.Externalize()
+	require.NoError(t, err)
+
+	envVars := make([]string, 0, len(secrets))
+	for _, secret := range secrets {
+		envVars = append(envVars, secret.EnvVar)
+	}
+	assert.Equal(t, []string{
+		"DECK_PLUGIN_OPENID_CONNECT_SERVICE_ORDERS_CLIENT_SECRET",
+		"DECK_PLUGIN_OPENID_CONNECT_SERVICE_ORDERS_HEADERS_1",
+		"DECK_PLUGIN_OPENID_CONNECT_SERVICE_ORDERS_HEADERS_2",
+		"DECK_CONSUMER_ALICE_KEY_AUTH_KEY",
+		"DECK_CONSUMER_ALICE_KEY_AUTH_KEY_2",
+		"DECK_CONSUMER_ALICE_HMAC_AUTH_SECRET",
+		"DECK_CERTIFICATE_EXAMPLE_COM_CERT",
+		"DECK_CERTIFICATE_EXAMPLE_COM_KEY",
+		"DECK_KEY_SIGNING_PRIVATE_KEY",
+	}, envVars)
+
+	config := result.Services[0].Plugins[0].Config
+	assert.Equal(t, "orders", config["client_id"])
+	assert.Equal(t, secrets[0].Placeholder(), config["client_secret"])
+	assert.Equal(t, "{vault://env/redis}", config["redis"].(map[string]interface{})["password"],
+		"vault references are kept")
+	assert.Equal(t, "pub", *result.Keys[0].PEM.PublicKey)
+	assert.Equal(t, "alice-hmac", *result.Consumers[0].HMACAuths[0].Username)
+	assert.Equal(t, "k2", secrets[4].Value)
+}
+
+func Test_ExternalizeSchemaError(t *testing.T) {
+	externalizer := NewExternalizer(&ExternalizerOptions{
+		Ctx: context.Background(),
+		Content: &file.Content{Plugins: []file.FPlugin{{Plugin: kong.Plugin{
+			Name: kong.String("key-auth"), Config: kong.Configuration{"key_names": []interface{}{"apikey"}},
+		}}}},
+	})
+	_, _, err := externalizer.Externalize()
+	require.EqualError(t, err, `fetching schema of plugin "key-auth": kong client is not initialized`)
+}
+
+func Test_ReplacePlaceholders(t *testing.T) {
+	secret := Secret{EnvVar: "DECK_CERT", Value: "-----BEGIN-----\nit's \"quoted\" \\ <ok>\n"}
+
+	yamlContent := []byte("cert: " + secret.Placeholder() + "\n")
+	assert.Equal(t, "cert: ${{ env \"DECK_CERT\" | printf \"%q\" }}\n",
+		string(ReplacePlaceholders(yamlContent, []Secret{secret})))
+	jsonContent := []byte(`{"cert": "` + secret.Placeholder() + `"}`)
+	assert.Equal(t, `{"cert": ${{ env "DECK_CERT" | printf "%q" }}}`,
+		string(ReplacePlaceholders(jsonContent, []Secret{secret})))
+}
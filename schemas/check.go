@@ -0,0 +1,247 @@
+package schemas
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/kong/go-kong/kong"
+)
+
+// FieldError is an error of a field of an entity.
+type FieldError struct {
+	// Path of the field, such as config.policy.
+	Path    string
+	Message string
+}
+
+func (e FieldError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return e.Path + ": " + e.Message
+}
+
+// Check checks an entity, decoded from JSON, against a Kong schema as Kong
+// would: field types, required fields, enums, ranges and lengths, and
+// unknown fields. Entity checks across fields are not checked.
+func Check(schema kong.Schema, entity map[string]interface{}) []FieldError {
+	c := &checker{}
+	c.record(map[string]interface{}(schema), entity, "")
+	sort.SliceStable(c.errs, func(i, j int) bool { return c.errs[i].Path < c.errs[j].Path })
+	return c.errs
+}
+
+// MergeFields returns base with the fields of override added, replacing
+// the fields of base with the same name. This combines the schema of the
+// plugins entity with the schema of a plugin, which only declares the
+// fields specific to the plugin, such as config.
+func MergeFields(base, override kong.Schema) kong.Schema {
+	merged := kong.Schema{}
+	for key, value := range base {
+		merged[key] = value
+	}
+	overrides := map[string]bool{}
+	var fields []interface{}
+	for _, field := range fieldList(override) {
+		name, _ := singleField(field)
+		overrides[name] = true
+	}
+	for _, field := range fieldList(base) {
+		if name, _ := singleField(field); !overrides[name] {
+			fields = append(fields, field)
+		}
+	}
+	fields = append(fields, fieldList(override)...)
+	merged["fields"] = fields
+	if shorthands, ok := override["shorthand_fields"].([]interface{}); ok {
+		merged["shorthand_fields"] = shorthands
+	}
+	return merged
+}
+
+type checker struct {
+	errs []FieldError
+}
+
+func (c *checker) fail(path, format string, args ...interface{}) {
+	c.errs = append(c.errs, FieldError{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func fieldList(schema map[string]interface{}) []interface{} {
+	fields, _ := schema["fields"].([]interface{})
+	return fields
+}
+
+// singleField returns the name and definition of a field of a fields list,
+// which holds a single-key object per field.
+func singleField(field interface{}) (string, map[string]interface{}) {
+	m, _ := field.(map[string]interface{})
+	for name, def := range m {
+		d, _ := def.(map[string]interface{})
+		return name, d
+	}
+	return "", nil
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func (c *checker) record(schema map[string]interface{}, value map[string]interface{}, path string) {
+	known := map[string]bool{}
+	for _, field := range fieldList(schema) {
+		name, def := singleField(field)
+		if def == nil {
+			continue
+		}
+		known[name] = true
+		c.field(def, value[name], join(path, name))
+	}
+	if shorthands, ok := schema["shorthand_fields"].([]interface{}); ok {
+		for _, field := range shorthands {
+			name, _ := singleField(field)
+			known[name] = true
+		}
+	}
+	names := make([]string, 0, len(value))
+	for name := range value {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !known[name] {
+			c.fail(join(path, name), "unknown field")
+		}
+	}
+}
+
+func (c *checker) field(def map[string]interface{}, value interface{}, path string) {
+	fieldType, _ := def["type"].(string)
+	if value == nil {
+		required, _ := def["required"].(bool)
+		auto, _ := def["auto"].(bool)
+		_, hasDefault := def["default"]
+		switch {
+		case !required || auto || hasDefault:
+		case fieldType == "record":
+			// Kong builds missing records from the defaults of their fields
+			c.record(def, map[string]interface{}{}, path)
+		default:
+			c.fail(path, "required field missing")
+		}
+		return
+	}
+	if s, ok := value.(string); ok && strings.HasPrefix(s, "{vault://") {
+		if referenceable, _ := def["referenceable"].(bool); referenceable {
+			return
+		}
+	}
+
+	switch fieldType {
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			c.fail(path, "expected a string")
+			return
+		}
+		c.length(def, utf8.RuneCountInString(s), path)
+		c.oneOf(def, value, path)
+	case "integer", "number":
+		n, ok := value.(float64)
+		if !ok || (fieldType == "integer" && n != math.Trunc(n)) {
+			c.fail(path, "expected %s %s", article(fieldType), fieldType)
+			return
+		}
+		c.oneOf(def, value, path)
+		if between, ok := def["between"].([]interface{}); ok && len(between) == 2 {
+			lo, _ := between[0].(float64)
+			hi, _ := between[1].(float64)
+			if n < lo || n > hi {
+				c.fail(path, "value should be between %v and %v", lo, hi)
+			}
+		}
+		if gt, ok := def["gt"].(float64); ok && n <= gt {
+			c.fail(path, "value must be greater than %v", gt)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			c.fail(path, "expected a boolean")
+		}
+	case "array", "set":
+		elements, ok := value.([]interface{})
+		if !ok {
+			c.fail(path, "expected %s %s", article(fieldType), fieldType)
+			return
+		}
+		c.length(def, len(elements), path)
+		if elementDef, ok := def["elements"].(map[string]interface{}); ok {
+			for i, element := range elements {
+				c.field(elementDef, element, fmt.Sprintf("%s[%d]", path, i+1))
+			}
+		}
+	case "map":
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			c.fail(path, "expected a map")
+			return
+		}
+		c.length(def, len(m), path)
+		keyDef, _ := def["keys"].(map[string]interface{})
+		valueDef, _ := def["values"].(map[string]interface{})
+		for key, v := range m {
+			if keyDef != nil {
+				c.field(keyDef, key, path)
+			}
+			if valueDef != nil {
+				c.field(valueDef, v, join(path, key))
+			}
+		}
+	case "record":
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			c.fail(path, "expected a record")
+			return
+		}
+		if abstract, _ := def["abstract"].(bool); !abstract {
+			c.record(def, m, path)
+		}
+	}
+	// other types, such as foreign keys and json, are not checked
+}
+
+func (c *checker) length(def map[string]interface{}, n int, path string) {
+	if lenMin, ok := def["len_min"].(float64); ok && float64(n) < lenMin {
+		c.fail(path, "length must be at least %v", lenMin)
+	}
+	if lenMax, ok := def["len_max"].(float64); ok && float64(n) > lenMax {
+		c.fail(path, "length must be at most %v", lenMax)
+	}
+}
+
+func (c *checker) oneOf(def map[string]interface{}, value interface{}, path string) {
+	oneOf, ok := def["one_of"].([]interface{})
+	if !ok {
+		return
+	}
+	values := make([]string, 0, len(oneOf))
+	for _, v := range oneOf {
+		if v == value {
+			return
+		}
+		values = append(values, fmt.Sprint(v))
+	}
+	c.fail(path, "expected one of: %s", strings.Join(values, ", "))
+}
+
+func article(word string) string {
+	if strings.ContainsRune("aeiou", rune(word[0])) {
+		return "an"
+	}
+	return "a"
+}
@@ -0,0 +1,78 @@
+package schemas
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/kong/go-kong/kong"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decodeSchema(t *testing.T, s string) kong.Schema {
+	var schema kong.Schema
+	require.NoError(t, json.Unmarshal([]byte(s), &schema))
+	return schema
+}
+
+const testPluginsSchema = `{"fields": [
+	{"id": {"type": "string", "auto": true}},
+	{"name": {"type": "string", "required": true}},
+	{"enabled": {"type": "boolean", "default": true}},
+	{"protocols": {"type": "set", "elements": {"type": "string", "one_of": ["http", "https"]}}},
+	{"config": {"type": "record", "abstract": true}}
+]}`
+
+const testRateLimitingSchema = `{"fields": [
+	{"config": {"type": "record", "required": true, "fields": [
+		{"minute": {"type": "number", "gt": 0}},
+		{"policy": {"type": "string", "default": "local", "one_of": ["local", "redis"]}},
+		{"limit_by": {"type": "string", "required": true}},
+		{"redis": {"type": "record", "required": true, "fields": [
+			{"port": {"type": "integer", "between": [0, 65535], "default": 6379}},
+			{"password": {"type": "string", "referenceable": true, "len_min": 8}}
+		]}}
+	]}}
+]}`
+
+func TestCheck(t *testing.T) {
+	schema := MergeFields(decodeSchema(t, testPluginsSchema), decodeSchema(t, testRateLimitingSchema))
+
+	errs := Check(schema, map[string]interface{}{
+		"name":   "rate-limiting",
+		"config": map[string]interface{}{"limit_by": "consumer", "minute": 5.0},
+	})
+	assert.Empty(t, errs)
+
+	errs = Check(schema, map[string]interface{}{
+		"name":      "rate-limiting",
+		"enabled":   "yes",
+		"protocols": []interface{}{"http", "grpc"},
+		"tag":       "a",
+		"config": map[string]interface{}{
+			"minute": 0.0,
+			"policy": "cluster",
+			"redis":  map[string]interface{}{"port": 70000.5, "password": "{vault://env/pw}"},
+		},
+	})
+	assert.Equal(t, []FieldError{
+		{Path: "config.limit_by", Message: "required field missing"},
+		{Path: "config.minute", Message: "value must be greater than 0"},
+		{Path: "config.policy", Message: "expected one of: local, redis"},
+		{Path: "config.redis.port", Message: "expected an integer"},
+		{Path: "enabled", Message: "expected a boolean"},
+		{Path: "protocols[2]", Message: "expected one of: http, https"},
+		{Path: "tag", Message: "unknown field"},
+	}, errs)
+
+	errs = Check(schema, map[string]interface{}{
+		"config": map[string]interface{}{
+			"limit_by": "consumer",
+			"redis":    map[string]interface{}{"password": "short"},
+		},
+	})
+	assert.Equal(t, []FieldError{
+		{Path: "config.redis.password", Message: "length must be at least 8"},
+		{Path: "name", Message: "required field missing"},
+	}, errs)
+}
@@ -0,0 +1,245 @@
+// Package schemas stores the entity and plugin schemas of Kong versions on
+// disk, so that they can be used without a connection to Kong.
+package schemas
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kong/go-kong/kong"
+)
+
+// Kinds of schemas.
+const (
+	KindEntity  = "entities"
+	KindPlugin  = "plugins"
+	KindPartial = "partials"
+	KindVault   = "vaults"
+)
+
+// Editions of Kong.
+const (
+	EditionEnterprise = "enterprise"
+	EditionOSS        = "oss"
+)
+
+// ErrNotStored is returned when a schema is not in the store.
+var ErrNotStored = errors.New("schema not stored")
+
+var (
+	versionPattern = regexp.MustCompile(`^(\d+)\.(\d+)(?:\.\d+)*(?:-(enterprise|oss))?$`)
+	// safeName matches the names schemas can be stored under.
+	safeName = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+)
+
+// Version identifies the schemas of Kong: schemas only change between
+// minor versions, and differ between editions.
+type Version struct {
+	Major, Minor uint64
+	// Edition is empty when not known.
+	Edition string
+}
+
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d", v.Major, v.Minor)
+	if v.Edition != "" {
+		s += "-" + v.Edition
+	}
+	return s
+}
+
+// ParseVersion parses a version such as 3.10, 3.10.0 or 3.10-enterprise.
+func ParseVersion(s string) (Version, error) {
+	m := versionPattern.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return Version{}, fmt.Errorf("invalid Kong version '%s', expected a version such as 3.10 or 3.10-enterprise", s)
+	}
+	major, _ := strconv.ParseUint(m[1], 10, 64)
+	minor, _ := strconv.ParseUint(m[2], 10, 64)
+	return Version{Major: major, Minor: minor, Edition: m[3]}, nil
+}
+
+// VersionOf returns the version of the schemas of the Kong reporting
+// version, as returned by its root endpoint.
+func VersionOf(version string) (Version, error) {
+	v, err := kong.ParseSemanticVersion(version)
+	if err != nil {
+		return Version{}, err
+	}
+	edition := EditionOSS
+	if v.IsKongGatewayEnterprise() {
+		edition = EditionEnterprise
+	}
+	return Version{Major: v.Major(), Minor: v.Minor(), Edition: edition}, nil
+}
+
+// Store is a directory of schemas, by version, kind and name.
+type Store struct {
+	dir string
+}
+
+// NewStore returns the store in dir. The directory is created when the
+// first schema is stored.
+func NewStore(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+// Dir returns the directory of the store.
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+func (s *Store) path(v Version, kind, name string) (string, error) {
+	if v.Edition == "" {
+		return "", fmt.Errorf("the edition of Kong %s is not known", v)
+	}
+	if !safeName.MatchString(name) {
+		return "", fmt.Errorf("invalid schema name '%s'", name)
+	}
+	return filepath.Join(s.dir, v.String(), kind, name+".json"), nil
+}
+
+// Get returns a schema, or an error wrapping ErrNotStored.
+func (s *Store) Get(v Version, kind, name string) (kong.Schema, error) {
+	filename, err := s.path(v, kind, name)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s/%s of Kong %s", ErrNotStored, kind, name, v)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading schema: %w", err)
+	}
+	var schema kong.Schema
+	if err := json.Unmarshal(b, &schema); err != nil {
+		return nil, fmt.Errorf("reading schema %s: %w", filename, err)
+	}
+	return schema, nil
+}
+
+// Put stores a schema, replacing the stored one.
+func (s *Store) Put(v Version, kind, name string, schema kong.Schema) error {
+	filename, err := s.path(v, kind, name)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(schema)
+	if err != nil {
+		return fmt.Errorf("encoding schema: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0o700); err != nil {
+		return fmt.Errorf("creating schema directory: %w", err)
+	}
+	// write to a temporary file first, as concurrent runs may read it
+	tmp, err := os.CreateTemp(filepath.Dir(filename), name+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("writing schema: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("writing schema: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing schema: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		return fmt.Errorf("writing schema: %w", err)
+	}
+	return nil
+}
+
+// Versions returns the versions with stored schemas.
+func (s *Store) Versions() ([]Version, error) {
+	entries, err := os.ReadDir(s.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading schema store: %w", err)
+	}
+	var versions []Version
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		v, err := ParseVersion(entry.Name())
+		if err != nil || v.Edition == "" {
+			continue
+		}
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		a, b := versions[i], versions[j]
+		if a.Major != b.Major {
+			return a.Major < b.Major
+		}
+		if a.Minor != b.Minor {
+			return a.Minor < b.Minor
+		}
+		return a.Edition < b.Edition
+	})
+	return versions, nil
+}
+
+// Resolve returns the stored version v refers to. The edition may be left
+// out when the schemas of a single edition are stored.
+func (s *Store) Resolve(v Version) (Version, error) {
+	versions, err := s.Versions()
+	if err != nil {
+		return Version{}, err
+	}
+	var matches []Version
+	for _, stored := range versions {
+		if stored.Major == v.Major && stored.Minor == v.Minor && (v.Edition == "" || stored.Edition == v.Edition) {
+			matches = append(matches, stored)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return Version{}, fmt.Errorf("%w: no schemas of Kong %s in %s", ErrNotStored, v, s.dir)
+	case 1:
+		return matches[0], nil
+	default:
+		return Version{}, fmt.Errorf("schemas of both editions of Kong %s are stored, use %s or %s",
+			v, matches[0], matches[1])
+	}
+}
+
+// Names returns the names of the stored schemas of a kind.
+func (s *Store) Names(v Version, kind string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(s.dir, v.String(), kind))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading schema store: %w", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if name, ok := strings.CutSuffix(entry.Name(), ".json"); ok && !entry.IsDir() {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// Remove removes the stored schemas of a version.
+func (s *Store) Remove(v Version) error {
+	if v.Edition == "" {
+		return fmt.Errorf("the edition of Kong %s is not known", v)
+	}
+	if err := os.RemoveAll(filepath.Join(s.dir, v.String())); err != nil {
+		return fmt.Errorf("removing schemas of Kong %s: %w", v, err)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package schemas
+
+import (
+	"testing"
+
+	"github.com/kong/go-kong/kong"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseVersion(t *testing.T) {
+	v, err := ParseVersion("3.10")
+	require.NoError(t, err)
+	assert.Equal(t, Version{Major: 3, Minor: 10}, v)
+
+	v, err = ParseVersion("3.10.0.1-enterprise")
+	require.NoError(t, err)
+	assert.Equal(t, Version{Major: 3, Minor: 10, Edition: EditionEnterprise}, v)
+	assert.Equal(t, "3.10-enterprise", v.String())
+
+	_, err = ParseVersion("latest")
+	require.Error(t, err)
+}
+
+func TestVersionOf(t *testing.T) {
+	v, err := VersionOf("3.10.0.1-enterprise-edition")
+	require.NoError(t, err)
+	assert.Equal(t, Version{Major: 3, Minor: 10, Edition: EditionEnterprise}, v)
+
+	v, err = VersionOf("3.9.1")
+	require.NoError(t, err)
+	assert.Equal(t, Version{Major: 3, Minor: 9, Edition: EditionOSS}, v)
+}
+
+func TestStore(t *testing.T) {
+	store := NewStore(t.TempDir())
+	oss := Version{Major: 3, Minor: 10, Edition: EditionOSS}
+	schema := kong.Schema{"fields": []interface{}{map[string]interface{}{"name": map[string]interface{}{"type": "string"}}}}
+
+	_, err := store.Get(oss, KindEntity, "services")
+	require.ErrorIs(t, err, ErrNotStored)
+	_, err = store.Resolve(Version{Major: 3, Minor: 10})
+	require.ErrorIs(t, err, ErrNotStored)
+
+	require.NoError(t, store.Put(oss, KindEntity, "services", schema))
+	got, err := store.Get(oss, KindEntity, "services")
+	require.NoError(t, err)
+	assert.Equal(t, schema, got)
+	require.Error(t, store.Put(oss, KindEntity, "../services", schema))
+
+	v, err := store.Resolve(Version{Major: 3, Minor: 10})
+	require.NoError(t, err)
+	assert.Equal(t, oss, v)
+
+	enterprise := Version{Major: 3, Minor: 10, Edition: EditionEnterprise}
+	require.NoError(t, store.Put(enterprise, KindPlugin, "rate-limiting", schema))
+	versions, err := store.Versions()
+	require.NoError(t, err)
+	assert.Equal(t, []Version{enterprise, oss}, versions)
+	_, err = store.Resolve(Version{Major: 3, Minor: 10})
+	require.EqualError(t, err, "schemas of both editions of Kong 3.10 are stored, use 3.10-enterprise or 3.10-oss")
+}
@@ -0,0 +1,161 @@
+package validate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/kong/deck/schemas"
+	"github.com/kong/go-database-reconciler/pkg/state"
+	reconcilerUtils "github.com/kong/go-database-reconciler/pkg/utils"
+	"github.com/kong/go-kong/kong"
+)
+
+// OfflineValidator validates the entities of a state against the schemas
+// of a Kong version stored on disk, without a connection to Kong.
+type OfflineValidator struct {
+	state                *state.KongState
+	store                *schemas.Store
+	version              schemas.Version
+	rbacResourcesOnly    bool
+	onlineEntitiesFilter []string
+
+	// missing holds the schemas reported as not stored, to report them once
+	missing map[string]bool
+}
+
+type OfflineValidatorOpts struct {
+	State                *state.KongState
+	Store                *schemas.Store
+	Version              schemas.Version
+	RBACResourcesOnly    bool
+	OnlineEntitiesFilter []string
+}
+
+func NewOfflineValidator(opt OfflineValidatorOpts) *OfflineValidator {
+	return &OfflineValidator{
+		state:                opt.State,
+		store:                opt.Store,
+		version:              opt.Version,
+		rbacResourcesOnly:    opt.RBACResourcesOnly,
+		onlineEntitiesFilter: opt.OnlineEntitiesFilter,
+		missing:              map[string]bool{},
+	}
+}
+
+// Validate validates the entities of the state, returning an error per
+// invalid entity, and per schema that is not stored.
+func (v *OfflineValidator) Validate() []error {
+	fieldNames := make([]string, 0, len(EntityMap))
+	switch {
+	case v.rbacResourcesOnly:
+		fieldNames = append(fieldNames, "RBACEndpointPermissions", "RBACRoles")
+	case len(v.onlineEntitiesFilter) > 0:
+		for _, name := range v.onlineEntitiesFilter {
+			if _, ok := EntityMap[name]; ok {
+				fieldNames = append(fieldNames, name)
+			}
+		}
+	default:
+		for name := range EntityMap {
+			fieldNames = append(fieldNames, name)
+		}
+	}
+	// validate in a stable order, to report errors in a stable order
+	sort.Strings(fieldNames)
+
+	allErr := []error{}
+	stateValue := reflect.ValueOf(v.state).Elem()
+	for _, fieldName := range fieldNames {
+		fieldValue := stateValue.FieldByName(fieldName)
+		if !fieldValue.IsValid() || !fieldValue.CanInterface() {
+			allErr = append(allErr, fmt.Errorf("invalid field '%s' in state", fieldName))
+			continue
+		}
+		allErr = append(allErr, v.entities(fieldValue.Interface(), EntityMap[fieldName])...)
+	}
+	return allErr
+}
+
+func (v *OfflineValidator) entities(obj interface{}, entityType string) []error {
+	entities, err := reconcilerUtils.CallGetAll(obj)
+	if err != nil {
+		return []error{err}
+	}
+	var errs []error
+	for i := 0; i < entities.Len(); i++ {
+		entity := getEmbeddedTypeFromWrappedType(entities.Index(i).Interface(), entityType)
+		if err := v.validateEntity(entityType, entity); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+func (v *OfflineValidator) validateEntity(entityType string, entity interface{}) error {
+	nameOrID := getEntityNameOrID(entity)
+	errWrap := "validate entity '%s (%s)': %s"
+
+	schema, err := v.schema(entityType, entity)
+	if errors.Is(err, schemas.ErrNotStored) {
+		if key := err.Error(); !v.missing[key] {
+			v.missing[key] = true
+			return fmt.Errorf("%w, validate online against Kong %s to cache it", err, v.version)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf(errWrap, entityType, nameOrID, err)
+	}
+
+	b, err := json.Marshal(entity)
+	if err != nil {
+		return fmt.Errorf(errWrap, entityType, nameOrID, err)
+	}
+	var value map[string]interface{}
+	if err := json.Unmarshal(b, &value); err != nil {
+		return fmt.Errorf(errWrap, entityType, nameOrID, err)
+	}
+	fieldErrs := schemas.Check(schema, value)
+	if len(fieldErrs) == 0 {
+		return nil
+	}
+	messages := make([]string, 0, len(fieldErrs))
+	for _, fieldErr := range fieldErrs {
+		messages = append(messages, fieldErr.Error())
+	}
+	return fmt.Errorf(errWrap, entityType, nameOrID, strings.Join(messages, "; "))
+}
+
+// schema returns the schema of an entity. Plugins, vaults and partials are
+// validated against the schema of their entity type combined with the
+// schema of their plugin, vault or partial type.
+func (v *OfflineValidator) schema(entityType string, entity interface{}) (kong.Schema, error) {
+	schema, err := v.store.Get(v.version, schemas.KindEntity, entityType)
+	if err != nil {
+		return nil, err
+	}
+	var kind string
+	var name *string
+	switch e := entity.(type) {
+	case *state.Plugin:
+		kind, name = schemas.KindPlugin, e.Name
+	case *state.Vault:
+		kind, name = schemas.KindVault, e.Name
+	case *state.Partial:
+		kind, name = schemas.KindPartial, e.Type
+	default:
+		return schema, nil
+	}
+	if name == nil {
+		return schema, nil
+	}
+	typeSchema, err := v.store.Get(v.version, kind, *name)
+	if err != nil {
+		return nil, err
+	}
+	return schemas.MergeFields(schema, typeSchema), nil
+}
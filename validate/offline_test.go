@@ -0,0 +1,54 @@
+package validate
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/kong/deck/schemas"
+	"github.com/kong/go-database-reconciler/pkg/state"
+	"github.com/kong/go-kong/kong"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_OfflineValidator(t *testing.T) {
+	store := schemas.NewStore(t.TempDir())
+	version := schemas.Version{Major: 3, Minor: 10, Edition: schemas.EditionOSS}
+	put := func(kind, name, s string) {
+		var schema kong.Schema
+		require.NoError(t, json.Unmarshal([]byte(s), &schema))
+		require.NoError(t, store.Put(version, kind, name, schema))
+	}
+	put(schemas.KindEntity, "services", `{"fields": [
+		{"id": {"type": "string", "auto": true}},
+		{"name": {"type": "string"}},
+		{"host": {"type": "string", "required": true}},
+		{"port": {"type": "integer", "between": [0, 65535]}}
+	]}`)
+	put(schemas.KindEntity, "plugins", `{"fields": [
+		{"id": {"type": "string", "auto": true}},
+		{"name": {"type": "string", "required": true}},
+		{"config": {"type": "record", "abstract": true}}
+	]}`)
+
+	ks, err := state.NewKongState()
+	require.NoError(t, err)
+	require.NoError(t, ks.Services.Add(state.Service{Service: kong.Service{
+		ID: kong.String("s1"), Name: kong.String("orders"), Host: kong.String("orders"), Port: kong.Int(70000),
+	}}))
+	require.NoError(t, ks.Plugins.Add(state.Plugin{Plugin: kong.Plugin{
+		ID: kong.String("p1"), Name: kong.String("cors"), Config: kong.Configuration{},
+	}}))
+	require.NoError(t, ks.Plugins.Add(state.Plugin{Plugin: kong.Plugin{
+		ID: kong.String("p2"), Name: kong.String("cors"), Config: kong.Configuration{},
+		Service: &kong.Service{ID: kong.String("s1")},
+	}}))
+
+	errs := NewOfflineValidator(OfflineValidatorOpts{State: ks, Store: store, Version: version}).Validate()
+	require.Len(t, errs, 2)
+	assert.ErrorIs(t, errs[0], schemas.ErrNotStored)
+	assert.EqualError(t, errs[0],
+		"schema not stored: plugins/cors of Kong 3.10-oss, validate online against Kong 3.10-oss to cache it")
+	assert.EqualError(t, errs[1],
+		"validate entity 'services (orders)': port: value should be between 0 and 65535")
+}